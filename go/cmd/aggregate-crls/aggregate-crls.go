@@ -1,51 +1,139 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
 	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/armon/go-metrics"
-	"github.com/golang/glog"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/google/certificate-transparency-go/x509"
 	"github.com/google/certificate-transparency-go/x509/pkix"
 	"github.com/mozilla/crlite/go"
 	"github.com/mozilla/crlite/go/config"
+	"github.com/mozilla/crlite/go/crlcheck"
 	"github.com/mozilla/crlite/go/downloader"
 	"github.com/mozilla/crlite/go/engine"
+	"github.com/mozilla/crlite/go/logging"
 	"github.com/mozilla/crlite/go/rootprogram"
 	"github.com/mozilla/crlite/go/storage"
+	"github.com/mozilla/crlite/go/telemetry"
 	"github.com/vbauerster/mpb/v5"
 	"github.com/vbauerster/mpb/v5/decor"
 )
 
+// The modes output files and folders are created with, before the umask, as
+// set by -filemode and -dirmode
+var (
+	permMode    os.FileMode = defaultPermMode
+	permModeDir os.FileMode = defaultPermModeDir
+)
+
 const (
-	permMode    = 0644
-	permModeDir = 0755
+	defaultPermMode    = 0644
+	defaultPermModeDir = 0755
+	// Downloads spend most of their time waiting on the network
+	downloadWorkersPerCPU = 4
+	// Most filesystems cap a name at 255 bytes, and the downloader writes to
+	// tmp and partial download siblings first
+	maxCrlFilenameLength = 255 - downloader.MaxSuffixLength
+	// A tmp file or partial download untouched for this long is from an
+	// interrupted run, rather than another run's download in progress
+	orphanedTmpFileAge = time.Hour
+	// As -revokedpath, writes the one filtered issuer's serials to stdout
+	revokedPathStdout = "-"
+	// Exit status when some issuers' revoked serials couldn't be saved, once
+	// everything else has been
+	exitStoreFailed = 4
+	// Exit status when the run was stopped, by a signal or -maxruntime, once
+	// its partial results have been saved
+	exitStopped = 5
 )
 
 var (
-	inccadb      = flag.String("ccadb", "<path>", "input CCADB CSV path")
+	ccadburl     = flag.String("ccadburl", "<url>", "input CCADB CSV URL, fetched directly instead of -ccadb")
+	ccadbretries = flag.Uint("ccadbretries", rootprogram.DefaultLoadRetries, "times to retry fetching CCADB over the network, with backoff, before giving up")
 	crlpath      = flag.String("crlpath", "<path>", "root of folders of the form /<path>/<issuer> containing .crl files to be updated")
-	revokedpath  = flag.String("revokedpath", "<path>", "output folder of revoked serial files of the form <issuer>")
+	crlsource    = flag.String("crlsource", crlSourceDownload, "where the CRLs come from: download, fetching each issuer's CRLs into crlpath, or local, aggregating the .crl files already in crlpath/<issuer> without identifying or downloading any, e.g. to replay a run offline; local can't be combined with -ocspout or -ccadbcoverage")
+	revokedpath  = flag.String("revokedpath", "<path>", "output folder of revoked serial files of the form <issuer>, or - to write a single -issuerfilter issuer's serials to stdout")
 	enrolledpath = flag.String("enrolledpath", "<path>", "output JSON file of issuers with their enrollment status")
+	enrollchange = flag.String("enrollmentchangesout", "<path>", "output JSON file of the issuers newly enrolled and no longer enrolled since the previous run's enrolledpath file; not written when there's no previous file")
 	auditpath    = flag.String("auditpath", "<path>", "output JSON audit report")
-	nobars       = flag.Bool("nobars", false, "disable display of download bars")
+	ocspout      = flag.String("ocspout", "<path>", "output JSON file of in-program issuers with no CRLs and their OCSP URLs")
+	badurlsout   = flag.String("badurlsout", "<path>", "output JSON file of malformed CRL URLs that were skipped, with their issuers")
+	nobars       = flag.Bool("nobars", false, "disable display of progress bars, e.g. when logging under CI or cron")
+	outbackend   = flag.String("output-backend", "disk", "where to write revoked serial files: disk or s3")
+	s3bucket     = flag.String("s3bucket", "", "S3 bucket for revoked serial files, with -output-backend=s3")
+	s3prefix     = flag.String("s3prefix", "", "S3 key prefix for revoked serial files, with -output-backend=s3")
+	generational = flag.Bool("generational-output", false, "write revoked serial files to a new <revokedpath>/<timestamp> folder each run, and repoint the <revokedpath>/latest symlink at it once the run succeeds; needs -output-backend=disk")
+	deltapath    = flag.String("deltapath", "<path>", "output folder of per-issuer files listing the revoked serials added (+hex) and removed (-hex) since the previous run's revokedpath files; needs -output-backend=disk")
+	manifestout  = flag.String("manifestout", "<path>", "output JSON path listing the CRL files used for each issuer")
+	crlsigalgs   = flag.String("crlsigalgs", "", "comma-separated CRL signature algorithms to accept, e.g. SHA256-RSA,ECDSA-SHA256; empty accepts any")
+	dlthreads    = flag.Int("downloadthreads", 0, "number of concurrent CRL download workers, 0 for a multiple of the CPU count")
+	aggthreads   = flag.Int("aggregatethreads", 0, "number of concurrent CRL aggregation workers, 0 for the CPU count")
+	issuerfilter = flag.String("issuerfilter", "", "comma-separated issuer IDs to restrict processing to, or @<path> to a file listing one per line")
+	serialformat = flag.String("serialformat", "default", "format of revoked serial files with -output-backend=disk: default (hex lines), binary, or spki-bundle (PEM blocks headed by issuer ID, also concatenated into "+storage.SerialsBundleName+")")
+	logjson      = flag.Bool("logjson", false, "write logs as JSON lines to stderr instead of through glog")
+	hostrps      = flag.Float64("hostrps", 0, "maximum CRL download requests per second to any one host, 0 for no limit")
+	maxcrlsper   = flag.Int("maxcrlsperissuer", 0, "download at most this many CRLs for any one issuer, dropping the rest with a warning, to bound the fan-out from a bad CCADB entry; 0 for no limit")
+	jitter       = flag.Duration("jitter", 0, "each download worker waits a random time up to this before starting each issuer, including its first, to spread out the initial burst of requests; 0 disables")
+	maxcrlsize   = flag.Int64("maxcrlsize", downloader.DefaultMaxDownloadSize, "maximum size in bytes of a CRL download, and of a gzip-wrapped CRL once decompressed, 0 for no limit")
+	maxclockskew = flag.Duration("maxclockskew", crlcheck.MaxClockSkew, "reject CRLs whose thisUpdate is more than this far in the future, allowing for clock skew with the CA; 0 for no limit")
+	streamcrls   = flag.Int64("streamcrlsover", crlcheck.StreamingThreshold, "read DER CRL files larger than this many bytes one entry at a time, rather than whole; 0 always reads them whole")
+	useragent    = flag.String("useragent", downloader.DefaultUserAgent, "User-Agent header sent with CRL downloads")
+	proxy        = flag.String("proxy", "", "proxy URL for CRL downloads, e.g. http://proxy:3128, overriding HTTP_PROXY/HTTPS_PROXY/NO_PROXY")
+	tlsroots     = flag.String("tlsrootbundle", "", "PEM file of root certificates to trust, in addition to the system's, for TLS to CRL servers and -proxy, e.g. behind a TLS-intercepting proxy")
+	crltimeout   = flag.Duration("crltimeout", 0, "deadline for each CRL download attempt, after which it's retried; 0 for no limit")
+	refetchafter = flag.Duration("refetchafter", 0, "reuse a cached CRL without contacting its server while its local copy is younger than this, by modification time; 0 always checks")
+	maxruntime   = flag.Duration("maxruntime", 0, "stop gracefully, as on SIGTERM, once the run has taken this long, saving the partial results and exiting with status 5; 0 for no limit")
+	expirybucket = flag.String("expirybuckets", "", "split revoked serial files by certificate expiry into per-period folders (or S3 prefixes): month or day; empty writes one file per issuer")
+	skipbadccadb = flag.Bool("ccadbskipinvalid", false, "leave out and log CCADB rows whose certificates can't be decoded, rather than failing to load CCADB")
+	inactive     = flag.Bool("includeinactive", false, "keep CCADB certificates that are revoked or expired, which are otherwise excluded")
+	checkonecrl  = flag.Bool("onecrl", false, "fetch OneCRL and never enroll issuers with a certificate revoked there")
+	rootstore    = flag.String("rootstore", "", "PEM file of trusted root certificates, such as CCADB's included roots report; if set, issuers with no certificate chaining to one of them, through the CCADB certificates, are never enrolled")
+	ccadbcover   = flag.Bool("ccadbcoverage", false, "check every CRL URL CCADB lists for an issuer was fetched and validated, listing any that weren't as missingCrlUrls in the enrolled output, and any only covering attribute certificates as outOfScopeCrlUrls")
+	insecuresig  = flag.Bool("insecure-skip-crl-signature", false, "UNSAFE, for testing only: accept CRLs without verifying their signatures")
+	crloverrides = flag.String("crloverrides", "", "JSON file mapping issuer IDs or CRL URLs to replacement CRL URLs")
+	forcerevoked = flag.String("forcerevoked", "", "JSON file mapping issuer IDs to arrays of hex serials to save as revoked even if their CRLs don't list them")
+	forceunrevok = flag.String("forceunrevoked", "", "JSON file mapping issuer IDs to arrays of hex serials to leave out of the saved revoked serials even if their CRLs list them")
+	failfraction = flag.Float64("failfraction", 1.0, "exit with status 3 if more than this fraction (0.0-1.0) of enrollable issuers failed to produce usable CRLs")
+	invalidout   = flag.String("invaliditydatesout", "<path>", "output JSON file of the invalidity date, from the CRL entry extension, of each revoked serial which has one, by issuer ID")
+	timingout    = flag.String("timingout", "<path>", "output JSON file of the time spent downloading, processing, and storing each issuer's CRLs, slowest first")
+	failreport   = flag.String("failreport", "<path>", "output JSON report of the issuers counted against -failfraction")
+	minfreemib   = flag.Uint64("minfreemib", 0, "fail at startup unless crlpath and the revokedpath folder each have at least this many MiB available; 0 skips the check")
+	metasnapshot = flag.String("metadatasnapshot", "", "input JSON file written by export-metadata to read issuer metadata from instead of the configured cache, e.g. to develop offline; can't be combined with -expirybuckets, as it holds no serials")
+	filemode     = flag.String("filemode", "0644", "octal mode of the output files written, such as revoked serial files and enrolledpath; must let the owner read and write")
+	dirmode      = flag.String("dirmode", "0755", "octal mode of the output folders made, such as crlpath's and revokedpath's; must let the owner read, write, and search")
+	strictissuer = flag.Bool("strict-issuers", false, "before downloading any CRLs, check every in-program issuer to be processed has a certificate to verify them with, and fail listing those without one")
+	hashserials  = flag.Bool("hashserials", false, "write SHA-256(issuer SPKI || serial) in place of each raw revoked serial, as described at storage.HashSerial, so no raw serials are persisted; applies to revokedpath and deltapath files, not to other outputs such as invaliditydatesout; can't be combined with -expirybuckets")
+	compressout  = flag.Bool("compress-output", false, "gzip each revoked serial file, written as <issuer>.gz, with either -output-backend; this repo's tools read both forms, but other readers must decompress them; can't be combined with a revokedpath of -")
+	metricsaddr  = flag.String("metricsaddr", "", "address, e.g. :9100, on which to serve Prometheus-style progress counters; empty disables")
+	pprofaddr    = flag.String("pprofaddr", "", "address, e.g. localhost:6060, on which to serve net/http/pprof under /debug/pprof/; empty disables")
+	cpuprofile   = flag.String("cpuprofile", "<path>", "output CPU profile covering the whole run")
+	memprofile   = flag.String("memprofile", "<path>", "output heap profile, taken once CRLs are aggregated")
 	ctconfig     = config.NewCTConfig()
+	inccadbs     config.StringList
 
 	illegalPath = regexp.MustCompile(`[^[:alnum:]\~\-\./]`)
 
@@ -57,9 +145,87 @@ type AggregateEngine struct {
 	saveStorage   storage.StorageBackend
 	remoteCache   storage.RemoteCache
 
-	issuers *rootprogram.MozIssuers
-	display *mpb.Progress
-	auditor *CrlAuditor
+	issuers     *rootprogram.MozIssuers
+	display     *mpb.Progress
+	auditor     *CrlAuditor
+	dlOptions   downloader.DownloadOptions
+	hostLimiter *downloader.HostRateLimiter
+	manifest    *types.CrlManifest
+	progress    *telemetry.Progress
+
+	downloadThreads  int
+	aggregateThreads int
+	// Cached CRLs younger than this are used without contacting the server;
+	// 0 always checks
+	refetchAfter time.Duration
+	// Download workers wait a random time up to this before each issuer
+	jitter time.Duration
+	// Issuers with more CRL URLs than this have the rest dropped; 0 for no
+	// limit
+	maxCrlsPerIssuer int
+	// The IDs of issuers whose CRL URLs were dropped, which can't be
+	// enrolled. Only written before the download workers start.
+	truncatedIssuers map[string]bool
+	// Whether to check issuers' CRLs cover those CCADB lists for them
+	checkCcadbCoverage bool
+	// Whether to fail before downloading if any issuer to process has no
+	// certificate
+	strictIssuers bool
+
+	// If non-nil, only these issuer IDs are processed
+	issuerFilter map[string]bool
+	// If non-nil, revoked serials are saved split by certificate expiry
+	// rather than through saveStorage
+	expiryBuckets *expiryBucketer
+	// If non-nil, replacements for broken CRL URLs
+	crlOverrides *crlOverrides
+	// If non-nil, serials forced into or out of issuers' revoked serials
+	serialOverrides *serialOverrides
+	// If non-nil, revoked serials are written here as hex lines rather than
+	// saved
+	serialOut io.Writer
+	// Whether revoked serials are replaced by storage.HashSerial of them
+	// before being written anywhere
+	hashSerials bool
+	// If non-nil, each enrolled issuer's changes since the previous run are
+	// written before its serials are saved
+	deltas *deltaWriter
+	// If non-nil, records how long each issuer took in each stage
+	timings *issuerTimings
+	// If non-nil, collects the invalidity dates of revoked serials
+	invalidityDates *invalidityDates
+	// Progress through all the phases, set up by identifyCrlsByIssuer
+	runProgress *runProgress
+	// Whether downloadCRLs was stopped before downloading every issuer. Set
+	// before it closes its results, so it's read once they're drained.
+	downloadsStopped bool
+	// Closed by downloadCRLs once downloadsStopped is set
+	downloadsFinished chan struct{}
+}
+
+// An issuer that couldn't be aggregated: its revoked serials couldn't be
+// saved, or it has no certificate to check its CRLs against
+type issuerError struct {
+	Issuer storage.Issuer
+	Reason rootprogram.EnrollmentReason
+	Err    error
+}
+
+func (e issuerError) Error() string {
+	return fmt.Sprintf("[%s] %s", e.Issuer.ID(), e.Err)
+}
+
+// Where the progress bars go. With -nobars that's nil, for which mpb neither
+// renders nor writes anything, so logs under CI or cron stay clean.
+func progressOutput(aNoBars bool, aSerialsToStdout bool) io.Writer {
+	if aNoBars {
+		return nil
+	}
+	// Keep stdout for the serials alone
+	if aSerialsToStdout {
+		return os.Stderr
+	}
+	return os.Stdout
 }
 
 func makeFilenameFromUrl(crlUrl url.URL) string {
@@ -68,17 +234,22 @@ func makeFilenameFromUrl(crlUrl url.URL) string {
 	filename = illegalPath.ReplaceAllString(filename, "")
 
 	hash := sha256.Sum256([]byte(crlUrl.String()))
+	suffix := fmt.Sprintf("-%s.crl", hex.EncodeToString(hash[:8]))
 
+	// The hash covers the whole URL, so it keeps truncated names unique
 	filename = strings.TrimSuffix(filename, ".crl")
-	filename = fmt.Sprintf("%s-%s.crl", filename, hex.EncodeToString(hash[:8]))
-	return filename
+	if maxPrefix := maxCrlFilenameLength - len(suffix); len(filename) > maxPrefix {
+		filename = filename[:maxPrefix]
+	}
+	return filename + suffix
 }
 
 func (ae *AggregateEngine) findCrlWorker(ctx context.Context, wg *sync.WaitGroup,
-	issuerChan <-chan storage.Issuer, resultChan chan<- types.IssuerCrlMap, progBar *mpb.Bar) {
+	issuerChan <-chan storage.Issuer, issuerCrls *types.ConcurrentIssuerCrlMap,
+	ocspResultChan chan<- types.IssuerOcspMap, progBar *mpb.Bar) {
 	defer wg.Done()
 
-	issuerCrls := make(types.IssuerCrlMap)
+	issuerOcsps := make(types.IssuerOcspMap)
 
 	for issuer := range issuerChan {
 		select {
@@ -87,51 +258,190 @@ func (ae *AggregateEngine) findCrlWorker(ctx context.Context, wg *sync.WaitGroup
 		default:
 			meta := ae.loadStorageDB.GetIssuerMetadata(issuer)
 
-			crls, prs := issuerCrls[issuer.ID()]
-			if !prs {
-				crls = make(map[string]bool)
-			}
-
 			crlSet := meta.CRLs()
+			if len(crlSet) == 0 && ae.issuers.IsIssuerInProgram(issuer) {
+				crlSet = ae.crlsFromIssuerCert(issuer)
+			}
 
 			if len(crlSet) == 0 {
 				if ae.issuers.IsIssuerInProgram(issuer) {
+					ae.issuers.MarkUnenrolled(issuer, rootprogram.ReasonNoCrls)
+
 					issuerSubj, err := ae.issuers.GetSubjectForIssuer(issuer)
 					if err != nil {
-						glog.Warningf("No known CRLs and couldn't get subject for issuer=%s that is in the root program: %s",
+						logging.Warningf("No known CRLs and couldn't get subject for issuer=%s that is in the root program: %s",
 							issuer.ID(), err)
 					} else {
-						glog.Infof("No known CRLs for issuer=%s (%s) in the root program. Not enrolling into CRLite.",
+						logging.Infof("No known CRLs for issuer=%s (%s) in the root program. Not enrolling into CRLite.",
 							issuer.ID(), issuerSubj)
 					}
+
+					// Surface OCSP responders as candidates for a downstream checker
+					ocspSet := meta.OCSPs()
+					if len(ocspSet) > 0 {
+						ocsps := make(map[string]bool)
+						for _, url := range ocspSet {
+							ocsps[url] = true
+						}
+						issuerOcsps[issuer.ID()] = ocsps
+					}
 				}
 			}
 
-			for _, url := range crlSet {
-				crls[url] = true
-			}
-			issuerCrls[issuer.ID()] = crls
+			issuerCrls.Add(issuer.ID(), crlSet)
 
 			progBar.Increment()
+			ae.runProgress.issuerPhaseDone()
 		}
 	}
 
-	resultChan <- issuerCrls
+	ocspResultChan <- issuerOcsps
+}
+
+// Returns the URLs in the issuer certificate's own CRL Distribution Points,
+// for when no certificate it issued has named a CRL. Those name the CRL that
+// would list the certificate itself, which its parent signs, so they're only
+// the issuer's CRLs when the certificate is self-issued.
+func (ae *AggregateEngine) crlsFromIssuerCert(aIssuer storage.Issuer) []string {
+	cert, err := ae.issuers.GetCertificateForIssuer(aIssuer)
+	if err != nil || len(cert.CRLDistributionPoints) == 0 {
+		return nil
+	}
+	if !bytes.Equal(cert.RawSubject, cert.RawIssuer) {
+		logging.V(1).Infof("Not using the CRL Distribution Points of issuer=%s, which name its parent's CRLs",
+			aIssuer.ID())
+		return nil
+	}
+	logging.Infof("Using the CRL Distribution Points of issuer=%s's own certificate: %v",
+		aIssuer.ID(), cert.CRLDistributionPoints)
+	return cert.CRLDistributionPoints
 }
 
 type CrlVerifier struct {
 	expectedIssuerCert *x509.Certificate
+	signers            crlcheck.SignerLookup
+	// The cached CRL from the same URL, whose CRL number a download mustn't
+	// roll back
+	previousPath string
 }
 
 func (cv *CrlVerifier) IsValid(path string) error {
-	_, _, err := loadAndCheckSignatureOfCRL(path, cv.expectedIssuerCert)
-	return err
+	// Files for a preprocessor needn't look like CRLs until it's run
+	if crlcheck.Preprocessor == nil {
+		if err := looksLikeDER(path); err != nil {
+			return err
+		}
+	}
+	if crlcheck.ShouldStream(path) {
+		return cv.isValidStreamed(path)
+	}
+	crl, _, err := crlcheck.LoadAndCheckSignatureOfCRL(path, cv.expectedIssuerCert, cv.signers)
+	if err != nil {
+		logVerifyingCert(path, cv.expectedIssuerCert, err)
+		return err
+	}
+	// As StreamCRL does, so a CRL with untrustworthy dates doesn't replace
+	// the cached one
+	if err = crlcheck.NewValidity(crl).Check(time.Now()); err != nil {
+		return err
+	}
+	if cv.previousPath == "" || path == cv.previousPath {
+		return nil
+	}
+
+	previousCrl, _, err := crlcheck.LoadAndCheckSignatureOfCRL(cv.previousPath, cv.expectedIssuerCert, cv.signers)
+	if err != nil {
+		// Nothing usable is cached, so there's nothing to roll back
+		return nil
+	}
+	return crlcheck.CheckCRLNumberNotRegressed(crl, previousCrl)
+}
+
+// As IsValid, for a CRL too large to read whole
+func (cv *CrlVerifier) isValidStreamed(path string) error {
+	validity, _, err := loadCrlValidity(path, cv.expectedIssuerCert, cv.signers)
+	if err != nil {
+		logVerifyingCert(path, cv.expectedIssuerCert, err)
+		return err
+	}
+	if cv.previousPath == "" || path == cv.previousPath {
+		return nil
+	}
+
+	previous, _, err := loadCrlValidity(cv.previousPath, cv.expectedIssuerCert, cv.signers)
+	if err != nil {
+		return nil
+	}
+	return crlcheck.CheckNumberNotRegressed(validity.Number, previous.Number)
+}
+
+// Checks the CRL at aPath and returns its validity and digest, streaming it
+// if it's large
+func loadCrlValidity(aPath string, aIssuerCert *x509.Certificate,
+	aSigners crlcheck.SignerLookup) (crlcheck.Validity, []byte, error) {
+	if crlcheck.ShouldStream(aPath) {
+		streamed, err := crlcheck.StreamCRL(aPath, aIssuerCert, aSigners, false)
+		if err != nil {
+			return crlcheck.Validity{}, nil, err
+		}
+		return streamed.Validity, streamed.SHA256, nil
+	}
+
+	crl, sha256sum, err := crlcheck.LoadAndCheckSignatureOfCRL(aPath, aIssuerCert, aSigners)
+	if err != nil {
+		return crlcheck.Validity{}, nil, err
+	}
+	return crlcheck.NewValidity(crl), sha256sum, nil
+}
+
+// Logs which issuer certificate a CRL failed to verify against, which is
+// the first thing to check after a CA rotates keys. The audit already records
+// the failure, so this is only at -v=1.
+func logVerifyingCert(aSubject string, aCert *x509.Certificate, aErr error) {
+	logging.V(1).Infof("[%s] Failed to verify against issuer certificate %s (%s): %s", aSubject,
+		crlcheck.CertFingerprint(aCert), aCert.Subject.String(), aErr)
+}
+
+// A cheap sniff of the first few bytes, so that obviously-wrong content like
+// an HTML error page is rejected before the full parse. Accepts the start of
+// a DER SEQUENCE, a PEM header, or gzip magic.
+func looksLikeDER(aPath string) error {
+	fd, err := os.Open(aPath)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	header := make([]byte, 64)
+	n, err := io.ReadFull(fd, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return fmt.Errorf("Could not read CRL header: %s", err)
+	}
+	header = header[:n]
+
+	if n >= 2 && header[0] == 0x30 {
+		// Short-form length, or a long-form length of 1-4 bytes
+		if header[1] < 0x80 || (header[1] > 0x80 && header[1] <= 0x84) {
+			return nil
+		}
+	}
+	if n >= 2 && header[0] == 0x1f && header[1] == 0x8b {
+		return nil
+	}
+	if bytes.HasPrefix(bytes.TrimLeft(header, " \t\r\n"), []byte(crlcheck.PemHeaderPrefix)) {
+		return nil
+	}
+
+	if len(header) > 16 {
+		header = header[:16]
+	}
+	return fmt.Errorf("Content does not look like a CRL (starts with %q)", header)
 }
 
 func (ae *AggregateEngine) crlFetchWorkerProcessOne(ctx context.Context, crlUrl url.URL, issuer storage.Issuer) (string, error) {
 	err := os.MkdirAll(filepath.Join(*crlpath, issuer.ID()), permModeDir)
 	if err != nil {
-		glog.Warningf("Couldn't make directory: %s", err)
+		logging.Warningf("Couldn't make directory: %s", err)
 		return "", err
 	}
 
@@ -140,51 +450,146 @@ func (ae *AggregateEngine) crlFetchWorkerProcessOne(ctx context.Context, crlUrl
 
 	cert, err := ae.issuers.GetCertificateForIssuer(issuer)
 	if err != nil {
-		glog.Fatalf("[%s] Could not find certificate for issuer: %s", issuer.ID(), err)
+		logging.Errorf("[%s] Could not find certificate for issuer: %s", issuer.ID(), err)
+		return "", err
 	}
 
 	verifyFunc := &CrlVerifier{
 		expectedIssuerCert: cert,
+		signers:            ae.issuers,
+		previousPath:       finalPath,
 	}
 
-	fileOnDiskIsAcceptable, dlErr := downloader.DownloadAndVerifyFileSync(ctx, verifyFunc, ae.auditor, &issuer, ae.display, crlUrl, finalPath, 3)
+	var fileOnDiskIsAcceptable bool
+	var dlErr error
+	if ae.localCrlIsFresh(finalPath) && verifyFunc.IsValid(finalPath) == nil {
+		logging.V(1).Infof("[%s] Local copy is younger than %s, not contacting the server", crlUrl.String(),
+			ae.refetchAfter)
+		fileOnDiskIsAcceptable = true
+	} else {
+		// The HEAD request and any download that follows it are one visit
+		// to the host, so they share one wait
+		if ae.hostLimiter != nil {
+			if err := ae.hostLimiter.Wait(ctx, crlUrl.Hostname()); err != nil {
+				return "", err
+			}
+		}
+
+		if ae.localCrlIsCurrent(ctx, crlUrl, finalPath) && verifyFunc.IsValid(finalPath) == nil {
+			logging.V(1).Infof("[%s] Local copy matches the remote size and date, not downloading", crlUrl.String())
+			fileOnDiskIsAcceptable = true
+		} else {
+			fileOnDiskIsAcceptable, dlErr = downloader.DownloadAndVerifyFileSync(ctx, verifyFunc, ae.auditor, &issuer, ae.display, crlUrl, finalPath, 3, ae.dlOptions)
+		}
+	}
 	if !fileOnDiskIsAcceptable {
-		glog.Errorf("[%s] Could not download, and no local file, will not be populating the "+
+		logging.Errorf("[%s] Could not download, and no local file, will not be populating the "+
 			"revocations: %s", crlUrl.String(), dlErr)
 		return "", dlErr
 	}
 	if dlErr != nil {
-		glog.Errorf("[%s] Problem downloading: %s", crlUrl.String(), dlErr)
+		logging.Errorf("[%s] Problem downloading: %s", crlUrl.String(), dlErr)
 	}
 
 	// Ensure the final path is acceptable
 	localSize, localDate, err := downloader.GetSizeAndDateOfFile(finalPath)
 	if err != nil {
-		glog.Errorf("[%s] Unexpected error on local file, will not be populating the "+
+		logging.Errorf("[%s] Unexpected error on local file, will not be populating the "+
 			"revocations: %s", crlUrl.String(), err)
 		return "", err
 	}
 
-	age := time.Now().Sub(localDate)
+	now := time.Now()
+	age := now.Sub(localDate)
+
+	validity, sha256sum, err := loadCrlValidity(finalPath, cert, ae.issuers)
+	if err != nil {
+		logVerifyingCert(crlUrl.String(), cert, err)
+		logging.Errorf("[%s] Unexpected error loading local CRL, will not be populating the "+
+			"revocations: %s", crlUrl.String(), err)
+		return "", err
+	}
 
-	if age > allowableAgeOfLocalCRL {
-		ae.auditor.Old(&issuer, &crlUrl, age)
-		glog.Warningf("[%s] CRL appears not very fresh, but proceeding with expiration check. Age: %s", crlUrl.String(), age)
+	if ae.manifest != nil {
+		ae.manifest.Add(issuer, types.CrlManifestEntry{
+			Url:        crlUrl.String(),
+			Path:       finalPath,
+			SHA256:     hex.EncodeToString(sha256sum),
+			Size:       localSize,
+			ThisUpdate: validity.ThisUpdate,
+			NextUpdate: validity.NextUpdate,
+			CrlNumber:  validity.Number,
+			FromCache:  dlErr != nil,
+		})
 	}
 
-	glog.Infof("[%s] Updated CRL %s (path=%s) (sz=%d) (age=%s)", issuer.ID(), crlUrl.String(),
+	if validity.IsStaleAt(now, localDate, allowableAgeOfLocalCRL) {
+		if validity.HasNextUpdate() {
+			ae.auditor.Expired(&issuer, &crlUrl, validity.NextUpdate)
+			logging.Warningf("[%s] CRL is past its nextUpdate, but proceeding anyway. (ThisUpdate=%s, NextUpdate=%s)",
+				crlUrl.String(), validity.ThisUpdate, validity.NextUpdate)
+		} else {
+			ae.auditor.Old(&issuer, &crlUrl, age)
+			logging.Warningf("[%s] CRL has no nextUpdate and appears not very fresh, but proceeding anyway. Age: %s",
+				crlUrl.String(), age)
+		}
+	}
+
+	logging.Infof("[%s] Updated CRL %s (path=%s) (sz=%d) (age=%s)", issuer.ID(), crlUrl.String(),
 		finalPath, localSize, age)
 
 	return finalPath, nil
 }
 
+// Whether the local copy at aPath was modified within ae.refetchAfter. The
+// downloader sets that to the server's Last-Modified, when it sends one.
+func (ae *AggregateEngine) localCrlIsFresh(aPath string) bool {
+	if ae.refetchAfter <= 0 {
+		return false
+	}
+	_, localDate, err := downloader.GetSizeAndDateOfFile(aPath)
+	if err != nil {
+		return false
+	}
+	return time.Since(localDate) < ae.refetchAfter
+}
+
+// Whether the server reports the same size, and no newer date, than the local
+// copy at aPath. Any failure to tell means the CRL should be downloaded.
+func (ae *AggregateEngine) localCrlIsCurrent(ctx context.Context, crlUrl url.URL, aPath string) bool {
+	localSize, localDate, err := downloader.GetSizeAndDateOfFile(aPath)
+	if err != nil {
+		return false
+	}
+
+	remoteSize, remoteDate, err := downloader.GetRemoteSizeAndDate(ctx, crlUrl, ae.dlOptions)
+	if err != nil {
+		logging.V(1).Infof("[%s] Couldn't get the remote size and date: %s", crlUrl.String(), err)
+		return false
+	}
+
+	return remoteSize == localSize && !remoteDate.After(localDate)
+}
+
 func (ae *AggregateEngine) crlFetchWorker(ctx context.Context, wg *sync.WaitGroup,
 	crlsChan <-chan types.IssuerCrlUrls, resultChan chan<- types.IssuerCrlUrlPaths, progBar *mpb.Bar) {
 	defer wg.Done()
 
 	for tuple := range crlsChan {
+		if err := downloader.WaitJitter(ctx, ae.jitter); err != nil {
+			return
+		}
+
+		start := time.Now()
 		urlPaths := make([]types.UrlPath, 0)
 
+		issuerDir := filepath.Join(*crlpath, tuple.Issuer.ID())
+		if removed, err := downloader.RemoveOrphanedTmpFiles(issuerDir, orphanedTmpFileAge); err != nil {
+			logging.Warningf("[%s] Couldn't remove orphaned tmp files: %s", tuple.Issuer.ID(), err)
+		} else if removed > 0 {
+			logging.Infof("[%s] Removed %d orphaned tmp files", tuple.Issuer.ID(), removed)
+		}
+
 		for _, crlUrl := range tuple.Urls {
 			select {
 			case <-ctx.Done():
@@ -194,16 +599,22 @@ func (ae *AggregateEngine) crlFetchWorker(ctx context.Context, wg *sync.WaitGrou
 
 			path, err := ae.crlFetchWorkerProcessOne(ctx, crlUrl, tuple.Issuer)
 			if err != nil {
-				glog.Warningf("[%s] CRL %s path=%s had error=%s", tuple.Issuer.ID(), crlUrl.String(), path, err)
+				ae.progress.CrlFailed()
+				logging.Warningf("[%s] CRL %s path=%s had error=%s", tuple.Issuer.ID(), crlUrl.String(), path, err)
+			} else {
+				ae.progress.CrlDownloaded()
 			}
 			// Even if err is set, pass the blank path to the results, so we
 			// can use it in enrolled/not enrolled determination
 			urlPaths = append(urlPaths, types.UrlPath{Path: path, Url: crlUrl})
 		}
+		if ae.timings != nil {
+			ae.timings.record(tuple.Issuer, stageDownload, time.Since(start))
+		}
 
 		subj, err := ae.issuers.GetSubjectForIssuer(tuple.Issuer)
 		if err != nil {
-			glog.Error(err)
+			logging.Error(err)
 		}
 
 		resultChan <- types.IssuerCrlUrlPaths{
@@ -213,39 +624,22 @@ func (ae *AggregateEngine) crlFetchWorker(ctx context.Context, wg *sync.WaitGrou
 		}
 
 		progBar.Increment()
+		ae.runProgress.issuerPhaseDone()
 	}
 }
 
-func loadAndCheckSignatureOfCRL(aPath string, aIssuerCert *x509.Certificate) (*pkix.CertificateList, []byte, error) {
-	crlBytes, err := ioutil.ReadFile(aPath)
-	if err != nil {
-		return nil, []byte{}, fmt.Errorf("Error reading CRL, will not process revocations: %s", err)
-	}
-
-	crl, err := x509.ParseCRL(crlBytes)
-	if err != nil {
-		return nil, []byte{}, fmt.Errorf("Error parsing, will not process revocations: %s", err)
-	}
-
-	if err = aIssuerCert.CheckCRLSignature(crl); err != nil {
-		return nil, []byte{}, fmt.Errorf("Invalid signature on CRL, will not process revocations: %s", err)
-	}
-
-	shasum := sha256.Sum256(crlBytes)
-	return crl, shasum[:], err
-}
-
 func (ae *AggregateEngine) verifyCRL(aIssuer storage.Issuer, dlTracer *downloader.DownloadTracer, crlUrl *url.URL, aPath string, aIssuerCert *x509.Certificate, aPreviousPath string) (*pkix.CertificateList, error) {
-	glog.V(1).Infof("[%s] Verifying CRL from URL %s", aPath, crlUrl)
+	logging.V(1).Infof("[%s] Verifying CRL from URL %s", aPath, crlUrl)
 
-	crl, _, err := loadAndCheckSignatureOfCRL(aPath, aIssuerCert)
+	crl, _, err := crlcheck.LoadAndCheckSignatureOfCRL(aPath, aIssuerCert, ae.issuers)
 	if err != nil {
+		logVerifyingCert(crlUrl.String(), aIssuerCert, err)
 		ae.auditor.FailedVerifyUrl(&aIssuer, crlUrl, dlTracer, err)
 		return nil, err
 	}
 
 	if _, err = os.Stat(aPreviousPath); err == nil {
-		previousCrl, _, err := loadAndCheckSignatureOfCRL(aPreviousPath, aIssuerCert)
+		previousCrl, _, err := crlcheck.LoadAndCheckSignatureOfCRL(aPreviousPath, aIssuerCert, ae.issuers)
 		if err != nil {
 			ae.auditor.FailedVerifyPath(&aIssuer, crlUrl, aPreviousPath, err)
 			return nil, err
@@ -256,46 +650,68 @@ func (ae *AggregateEngine) verifyCRL(aIssuer storage.Issuer, dlTracer *downloade
 			return previousCrl, fmt.Errorf("[%s] CRL is older than the previous CRL (previous=%s, this=%s)",
 				aPath, previousCrl.TBSCertList.ThisUpdate, crl.TBSCertList.ThisUpdate)
 		}
+
+		if err = crlcheck.CheckCRLNumberNotRegressed(crl, previousCrl); err != nil {
+			ae.auditor.FailedVerifyUrl(&aIssuer, crlUrl, dlTracer, err)
+			return previousCrl, fmt.Errorf("[%s] %s", aPath, err)
+		}
 	}
 
 	if crl.HasExpired(time.Now()) {
 		ae.auditor.Expired(&aIssuer, crlUrl, crl.TBSCertList.NextUpdate)
-		glog.Warningf("[%s] CRL is expired, but proceeding anyway. (ThisUpdate=%s,"+
+		logging.Warningf("[%s] CRL is expired, but proceeding anyway. (ThisUpdate=%s,"+
 			" NextUpdate=%s)", aPath, crl.TBSCertList.ThisUpdate, crl.TBSCertList.NextUpdate)
 	}
 
 	return crl, nil
 }
 
-func processCRL(aCRL *pkix.CertificateList) ([]storage.Serial, error) {
-	revokedList, err := types.DecodeRawTBSCertList(aCRL.TBSCertList.Raw)
-	if err != nil {
-		return []storage.Serial{}, fmt.Errorf("CRL list couldn't be decoded: %s", err)
-	}
-
-	serials := make([]storage.Serial, 0, 1024*16)
-	for _, ent := range revokedList.RevokedCertificates {
-		serial := storage.NewSerialFromBytes(ent.SerialNumber.Bytes)
-		serials = append(serials, serial)
-	}
-
-	return serials, nil
-}
-
+// Sends each issuer that couldn't be aggregated to errChan, leaving the
+// issuer unenrolled, and carries on with the rest.
 func (ae *AggregateEngine) aggregateCRLWorker(ctx context.Context, wg *sync.WaitGroup,
-	workChan <-chan types.IssuerCrlUrlPaths, progBar *mpb.Bar) {
+	workChan <-chan types.IssuerCrlUrlPaths, errChan chan<- issuerError, progBar *mpb.Bar) {
 	defer wg.Done()
 
 	for tuple := range workChan {
+		start := time.Now()
 		anyCrlFailed := false
+		// Kept apart, since CRLs that fail validation point at a CA problem,
+		// while failed downloads are often transient
+		failedDownloadCount := 0
+		failedValidationCount := 0
+		// Valid, but only for attribute certificates, so skipped
+		outOfScopeCount := 0
+		// A CRL can validate yet list nothing, which still means the issuer
+		// is maintaining it
+		anyCrlValid := false
 
 		cert, err := ae.issuers.GetCertificateForIssuer(tuple.Issuer)
 		if err != nil {
-			glog.Fatalf("[%s] Could not find certificate for issuer: %s", tuple.Issuer.ID(), err)
+			// None of its CRLs can be verified, but the rest of the run can
+			// carry on
+			logging.Errorf("[%s] Could not find certificate for issuer: %s", tuple.Issuer.ID(), err)
+			ae.issuers.MarkUnenrolled(tuple.Issuer, rootprogram.ReasonNoIssuerCertificate)
+			metrics.IncrCounter([]string{"aggregateCRLWorker", "notEnrolled", string(rootprogram.ReasonNoIssuerCertificate)}, 1)
+			errChan <- issuerError{Issuer: tuple.Issuer, Reason: rootprogram.ReasonNoIssuerCertificate, Err: err}
+			ae.progress.IssuerProcessed()
+			progBar.Increment()
+			ae.runProgress.issuerPhaseDone()
+			continue
 		}
 
 		serialCount := 0
 		serials := make([]storage.Serial, 0, 128*1024)
+		// Shards and base+delta CRLs can overlap, so only keep the first
+		// occurrence of each serial
+		serialSet := types.NewSerialSet()
+		// Mirrors, and http vs https, often serve byte-identical CRLs under
+		// different URLs, so only process each distinct CRL once
+		processedHashes := make(map[string]string)
+		// The URLs whose CRLs were fetched and validated
+		covered := make(map[string]bool)
+		// The URLs whose CRLs were fetched and validated, but only cover
+		// attribute certificates
+		outOfScope := make(map[string]bool)
 
 		for _, crlUrlPath := range tuple.CrlUrlPaths {
 			select {
@@ -304,91 +720,285 @@ func (ae *AggregateEngine) aggregateCRLWorker(ctx context.Context, wg *sync.Wait
 			default:
 				if crlUrlPath.Path == "" {
 					anyCrlFailed = true
+					failedDownloadCount++
 					// DownloadAndVerifyFileSync already notified the auditor
-					glog.Errorf("[%+v] Failed to download: %s", crlUrlPath, err)
+					logging.Errorf("[%+v] Failed to download: %s", crlUrlPath, err)
 					continue
 				}
 
-				crl, sha256sum, err := loadAndCheckSignatureOfCRL(crlUrlPath.Path, cert)
+				// Large CRLs are streamed, which checks and processes
+				// them in one pass
+				var streamed *crlcheck.StreamedCRL
+				var crl *pkix.CertificateList
+				var sha256sum []byte
+				var err error
+				if crlcheck.ShouldStream(crlUrlPath.Path) {
+					streamed, err = crlcheck.StreamCRL(crlUrlPath.Path, cert, ae.issuers, true)
+					if err == nil {
+						sha256sum = streamed.SHA256
+					}
+				} else {
+					crl, sha256sum, err = crlcheck.LoadAndCheckSignatureOfCRL(crlUrlPath.Path, cert, ae.issuers)
+				}
 				if err != nil {
 					anyCrlFailed = true
+					failedValidationCount++
 					ae.auditor.FailedVerifyPath(&tuple.Issuer, &crlUrlPath.Url, crlUrlPath.Path, err)
-					glog.Errorf("[%+v] Failed to verify: %s", crlUrlPath, err)
+					logging.Errorf("[%+v] Failed to verify: %s", crlUrlPath, err)
+					logVerifyingCert(crlUrlPath.Url.String(), cert, err)
 					continue
 				}
 
-				revokedSerials, err := processCRL(crl)
+				// Attribute certificates aren't covered by CRLite, so
+				// such a CRL can't show that the issuer is covered
+				var attributeCertsOnly bool
+				if streamed != nil {
+					attributeCertsOnly = streamed.AttributeCertsOnly
+				} else {
+					attributeCertsOnly, err = crlcheck.IsAttributeCertCRL(crl)
+				}
 				if err != nil {
 					anyCrlFailed = true
+					failedValidationCount++
 					ae.auditor.FailedProcessLocal(&tuple.Issuer, &crlUrlPath.Url, crlUrlPath.Path, err)
-					glog.Errorf("[%+v] Failed to process: %s", crlUrlPath, err)
+					logging.Errorf("[%+v] Failed to process: %s", crlUrlPath, err)
+					continue
+				}
+				if attributeCertsOnly {
+					outOfScopeCount++
+					outOfScope[crlUrlPath.Url.String()] = true
+					logging.Infof("[%s] Skipping CRL %s, it only covers attribute certificates",
+						tuple.Issuer.ID(), crlUrlPath.Url.String())
+					metrics.IncrCounter([]string{"aggregateCRLWorker", "attributeCertCrls"}, 1)
 					continue
 				}
 
+				crlHash := hex.EncodeToString(sha256sum)
+				if firstUrl, seen := processedHashes[crlHash]; seen {
+					covered[crlUrlPath.Url.String()] = true
+					logging.Infof("[%s] Skipping CRL %s, identical to already-processed %s (sha256=%s)",
+						tuple.Issuer.ID(), crlUrlPath.Url.String(), firstUrl, crlHash)
+					continue
+				}
+				processedHashes[crlHash] = crlUrlPath.Url.String()
+
+				var revokedSerials []storage.Serial
+				var validity crlcheck.Validity
+				if streamed != nil {
+					revokedSerials, validity = streamed.Serials, streamed.Validity
+				} else {
+					revokedSerials, validity, err = crlcheck.ProcessCRL(crl, cert)
+				}
+				if err != nil {
+					anyCrlFailed = true
+					failedValidationCount++
+					ae.auditor.FailedProcessLocal(&tuple.Issuer, &crlUrlPath.Url, crlUrlPath.Path, err)
+					logging.Errorf("[%+v] Failed to process: %s", crlUrlPath, err)
+					continue
+				}
+
+				if ae.manifest != nil {
+					ae.manifest.MarkAggregated(tuple.Issuer, crlUrlPath.Url.String())
+				}
+				anyCrlValid = true
+				covered[crlUrlPath.Url.String()] = true
+
+				if ae.invalidityDates != nil {
+					if streamed != nil {
+						ae.invalidityDates.add(tuple.Issuer, streamed.InvalidityDates)
+					} else if dates, err := crlcheck.InvalidityDates(crl, cert); err != nil {
+						logging.Warningf("[%+v] Couldn't read invalidity dates: %s", crlUrlPath, err)
+					} else {
+						ae.invalidityDates.add(tuple.Issuer, dates)
+					}
+				}
+
 				revokedCount := len(revokedSerials)
 				if revokedCount == 0 {
 					ae.auditor.NoRevocations(&tuple.Issuer, &crlUrlPath.Url, crlUrlPath.Path)
 					continue
 				}
 
-				age := time.Since(crl.TBSCertList.ThisUpdate)
+				age := time.Since(validity.ThisUpdate)
 
 				ae.auditor.ValidAndProcessed(&tuple.Issuer, &crlUrlPath.Url, crlUrlPath.Path, revokedCount, age, sha256sum)
-				serials = append(serials, revokedSerials...)
+
+				// Its entries may include certificates which have since
+				// expired, which clients reject anyway
+				var expiredCertsOnCRL time.Time
+				var keepsExpired bool
+				if streamed != nil {
+					expiredCertsOnCRL, keepsExpired = streamed.ExpiredCertsOnCRL, !streamed.ExpiredCertsOnCRL.IsZero()
+				} else {
+					expiredCertsOnCRL, keepsExpired = crlcheck.ExpiredCertsOnCRL(crl)
+				}
+				if keepsExpired {
+					logging.Infof("[%s] CRL %s keeps listing certificates which expired since %s (expiredCertsOnCRL)",
+						tuple.Issuer.ID(), crlUrlPath.Url.String(), expiredCertsOnCRL.Format(time.RFC3339))
+					metrics.IncrCounter([]string{"aggregateCRLWorker", "expiredCertsOnCrl"}, 1)
+				}
+
+				for _, serial := range revokedSerials {
+					if serialSet.Add(serial) {
+						serials = append(serials, serial)
+					}
+				}
 				serialCount += revokedCount
 			}
 		}
 
+		if ae.checkCcadbCoverage {
+			missing, skipped := ae.missingCcadbCrls(tuple.Issuer, covered, outOfScope)
+			if len(missing) > 0 {
+				logging.Warningf("[%s] Partial coverage: %d CRLs listed in CCADB weren't fetched and validated: %s",
+					tuple.Issuer.ID(), len(missing), strings.Join(missing, ", "))
+				ae.issuers.SetMissingCrlUrls(tuple.Issuer, missing)
+				metrics.IncrCounter([]string{"aggregateCRLWorker", "partialCoverage"}, 1)
+			}
+			if len(skipped) > 0 {
+				ae.issuers.SetOutOfScopeCrlUrls(tuple.Issuer, skipped)
+			}
+		}
+
 		// Issuer is considered enrolled if no CRLs failed to download or process,
-		// and at least one revocation was collected
-		if anyCrlFailed == false && serialCount > 0 {
-			ae.issuers.Enroll(tuple.Issuer)
+		// and at least one CRL validated, even if none of them list revocations
+		if ae.timings != nil {
+			ae.timings.record(tuple.Issuer, stageProcess, time.Since(start))
+		}
 
-			glog.Infof("[%s] Saving %d revoked serials", tuple.Issuer.ID(), serialCount)
-			if err := ae.saveStorage.StoreKnownCertificateList(ctx, tuple.Issuer, serials); err != nil {
-				glog.Fatalf("[%s] Could not save revoked certificates file: %s", tuple.Issuer.ID(), err)
+		// Its dropped CRLs weren't checked, so its revocations are incomplete
+		truncated := ae.truncatedIssuers[tuple.Issuer.ID()]
+
+		if anyCrlFailed == false && anyCrlValid && !truncated {
+			storeStart := time.Now()
+			serials = ae.serialOverrides.apply(tuple.Issuer.ID(), serials)
+
+			logging.Infof("[%s] Saving %d revoked serials (%d before de-duplication)", tuple.Issuer.ID(),
+				len(serials), serialCount)
+			if err = ae.storeSerials(ctx, tuple.Issuer, serials); err != nil {
+				logging.Errorf("[%s] Could not save revoked certificates file: %s", tuple.Issuer.ID(), err)
+				ae.issuers.MarkUnenrolled(tuple.Issuer, rootprogram.ReasonStoreFailed)
+				metrics.IncrCounter([]string{"aggregateCRLWorker", "notEnrolled", string(rootprogram.ReasonStoreFailed)}, 1)
+				errChan <- issuerError{Issuer: tuple.Issuer, Reason: rootprogram.ReasonStoreFailed, Err: err}
+				ae.progress.IssuerProcessed()
+				progBar.Increment()
+				ae.runProgress.issuerPhaseDone()
+				continue
+			}
+			// Only once its serials are saved, so that an enrolled issuer
+			// always has them
+			ae.issuers.Enroll(tuple.Issuer)
+			if ae.timings != nil {
+				ae.timings.record(tuple.Issuer, stageStore, time.Since(storeStart))
 			}
+			ae.progress.SerialsAggregated(int64(len(serials)))
 
-			glog.Infof("[%s] %d total revoked serials for %s (len=%d, cap=%d)", tuple.Issuer.ID(),
-				serialCount, tuple.IssuerDN, len(serials), cap(serials))
+			logging.Infof("[%s] %d total revoked serials for %s (raw=%d, duplicates=%d, len=%d, cap=%d)",
+				tuple.Issuer.ID(), len(serials), tuple.IssuerDN, serialCount, serialCount-len(serials),
+				len(serials), cap(serials))
 		} else {
-			glog.Infof("Issuer %s not enrolled", tuple.Issuer.ID())
+			reason := rootprogram.ReasonNoRevocations
+			if truncated {
+				reason = rootprogram.ReasonTooManyCrls
+			} else if outOfScopeCount > 0 && outOfScopeCount == len(tuple.CrlUrlPaths) {
+				// None of its CRLs cover certificates CRLite does, so none
+				// showed it has no revocations
+				reason = rootprogram.ReasonNoCrls
+			} else if failedValidationCount > 0 && failedValidationCount == len(tuple.CrlUrlPaths) {
+				reason = rootprogram.ReasonAllCrlsFailedValidation
+			} else if failedDownloadCount > 0 && failedDownloadCount == len(tuple.CrlUrlPaths) {
+				reason = rootprogram.ReasonAllCrlsFailedDownload
+			} else if anyCrlFailed {
+				reason = rootprogram.ReasonSomeCrlsFailed
+			}
+			ae.issuers.MarkUnenrolled(tuple.Issuer, reason)
+			metrics.IncrCounter([]string{"aggregateCRLWorker", "notEnrolled", string(reason)}, 1)
+
+			if reason == rootprogram.ReasonAllCrlsFailedValidation {
+				logging.Warningf("[%s] Issuer not enrolled: all %d of its CRLs failed validation (%s)",
+					tuple.Issuer.ID(), failedValidationCount, tuple.IssuerDN)
+			} else {
+				logging.Infof("Issuer %s not enrolled (%s)", tuple.Issuer.ID(), reason)
+			}
 		}
 
+		ae.progress.IssuerProcessed()
 		progBar.Increment()
+		ae.runProgress.issuerPhaseDone()
 	}
 }
 
-func (ae *AggregateEngine) identifyCrlsByIssuer(ctx context.Context) types.IssuerCrlMap {
+// Writes the delta, if enabled, then saves the serials wherever configured
+func (ae *AggregateEngine) storeSerials(ctx context.Context, aIssuer storage.Issuer, aSerials []storage.Serial) error {
+	if ae.hashSerials {
+		cert, err := ae.issuers.GetCertificateForIssuer(aIssuer)
+		if err != nil {
+			return fmt.Errorf("Could not hash revoked serials: %s", err)
+		}
+		aSerials = storage.HashSerials(cert.RawSubjectPublicKeyInfo, aSerials)
+	}
+	if ae.deltas != nil {
+		if err := ae.deltas.store(ctx, aIssuer, aSerials); err != nil {
+			return fmt.Errorf("Could not save revoked serials delta: %s", err)
+		}
+	}
+	if ae.serialOut != nil {
+		return writeSerialsHex(ctx, ae.serialOut, aSerials)
+	}
+	if ae.expiryBuckets != nil {
+		return ae.expiryBuckets.store(ctx, aIssuer, aSerials)
+	}
+	return ae.saveStorage.StoreKnownCertificateList(ctx, aIssuer, aSerials)
+}
+
+func (ae *AggregateEngine) identifyCrlsByIssuer(ctx context.Context) (types.IssuerCrlMap, types.IssuerOcspMap) {
 	var wg sync.WaitGroup
 
-	glog.Infof("Listing issuers and their expiration dates...")
+	ae.progress.SetPhase("identify")
+	logging.Infof("Listing issuers and their expiration dates...")
 	issuerList, err := ae.loadStorageDB.GetIssuerAndDatesFromCache()
 	if err != nil {
-		glog.Fatal(err)
+		logging.Fatal(err)
 	}
 
-	issuerChan := make(chan storage.Issuer, len(issuerList))
+	if ae.strictIssuers {
+		issuers := make([]storage.Issuer, 0, len(issuerList))
+		for _, issuerObj := range issuerList {
+			issuers = append(issuers, issuerObj.Issuer)
+		}
+		if err = ae.checkIssuerCertificates(issuers); err != nil {
+			logging.Fatalf("Preflight check of issuer certificates failed: %s", err)
+		}
+	}
 
+	// Count up front so the progress bar has its total, then stream the
+	// issuers to the workers rather than queueing them all at once
 	var count int64
 	for _, issuerObj := range issuerList {
-		if !ae.issuers.IsIssuerInProgram(issuerObj.Issuer) {
-			continue
-		}
-
-		select {
-		case <-ctx.Done():
-			glog.Infof("Quit received")
-			break
-		case issuerChan <- issuerObj.Issuer:
+		if ae.wantsIssuer(issuerObj.Issuer) {
 			count = count + 1
-		default:
-			glog.Fatalf("Channel overflow. Aborting at %s", issuerObj.Issuer.ID())
 		}
 	}
 
-	// Signal that was the last work
-	close(issuerChan)
+	issuerChan := make(chan storage.Issuer, *ctconfig.NumThreads)
+	go func() {
+		// Signal that was the last work
+		defer close(issuerChan)
+
+		for _, issuerObj := range issuerList {
+			if !ae.wantsIssuer(issuerObj.Issuer) {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				logging.Infof("Quit received")
+				return
+			case issuerChan <- issuerObj.Issuer:
+			}
+		}
+	}()
+
+	ae.runProgress = newRunProgress(ae.display, count)
 
 	progressBar := ae.display.AddBar(count,
 		mpb.PrependDecorators(
@@ -403,12 +1013,13 @@ func (ae *AggregateEngine) identifyCrlsByIssuer(ctx context.Context) types.Issue
 		mpb.BarRemoveOnComplete(),
 	)
 
-	resultChan := make(chan types.IssuerCrlMap, *ctconfig.NumThreads)
+	issuerCrls := types.NewConcurrentIssuerCrlMap()
+	ocspResultChan := make(chan types.IssuerOcspMap, *ctconfig.NumThreads)
 
 	// Start the workers
 	for t := 0; t < *ctconfig.NumThreads; t++ {
 		wg.Add(1)
-		go ae.findCrlWorker(ctx, &wg, issuerChan, resultChan, progressBar)
+		go ae.findCrlWorker(ctx, &wg, issuerChan, issuerCrls, ocspResultChan, progressBar)
 	}
 
 	// Set up a notifier for the workers closing
@@ -420,19 +1031,75 @@ func (ae *AggregateEngine) identifyCrlsByIssuer(ctx context.Context) types.Issue
 
 	select {
 	case <-ctx.Done():
-		glog.Infof("Signal caught, stopping threads at next opportunity.")
-		return nil
+		logging.Infof("Signal caught, stopping threads at next opportunity.")
+		return nil, nil
 	case <-doneChan:
-		close(resultChan)
+		close(ocspResultChan)
 	}
 
-	// Take all worker results and merge them into one JSON structure
-	mergedCrls := make(types.IssuerCrlMap)
-	for mapPart := range resultChan {
-		mergedCrls.Merge(mapPart)
+	// The workers merged their CRLs as they went; the OCSP candidates are
+	// few enough to gather afterward
+	mergedOcsps := make(types.IssuerOcspMap)
+	for mapPart := range ocspResultChan {
+		mergedOcsps.Merge(mapPart)
 	}
 
-	return mergedCrls
+	return issuerCrls.Map(), mergedOcsps
+}
+
+// Parses a CRL distribution point URL, rejecting those that can't be fetched
+// as written, such as ones missing their scheme
+func parseCrlUrl(aUrl string) (*url.URL, error) {
+	urlObj, err := url.Parse(strings.TrimSpace(aUrl))
+	if err != nil {
+		return nil, err
+	}
+	if urlObj.Scheme == "" {
+		return nil, fmt.Errorf("Missing scheme")
+	}
+	if (urlObj.Scheme == "http" || urlObj.Scheme == "https") && urlObj.Host == "" {
+		return nil, fmt.Errorf("Missing host")
+	}
+	return urlObj, nil
+}
+
+// Applies any overrides, and drops URLs that don't parse, recording them with
+// the auditor
+func (ae *AggregateEngine) crlUrlsForIssuer(aIssuerID string, aCrls map[string]bool) []url.URL {
+	issuer := storage.NewIssuerFromString(aIssuerID)
+	var urls []url.URL
+	for iUrl := range ae.crlOverrides.apply(aIssuerID, aCrls) {
+		urlObj, err := parseCrlUrl(iUrl)
+		if err != nil {
+			logging.Warningf("[%s] Ignoring URL %q: %s", aIssuerID, iUrl, err)
+			ae.auditor.BadUrl(&issuer, iUrl, err)
+			continue
+		}
+		urls = append(urls, *urlObj)
+	}
+	return urls
+}
+
+// Truncates aUrls to maxCrlsPerIssuer, keeping those first in sorted order
+// so that the same ones are kept from run to run
+func (ae *AggregateEngine) limitCrlUrls(aIssuerID string, aUrls []url.URL) []url.URL {
+	if ae.maxCrlsPerIssuer <= 0 || len(aUrls) <= ae.maxCrlsPerIssuer {
+		return aUrls
+	}
+	sort.Slice(aUrls, func(i, j int) bool {
+		return aUrls[i].String() < aUrls[j].String()
+	})
+	dropped := len(aUrls) - ae.maxCrlsPerIssuer
+	logging.Warningf("[%s] Issuer lists %d CRL URLs, more than -maxcrlsperissuer=%d; dropping %d of them, "+
+		"so it won't be enrolled. Its CCADB entry may be misconfigured.", aIssuerID, len(aUrls),
+		ae.maxCrlsPerIssuer, dropped)
+	if ae.truncatedIssuers == nil {
+		ae.truncatedIssuers = make(map[string]bool)
+	}
+	ae.truncatedIssuers[aIssuerID] = true
+	metrics.IncrCounter([]string{"downloadCRLs", "truncatedIssuers"}, 1)
+	metrics.IncrCounter([]string{"downloadCRLs", "truncatedUrls"}, float32(dropped))
+	return aUrls[:ae.maxCrlsPerIssuer]
 }
 
 func (ae *AggregateEngine) downloadCRLs(ctx context.Context, issuerToUrls types.IssuerCrlMap) (<-chan types.IssuerCrlUrlPaths, int64) {
@@ -441,17 +1108,7 @@ func (ae *AggregateEngine) downloadCRLs(ctx context.Context, issuerToUrls types.
 	crlChan := make(chan types.IssuerCrlUrls, 16*1024*1024)
 	var count int64
 	for issuer, crlMap := range issuerToUrls {
-		var urls []url.URL
-
-		for iUrl := range crlMap {
-			urlObj, err := url.Parse(strings.TrimSpace(iUrl))
-			if err != nil {
-				glog.Warningf("Ignoring URL %s: %s", iUrl, err)
-				continue
-			}
-			urls = append(urls, *urlObj)
-		}
-
+		urls := ae.limitCrlUrls(issuer, ae.crlUrlsForIssuer(issuer, crlMap))
 		if len(urls) > 0 {
 			crlChan <- types.IssuerCrlUrls{
 				Issuer: storage.NewIssuerFromString(issuer),
@@ -462,6 +1119,10 @@ func (ae *AggregateEngine) downloadCRLs(ctx context.Context, issuerToUrls types.
 	}
 	close(crlChan)
 
+	ae.progress.SetPhase("download")
+	ae.progress.SetIssuersTotal(count)
+	ae.runProgress.issuersWithCrls(count)
+
 	progressBar := ae.display.AddBar(count,
 		mpb.PrependDecorators(
 			decor.Name("Download CRLs"),
@@ -475,32 +1136,64 @@ func (ae *AggregateEngine) downloadCRLs(ctx context.Context, issuerToUrls types.
 		mpb.BarRemoveOnComplete(),
 	)
 
+	// Holds every issuer, so the workers never block on it
 	resultChan := make(chan types.IssuerCrlUrlPaths, count)
 
 	// Start the workers
-	for t := 0; t < *ctconfig.NumThreads; t++ {
+	for t := 0; t < ae.downloadThreads; t++ {
 		wg.Add(1)
 		go ae.crlFetchWorker(ctx, &wg, crlChan, resultChan, progressBar)
 	}
 
-	// Set up a notifier for the workers closing
-	doneChan := make(chan bool)
+	// Each issuer's result is sent as soon as its CRLs are downloaded, so
+	// aggregateCRLs can start on it while the rest download. The results
+	// are closed once the workers finish.
+	ae.downloadsFinished = make(chan struct{})
 	go func(wait *sync.WaitGroup) {
 		wait.Wait()
-		doneChan <- true
-	}(&wg)
-
-	select {
-	case <-doneChan:
 		progressBar.SetTotal(progressBar.Current(), true)
+		if ctx.Err() != nil {
+			ae.downloadsStopped = true
+		}
+		ae.progress.SetPhase("aggregate")
+		close(ae.downloadsFinished)
 		close(resultChan)
-		return resultChan, count
-	}
+	}(&wg)
+
+	return resultChan, count
 }
 
-func (ae *AggregateEngine) aggregateCRLs(ctx context.Context, count int64, crlPaths <-chan types.IssuerCrlUrlPaths) {
+// Returns the issuers that couldn't be aggregated. Stopping ctx
+// while downloadCRLs is producing crlPaths only stops the downloads, so the
+// issuers already downloaded are still aggregated, and the partial results
+// saved cover them. Stopping it after stops the aggregation.
+func (ae *AggregateEngine) aggregateCRLs(ctx context.Context, count int64,
+	crlPaths <-chan types.IssuerCrlUrlPaths) []issuerError {
 	var wg sync.WaitGroup
 
+	workCtx, stopWork := context.WithCancel(context.Background())
+	defer stopWork()
+	stopUnlessDownloadsStopped := func() {
+		if ae.downloadsFinished != nil {
+			<-ae.downloadsFinished
+			if ae.downloadsStopped {
+				return
+			}
+		}
+		stopWork()
+	}
+	if ctx.Err() != nil {
+		stopUnlessDownloadsStopped()
+	} else {
+		go func() {
+			select {
+			case <-ctx.Done():
+				stopUnlessDownloadsStopped()
+			case <-workCtx.Done():
+			}
+		}()
+	}
+
 	progressBar := ae.display.AddBar(count,
 		mpb.PrependDecorators(
 			decor.Name("Aggregate CRLs"),
@@ -514,10 +1207,13 @@ func (ae *AggregateEngine) aggregateCRLs(ctx context.Context, count int64, crlPa
 		mpb.BarRemoveOnComplete(),
 	)
 
+	// Each issuer sends at most one error, so the workers never block on it
+	errChan := make(chan issuerError, count)
+
 	// Start the workers
-	for t := 0; t < *ctconfig.NumThreads; t++ {
+	for t := 0; t < ae.aggregateThreads; t++ {
 		wg.Add(1)
-		go ae.aggregateCRLWorker(ctx, &wg, crlPaths, progressBar)
+		go ae.aggregateCRLWorker(workCtx, &wg, crlPaths, errChan, progressBar)
 	}
 
 	// Set up a notifier for the workers closing
@@ -531,77 +1227,590 @@ func (ae *AggregateEngine) aggregateCRLs(ctx context.Context, count int64, crlPa
 	case <-doneChan:
 		progressBar.SetTotal(progressBar.Current(), true)
 	}
+
+	// Workers stopped early leave the rest, so wait for whatever's
+	// producing crlPaths to stop too
+	for range crlPaths {
+	}
+
+	close(errChan)
+	var issuerErrs []issuerError
+	for issuerErr := range errChan {
+		issuerErrs = append(issuerErrs, issuerErr)
+	}
+	return issuerErrs
+}
+
+func saveOcspCandidates(aPath string, aOcsps types.IssuerOcspMap) error {
+	fd, err := os.Create(aPath)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(fd)
+	if err = enc.Encode(aOcsps); err != nil {
+		fd.Close() // ignore error
+		return err
+	}
+
+	return fd.Close()
+}
+
+// Writes one hex serial per line, as the disk backend's default format does
+func writeSerialsHex(ctx context.Context, w io.Writer, aSerials []storage.Serial) error {
+	writer := bufio.NewWriter(w)
+	for _, s := range aSerials {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if _, err := writer.WriteString(s.HexString() + "\n"); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+func saveBadUrls(aPath string, aBadUrls []BadCrlUrl) error {
+	fd, err := os.Create(aPath)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(fd)
+	if err = enc.Encode(aBadUrls); err != nil {
+		fd.Close() // ignore error
+		return err
+	}
+
+	return fd.Close()
+}
+
+func saveManifest(aPath string, aManifest *types.CrlManifest) error {
+	fd, err := os.Create(aPath)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(fd)
+	if err = enc.Encode(aManifest); err != nil {
+		fd.Close() // ignore error
+		return err
+	}
+
+	return fd.Close()
+}
+
+// Parses a comma-separated list of issuer IDs, or, if prefixed with @, a
+// file of them one per line. An empty spec means no filtering.
+func parseIssuerFilter(aSpec string) (map[string]bool, error) {
+	if strings.TrimSpace(aSpec) == "" {
+		return nil, nil
+	}
+
+	var ids []string
+	if strings.HasPrefix(aSpec, "@") {
+		data, err := ioutil.ReadFile(aSpec[1:])
+		if err != nil {
+			return nil, err
+		}
+		ids = strings.Split(string(data), "\n")
+	} else {
+		ids = strings.Split(aSpec, ",")
+	}
+
+	filter := make(map[string]bool)
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if id == "" || strings.HasPrefix(id, "#") {
+			continue
+		}
+		filter[id] = true
+	}
+
+	if len(filter) == 0 {
+		return nil, fmt.Errorf("No issuer IDs in %s", aSpec)
+	}
+	return filter, nil
+}
+
+// Returns aRequested if set, otherwise scales with the number of CPUs.
+func workerCount(aRequested int, aPerCPU int) int {
+	if aRequested > 0 {
+		return aRequested
+	}
+	return runtime.NumCPU() * aPerCPU
+}
+
+func downloadOptionsFromFlags(aProxy *url.URL) (downloader.DownloadOptions, error) {
+	dlOptions := downloader.NewDownloadOptions()
+	dlOptions.MaxSize = *maxcrlsize
+	dlOptions.Timeout = *crltimeout
+	dlOptions.UserAgent = *useragent
+	if aProxy != nil {
+		dlOptions.SetProxy(aProxy)
+	}
+	if *tlsroots != "" {
+		if err := dlOptions.AddTLSRootsFromPEM(*tlsroots); err != nil {
+			return dlOptions, err
+		}
+	}
+	return dlOptions, nil
 }
 
 func checkPathArg(strObj string, confOptionName string, ctconfig *config.CTConfig) {
 	if strObj == "<path>" {
-		glog.Errorf("Flag %s is not set", confOptionName)
+		logging.Errorf("Flag %s is not set", confOptionName)
 		ctconfig.Usage()
 		os.Exit(2)
 	}
 }
 
 func main() {
+	flag.Var(&inccadbs, "ccadb", "input CCADB CSV path; further uses name local overlays merged on top, later ones overriding earlier ones per issuer")
 	ctconfig.Init()
+	if *logjson {
+		logging.EnableJSON(os.Stderr)
+	}
 	ctx, cancel := context.WithCancel(context.Background())
-	storageDB, remoteCache, _ := engine.GetConfiguredStorage(ctx, ctconfig)
-	defer glog.Flush()
+	defer logging.Flush()
+	stopCPUProfile := func() {}
+	if *cpuprofile != "<path>" {
+		stop, err := startCPUProfile(*cpuprofile)
+		if err != nil {
+			logging.Fatalf("Unable to start the CPU profile: %s", err)
+		}
+		stopCPUProfile = stop
+		defer stopCPUProfile()
+	}
+	if *pprofaddr != "" {
+		go func() {
+			logging.Infof("Serving pprof on %s", *pprofaddr)
+			if err := http.ListenAndServe(*pprofaddr, pprofHandler()); err != nil {
+				logging.Errorf("pprof server stopped: %s", err)
+			}
+		}()
+	}
+	var storageDB storage.CertDatabase
+	var remoteCache storage.RemoteCache
+	if *metasnapshot != "" {
+		snapshot, err := storage.LoadMetadataSnapshot(*metasnapshot)
+		if err != nil {
+			logging.Fatalf("Unable to load the metadata snapshot: %s", err)
+		}
+		snapshotCache, err := snapshot.Cache()
+		if err != nil {
+			logging.Fatalf("Unable to load the metadata snapshot: %s", err)
+		}
+		remoteCache = snapshotCache
+		storageDB, _ = storage.NewFilesystemDatabase(storage.NewNoopBackend(), snapshotCache)
+		logging.Infof("Reading metadata for %d issuers from the snapshot %s", len(snapshot.Issuers), *metasnapshot)
+	} else {
+		storageDB, remoteCache, _ = engine.GetConfiguredStorage(ctx, ctconfig)
+	}
 
-	checkPathArg(*revokedpath, "revokedpath", ctconfig)
 	checkPathArg(*crlpath, "crlpath", ctconfig)
 	checkPathArg(*enrolledpath, "enrolledpath", ctconfig)
 	checkPathArg(*auditpath, "auditpath", ctconfig)
+	switch *crlsource {
+	case crlSourceDownload:
+	case crlSourceLocal:
+		if *ocspout != "<path>" || *ccadbcover {
+			logging.Errorf("Flag crlsource of %s can't be combined with ocspout or ccadbcoverage", crlSourceLocal)
+			ctconfig.Usage()
+			os.Exit(2)
+		}
+	default:
+		logging.Errorf("Flag crlsource is invalid: %q is neither %s nor %s", *crlsource, crlSourceDownload,
+			crlSourceLocal)
+		ctconfig.Usage()
+		os.Exit(2)
+	}
+	if err := setFileModes(*filemode, *dirmode); err != nil {
+		logging.Errorf("%s", err)
+		ctconfig.Usage()
+		os.Exit(2)
+	}
+
+	serialsToStdout := *outbackend == "disk" && *revokedpath == revokedPathStdout
+
+	var saveBackend storage.StorageBackend
+	var newBucketBackend func(aBucket string) storage.StorageBackend
+	var generation *outputGeneration
+	// With generational output, the previous run's files are in its
+	// generation, not the folder written to
+	var previousSerials storage.KnownCertificateListLoader
+	// Set with -serialformat spki-bundle
+	var serialsBundlePath string
+	// The output folders, with crlpath, checked at startup for being
+	// writable and having -minfreemib available
+	var spaceDirs []string
+	if *generational && (*outbackend != "disk" || serialsToStdout) {
+		logging.Errorf("Flag generational-output needs -output-backend=disk with a revokedpath folder")
+		ctconfig.Usage()
+		os.Exit(2)
+	}
+	switch *outbackend {
+	case "disk":
+		checkPathArg(*revokedpath, "revokedpath", ctconfig)
+		format, err := storage.ParseSerialFormat(*serialformat)
+		if err != nil {
+			logging.Errorf("Flag serialformat is invalid: %s", err)
+			ctconfig.Usage()
+			os.Exit(2)
+		}
+		if serialsToStdout {
+			if format != storage.SerialFormatDefault || *expirybucket != "" || *compressout {
+				logging.Errorf("Flag revokedpath of %s can't be combined with serialformat, expirybuckets, or compress-output",
+					revokedPathStdout)
+				ctconfig.Usage()
+				os.Exit(2)
+			}
+			break
+		}
+		outPath := *revokedpath
+		if *generational {
+			generation, err = newOutputGeneration(*revokedpath, time.Now())
+			if err != nil {
+				logging.Fatalf("Unable to make the revokedpath generation directory: %s", err)
+			}
+			outPath = generation.path
+			logging.Infof("Writing revoked serials to generation %s", outPath)
+		} else if err := os.MkdirAll(outPath, permModeDir); err != nil {
+			logging.Fatalf("Unable to make the revokedpath directory: %s", err)
+		}
+		spaceDirs = append(spaceDirs, outPath)
+		saveBackend = storage.NewLocalDiskBackendWithCompression(permMode, outPath, format, *compressout)
+		newBucketBackend = func(aBucket string) storage.StorageBackend {
+			return storage.NewLocalDiskBackendWithCompression(permMode, filepath.Join(outPath, aBucket), format,
+				*compressout)
+		}
+		previousSerials = saveBackend.(storage.KnownCertificateListLoader)
+		if format == storage.SerialFormatSPKIBundle {
+			serialsBundlePath = filepath.Join(outPath, storage.SerialsBundleName)
+		}
+		if generation != nil {
+			previousSerials = storage.NewLocalDiskBackendWithSerialFormat(permMode, generation.latestPath(),
+				format).(storage.KnownCertificateListLoader)
+		}
+	case "s3":
+		if *serialformat != string(storage.SerialFormatDefault) {
+			logging.Errorf("Flag serialformat is only supported with -output-backend=disk")
+			ctconfig.Usage()
+			os.Exit(2)
+		}
+		if *s3bucket == "" {
+			logging.Errorf("Flag s3bucket is not set")
+			ctconfig.Usage()
+			os.Exit(2)
+		}
+		sess, err := session.NewSession()
+		if err != nil {
+			logging.Fatalf("Unable to create an S3 session: %s", err)
+		}
+		s3Client := s3.New(sess)
+		saveBackend = storage.NewS3BackendWithCompression(s3Client, *s3bucket, *s3prefix, *compressout)
+		newBucketBackend = func(aBucket string) storage.StorageBackend {
+			return storage.NewS3BackendWithCompression(s3Client, *s3bucket, path.Join(*s3prefix, aBucket),
+				*compressout)
+		}
+	default:
+		logging.Errorf("Unknown output-backend: %s", *outbackend)
+		ctconfig.Usage()
+		os.Exit(2)
+	}
+	sigAlgs, err := crlcheck.ParseSignatureAlgorithms(*crlsigalgs)
+	if err != nil {
+		logging.Errorf("Flag crlsigalgs is invalid: %s", err)
+		ctconfig.Usage()
+		os.Exit(2)
+	}
+	crlcheck.AllowedSignatureAlgorithms = sigAlgs
+
+	if *streamcrls < 0 {
+		logging.Errorf("Flag streamcrlsover is invalid: %d is negative", *streamcrls)
+		ctconfig.Usage()
+		os.Exit(2)
+	}
+	crlcheck.StreamingThreshold = *streamcrls
 
-	if err := os.MkdirAll(*revokedpath, permModeDir); err != nil {
-		glog.Fatalf("Unable to make the revokedpath directory: %s", err)
+	if *maxclockskew < 0 {
+		logging.Errorf("Flag maxclockskew is invalid: %s is negative", *maxclockskew)
+		ctconfig.Usage()
+		os.Exit(2)
+	}
+	crlcheck.MaxClockSkew = *maxclockskew
+	crlcheck.MaxDecompressedSize = *maxcrlsize
+
+	if *insecuresig {
+		logging.Warningf("**************************************************************************")
+		logging.Warningf("* -insecure-skip-crl-signature is set: CRL signatures are NOT verified.  *")
+		logging.Warningf("* Anyone can forge these revocations. Never use this in production.     *")
+		logging.Warningf("**************************************************************************")
+		crlcheck.InsecureSkipSignature = true
 	}
-	if err := os.MkdirAll(*crlpath, permModeDir); err != nil {
-		glog.Fatalf("Unable to make the CRL directory: %s", err)
+
+	if *refetchafter < 0 {
+		logging.Errorf("Flag refetchafter is invalid: %s is negative", *refetchafter)
+		ctconfig.Usage()
+		os.Exit(2)
 	}
 
+	if *maxcrlsper < 0 {
+		logging.Errorf("Flag maxcrlsperissuer is invalid: %d is negative", *maxcrlsper)
+		ctconfig.Usage()
+		os.Exit(2)
+	}
+	if *jitter < 0 {
+		logging.Errorf("Flag jitter is invalid: %s is negative", *jitter)
+		ctconfig.Usage()
+		os.Exit(2)
+	}
+
+	if *failfraction < 0 || *failfraction > 1 {
+		logging.Errorf("Flag failfraction is invalid: %f is not between 0 and 1", *failfraction)
+		ctconfig.Usage()
+		os.Exit(2)
+	}
+
+	var deltas *deltaWriter
+	if *deltapath != "<path>" {
+		if previousSerials == nil || *expirybucket != "" {
+			logging.Errorf("Flag deltapath needs the previous revoked serials, so -output-backend=disk with a " +
+				"revokedpath folder, and no expirybuckets")
+			ctconfig.Usage()
+			os.Exit(2)
+		}
+		if err := os.MkdirAll(*deltapath, permModeDir); err != nil {
+			logging.Fatalf("Unable to make the deltapath directory: %s", err)
+		}
+		deltas = &deltaWriter{
+			previous: previousSerials,
+			outPath:  *deltapath,
+		}
+	}
+
+	var expiryBuckets *expiryBucketer
+	if *hashserials && *expirybucket != "" {
+		logging.Errorf("Flag hashserials can't be combined with expirybuckets")
+		ctconfig.Usage()
+		os.Exit(2)
+	}
+	if *expirybucket != "" && *metasnapshot != "" {
+		logging.Errorf("Flag expirybuckets can't be combined with metadatasnapshot")
+		ctconfig.Usage()
+		os.Exit(2)
+	}
+	if *expirybucket != "" {
+		layout, err := parseExpiryBucketPeriod(*expirybucket)
+		if err != nil {
+			logging.Errorf("Flag expirybuckets is invalid: %s", err)
+			ctconfig.Usage()
+			os.Exit(2)
+		}
+		expiryBuckets, err = newExpiryBucketer(layout, storageDB, newBucketBackend)
+		if err != nil {
+			logging.Fatalf("Unable to load certificate expiration dates: %s", err)
+		}
+	}
+
+	issuerFilter, err := parseIssuerFilter(*issuerfilter)
+	if err != nil {
+		logging.Errorf("Flag issuerfilter is invalid: %s", err)
+		ctconfig.Usage()
+		os.Exit(2)
+	}
+	if issuerFilter != nil {
+		logging.Infof("Restricting processing to %d issuers", len(issuerFilter))
+	}
+	if serialsToStdout && len(issuerFilter) != 1 {
+		logging.Errorf("Flag revokedpath of %s requires an issuerfilter of exactly one issuer", revokedPathStdout)
+		ctconfig.Usage()
+		os.Exit(2)
+	}
+
+	var overrides *crlOverrides
+	if *crloverrides != "" {
+		overrides, err = loadCrlOverrides(*crloverrides)
+		if err != nil {
+			logging.Errorf("Flag crloverrides is invalid: %s", err)
+			ctconfig.Usage()
+			os.Exit(2)
+		}
+		logging.Infof("Loaded %d issuer and %d URL CRL overrides", len(overrides.byIssuer), len(overrides.byUrl))
+	}
+	// Read before this run replaces it
+	previousEnrollment, err := loadPreviousEnrollment(*enrolledpath)
+	if err != nil {
+		logging.Warningf("Couldn't load the previous enrolled issuers from %s, so enrollment changes won't be reported: %s",
+			*enrolledpath, err)
+	} else if previousEnrollment == nil {
+		logging.Infof("No previous enrolled issuers at %s to report enrollment changes against", *enrolledpath)
+	}
+	var forcedSerials *serialOverrides
+	if *forcerevoked != "" || *forceunrevok != "" {
+		forcedSerials, err = loadSerialOverrides(*forcerevoked, *forceunrevok)
+		if err != nil {
+			logging.Errorf("Flag forcerevoked or forceunrevoked is invalid: %s", err)
+			ctconfig.Usage()
+			os.Exit(2)
+		}
+		logging.Warningf("Loaded %d forced serial overrides", forcedSerials.count())
+	}
+	var trustedRoots *x509.CertPool
+	if *rootstore != "" {
+		trustedRoots, err = rootprogram.LoadRootsFromPEM(*rootstore)
+		if err != nil {
+			logging.Errorf("Flag rootstore is invalid: %s", err)
+			ctconfig.Usage()
+			os.Exit(2)
+		}
+	}
+	var proxyUrl *url.URL
+	if *proxy != "" {
+		proxyUrl, err = downloader.ParseProxyURL(*proxy)
+		if err != nil {
+			logging.Errorf("Flag proxy is invalid: %s", err)
+			ctconfig.Usage()
+			os.Exit(2)
+		}
+		logging.Infof("Downloading CRLs through the proxy at %s://%s", proxyUrl.Scheme, proxyUrl.Host)
+	}
+	dlOptions, err := downloadOptionsFromFlags(proxyUrl)
+	if err != nil {
+		logging.Errorf("Flag tlsrootbundle is invalid: %s", err)
+		ctconfig.Usage()
+		os.Exit(2)
+	}
+
+	// Local CRLs are only read, so crlpath can be read-only
+	if *crlsource != crlSourceLocal {
+		if err := os.MkdirAll(*crlpath, permModeDir); err != nil {
+			logging.Fatalf("Unable to make the CRL directory: %s", err)
+		}
+		spaceDirs = append(spaceDirs, *crlpath)
+	}
+	if err = preflightOutputDirs(spaceDirs, *minfreemib); err != nil {
+		logging.Fatalf("Preflight check failed: %s", err)
+	}
+	if err = preflightOutputDirs([]string{filepath.Dir(*enrolledpath)}, 0); err != nil {
+		logging.Fatalf("Preflight check of enrolledpath failed: %s", err)
+	}
+
+	*ctconfig.NumThreads = workerCount(*ctconfig.NumThreads, 1)
+	downloadThreads := workerCount(*dlthreads, downloadWorkersPerCPU)
+	aggregateThreads := workerCount(*aggthreads, 1)
+	logging.Infof("Using %d threads to identify CRLs, %d to download, and %d to aggregate.",
+		*ctconfig.NumThreads, downloadThreads, aggregateThreads)
+
 	refreshDur, err := time.ParseDuration(*ctconfig.OutputRefreshPeriod)
 	if err != nil {
-		glog.Fatal(err)
+		logging.Fatal(err)
 	}
-	glog.Infof("Progress bar refresh rate is every %s.\n", refreshDur.String())
+	logging.Infof("Progress bar refresh rate is every %s.\n", refreshDur.String())
 
 	engine.PrepareTelemetry("aggregate-crls", ctconfig)
 
-	saveBackend := storage.NewLocalDiskBackend(permMode, *revokedpath)
+	progress := telemetry.NewProgress("aggregate_crls")
+	if *metricsaddr != "" {
+		go func() {
+			logging.Infof("Serving progress metrics on %s", *metricsaddr)
+			if err := http.ListenAndServe(*metricsaddr, progress); err != nil {
+				logging.Errorf("Progress metrics server stopped: %s", err)
+			}
+		}()
+	}
 
 	mozIssuers := rootprogram.NewMozillaIssuers()
-	if *inccadb != "<path>" {
-		mozIssuers.DiskPath = *inccadb
+	mozIssuers.IncludeInactive = *inactive
+	mozIssuers.SkipInvalidRows = *skipbadccadb
+	mozIssuers.LoadRetries = *ccadbretries
+	// The first -ccadb path is where the CCADB report is cached; any others
+	// are overlays
+	var ccadbOverlays []string
+	if len(inccadbs) > 0 {
+		mozIssuers.DiskPath = inccadbs[0]
+		ccadbOverlays = inccadbs[1:]
 	}
 
-	err = mozIssuers.Load()
+	if *ccadburl != "<url>" {
+		err = mozIssuers.LoadFromURL(ctx, *ccadburl)
+	} else {
+		err = mozIssuers.Load()
+	}
 	if err != nil {
-		glog.Fatalf("Unable to load the Mozilla issuers: %s", err)
+		logging.Fatalf("Unable to load the Mozilla issuers: %s", err)
 		return
 	}
 
+	if len(ccadbOverlays) > 0 {
+		if err = mozIssuers.LoadFromDiskMerge(ccadbOverlays...); err != nil {
+			logging.Fatalf("Unable to merge the CCADB overlays: %s", err)
+		}
+		logging.Infof("Merged %d CCADB overlays", len(ccadbOverlays))
+	}
+
+	if *checkonecrl {
+		if err = mozIssuers.LoadOneCRL(ctx); err != nil {
+			logging.Fatalf("Unable to load OneCRL: %s", err)
+		}
+	}
+
+	if trustedRoots != nil {
+		unchained := mozIssuers.VerifyChains(trustedRoots)
+		metrics.SetGauge([]string{"IssuersWithoutValidChain"}, float32(unchained))
+	}
+
+	// Each enrolled issuer also records the fingerprint; a metric label would
+	// add a new series every time the CCADB input changes.
+	logging.Infof("CCADB input fingerprint is %s", mozIssuers.Fingerprint())
 	metrics.SetGauge([]string{"IssuersAgeSeconds"}, float32(mozIssuers.DatasetAge().Seconds()))
+	metrics.SetGauge([]string{"IssuersInvalidRows"}, float32(len(mozIssuers.GetInvalidRows())))
 
 	// Exit signal, used by signals from the OS
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGTERM, os.Interrupt)
 	defer signal.Stop(sigChan)
 
+	var budgetChan <-chan time.Time
+	if *maxruntime > 0 {
+		budgetTimer := time.NewTimer(*maxruntime)
+		defer budgetTimer.Stop()
+		budgetChan = budgetTimer.C
+	}
+
+	stopReason := ""
 	go func() {
-		<-sigChan
-		glog.Infof("Signal caught, stopping threads at next opportunity.")
+		select {
+		case <-sigChan:
+			stopReason = "signal"
+			logging.Infof("Signal caught, stopping threads at next opportunity.")
+		case <-budgetChan:
+			stopReason = fmt.Sprintf("maxruntime of %s exceeded", *maxruntime)
+			logging.Warningf("Run exceeded its maxruntime of %s, stopping threads at next opportunity.", *maxruntime)
+		}
 		cancel()
 		signal.Stop(sigChan)
 	}()
 
-	var barOutput io.Writer = nil
-	if nobars != nil && !*nobars {
-		barOutput = os.Stdout
+	// Once everything that could be saved has been, a stopped run exits
+	// non-zero, so callers can tell it was cut short
+	exitIfStopped := func() {
+		// stopReason is written before cancel(), so it's visible once ctx reports done
+		if ctx.Err() == nil {
+			return
+		}
+		logging.Errorf("Run stopped early (%s) with %d of %d issuers completed",
+			stopReason, progress.IssuersProcessed(), progress.IssuersTotal())
+		stopCPUProfile()
+		logging.Flush()
+		os.Exit(exitStopped)
 	}
 
-	display := mpb.NewWithContext(ctx,
+	// Not bound to ctx, as a stopped run still aggregates and saves what it
+	// has, which may add bars after the stop
+	display := mpb.New(
 		mpb.WithRefreshRate(refreshDur),
-		mpb.WithOutput(barOutput),
+		mpb.WithOutput(progressOutput(*nobars, serialsToStdout)),
 	)
 
 	auditor := NewCrlAuditor(mozIssuers)
@@ -613,35 +1822,214 @@ func main() {
 		issuers:       mozIssuers,
 		display:       display,
 		auditor:       auditor,
+		dlOptions:     dlOptions,
+		hostLimiter:   downloader.NewHostRateLimiter(*hostrps),
+		manifest:      types.NewCrlManifest(),
+		progress:      progress,
+
+		downloadThreads:  downloadThreads,
+		aggregateThreads: aggregateThreads,
+		refetchAfter:     *refetchafter,
+		jitter:           *jitter,
+		maxCrlsPerIssuer: *maxcrlsper,
+
+		checkCcadbCoverage: *ccadbcover,
+		strictIssuers:      *strictissuer,
+
+		issuerFilter:  issuerFilter,
+		expiryBuckets: expiryBuckets,
+		crlOverrides:  overrides,
+		deltas:        deltas,
+
+		serialOverrides: forcedSerials,
+		hashSerials:     *hashserials,
+	}
+	if serialsToStdout {
+		ae.serialOut = os.Stdout
+	}
+	if *timingout != "<path>" {
+		ae.timings = newIssuerTimings()
+	}
+	if *invalidout != "<path>" {
+		ae.invalidityDates = newInvalidityDates()
 	}
 
-	mergedCrls := ae.identifyCrlsByIssuer(ctx)
-	if mergedCrls == nil {
-		return
+	var mergedCrls types.IssuerCrlMap
+	var mergedOcsps types.IssuerOcspMap
+	if *crlsource == crlSourceLocal {
+		if mergedCrls, err = ae.listLocalCRLs(*crlpath); err != nil {
+			logging.Fatalf("Unable to list the CRLs in %s: %s", *crlpath, err)
+		}
+		logging.Infof("Found CRLs for %d issuers in %s", len(mergedCrls), *crlpath)
+		if ae.strictIssuers {
+			issuers := make([]storage.Issuer, 0, len(mergedCrls))
+			for issuerID := range mergedCrls {
+				issuers = append(issuers, storage.NewIssuerFromString(issuerID))
+			}
+			if err = ae.checkIssuerCertificates(issuers); err != nil {
+				logging.Fatalf("Preflight check of issuer certificates failed: %s", err)
+			}
+		}
+	} else {
+		mergedCrls, mergedOcsps = ae.identifyCrlsByIssuer(ctx)
+		if mergedCrls == nil {
+			exitIfStopped()
+			return
+		}
 	}
 
-	crlPaths, count := ae.downloadCRLs(ctx, mergedCrls)
+	if *ocspout != "<path>" {
+		if err = saveOcspCandidates(*ocspout, mergedOcsps); err != nil {
+			logging.Warningf("Could not save OCSP candidates to %s: %v", *ocspout, err)
+		} else {
+			logging.Infof("Saved %d OCSP-only issuer candidates to %s", len(mergedOcsps), *ocspout)
+		}
+	}
 
-	if ctx.Err() != nil {
-		return
+	var crlPaths <-chan types.IssuerCrlUrlPaths
+	var count int64
+	if *crlsource == crlSourceLocal {
+		crlPaths, count = ae.localCRLPaths(mergedCrls)
+	} else {
+		crlPaths, count = ae.downloadCRLs(ctx, mergedCrls)
 	}
 
-	ae.aggregateCRLs(ctx, count, crlPaths)
-	if err = mozIssuers.SaveIssuersList(*enrolledpath); err != nil {
-		glog.Fatalf("Unable to save the crlite-informed intermediate issuers to %s: %s", *enrolledpath, err)
+	// Issuers are aggregated as they're downloaded. Stopping during the
+	// downloads still aggregates those already downloaded, and the outputs
+	// are saved for them, but the generation isn't promoted.
+	issuerErrs := ae.aggregateCRLs(ctx, count, crlPaths)
+	if ae.downloadsStopped {
+		logging.Warningf("Downloads were stopped, so only the issuers already downloaded were aggregated")
+	}
+	// Issuers without a certificate are left to the failure report, as other
+	// other CRL problems are, while store failures fail the run outright
+	var storeErrs []issuerError
+	for _, issuerErr := range issuerErrs {
+		if issuerErr.Reason == rootprogram.ReasonStoreFailed {
+			storeErrs = append(storeErrs, issuerErr)
+		} else {
+			logging.Errorf("Could not aggregate revoked serials (%s): %s", issuerErr.Reason, issuerErr)
+		}
+	}
+
+	// Save everything else before deciding what the store failures mean
+	if *memprofile != "<path>" {
+		if err = writeHeapProfile(*memprofile); err != nil {
+			logging.Warningf("Could not save heap profile to %s: %v", *memprofile, err)
+		} else {
+			logging.Infof("Saved heap profile to %s", *memprofile)
+		}
+	}
+	ae.progress.SetPhase("save")
+	if err = mozIssuers.SaveIssuersListWithMode(*enrolledpath, permMode); err != nil {
+		logging.Fatalf("Unable to save the crlite-informed intermediate issuers to %s: %s", *enrolledpath, err)
+	}
+	logging.Infof("Saved crlite-informed intermediate issuers to %s", *enrolledpath)
+
+	if serialsBundlePath != "" {
+		count, err := saveSerialsBundle(ctx, serialsBundlePath, saveBackend.(storage.KnownCertificateListLoader), mozIssuers)
+		if err != nil {
+			logging.Warningf("Could not save revoked serials bundle to %s: %v", serialsBundlePath, err)
+		} else {
+			logging.Infof("Saved %d issuers' revoked serials to %s", count, serialsBundlePath)
+		}
+	}
+
+	if previousEnrollment != nil {
+		changes := compareEnrollment(previousEnrollment, mozIssuers)
+		changes.log()
+		metrics.SetGauge([]string{"IssuersNewlyEnrolled"}, float32(len(changes.NewlyEnrolled)))
+		metrics.SetGauge([]string{"IssuersDropped"}, float32(len(changes.Dropped)))
+		if *enrollchange != "<path>" {
+			if err = saveEnrollmentChanges(*enrollchange, changes); err != nil {
+				logging.Warningf("Could not save enrollment changes to %s: %v", *enrollchange, err)
+			} else {
+				logging.Infof("Saved enrollment changes to %s", *enrollchange)
+			}
+		}
+	}
+
+	if *manifestout != "<path>" {
+		if err = saveManifest(*manifestout, ae.manifest); err != nil {
+			logging.Warningf("Could not save CRL manifest to %s: %v", *manifestout, err)
+		} else {
+			logging.Infof("Saved CRL manifest to %s", *manifestout)
+		}
+	}
+
+	if *badurlsout != "<path>" {
+		badUrls := auditor.GetBadUrls()
+		if err = saveBadUrls(*badurlsout, badUrls); err != nil {
+			logging.Warningf("Could not save bad CRL URLs to %s: %v", *badurlsout, err)
+		} else {
+			logging.Infof("Saved %d bad CRL URLs to %s", len(badUrls), *badurlsout)
+		}
+	}
+
+	if ae.timings != nil {
+		if err = saveTimings(*timingout, ae.timings); err != nil {
+			logging.Warningf("Could not save issuer timings to %s: %v", *timingout, err)
+		} else {
+			logging.Infof("Saved issuer timings to %s", *timingout)
+		}
+	}
+
+	if ae.invalidityDates != nil {
+		if err = saveInvalidityDates(*invalidout, ae.invalidityDates); err != nil {
+			logging.Warningf("Could not save invalidity dates to %s: %v", *invalidout, err)
+		} else {
+			logging.Infof("Saved invalidity dates to %s", *invalidout)
+		}
 	}
-	glog.Infof("Saved crlite-informed intermediate issuers to %s", *enrolledpath)
 
 	fd, err := os.Create(*auditpath)
 	if err != nil {
-		glog.Warningf("Could not open audit report path %s: %v", *auditpath, err)
-		return
+		logging.Warningf("Could not open audit report path %s: %v", *auditpath, err)
+	} else {
+		if err = auditor.WriteReport(fd); err != nil {
+			logging.Warningf("Could not write audit report %s: %v", *auditpath, err)
+		}
+		err = fd.Close()
+		if err != nil {
+			logging.Warningf("Could not close audit report %s: %v", *auditpath, err)
+		}
 	}
-	if err = auditor.WriteReport(fd); err != nil {
-		glog.Warningf("Could not write audit report %s: %v", *auditpath, err)
+
+	failures := buildFailureReport(mozIssuers, issuersWithCrls(mergedCrls), *failfraction)
+	if *failreport != "<path>" {
+		if err = saveFailureReport(*failreport, failures); err != nil {
+			logging.Warningf("Could not save failure report to %s: %v", *failreport, err)
+		} else {
+			logging.Infof("Saved failure report to %s", *failreport)
+		}
 	}
-	err = fd.Close()
-	if err != nil {
-		glog.Warningf("Could not close audit report %s: %v", *auditpath, err)
+
+	if generation != nil {
+		if ctx.Err() != nil || failures.Exceeded || len(storeErrs) > 0 {
+			logging.Warningf("Run did not succeed, so %s still points at the previous generation",
+				generation.latestPath())
+		} else if err = generation.promote(); err != nil {
+			logging.Fatalf("Unable to point %s at %s: %s", generation.latestPath(), generation.path, err)
+		} else {
+			logging.Infof("Pointed %s at %s", generation.latestPath(), generation.path)
+		}
+	}
+	ae.progress.SetPhase("done")
+
+	for _, storeErr := range storeErrs {
+		logging.Errorf("Could not save revoked serials: %s", storeErr)
+	}
+	exitIfStopped()
+	if len(storeErrs) > 0 {
+		stopCPUProfile()
+		logging.Flush()
+		os.Exit(exitStoreFailed)
+	}
+	if failures.Exceeded {
+		logging.Errorf("Too many issuers failed: %s", failures)
+		stopCPUProfile()
+		logging.Flush()
+		os.Exit(exitFailFraction)
 	}
+	logging.Infof("Issuer failures: %s", failures)
 }
@@ -2,27 +2,44 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
 	"io/ioutil"
 	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/google/certificate-transparency-go/asn1"
 	"github.com/google/certificate-transparency-go/x509"
 	"github.com/google/certificate-transparency-go/x509/pkix"
 	"github.com/mozilla/crlite/go"
+	"github.com/mozilla/crlite/go/crlcheck"
 	"github.com/mozilla/crlite/go/downloader"
+	"github.com/mozilla/crlite/go/logging"
 	"github.com/mozilla/crlite/go/rootprogram"
 	"github.com/mozilla/crlite/go/storage"
+	"github.com/mozilla/crlite/go/telemetry"
 	"github.com/vbauerster/mpb/v5"
 )
 
@@ -55,7 +72,50 @@ func Test_makeFilenameFromUrl(t *testing.T) {
 	checkCollision(t, crls2, names)
 }
 
-func makeCA(t *testing.T) (*x509.Certificate, interface{}) {
+func Test_makeFilenameFromUrlLongPaths(t *testing.T) {
+	longHost := strings.Repeat("a", 63) + "." + strings.Repeat("b", 63) + "." + strings.Repeat("c", 63) + ".example"
+	longName := strings.Repeat("x", 400)
+
+	crls := []string{
+		"http://" + longHost + "/" + longName + ".crl",
+		"http://" + longHost + "/" + longName + "-1.crl",
+		"http://" + longHost + "/" + longName + "-2.crl",
+		"http://repository.net/" + longName + "1.crl",
+		"http://repository.net/" + longName + "2.crl",
+	}
+
+	names := make(map[string]bool)
+	for _, crl := range crls {
+		crlUrl, err := url.Parse(crl)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filename := makeFilenameFromUrl(*crlUrl)
+		if len(filename)+downloader.MaxSuffixLength > 255 {
+			t.Errorf("Filename of %d bytes is too long for its temporary files: %s", len(filename), filename)
+		}
+		if !strings.HasSuffix(filename, ".crl") {
+			t.Errorf("Expected a .crl suffix on %s", filename)
+		}
+		if names[filename] {
+			t.Errorf("Name collision: %s", filename)
+		}
+		names[filename] = true
+	}
+
+	// URLs differing only past the truncation point share a readable prefix
+	first, _ := url.Parse(crls[3])
+	second, _ := url.Parse(crls[4])
+	firstName := makeFilenameFromUrl(*first)
+	secondName := makeFilenameFromUrl(*second)
+	prefixLen := len(firstName) - len("-0123456789abcdef.crl")
+	if firstName[:prefixLen] != secondName[:prefixLen] {
+		t.Errorf("Expected a shared truncated prefix, got %s and %s", firstName, secondName)
+	}
+}
+
+func makeCA(t testing.TB) (*x509.Certificate, interface{}) {
 	t.Helper()
 	caTemplate := &x509.Certificate{
 		SerialNumber: big.NewInt(time.Now().Unix()),
@@ -66,7 +126,7 @@ func makeCA(t *testing.T) (*x509.Certificate, interface{}) {
 		NotAfter:              time.Now().AddDate(10, 0, 0),
 		IsCA:                  true,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 		BasicConstraintsValid: true,
 	}
 
@@ -90,60 +150,19 @@ func makeCA(t *testing.T) (*x509.Certificate, interface{}) {
 
 func makeCRL(t *testing.T, ca *x509.Certificate, caPrivKey interface{}, thisUpdate time.Time, nextUpdate time.Time) []byte {
 	t.Helper()
-	revokedCerts := []pkix.RevokedCertificate{}
-
-	crlBytes, err := ca.CreateCRL(rand.Reader, caPrivKey, revokedCerts, thisUpdate, nextUpdate)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	return crlBytes
+	return makeCRLWithRevocations(t, ca, caPrivKey, thisUpdate, nextUpdate, []pkix.RevokedCertificate{})
 }
 
-func Test_loadAndCheckSignatureOfCRL(t *testing.T) {
-	thisUpdate := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
-	nextUpdate := time.Date(2020, time.February, 1, 0, 0, 0, 0, time.UTC)
-
-	ca, caPrivKey := makeCA(t)
-
-	crlBytes := makeCRL(t, ca, caPrivKey, thisUpdate, nextUpdate)
+func makeCRLWithRevocations(t testing.TB, ca *x509.Certificate, caPrivKey interface{}, thisUpdate time.Time,
+	nextUpdate time.Time, revokedCerts []pkix.RevokedCertificate) []byte {
+	t.Helper()
 
-	crlPath, err := ioutil.TempFile("", "loadAndCheckSignatureOfCRL")
+	crlBytes, err := ca.CreateCRL(rand.Reader, caPrivKey, revokedCerts, thisUpdate, nextUpdate)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	defer os.Remove(crlPath.Name())
-
-	if _, err := crlPath.Write(crlBytes); err != nil {
-		t.Fatal(err)
-	}
-	if err := crlPath.Close(); err != nil {
-		t.Fatal(err)
-	}
-
-	list, sha256sum, err := loadAndCheckSignatureOfCRL(crlPath.Name(), ca)
-	if err != nil {
-		t.Error(err)
-	}
-
-	if list.TBSCertList.ThisUpdate != thisUpdate {
-		t.Error("This Update didn't match")
-	}
-
-	if list.TBSCertList.NextUpdate != nextUpdate {
-		t.Error("This Update didn't match")
-	}
-
-	if len(sha256sum) != 32 {
-		t.Error("Expected a 32-byte sha256 digest")
-	}
-
-	otherCa, _ := makeCA(t)
-	_, _, err = loadAndCheckSignatureOfCRL(crlPath.Name(), otherCa)
-	if !strings.Contains(err.Error(), "verification failure") {
-		t.Error(err)
-	}
+	return crlBytes
 }
 
 func Test_verifyCRL(t *testing.T) {
@@ -241,7 +260,7 @@ func Test_verifyCRL(t *testing.T) {
 	}
 }
 
-func hostCRL(t *testing.T, crlBytes []byte) *httptest.Server {
+func hostCRL(t testing.TB, crlBytes []byte) *httptest.Server {
 	t.Helper()
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		_, err := w.Write(crlBytes)
@@ -370,6 +389,87 @@ func Test_crlFetchWorker(t *testing.T) {
 	}
 }
 
+// A URL truncated to the longest filename still downloads, with room for the
+// downloader's tmp and partial download files
+func Test_crlFetchWorkerProcessOneLongUrl(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_crlFetchWorkerProcessOneLongUrl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	*crlpath = tmpDir
+	defer os.RemoveAll(tmpDir)
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+	ca, caPrivKey := makeCA(t)
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+	ae := AggregateEngine{
+		loadStorageDB: storageDB,
+		saveStorage:   storage.NewMockBackend(),
+		remoteCache:   storage.NewMockRemoteCache(),
+		issuers:       issuersObj,
+		display:       display,
+		auditor:       NewCrlAuditor(issuersObj),
+	}
+
+	crlBytes := makeCRL(t, ca, caPrivKey, time.Now().AddDate(0, 0, -1), time.Now().AddDate(0, 0, 1))
+	lastMod := time.Now().Add(-1 * time.Hour)
+
+	// The first download is cut off, so the next attempt resumes it
+	var mutex sync.Mutex
+	var truncated bool
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		w.Header().Set("Etag", `"v1"`)
+		if r.Method == http.MethodGet && r.Header.Get("Range") != "" {
+			ranges = append(ranges, r.Header.Get("Range"))
+		}
+		if r.Method == http.MethodGet && !truncated {
+			truncated = true
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", len(crlBytes)))
+			_, _ = w.Write(crlBytes[:len(crlBytes)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "long.crl", lastMod, bytes.NewReader(crlBytes))
+	}))
+	defer server.Close()
+
+	crlUrl, _ := url.Parse(server.URL + "/" + strings.Repeat("x", 400) + ".crl")
+	path, err := ae.crlFetchWorkerProcessOne(context.TODO(), *crlUrl, issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filepath.Base(path)) != maxCrlFilenameLength {
+		t.Errorf("Expected a filename at the cap of %d bytes, got %d: %s", maxCrlFilenameLength,
+			len(filepath.Base(path)), path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil || !bytes.Equal(data, crlBytes) {
+		t.Errorf("Expected the downloaded CRL: %v", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(ranges) != 1 {
+		t.Errorf("Expected the cut off download to be resumed once, got %v", ranges)
+	}
+	entries, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only the CRL to be left, got %d files", len(entries))
+	}
+}
+
 func Test_crlFetchWorkerProcessOne(t *testing.T) {
 	tmpDir, err := ioutil.TempDir("", "Test_crlFetchWorkerProcessOne")
 	if err != nil {
@@ -437,3 +537,2550 @@ func Test_crlFetchWorkerProcessOne(t *testing.T) {
 		assertEntryUrlAndIssuer(t, &e, issuer, issuersObj, unavailableUrl)
 	}
 }
+
+func writeTempCRL(t *testing.T, prefix string, crlBytes []byte) string {
+	t.Helper()
+	fd, err := ioutil.TempFile("", prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fd.Write(crlBytes); err != nil {
+		t.Fatal(err)
+	}
+	if err := fd.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return fd.Name()
+}
+
+func Test_aggregateCRLWorkerDeduplicates(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_aggregateCRLWorkerDeduplicates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+	auditor := NewCrlAuditor(issuersObj)
+
+	ae := AggregateEngine{
+		loadStorageDB: storageDB,
+		saveStorage:   storage.NewLocalDiskBackend(permMode, tmpDir),
+		remoteCache:   storage.NewMockRemoteCache(),
+		issuers:       issuersObj,
+		display:       display,
+		auditor:       auditor,
+	}
+
+	ca, caPrivKey := makeCA(t)
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+	thisUpdate := time.Now().UTC()
+	nextUpdate := thisUpdate.AddDate(0, 0, 1)
+
+	revoked := func(serials ...int64) []pkix.RevokedCertificate {
+		list := []pkix.RevokedCertificate{}
+		for _, s := range serials {
+			list = append(list, pkix.RevokedCertificate{
+				SerialNumber:   big.NewInt(s),
+				RevocationTime: thisUpdate,
+			})
+		}
+		return list
+	}
+
+	crl1Path := writeTempCRL(t, "crl1", makeCRLWithRevocations(t, ca, caPrivKey, thisUpdate, nextUpdate,
+		revoked(1, 2, 3)))
+	defer os.Remove(crl1Path)
+	crl2Path := writeTempCRL(t, "crl2", makeCRLWithRevocations(t, ca, caPrivKey, thisUpdate, nextUpdate,
+		revoked(2, 3, 4)))
+	defer os.Remove(crl2Path)
+
+	crl1Url, _ := url.Parse("http://example.com/crl1.crl")
+	crl2Url, _ := url.Parse("http://example.com/crl2.crl")
+
+	workChan := make(chan types.IssuerCrlUrlPaths, 1)
+	workChan <- types.IssuerCrlUrlPaths{
+		Issuer: issuer,
+		CrlUrlPaths: []types.UrlPath{
+			{Url: *crl1Url, Path: crl1Path},
+			{Url: *crl2Url, Path: crl2Path},
+		},
+	}
+	close(workChan)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	ae.aggregateCRLWorker(context.TODO(), &wg, workChan, make(chan issuerError, 1), display.AddBar(1))
+
+	if !issuersObj.IsIssuerEnrolled(issuer) {
+		t.Error("Issuer should have been enrolled")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(tmpDir, issuer.ID()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 4 {
+		t.Errorf("Expected 4 unique serials, got %d: %v", len(lines), lines)
+	}
+}
+
+func Test_aggregateCRLWorkerSerialOut(t *testing.T) {
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+
+	// Nothing may be saved, since there's nowhere to save it
+	var stdout bytes.Buffer
+	ae := AggregateEngine{
+		loadStorageDB: storageDB,
+		remoteCache:   storage.NewMockRemoteCache(),
+		issuers:       issuersObj,
+		display:       display,
+		auditor:       NewCrlAuditor(issuersObj),
+		serialOut:     &stdout,
+	}
+
+	ca, caPrivKey := makeCA(t)
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+	thisUpdate := time.Now().UTC()
+	crlPath := writeTempCRL(t, "crl", makeCRLWithRevocations(t, ca, caPrivKey, thisUpdate, thisUpdate.AddDate(0, 0, 1),
+		[]pkix.RevokedCertificate{
+			{SerialNumber: big.NewInt(0x2a), RevocationTime: thisUpdate},
+			{SerialNumber: big.NewInt(0x0102), RevocationTime: thisUpdate},
+		}))
+	defer os.Remove(crlPath)
+	crlUrl, _ := url.Parse("http://example.com/crl.crl")
+
+	workChan := make(chan types.IssuerCrlUrlPaths, 1)
+	workChan <- types.IssuerCrlUrlPaths{
+		Issuer:      issuer,
+		CrlUrlPaths: []types.UrlPath{{Url: *crlUrl, Path: crlPath}},
+	}
+	close(workChan)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	ae.aggregateCRLWorker(context.TODO(), &wg, workChan, make(chan issuerError, 1), display.AddBar(1))
+
+	if !issuersObj.IsIssuerEnrolled(issuer) {
+		t.Error("Issuer should have been enrolled")
+	}
+	if stdout.String() != "2a\n0102\n" {
+		t.Errorf("Expected the serials as hex lines, got %q", stdout.String())
+	}
+}
+
+func Test_aggregateCRLWorkerHashSerials(t *testing.T) {
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+
+	var stdout bytes.Buffer
+	ae := AggregateEngine{
+		loadStorageDB: storageDB,
+		remoteCache:   storage.NewMockRemoteCache(),
+		issuers:       issuersObj,
+		display:       display,
+		auditor:       NewCrlAuditor(issuersObj),
+		serialOut:     &stdout,
+		hashSerials:   true,
+	}
+
+	ca, caPrivKey := makeCA(t)
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+	thisUpdate := time.Now().UTC()
+	crlPath := writeTempCRL(t, "crl", makeCRLWithRevocations(t, ca, caPrivKey, thisUpdate, thisUpdate.AddDate(0, 0, 1),
+		[]pkix.RevokedCertificate{
+			{SerialNumber: big.NewInt(0x2a), RevocationTime: thisUpdate},
+		}))
+	defer os.Remove(crlPath)
+	crlUrl, _ := url.Parse("http://example.com/crl.crl")
+
+	workChan := make(chan types.IssuerCrlUrlPaths, 1)
+	workChan <- types.IssuerCrlUrlPaths{
+		Issuer:      issuer,
+		CrlUrlPaths: []types.UrlPath{{Url: *crlUrl, Path: crlPath}},
+	}
+	close(workChan)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	ae.aggregateCRLWorker(context.TODO(), &wg, workChan, make(chan issuerError, 1), display.AddBar(1))
+
+	if !issuersObj.IsIssuerEnrolled(issuer) {
+		t.Error("Issuer should have been enrolled")
+	}
+	digest := sha256.Sum256(append(append([]byte{}, ca.RawSubjectPublicKeyInfo...), 0x2a))
+	expected := hex.EncodeToString(digest[:]) + "\n"
+	if stdout.String() != expected {
+		t.Errorf("Expected the hashed serial %q, got %q", expected, stdout.String())
+	}
+}
+
+func Test_crlFetchWorkerProcessOneNextUpdate(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_crlFetchWorkerProcessOneNextUpdate")
+	if err != nil {
+		t.Error(err)
+	}
+	*crlpath = tmpDir
+	defer os.RemoveAll(tmpDir)
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+
+	ca, caPrivKey := makeCA(t)
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+	newEngine := func() (AggregateEngine, *CrlAuditor) {
+		auditor := NewCrlAuditor(issuersObj)
+		return AggregateEngine{
+			loadStorageDB: storageDB,
+			saveStorage:   storage.NewMockBackend(),
+			remoteCache:   storage.NewMockRemoteCache(),
+			issuers:       issuersObj,
+			display:       display,
+			auditor:       auditor,
+		}, auditor
+	}
+
+	// nextUpdate in the past, but freshly downloaded
+	expiredCrlBytes := makeCRL(t, ca, caPrivKey, time.Now().AddDate(0, 0, -2), time.Now().AddDate(0, 0, -1))
+	expiredServer := hostCRL(t, expiredCrlBytes)
+	defer expiredServer.Close()
+
+	ae, auditor := newEngine()
+	expiredUrl, _ := url.Parse(expiredServer.URL + "/expired.crl")
+	path, err := ae.crlFetchWorkerProcessOne(context.TODO(), *expiredUrl, issuer)
+	if err != nil {
+		t.Error(err)
+	}
+	if path == "" {
+		t.Error("A stale CRL should still have been accepted")
+	}
+	assertAuditorReportHasEntries(t, auditor, 1)
+	if auditor.GetEntries()[0].Kind != AuditKindExpired {
+		t.Errorf("Expected an expired entry, got %+v", auditor.GetEntries()[0])
+	}
+
+	// nextUpdate in the future, but the local file is old
+	validCrlBytes := makeCRL(t, ca, caPrivKey, time.Now().AddDate(0, 0, -1), time.Now().AddDate(0, 0, 1))
+	oldServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastMod := time.Now().Add(-2 * allowableAgeOfLocalCRL)
+		w.Header().Set("Last-Modified", lastMod.UTC().Format(http.TimeFormat))
+		_, _ = w.Write(validCrlBytes)
+	}))
+	defer oldServer.Close()
+
+	ae, auditor = newEngine()
+	oldUrl, _ := url.Parse(oldServer.URL + "/old.crl")
+	path, err = ae.crlFetchWorkerProcessOne(context.TODO(), *oldUrl, issuer)
+	if err != nil {
+		t.Error(err)
+	}
+	_, localDate, err := downloader.GetSizeAndDateOfFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(localDate) < allowableAgeOfLocalCRL {
+		t.Errorf("Expected the local file to be old, but it is from %s", localDate)
+	}
+	assertAuditorReportHasEntries(t, auditor, 0)
+}
+
+func Test_gzippedCRL(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_gzippedCRL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	*crlpath = tmpDir
+	defer os.RemoveAll(tmpDir)
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+
+	ae := AggregateEngine{
+		loadStorageDB: storageDB,
+		saveStorage:   storage.NewMockBackend(),
+		remoteCache:   storage.NewMockRemoteCache(),
+		issuers:       issuersObj,
+		display:       display,
+		auditor:       NewCrlAuditor(issuersObj),
+	}
+
+	ca, caPrivKey := makeCA(t)
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+	thisUpdate := time.Now().UTC()
+	revokedCerts := []pkix.RevokedCertificate{
+		{SerialNumber: big.NewInt(1), RevocationTime: thisUpdate},
+		{SerialNumber: big.NewInt(0xABCDEF), RevocationTime: thisUpdate},
+	}
+	crlBytes := makeCRLWithRevocations(t, ca, caPrivKey, thisUpdate, thisUpdate.AddDate(0, 0, 1), revokedCerts)
+
+	var gzBuf bytes.Buffer
+	gzWriter := gzip.NewWriter(&gzBuf)
+	if _, err := gzWriter.Write(crlBytes); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	plainServer := hostCRL(t, crlBytes)
+	defer plainServer.Close()
+	gzServer := hostCRL(t, gzBuf.Bytes())
+	defer gzServer.Close()
+
+	loadSerials := func(crlUrl string) []storage.Serial {
+		u, _ := url.Parse(crlUrl)
+		path, err := ae.crlFetchWorkerProcessOne(context.TODO(), *u, issuer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		crl, _, err := crlcheck.LoadAndCheckSignatureOfCRL(path, ca, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serials, _, err := crlcheck.ProcessCRL(crl, ca)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return serials
+	}
+
+	plainSerials := loadSerials(plainServer.URL + "/plain.crl")
+	gzSerials := loadSerials(gzServer.URL + "/wrapped.crl.gz")
+
+	if len(plainSerials) != len(revokedCerts) {
+		t.Errorf("Expected %d serials, got %d", len(revokedCerts), len(plainSerials))
+	}
+	if !reflect.DeepEqual(plainSerials, gzSerials) {
+		t.Errorf("Expected identical serials, got %v and %v", plainSerials, gzSerials)
+	}
+}
+
+func Test_crlFetchWorkerProcessOneManifest(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_crlFetchWorkerProcessOneManifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	*crlpath = tmpDir
+	defer os.RemoveAll(tmpDir)
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+
+	ca, caPrivKey := makeCA(t)
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+	ae := AggregateEngine{
+		loadStorageDB: storageDB,
+		saveStorage:   storage.NewMockBackend(),
+		remoteCache:   storage.NewMockRemoteCache(),
+		issuers:       issuersObj,
+		display:       display,
+		auditor:       NewCrlAuditor(issuersObj),
+		manifest:      types.NewCrlManifest(),
+	}
+
+	thisUpdate := time.Now().AddDate(0, 0, -1).UTC().Truncate(time.Second)
+	nextUpdate := time.Now().AddDate(0, 0, 1).UTC().Truncate(time.Second)
+	crlBytes := makeCRL(t, ca, caPrivKey, thisUpdate, nextUpdate)
+	server := hostCRL(t, crlBytes)
+
+	crlUrl, _ := url.Parse(server.URL + "/manifest.crl")
+	path, err := ae.crlFetchWorkerProcessOne(context.TODO(), *crlUrl, issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shasum := sha256.Sum256(crlBytes)
+	expected := types.CrlManifestEntry{
+		Url:        crlUrl.String(),
+		Path:       path,
+		SHA256:     hex.EncodeToString(shasum[:]),
+		Size:       int64(len(crlBytes)),
+		ThisUpdate: thisUpdate,
+		NextUpdate: nextUpdate,
+	}
+
+	entry, ok := ae.manifest.Get(issuer, crlUrl.String())
+	if !ok {
+		t.Fatal("Expected a manifest entry for the downloaded CRL")
+	}
+	if !entry.ThisUpdate.Equal(expected.ThisUpdate) || !entry.NextUpdate.Equal(expected.NextUpdate) {
+		t.Errorf("Unexpected validity: %+v", entry)
+	}
+	entry.ThisUpdate, entry.NextUpdate = expected.ThisUpdate, expected.NextUpdate
+	if entry != expected {
+		t.Errorf("Expected %+v, got %+v", expected, entry)
+	}
+
+	// With the server gone, the cached copy is used and must still be listed
+	server.Close()
+	ae.manifest = types.NewCrlManifest()
+
+	path, err = ae.crlFetchWorkerProcessOne(context.TODO(), *crlUrl, issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, ok = ae.manifest.Get(issuer, crlUrl.String())
+	if !ok {
+		t.Fatal("Expected a manifest entry for the cached CRL")
+	}
+	if !entry.FromCache {
+		t.Error("Expected the entry to be marked as from the cache")
+	}
+	if entry.Path != path || entry.SHA256 != expected.SHA256 {
+		t.Errorf("Expected the cached entry to match the original, got %+v", entry)
+	}
+}
+
+func Test_workerCount(t *testing.T) {
+	if count := workerCount(3, downloadWorkersPerCPU); count != 3 {
+		t.Errorf("Expected an explicit count to be kept, got %d", count)
+	}
+	if count := workerCount(0, 1); count != runtime.NumCPU() {
+		t.Errorf("Expected %d workers, got %d", runtime.NumCPU(), count)
+	}
+	if count := workerCount(-1, downloadWorkersPerCPU); count != runtime.NumCPU()*downloadWorkersPerCPU {
+		t.Errorf("Expected %d workers, got %d", runtime.NumCPU()*downloadWorkersPerCPU, count)
+	}
+}
+
+func Test_aggregateCRLWorkerSkipsIdenticalContent(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_aggregateCRLWorkerSkipsIdenticalContent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+	auditor := NewCrlAuditor(issuersObj)
+
+	ae := AggregateEngine{
+		loadStorageDB: storageDB,
+		saveStorage:   storage.NewLocalDiskBackend(permMode, tmpDir),
+		remoteCache:   storage.NewMockRemoteCache(),
+		issuers:       issuersObj,
+		display:       display,
+		auditor:       auditor,
+	}
+
+	ca, caPrivKey := makeCA(t)
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+	thisUpdate := time.Now().UTC()
+	crlBytes := makeCRLWithRevocations(t, ca, caPrivKey, thisUpdate, thisUpdate.AddDate(0, 0, 1),
+		[]pkix.RevokedCertificate{{SerialNumber: big.NewInt(1), RevocationTime: thisUpdate}})
+
+	// Same content, fetched separately over http and https
+	httpPath := writeTempCRL(t, "httpCrl", crlBytes)
+	defer os.Remove(httpPath)
+	httpsPath := writeTempCRL(t, "httpsCrl", crlBytes)
+	defer os.Remove(httpsPath)
+
+	httpUrl, _ := url.Parse("http://example.com/ca.crl")
+	httpsUrl, _ := url.Parse("https://example.com/ca.crl")
+
+	workChan := make(chan types.IssuerCrlUrlPaths, 1)
+	workChan <- types.IssuerCrlUrlPaths{
+		Issuer: issuer,
+		CrlUrlPaths: []types.UrlPath{
+			{Url: *httpUrl, Path: httpPath},
+			{Url: *httpsUrl, Path: httpsPath},
+		},
+	}
+	close(workChan)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	ae.aggregateCRLWorker(context.TODO(), &wg, workChan, make(chan issuerError, 1), display.AddBar(1))
+
+	if !issuersObj.IsIssuerEnrolled(issuer) {
+		t.Error("Issuer should have been enrolled")
+	}
+
+	entries := auditor.GetEntries()
+	if len(entries) != 1 {
+		t.Fatalf("Expected one audit entry, got %+v", entries)
+	}
+	if entries[0].Kind != AuditKindValid || entries[0].Url != httpUrl.String() {
+		t.Errorf("Expected only the first URL to be processed, got %+v", entries[0])
+	}
+}
+
+func Test_aggregateCRLWorkerCountsProgress(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_aggregateCRLWorkerCountsProgress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+
+	ae := AggregateEngine{
+		loadStorageDB: storageDB,
+		saveStorage:   storage.NewLocalDiskBackend(permMode, tmpDir),
+		remoteCache:   storage.NewMockRemoteCache(),
+		issuers:       issuersObj,
+		display:       display,
+		auditor:       NewCrlAuditor(issuersObj),
+		progress:      telemetry.NewProgress("test"),
+	}
+
+	ca, caPrivKey := makeCA(t)
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+	thisUpdate := time.Now().UTC()
+	crlBytes := makeCRLWithRevocations(t, ca, caPrivKey, thisUpdate, thisUpdate.AddDate(0, 0, 1),
+		[]pkix.RevokedCertificate{
+			{SerialNumber: big.NewInt(1), RevocationTime: thisUpdate},
+			{SerialNumber: big.NewInt(2), RevocationTime: thisUpdate},
+		})
+	crlPath := writeTempCRL(t, "progressCrl", crlBytes)
+	defer os.Remove(crlPath)
+
+	crlUrl, _ := url.Parse("http://example.com/ca.crl")
+
+	workChan := make(chan types.IssuerCrlUrlPaths, 1)
+	workChan <- types.IssuerCrlUrlPaths{
+		Issuer:      issuer,
+		CrlUrlPaths: []types.UrlPath{{Url: *crlUrl, Path: crlPath}},
+	}
+	close(workChan)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	ae.aggregateCRLWorker(context.TODO(), &wg, workChan, make(chan issuerError, 1), display.AddBar(1))
+
+	recorder := httptest.NewRecorder()
+	ae.progress.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	for _, line := range []string{"test_issuers_processed 1\n", "test_serials_aggregated 2\n"} {
+		if !strings.Contains(body, line) {
+			t.Errorf("Expected %q in the metrics:\n%s", line, body)
+		}
+	}
+}
+
+func Test_aggregateCRLWorkerEnrollmentReasons(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_aggregateCRLWorkerEnrollmentReasons")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	ca, caPrivKey := makeCA(t)
+	otherCa, otherPrivKey := makeCA(t)
+	thisUpdate := time.Now().UTC()
+
+	revokedPath := writeTempCRL(t, "revoked", makeCRLWithRevocations(t, ca, caPrivKey, thisUpdate,
+		thisUpdate.AddDate(0, 0, 1), []pkix.RevokedCertificate{{SerialNumber: big.NewInt(1), RevocationTime: thisUpdate}}))
+	defer os.Remove(revokedPath)
+	emptyPath := writeTempCRL(t, "empty", makeCRL(t, ca, caPrivKey, thisUpdate, thisUpdate.AddDate(0, 0, 1)))
+	defer os.Remove(emptyPath)
+	// Signed by the wrong CA, so it fails validation
+	wrongSignerPath := writeTempCRL(t, "wrongSigner", makeCRL(t, otherCa, otherPrivKey, thisUpdate, thisUpdate.AddDate(0, 0, 1)))
+	defer os.Remove(wrongSignerPath)
+	garbagePath := writeTempCRL(t, "garbage", []byte("not a CRL"))
+	defer os.Remove(garbagePath)
+
+	testcases := []struct {
+		name     string
+		paths    []string
+		enrolled bool
+		reason   rootprogram.EnrollmentReason
+	}{
+		{"valid", []string{revokedPath}, true, rootprogram.ReasonEnrolled},
+		{"no revocations", []string{emptyPath}, true, rootprogram.ReasonEnrolled},
+		{"empty and revoked", []string{emptyPath, revokedPath}, true, rootprogram.ReasonEnrolled},
+		{"no CRLs", []string{}, false, rootprogram.ReasonNoRevocations},
+		{"empty and invalid", []string{emptyPath, garbagePath}, false, rootprogram.ReasonSomeCrlsFailed},
+		{"all invalid", []string{wrongSignerPath, garbagePath}, false, rootprogram.ReasonAllCrlsFailedValidation},
+		{"all undownloaded", []string{"", ""}, false, rootprogram.ReasonAllCrlsFailedDownload},
+		{"invalid and undownloaded", []string{wrongSignerPath, ""}, false, rootprogram.ReasonSomeCrlsFailed},
+		{"some invalid", []string{revokedPath, garbagePath}, false, rootprogram.ReasonSomeCrlsFailed},
+	}
+
+	for _, tc := range testcases {
+		display := mpb.New(
+			mpb.WithOutput(ioutil.Discard),
+		)
+		storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+		issuersObj := rootprogram.NewMozillaIssuers()
+		issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+		ae := AggregateEngine{
+			loadStorageDB: storageDB,
+			saveStorage:   storage.NewLocalDiskBackend(permMode, tmpDir),
+			remoteCache:   storage.NewMockRemoteCache(),
+			issuers:       issuersObj,
+			display:       display,
+			auditor:       NewCrlAuditor(issuersObj),
+		}
+
+		urlPaths := []types.UrlPath{}
+		for i, p := range tc.paths {
+			crlUrl, _ := url.Parse(fmt.Sprintf("http://example.com/%d.crl", i))
+			urlPaths = append(urlPaths, types.UrlPath{Url: *crlUrl, Path: p})
+		}
+
+		workChan := make(chan types.IssuerCrlUrlPaths, 1)
+		workChan <- types.IssuerCrlUrlPaths{Issuer: issuer, CrlUrlPaths: urlPaths}
+		close(workChan)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		ae.aggregateCRLWorker(context.TODO(), &wg, workChan, make(chan issuerError, 1), display.AddBar(1))
+
+		if issuersObj.IsIssuerEnrolled(issuer) != tc.enrolled {
+			t.Errorf("%s: Expected enrolled=%v", tc.name, tc.enrolled)
+		}
+		reason, err := issuersObj.GetEnrollmentReason(issuer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reason != tc.reason {
+			t.Errorf("%s: Expected reason %s, got %s", tc.name, tc.reason, reason)
+		}
+	}
+}
+
+func Test_aggregateCRLWorkerEmptyCRL(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_aggregateCRLWorkerEmptyCRL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	ca, caPrivKey := makeCA(t)
+	thisUpdate := time.Now().UTC()
+	emptyPath := writeTempCRL(t, "empty", makeCRL(t, ca, caPrivKey, thisUpdate, thisUpdate.AddDate(0, 0, 1)))
+	defer os.Remove(emptyPath)
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+	ae := AggregateEngine{
+		loadStorageDB: storageDB,
+		saveStorage:   storage.NewLocalDiskBackend(permMode, tmpDir),
+		remoteCache:   storage.NewMockRemoteCache(),
+		issuers:       issuersObj,
+		display:       display,
+		auditor:       NewCrlAuditor(issuersObj),
+	}
+
+	crlUrl, _ := url.Parse("http://example.com/empty.crl")
+	workChan := make(chan types.IssuerCrlUrlPaths, 1)
+	workChan <- types.IssuerCrlUrlPaths{
+		Issuer:      issuer,
+		CrlUrlPaths: []types.UrlPath{{Url: *crlUrl, Path: emptyPath}},
+	}
+	close(workChan)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	ae.aggregateCRLWorker(context.TODO(), &wg, workChan, make(chan issuerError, 1), display.AddBar(1))
+
+	if !issuersObj.IsIssuerEnrolled(issuer) {
+		t.Error("Expected the issuer of an empty but valid CRL to be enrolled")
+	}
+
+	// The issuer is covered, so it gets a serials file, just an empty one
+	data, err := ioutil.ReadFile(filepath.Join(tmpDir, issuer.ID()))
+	if err != nil {
+		t.Fatalf("Expected a revoked serials file: %v", err)
+	}
+	if len(data) != 0 {
+		t.Errorf("Expected no revoked serials, got %q", data)
+	}
+
+	// It's still noted in the audit, as an empty CRL is unusual
+	found := false
+	for _, entry := range ae.auditor.GetEntries() {
+		if entry.Kind == AuditKindNoRevocations {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expected the empty CRL to be audited")
+	}
+}
+
+func Test_aggregateCRLWorkerUnknownIssuerCert(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_aggregateCRLWorkerUnknownIssuerCert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	ca, caPrivKey := makeCA(t)
+	thisUpdate := time.Now().UTC()
+	crlPath := writeTempCRL(t, "revoked", makeCRLWithRevocations(t, ca, caPrivKey, thisUpdate,
+		thisUpdate.AddDate(0, 0, 1), []pkix.RevokedCertificate{{SerialNumber: big.NewInt(1), RevocationTime: thisUpdate}}))
+	defer os.Remove(crlPath)
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+	ae := AggregateEngine{
+		loadStorageDB: storageDB,
+		saveStorage:   storage.NewLocalDiskBackend(permMode, tmpDir),
+		remoteCache:   storage.NewMockRemoteCache(),
+		issuers:       issuersObj,
+		display:       display,
+		auditor:       NewCrlAuditor(issuersObj),
+	}
+
+	crlUrl, _ := url.Parse("http://example.com/revoked.crl")
+	urlPaths := []types.UrlPath{{Url: *crlUrl, Path: crlPath}}
+	// In the program, but its certificate wasn't loaded
+	dropped := issuersObj.NewTestIssuerFromSubjectString("CN=Dropped Certificate")
+	workChan := make(chan types.IssuerCrlUrlPaths, 2)
+	// Without a certificate, the first issuer is skipped rather than ending
+	// the run
+	workChan <- types.IssuerCrlUrlPaths{Issuer: dropped, CrlUrlPaths: urlPaths}
+	workChan <- types.IssuerCrlUrlPaths{Issuer: issuer, CrlUrlPaths: urlPaths}
+	close(workChan)
+
+	errChan := make(chan issuerError, 2)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	ae.aggregateCRLWorker(context.TODO(), &wg, workChan, errChan, display.AddBar(2))
+	close(errChan)
+
+	if !issuersObj.IsIssuerEnrolled(issuer) {
+		t.Error("Expected the issuer after the unknown one to be enrolled")
+	}
+	if reason, err := issuersObj.GetEnrollmentReason(dropped); err != nil || reason != rootprogram.ReasonNoIssuerCertificate {
+		t.Errorf("Expected reason %s, got %s (%v)", rootprogram.ReasonNoIssuerCertificate, reason, err)
+	}
+
+	var issuerErrs []issuerError
+	for issuerErr := range errChan {
+		issuerErrs = append(issuerErrs, issuerErr)
+	}
+	if len(issuerErrs) != 1 || issuerErrs[0].Issuer.ID() != dropped.ID() ||
+		issuerErrs[0].Reason != rootprogram.ReasonNoIssuerCertificate || issuerErrs[0].Err == nil {
+		t.Errorf("Expected one error for %s without a certificate, got %v", dropped.ID(), issuerErrs)
+	}
+}
+
+func Test_aggregateCRLWorkerStreamsLargeCRLs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_aggregateCRLWorkerStreamsLargeCRLs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Every CRL counts as large
+	defer func(aThreshold int64) {
+		crlcheck.StreamingThreshold = aThreshold
+	}(crlcheck.StreamingThreshold)
+	crlcheck.StreamingThreshold = 1
+
+	ca, caPrivKey := makeCA(t)
+	thisUpdate := time.Now().UTC()
+	crlPath := writeTempCRL(t, "streamed", makeCRLWithRevocations(t, ca, caPrivKey, thisUpdate,
+		thisUpdate.AddDate(0, 0, 1), []pkix.RevokedCertificate{
+			{SerialNumber: big.NewInt(1), RevocationTime: thisUpdate},
+			{SerialNumber: big.NewInt(2), RevocationTime: thisUpdate},
+		}))
+	defer os.Remove(crlPath)
+	if !crlcheck.ShouldStream(crlPath) {
+		t.Fatal("Expected the CRL to be streamed")
+	}
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+	ae := AggregateEngine{
+		loadStorageDB: storageDB,
+		saveStorage:   storage.NewLocalDiskBackend(permMode, tmpDir),
+		remoteCache:   storage.NewMockRemoteCache(),
+		issuers:       issuersObj,
+		display:       display,
+		auditor:       NewCrlAuditor(issuersObj),
+	}
+
+	crlUrl, _ := url.Parse("http://example.com/streamed.crl")
+	workChan := make(chan types.IssuerCrlUrlPaths, 1)
+	workChan <- types.IssuerCrlUrlPaths{
+		Issuer:      issuer,
+		CrlUrlPaths: []types.UrlPath{{Url: *crlUrl, Path: crlPath}},
+	}
+	close(workChan)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	ae.aggregateCRLWorker(context.TODO(), &wg, workChan, make(chan issuerError, 1), display.AddBar(1))
+
+	if !issuersObj.IsIssuerEnrolled(issuer) {
+		t.Fatal("Expected the issuer of a streamed CRL to be enrolled")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(tmpDir, issuer.ID()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "01\n02\n" {
+		t.Errorf("Expected serials 1 and 2, got %q", data)
+	}
+
+	verifier := &CrlVerifier{expectedIssuerCert: ca, signers: issuersObj}
+	if err = verifier.IsValid(crlPath); err != nil {
+		t.Errorf("Expected the streamed CRL to verify: %s", err)
+	}
+	otherCa, _ := makeCA(t)
+	verifier = &CrlVerifier{expectedIssuerCert: otherCa}
+	if err = verifier.IsValid(crlPath); err == nil {
+		t.Error("Expected the streamed CRL not to verify against another CA")
+	}
+}
+
+// Fails to store one issuer's serials
+type failingStoreBackend struct {
+	storage.StorageBackend
+	failIssuer storage.Issuer
+}
+
+func (b failingStoreBackend) StoreKnownCertificateList(ctx context.Context, issuer storage.Issuer,
+	serials []storage.Serial) error {
+	if issuer.ID() == b.failIssuer.ID() {
+		return fmt.Errorf("disk full")
+	}
+	return b.StorageBackend.StoreKnownCertificateList(ctx, issuer, serials)
+}
+
+func Test_aggregateCRLsStoreFailure(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_aggregateCRLsStoreFailure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+
+	workChan := make(chan types.IssuerCrlUrlPaths, 2)
+	var issuers []storage.Issuer
+	thisUpdate := time.Now().UTC()
+	for _, name := range []string{"failing", "working"} {
+		ca, caPrivKey := makeCA(t)
+		issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+		issuers = append(issuers, issuer)
+		crlPath := writeTempCRL(t, name, makeCRLWithRevocations(t, ca, caPrivKey, thisUpdate,
+			thisUpdate.AddDate(0, 0, 1), []pkix.RevokedCertificate{{SerialNumber: big.NewInt(1), RevocationTime: thisUpdate}}))
+		defer os.Remove(crlPath)
+		crlUrl, _ := url.Parse("http://example.com/" + name + ".crl")
+		workChan <- types.IssuerCrlUrlPaths{Issuer: issuer, CrlUrlPaths: []types.UrlPath{{Url: *crlUrl, Path: crlPath}}}
+	}
+	close(workChan)
+	failing, working := issuers[0], issuers[1]
+
+	ae := AggregateEngine{
+		loadStorageDB: storageDB,
+		saveStorage: failingStoreBackend{
+			StorageBackend: storage.NewLocalDiskBackend(permMode, tmpDir),
+			failIssuer:     failing,
+		},
+		remoteCache:      storage.NewMockRemoteCache(),
+		issuers:          issuersObj,
+		display:          display,
+		auditor:          NewCrlAuditor(issuersObj),
+		aggregateThreads: 1,
+	}
+
+	storeErrs := ae.aggregateCRLs(context.TODO(), 2, workChan)
+	if len(storeErrs) != 1 || storeErrs[0].Issuer.ID() != failing.ID() || storeErrs[0].Reason != rootprogram.ReasonStoreFailed {
+		t.Fatalf("Expected one store error for %s, got %v", failing.ID(), storeErrs)
+	}
+
+	if issuersObj.IsIssuerEnrolled(failing) {
+		t.Error("Expected the issuer that couldn't be saved not to be enrolled")
+	}
+	if reason, err := issuersObj.GetEnrollmentReason(failing); err != nil || reason != rootprogram.ReasonStoreFailed {
+		t.Errorf("Expected reason %s, got %s: %v", rootprogram.ReasonStoreFailed, reason, err)
+	}
+	if !issuersObj.IsIssuerEnrolled(working) {
+		t.Error("Expected the other issuer to still be enrolled")
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, working.ID())); err != nil {
+		t.Errorf("Expected the other issuer's serials to be saved: %s", err)
+	}
+}
+
+// Renders a bar to completion with output for -nobars=aNoBars, returning what
+// was written to stdout
+func renderProgress(t *testing.T, aNoBars bool) []byte {
+	t.Helper()
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() {
+		os.Stdout = stdout
+	}()
+
+	display := mpb.New(
+		mpb.WithRefreshRate(time.Millisecond),
+		mpb.WithOutput(progressOutput(aNoBars, false)),
+	)
+	bar := display.AddBar(10)
+	for i := 0; i < 10; i++ {
+		bar.Increment()
+		time.Sleep(time.Millisecond)
+	}
+	display.Wait()
+
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	output, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return output
+}
+
+func Test_progressOutputNoBars(t *testing.T) {
+	if output := renderProgress(t, false); !bytes.Contains(output, []byte("\x1b[")) {
+		t.Fatalf("Expected the bars to write ANSI escapes, got %q", output)
+	}
+	if output := renderProgress(t, true); len(output) != 0 {
+		t.Errorf("Expected nothing written with -nobars, got %q", output)
+	}
+}
+
+func Test_looksLikeDER(t *testing.T) {
+	ca, caPrivKey := makeCA(t)
+	crlBytes := makeCRL(t, ca, caPrivKey, time.Now(), time.Now().AddDate(0, 0, 1))
+
+	var gzipped bytes.Buffer
+	gzWriter := gzip.NewWriter(&gzipped)
+	if _, err := gzWriter.Write(crlBytes); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	acceptable := map[string][]byte{
+		"der":   crlBytes,
+		"pem":   []byte("-----BEGIN X509 CRL-----\n"),
+		"pemws": []byte("\r\n-----BEGIN X509 CRL-----\n"),
+		"gzip":  gzipped.Bytes(),
+	}
+	for name, data := range acceptable {
+		path := writeTempCRL(t, name, data)
+		defer os.Remove(path)
+		if err := looksLikeDER(path); err != nil {
+			t.Errorf("Expected %s to be accepted: %s", name, err)
+		}
+	}
+
+	unacceptable := map[string][]byte{
+		"html":  []byte("<!DOCTYPE html><html><body>Not Found</body></html>"),
+		"empty": []byte{},
+		"short": []byte{0x30},
+	}
+	for name, data := range unacceptable {
+		path := writeTempCRL(t, name, data)
+		defer os.Remove(path)
+		if err := looksLikeDER(path); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+}
+
+func Test_CrlVerifierRejectsHTML(t *testing.T) {
+	ca, _ := makeCA(t)
+	verifier := &CrlVerifier{expectedIssuerCert: ca}
+
+	path := writeTempCRL(t, "html", []byte("<html><body>Service Unavailable</body></html>"))
+	defer os.Remove(path)
+
+	err := verifier.IsValid(path)
+	if err == nil || !strings.Contains(err.Error(), "does not look like a CRL") {
+		t.Errorf("Expected an early rejection of the HTML body, got %v", err)
+	}
+}
+
+func Test_CrlVerifierPreprocessor(t *testing.T) {
+	defer func() {
+		crlcheck.Preprocessor = nil
+	}()
+
+	ca, caPrivKey := makeCA(t)
+	verifier := &CrlVerifier{
+		expectedIssuerCert: ca,
+		signers:            rootprogram.NewMozillaIssuers(),
+	}
+
+	thisUpdate := time.Now().UTC()
+	crlBytes := makeCRL(t, ca, caPrivKey, thisUpdate, thisUpdate.AddDate(0, 0, 1))
+	path := writeTempCRL(t, "wrapped", []byte(base64.StdEncoding.EncodeToString(crlBytes)))
+	defer os.Remove(path)
+
+	if err := verifier.IsValid(path); err == nil {
+		t.Fatal("Expected the wrapped CRL to be rejected without a preprocessor")
+	}
+
+	crlcheck.Preprocessor = func(raw []byte) ([]byte, error) {
+		return base64.StdEncoding.DecodeString(string(raw))
+	}
+	if err := verifier.IsValid(path); err != nil {
+		t.Errorf("Expected the unwrapped CRL to be valid, got %v", err)
+	}
+}
+
+func Test_CrlVerifierLogsIssuerCert(t *testing.T) {
+	if err := flag.Set("v", "1"); err != nil {
+		t.Fatal(err)
+	}
+	defer flag.Set("v", "0")
+	var buf bytes.Buffer
+	logging.EnableJSON(&buf)
+	defer logging.EnableJSON(nil)
+
+	ca, _ := makeCA(t)
+	otherCa, otherPrivKey := makeCA(t)
+	verifier := &CrlVerifier{
+		expectedIssuerCert: ca,
+		signers:            rootprogram.NewMozillaIssuers(),
+	}
+
+	thisUpdate := time.Now().UTC()
+	path := writeTempCRL(t, "wrongSigner", makeCRL(t, otherCa, otherPrivKey, thisUpdate, thisUpdate.AddDate(0, 0, 1)))
+	defer os.Remove(path)
+
+	if err := verifier.IsValid(path); err == nil {
+		t.Fatal("Expected a CRL from another CA to fail verification")
+	}
+	if fp := crlcheck.CertFingerprint(ca); !strings.Contains(buf.String(), fp) {
+		t.Errorf("Expected the issuer certificate %s in the logs:\n%s", fp, buf.String())
+	}
+}
+
+func Test_crlFetchWorkerProcessOneSkipsUnchanged(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_crlFetchWorkerProcessOneSkipsUnchanged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	*crlpath = tmpDir
+	defer os.RemoveAll(tmpDir)
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+
+	ca, caPrivKey := makeCA(t)
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+	ae := AggregateEngine{
+		loadStorageDB: storageDB,
+		saveStorage:   storage.NewMockBackend(),
+		remoteCache:   storage.NewMockRemoteCache(),
+		issuers:       issuersObj,
+		display:       display,
+		auditor:       NewCrlAuditor(issuersObj),
+	}
+
+	crlBytes := makeCRL(t, ca, caPrivKey, time.Now().AddDate(0, 0, -1), time.Now().AddDate(0, 0, 1))
+	lastMod := time.Now().Add(-1 * time.Hour)
+
+	var mutex sync.Mutex
+	var gets int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.Method == "GET" {
+			gets++
+		}
+		w.Header().Set("Last-Modified", lastMod.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(crlBytes)))
+		_, _ = w.Write(crlBytes)
+	}))
+	defer server.Close()
+
+	crlUrl, _ := url.Parse(server.URL + "/unchanged.crl")
+	for i := 0; i < 2; i++ {
+		path, err := ae.crlFetchWorkerProcessOne(context.TODO(), *crlUrl, issuer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path == "" {
+			t.Fatal("Expected a path")
+		}
+	}
+
+	mutex.Lock()
+	if gets != 1 {
+		t.Errorf("Expected the unchanged CRL to be downloaded once, got %d downloads", gets)
+	}
+
+	// A newer Last-Modified forces a download
+	lastMod = time.Now()
+	mutex.Unlock()
+	if _, err := ae.crlFetchWorkerProcessOne(context.TODO(), *crlUrl, issuer); err != nil {
+		t.Fatal(err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if gets != 2 {
+		t.Errorf("Expected the newer CRL to be downloaded, got %d downloads", gets)
+	}
+}
+
+func Test_crlFetchWorkerProcessOneWaitsOncePerCrl(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_crlFetchWorkerProcessOneWaitsOncePerCrl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	*crlpath = tmpDir
+	defer os.RemoveAll(tmpDir)
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+
+	ca, caPrivKey := makeCA(t)
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+	ae := AggregateEngine{
+		loadStorageDB: storageDB,
+		saveStorage:   storage.NewMockBackend(),
+		remoteCache:   storage.NewMockRemoteCache(),
+		issuers:       issuersObj,
+		display:       display,
+		auditor:       NewCrlAuditor(issuersObj),
+		// Any second wait for the host would outlast the test
+		hostLimiter: downloader.NewHostRateLimiter(0.001),
+	}
+
+	crlBytes := makeCRL(t, ca, caPrivKey, time.Now().AddDate(0, 0, -1), time.Now().AddDate(0, 0, 1))
+	lastMod := time.Now().Add(-1 * time.Hour)
+
+	var mutex sync.Mutex
+	var heads, gets int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.Method == "HEAD" {
+			heads++
+		} else {
+			gets++
+		}
+		w.Header().Set("Last-Modified", lastMod.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(crlBytes)))
+		_, _ = w.Write(crlBytes)
+	}))
+	defer server.Close()
+
+	// An older cached copy, so the server is asked before downloading
+	crlUrl, _ := url.Parse(server.URL + "/changed.crl")
+	cachedPath := filepath.Join(tmpDir, issuer.ID(), makeFilenameFromUrl(*crlUrl))
+	if err = os.MkdirAll(filepath.Dir(cachedPath), permModeDir); err != nil {
+		t.Fatal(err)
+	}
+	cachedBytes := makeCRL(t, ca, caPrivKey, time.Now().AddDate(0, 0, -2), time.Now().AddDate(0, 0, 1))
+	if err = ioutil.WriteFile(cachedPath, cachedBytes, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cachedDate := lastMod.Add(-1 * time.Hour)
+	if err = os.Chtimes(cachedPath, cachedDate, cachedDate); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := ae.crlFetchWorkerProcessOne(ctx, *crlUrl, issuer); err != nil {
+		t.Fatalf("Expected one wait to cover the HEAD request and the download: %s", err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if heads == 0 || gets != 1 {
+		t.Errorf("Expected the server asked then the CRL downloaded, got %d HEADs and %d GETs", heads, gets)
+	}
+}
+
+func Test_crlFetchWorkerRemovesOrphanedTmpFiles(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_crlFetchWorkerRemovesOrphanedTmpFiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	*crlpath = tmpDir
+	defer os.RemoveAll(tmpDir)
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+	ca, caPrivKey := makeCA(t)
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+	ae := AggregateEngine{
+		loadStorageDB: storageDB,
+		saveStorage:   storage.NewMockBackend(),
+		remoteCache:   storage.NewMockRemoteCache(),
+		issuers:       issuersObj,
+		display:       display,
+		auditor:       NewCrlAuditor(issuersObj),
+	}
+
+	crlBytes := makeCRL(t, ca, caPrivKey, time.Now().AddDate(0, 0, -1), time.Now().AddDate(0, 0, 1))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(crlBytes)
+	}))
+	defer server.Close()
+	crlUrl, _ := url.Parse(server.URL + "/orphans.crl")
+
+	// A killed run's partial download, which must neither be used nor left
+	issuerDir := filepath.Join(tmpDir, issuer.ID())
+	if err = os.MkdirAll(issuerDir, permModeDir); err != nil {
+		t.Fatal(err)
+	}
+	orphan := filepath.Join(issuerDir, makeFilenameFromUrl(*crlUrl)+".1-00000000.tmp")
+	if err = ioutil.WriteFile(orphan, crlBytes[:len(crlBytes)/2], permMode); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * orphanedTmpFileAge)
+	if err = os.Chtimes(orphan, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	crlsChan := make(chan types.IssuerCrlUrls, 1)
+	crlsChan <- types.IssuerCrlUrls{Issuer: issuer, Urls: []url.URL{*crlUrl}}
+	close(crlsChan)
+	resultChan := make(chan types.IssuerCrlUrlPaths, 1)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	ae.crlFetchWorker(context.TODO(), &wg, crlsChan, resultChan, display.AddBar(1))
+
+	result := <-resultChan
+	if len(result.CrlUrlPaths) != 1 || result.CrlUrlPaths[0].Path == "" {
+		t.Fatalf("Expected the CRL to be fetched, got %+v", result.CrlUrlPaths)
+	}
+	data, err := ioutil.ReadFile(result.CrlUrlPaths[0].Path)
+	if err != nil || !bytes.Equal(data, crlBytes) {
+		t.Errorf("Expected the downloaded CRL, not the partial one: %v", err)
+	}
+	if _, err = os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("Expected the orphaned tmp file to be removed: %v", err)
+	}
+}
+
+func Test_crlFetchWorkerProcessOneRefetchAfter(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_crlFetchWorkerProcessOneRefetchAfter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	*crlpath = tmpDir
+	defer os.RemoveAll(tmpDir)
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+
+	ca, caPrivKey := makeCA(t)
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+	ae := AggregateEngine{
+		loadStorageDB: storageDB,
+		saveStorage:   storage.NewMockBackend(),
+		remoteCache:   storage.NewMockRemoteCache(),
+		issuers:       issuersObj,
+		display:       display,
+		auditor:       NewCrlAuditor(issuersObj),
+		refetchAfter:  6 * time.Hour,
+	}
+
+	crlBytes := makeCRL(t, ca, caPrivKey, time.Now().AddDate(0, 0, -1), time.Now().AddDate(0, 0, 1))
+
+	var mutex sync.Mutex
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		requests++
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(crlBytes)))
+		_, _ = w.Write(crlBytes)
+	}))
+	defer server.Close()
+
+	crlUrl, _ := url.Parse(server.URL + "/cached.crl")
+	cachedPath := filepath.Join(tmpDir, issuer.ID(), makeFilenameFromUrl(*crlUrl))
+	if err = os.MkdirAll(filepath.Dir(cachedPath), permModeDir); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(cachedPath, crlBytes, permMode); err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		name      string
+		age       time.Duration
+		contacted bool
+	}{
+		{"just under", ae.refetchAfter - time.Minute, false},
+		{"just over", ae.refetchAfter + time.Minute, true},
+	}
+
+	for _, tc := range testcases {
+		modTime := time.Now().Add(-tc.age)
+		if err = os.Chtimes(cachedPath, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		mutex.Lock()
+		requests = 0
+		mutex.Unlock()
+
+		path, err := ae.crlFetchWorkerProcessOne(context.TODO(), *crlUrl, issuer)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if path != cachedPath {
+			t.Errorf("%s: Expected the cached path %s, got %s", tc.name, cachedPath, path)
+		}
+
+		mutex.Lock()
+		if (requests > 0) != tc.contacted {
+			t.Errorf("%s: Expected contacted=%v, got %d requests", tc.name, tc.contacted, requests)
+		}
+		mutex.Unlock()
+	}
+}
+
+// CreateCRL can't set a CRL number, so assemble the CRL by hand
+func makeNumberedCRL(t *testing.T, ca *x509.Certificate, caPrivKey interface{}, thisUpdate time.Time,
+	number int64) []byte {
+	t.Helper()
+
+	numberBytes, err := asn1.Marshal(big.NewInt(number))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return makeCRLWithExtensions(t, ca, caPrivKey, thisUpdate, []pkix.RevokedCertificate{},
+		[]pkix.Extension{{Id: asn1.ObjectIdentifier{2, 5, 29, 20}, Value: numberBytes}})
+}
+
+func makeCRLWithExtensions(t *testing.T, ca *x509.Certificate, caPrivKey interface{}, thisUpdate time.Time,
+	revokedCerts []pkix.RevokedCertificate, extensions []pkix.Extension) []byte {
+	t.Helper()
+
+	sigAlgo := pkix.AlgorithmIdentifier{
+		Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}, // ecdsa-with-SHA256
+	}
+
+	tbsCertList := pkix.TBSCertificateList{
+		Version:             1,
+		Signature:           sigAlgo,
+		Issuer:              ca.Subject.ToRDNSequence(),
+		ThisUpdate:          thisUpdate.UTC(),
+		NextUpdate:          thisUpdate.AddDate(0, 0, 7).UTC(),
+		RevokedCertificates: revokedCerts,
+		Extensions:          extensions,
+	}
+
+	tbsBytes, err := asn1.Marshal(tbsCertList)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256(tbsBytes)
+	signature, err := caPrivKey.(*ecdsa.PrivateKey).Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crlBytes, err := asn1.Marshal(pkix.CertificateList{
+		TBSCertList:        tbsCertList,
+		SignatureAlgorithm: sigAlgo,
+		SignatureValue:     asn1.BitString{Bytes: signature, BitLength: len(signature) * 8},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return crlBytes
+}
+
+func Test_crlFetchWorkerProcessOneRejectsRollback(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_crlFetchWorkerProcessOneRejectsRollback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	*crlpath = tmpDir
+	defer os.RemoveAll(tmpDir)
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+
+	ca, caPrivKey := makeCA(t)
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+	auditor := NewCrlAuditor(issuersObj)
+	ae := AggregateEngine{
+		loadStorageDB: storageDB,
+		saveStorage:   storage.NewMockBackend(),
+		remoteCache:   storage.NewMockRemoteCache(),
+		issuers:       issuersObj,
+		display:       display,
+		auditor:       auditor,
+	}
+
+	now := time.Now()
+	var mutex sync.Mutex
+	crlBytes := makeNumberedCRL(t, ca, caPrivKey, now.AddDate(0, 0, -2), 10)
+	lastMod := now.Add(-3 * time.Hour)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		w.Header().Set("Last-Modified", lastMod.UTC().Format(http.TimeFormat))
+		_, _ = w.Write(crlBytes)
+	}))
+	defer server.Close()
+
+	crlUrl, _ := url.Parse(server.URL + "/numbered.crl")
+	loadNumber := func() *big.Int {
+		t.Helper()
+		path, err := ae.crlFetchWorkerProcessOne(context.TODO(), *crlUrl, issuer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		crl, _, err := crlcheck.LoadAndCheckSignatureOfCRL(path, ca, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, validity, err := crlcheck.ProcessCRL(crl, ca)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return validity.Number
+	}
+
+	if number := loadNumber(); number.Int64() != 10 {
+		t.Fatalf("Expected CRL number 10, got %s", number)
+	}
+
+	// A replayed CRL with a newer thisUpdate but a lower number is refused,
+	// and the cached CRL stays in use
+	mutex.Lock()
+	crlBytes = makeNumberedCRL(t, ca, caPrivKey, now.AddDate(0, 0, -1), 9)
+	lastMod = now.Add(-2 * time.Hour)
+	mutex.Unlock()
+	if number := loadNumber(); number.Int64() != 10 {
+		t.Errorf("Expected the cached CRL number 10 to be retained, got %s", number)
+	}
+	assertAuditorReportHasEntries(t, auditor, 1)
+	if entry := auditor.GetEntries()[0]; entry.Kind != AuditKindFailedVerify ||
+		!strings.Contains(strings.Join(entry.Errors, " "), "lower than the previous") {
+		t.Errorf("Expected a rollback audit entry, got %+v", entry)
+	}
+
+	mutex.Lock()
+	crlBytes = makeNumberedCRL(t, ca, caPrivKey, now, 11)
+	lastMod = now.Add(-1 * time.Hour)
+	mutex.Unlock()
+	if number := loadNumber(); number.Int64() != 11 {
+		t.Errorf("Expected the newer CRL number 11, got %s", number)
+	}
+}
+
+func Test_crlFetchWorkerProcessOneRejectsBadDates(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_crlFetchWorkerProcessOneRejectsBadDates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	*crlpath = tmpDir
+	defer os.RemoveAll(tmpDir)
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+
+	ca, caPrivKey := makeCA(t)
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+	auditor := NewCrlAuditor(issuersObj)
+	ae := AggregateEngine{
+		loadStorageDB: storageDB,
+		saveStorage:   storage.NewMockBackend(),
+		remoteCache:   storage.NewMockRemoteCache(),
+		issuers:       issuersObj,
+		display:       display,
+		auditor:       auditor,
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	cachedThisUpdate := now.AddDate(0, 0, -2)
+	var mutex sync.Mutex
+	crlBytes := makeCRL(t, ca, caPrivKey, cachedThisUpdate, now.AddDate(0, 0, 7))
+	lastMod := now.Add(-4 * time.Hour)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		w.Header().Set("Last-Modified", lastMod.Format(http.TimeFormat))
+		_, _ = w.Write(crlBytes)
+	}))
+	defer server.Close()
+
+	crlUrl, _ := url.Parse(server.URL + "/dated.crl")
+	loadThisUpdate := func() time.Time {
+		t.Helper()
+		path, err := ae.crlFetchWorkerProcessOne(context.TODO(), *crlUrl, issuer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		crl, _, err := crlcheck.LoadAndCheckSignatureOfCRL(path, ca, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return crl.TBSCertList.ThisUpdate
+	}
+
+	if thisUpdate := loadThisUpdate(); !thisUpdate.Equal(cachedThisUpdate) {
+		t.Fatalf("Expected thisUpdate %s, got %s", cachedThisUpdate, thisUpdate)
+	}
+
+	for i, dates := range []struct {
+		name       string
+		thisUpdate time.Time
+		nextUpdate time.Time
+		expected   string
+	}{
+		{"inverted", now.AddDate(0, 0, -1), now.AddDate(0, 0, -3), "is before its thisUpdate"},
+		{"future-dated", now.Add(crlcheck.MaxClockSkew + 48*time.Hour), now.AddDate(0, 0, 10), "in the future"},
+	} {
+		mutex.Lock()
+		crlBytes = makeCRL(t, ca, caPrivKey, dates.thisUpdate, dates.nextUpdate)
+		lastMod = now.Add(time.Duration(i-3) * time.Hour)
+		mutex.Unlock()
+
+		if thisUpdate := loadThisUpdate(); !thisUpdate.Equal(cachedThisUpdate) {
+			t.Errorf("Expected the cached CRL to be retained over the %s one, got thisUpdate %s",
+				dates.name, thisUpdate)
+		}
+		assertAuditorReportHasEntries(t, auditor, i+1)
+		if entry := auditor.GetEntries()[i]; entry.Kind != AuditKindFailedVerify ||
+			!strings.Contains(strings.Join(entry.Errors, " "), dates.expected) {
+			t.Errorf("Expected an audit entry for the %s CRL, got %+v", dates.name, entry)
+		}
+	}
+}
+
+func Test_parseIssuerFilter(t *testing.T) {
+	filter, err := parseIssuerFilter("")
+	if err != nil || filter != nil {
+		t.Errorf("Expected an empty spec to not filter, got %v, %v", filter, err)
+	}
+
+	filter, err = parseIssuerFilter("issuerA, issuerB,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(filter, map[string]bool{"issuerA": true, "issuerB": true}) {
+		t.Errorf("Unexpected filter: %v", filter)
+	}
+
+	listFile, err := ioutil.TempFile("", "Test_parseIssuerFilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(listFile.Name())
+	if _, err = listFile.WriteString("# Chasing a CRL problem\nissuerC\n\nissuerD\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err = listFile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	filter, err = parseIssuerFilter("@" + listFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(filter, map[string]bool{"issuerC": true, "issuerD": true}) {
+		t.Errorf("Unexpected filter: %v", filter)
+	}
+
+	if _, err = parseIssuerFilter("@/nonexistent/issuers"); err == nil {
+		t.Error("Expected an error for a missing list file")
+	}
+	if _, err = parseIssuerFilter(" , "); err == nil {
+		t.Error("Expected an error for a spec with no IDs")
+	}
+}
+
+func makeIdentifyEngine() (*AggregateEngine, storage.CertDatabase) {
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	remoteCache := storage.NewMockRemoteCache()
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), remoteCache)
+	issuersObj := rootprogram.NewMozillaIssuers()
+
+	return &AggregateEngine{
+		loadStorageDB: storageDB,
+		saveStorage:   storage.NewMockBackend(),
+		remoteCache:   remoteCache,
+		issuers:       issuersObj,
+		display:       display,
+		auditor:       NewCrlAuditor(issuersObj),
+	}, storageDB
+}
+
+// Records a certificate from the issuer with a CRL at aCrl
+func addIdentifiableIssuer(tb testing.TB, aStorageDB storage.CertDatabase, aIssuer storage.Issuer, aCrl string) {
+	tb.Helper()
+	leaf := &x509.Certificate{
+		NotAfter:              time.Now().AddDate(0, 0, 30),
+		CRLDistributionPoints: []string{aCrl},
+	}
+	_, err := aStorageDB.GetKnownCertificates(storage.NewExpDateFromTime(leaf.NotAfter), aIssuer).
+		WasUnknown(storage.NewSerialFromHex("01"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if _, err = aStorageDB.GetIssuerMetadata(aIssuer).Accumulate(leaf); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func Test_identifyCrlsByIssuerFilter(t *testing.T) {
+	ae, storageDB := makeIdentifyEngine()
+
+	threads := *ctconfig.NumThreads
+	*ctconfig.NumThreads = 1
+	defer func() {
+		*ctconfig.NumThreads = threads
+	}()
+
+	var issuers []storage.Issuer
+	for i := 0; i < 2; i++ {
+		ca, _ := makeCA(t)
+		issuer := ae.issuers.InsertIssuerFromCertAndPem(ca, "")
+		issuers = append(issuers, issuer)
+		addIdentifiableIssuer(t, storageDB, issuer, fmt.Sprintf("http://example.com/%d.crl", i))
+	}
+
+	crls, _ := ae.identifyCrlsByIssuer(context.TODO())
+	if len(crls) != 2 {
+		t.Errorf("Expected both issuers without a filter, got %v", crls)
+	}
+
+	ae.issuerFilter = map[string]bool{issuers[1].ID(): true}
+	crls, _ = ae.identifyCrlsByIssuer(context.TODO())
+	expected := types.IssuerCrlMap{
+		issuers[1].ID(): {"http://example.com/1.crl": true},
+	}
+	if !reflect.DeepEqual(crls, expected) {
+		t.Errorf("Expected %v, got %v", expected, crls)
+	}
+}
+
+func Test_identifyCrlsByIssuerStreams(t *testing.T) {
+	ae, storageDB := makeIdentifyEngine()
+
+	// Many more issuers than the queue holds
+	threads := *ctconfig.NumThreads
+	*ctconfig.NumThreads = 2
+	defer func() {
+		*ctconfig.NumThreads = threads
+	}()
+
+	expected := make(types.IssuerCrlMap)
+	for i := 0; i < 50; i++ {
+		issuer := ae.issuers.NewTestIssuerFromSubjectString(fmt.Sprintf("Issuer %d", i))
+		crl := fmt.Sprintf("http://example.com/%d.crl", i)
+		addIdentifiableIssuer(t, storageDB, issuer, crl)
+		expected[issuer.ID()] = map[string]bool{crl: true}
+	}
+	addIdentifiableIssuer(t, storageDB, storage.NewIssuerFromString("not in the program"),
+		"http://example.com/ignored.crl")
+
+	crls, _ := ae.identifyCrlsByIssuer(context.TODO())
+	if !reflect.DeepEqual(crls, expected) {
+		t.Errorf("Expected %d issuers, got %v", len(expected), crls)
+	}
+}
+
+func Test_identifyCrlsByIssuerCancelled(t *testing.T) {
+	ae, storageDB := makeIdentifyEngine()
+
+	threads := *ctconfig.NumThreads
+	*ctconfig.NumThreads = 1
+	defer func() {
+		*ctconfig.NumThreads = threads
+	}()
+
+	for i := 0; i < 50; i++ {
+		issuer := ae.issuers.NewTestIssuerFromSubjectString(fmt.Sprintf("Issuer %d", i))
+		addIdentifiableIssuer(t, storageDB, issuer, fmt.Sprintf("http://example.com/%d.crl", i))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Mustn't hang with the queue full
+	crls, _ := ae.identifyCrlsByIssuer(ctx)
+	if len(crls) != 0 {
+		t.Errorf("Expected no results once cancelled, got %v", crls)
+	}
+}
+
+func Test_parseCrlUrl(t *testing.T) {
+	for rawUrl, valid := range map[string]bool{
+		"http://example.com/a.crl":              true,
+		" https://example.com/a.crl\n":          true,
+		"ldap:///CN=Example,O=Example?crl":      true,
+		"example.com/a.crl":                     false,
+		"http:///a.crl":                         false,
+		"http://exa mple.com/a.crl":             false,
+		"http://example.com/%zz.crl":            false,
+		"http://[::1/a.crl":                     false,
+		"//example.com/protocol-relative.crl":   false,
+		"https://example.com/with%20spaces.crl": true,
+	} {
+		if _, err := parseCrlUrl(rawUrl); (err == nil) != valid {
+			t.Errorf("Expected %q valid=%t, got error %v", rawUrl, valid, err)
+		}
+	}
+}
+
+func Test_crlUrlsForIssuerRecordsBadUrls(t *testing.T) {
+	ae, storageDB := makeIdentifyEngine()
+
+	threads := *ctconfig.NumThreads
+	*ctconfig.NumThreads = 1
+	defer func() {
+		*ctconfig.NumThreads = threads
+	}()
+
+	issuer := ae.issuers.NewTestIssuerFromSubjectString("Sloppy CA")
+	addIdentifiableIssuer(t, storageDB, issuer, "http://example.com/good.crl")
+	// ct-fetch drops most malformed URLs, but the cache can still hold them
+	for _, bad := range []string{"example.com/noscheme.crl", "http:///nohost.crl", "http://bad host/a.crl"} {
+		if _, err := ae.remoteCache.SetInsert("crl::"+issuer.ID(), bad); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	crls, _ := ae.identifyCrlsByIssuer(context.TODO())
+	urls := ae.crlUrlsForIssuer(issuer.ID(), crls[issuer.ID()])
+	if len(urls) != 1 || urls[0].String() != "http://example.com/good.crl" {
+		t.Errorf("Expected only the good URL, got %v", urls)
+	}
+
+	badUrls := ae.auditor.GetBadUrls()
+	found := make(map[string]bool)
+	for _, badUrl := range badUrls {
+		if badUrl.Issuer != issuer.ID() || badUrl.IssuerSubject != "Sloppy CA" || badUrl.Error == "" {
+			t.Errorf("Unexpected bad URL record %+v", badUrl)
+		}
+		found[badUrl.Url] = true
+	}
+	if len(found) != 3 || !found["http:///nohost.crl"] {
+		t.Errorf("Expected the 3 bad URLs, got %+v", badUrls)
+	}
+
+	tmpfile, err := ioutil.TempFile("", "Test_crlUrlsForIssuerRecordsBadUrls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	if err = saveBadUrls(tmpfile.Name(), badUrls); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []BadCrlUrl
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, badUrls) {
+		t.Errorf("Expected %+v, got %+v", badUrls, decoded)
+	}
+}
+
+// Run with -benchmem against the parent commit to compare the queueing cost
+func Benchmark_identifyCrlsByIssuer(b *testing.B) {
+	ae, storageDB := makeIdentifyEngine()
+
+	threads := *ctconfig.NumThreads
+	*ctconfig.NumThreads = 4
+	defer func() {
+		*ctconfig.NumThreads = threads
+	}()
+
+	for i := 0; i < 10000; i++ {
+		issuer := ae.issuers.NewTestIssuerFromSubjectString(fmt.Sprintf("Issuer %d", i))
+		addIdentifiableIssuer(b, storageDB, issuer, fmt.Sprintf("http://example.com/%d.crl", i))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		crls, _ := ae.identifyCrlsByIssuer(context.TODO())
+		if len(crls) != 10000 {
+			b.Fatalf("Expected 10000 issuers, got %d", len(crls))
+		}
+	}
+}
+
+// Compares aggregating once every download has finished against aggregating
+// each issuer as soon as it's downloaded, with slow servers and large CRLs
+func Benchmark_downloadAndAggregateCRLs(b *testing.B) {
+	const issuerCount = 8
+	const revocationsPerCrl = 20000
+	const serverLatency = 200 * time.Millisecond
+
+	defer func(aPath string) { *crlpath = aPath }(*crlpath)
+
+	issuersObj := rootprogram.NewMozillaIssuers()
+	issuerCrls := types.IssuerCrlMap{}
+	thisUpdate := time.Now().UTC()
+	for i := 0; i < issuerCount; i++ {
+		ca, caPrivKey := makeCA(b)
+		issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+		revoked := make([]pkix.RevokedCertificate, revocationsPerCrl)
+		for j := range revoked {
+			revoked[j] = pkix.RevokedCertificate{SerialNumber: big.NewInt(int64(j + 1)), RevocationTime: thisUpdate}
+		}
+		crlBytes := makeCRLWithRevocations(b, ca, caPrivKey, thisUpdate, thisUpdate.AddDate(0, 0, 1), revoked)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(serverLatency)
+			if _, err := w.Write(crlBytes); err != nil {
+				b.Error(err)
+			}
+		}))
+		defer server.Close()
+		issuerCrls[issuer.ID()] = map[string]bool{server.URL + "/issuer.crl": true}
+	}
+
+	for _, pipelined := range []bool{false, true} {
+		name := "sequential"
+		if pipelined {
+			name = "pipelined"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				tmpDir, err := ioutil.TempDir("", "Benchmark_downloadAndAggregateCRLs")
+				if err != nil {
+					b.Fatal(err)
+				}
+				*crlpath = tmpDir
+				storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+				ae := AggregateEngine{
+					loadStorageDB:    storageDB,
+					saveStorage:      storage.NewMockBackend(),
+					remoteCache:      storage.NewMockRemoteCache(),
+					issuers:          issuersObj,
+					display:          mpb.New(mpb.WithOutput(ioutil.Discard)),
+					auditor:          NewCrlAuditor(issuersObj),
+					downloadThreads:  2,
+					aggregateThreads: 2,
+				}
+				b.StartTimer()
+
+				crlPaths, count := ae.downloadCRLs(context.TODO(), issuerCrls)
+				if !pipelined {
+					downloaded := make(chan types.IssuerCrlUrlPaths, count)
+					for result := range crlPaths {
+						downloaded <- result
+					}
+					close(downloaded)
+					crlPaths = downloaded
+				}
+				if storeErrs := ae.aggregateCRLs(context.TODO(), count, crlPaths); len(storeErrs) != 0 {
+					b.Fatalf("Expected no store errors, got %v", storeErrs)
+				}
+
+				b.StopTimer()
+				os.RemoveAll(tmpDir)
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func Test_crlFetchWorkerJitter(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_crlFetchWorkerJitter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	*crlpath = tmpDir
+	defer os.RemoveAll(tmpDir)
+
+	// When each CRL was first requested, as failures are retried
+	var mutex sync.Mutex
+	requestTimes := make(map[string]time.Time)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		if _, ok := requestTimes[r.URL.Path]; !ok {
+			requestTimes[r.URL.Path] = time.Now()
+		}
+		mutex.Unlock()
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+	ae := AggregateEngine{
+		loadStorageDB: storageDB,
+		saveStorage:   storage.NewMockBackend(),
+		remoteCache:   storage.NewMockRemoteCache(),
+		issuers:       issuersObj,
+		display:       display,
+		auditor:       NewCrlAuditor(issuersObj),
+		jitter:        500 * time.Millisecond,
+	}
+
+	const workers = 6
+	crlsChan := make(chan types.IssuerCrlUrls, workers)
+	for i := 0; i < workers; i++ {
+		ca, _ := makeCA(t)
+		issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+		crlUrl, _ := url.Parse(fmt.Sprintf("%s/%d.crl", server.URL, i))
+		crlsChan <- types.IssuerCrlUrls{Issuer: issuer, Urls: []url.URL{*crlUrl}}
+	}
+	close(crlsChan)
+	resultChan := make(chan types.IssuerCrlUrlPaths, workers)
+
+	// Every worker is started at once, as by aggregateCRLs
+	var wg sync.WaitGroup
+	progBar := display.AddBar(workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go ae.crlFetchWorker(context.TODO(), &wg, crlsChan, resultChan, progBar)
+	}
+	wg.Wait()
+
+	if len(requestTimes) != workers {
+		t.Fatalf("Expected %d requests, got %d", workers, len(requestTimes))
+	}
+	var first, last time.Time
+	for _, requestTime := range requestTimes {
+		if first.IsZero() || requestTime.Before(first) {
+			first = requestTime
+		}
+		if requestTime.After(last) {
+			last = requestTime
+		}
+	}
+	// All six landing within 100ms of each other by chance is vanishingly
+	// unlikely
+	if last.Sub(first) < 100*time.Millisecond {
+		t.Errorf("Expected requests spread out, but they all came within %s", last.Sub(first))
+	}
+}
+
+func Test_crlFetchWorkerProcessOneTLSRootBundle(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_crlFetchWorkerProcessOneTLSRootBundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	*crlpath = tmpDir
+	defer os.RemoveAll(tmpDir)
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+	ca, caPrivKey := makeCA(t)
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+	crlBytes := makeCRL(t, ca, caPrivKey, time.Now().AddDate(0, 0, -1), time.Now().AddDate(0, 0, 1))
+	// Its certificate is its own root, which isn't otherwise trusted
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(crlBytes)
+	}))
+	defer server.Close()
+	crlUrl, _ := url.Parse(server.URL + "/private.crl")
+
+	rootPath := filepath.Join(tmpDir, "roots.pem")
+	rootPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err = ioutil.WriteFile(rootPath, rootPem, permMode); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bundle := range []string{"", rootPath} {
+		*tlsroots = bundle
+		dlOptions, err := downloadOptionsFromFlags(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ae := AggregateEngine{
+			loadStorageDB: storageDB,
+			saveStorage:   storage.NewMockBackend(),
+			remoteCache:   storage.NewMockRemoteCache(),
+			issuers:       issuersObj,
+			display:       display,
+			auditor:       NewCrlAuditor(issuersObj),
+			dlOptions:     dlOptions,
+		}
+
+		path, err := ae.crlFetchWorkerProcessOne(context.TODO(), *crlUrl, issuer)
+		if bundle == "" && err == nil {
+			t.Error("Expected the download to fail without the root bundle")
+		}
+		if bundle != "" && (err != nil || path == "") {
+			t.Errorf("Expected the download to succeed with the root bundle, got %v", err)
+		}
+	}
+
+	*tlsroots = filepath.Join(tmpDir, "missing.pem")
+	if _, err = downloadOptionsFromFlags(nil); err == nil {
+		t.Error("Expected an error for a missing root bundle")
+	}
+	*tlsroots = ""
+}
+
+func makeCAWithCDP(t *testing.T, aParent *x509.Certificate, aParentKey interface{},
+	aCdp string) (*x509.Certificate, interface{}) {
+	t.Helper()
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "CDP CA " + aCdp},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		CRLDistributionPoints: []string{aCdp},
+	}
+	if aParent == nil {
+		aParent, aParentKey = template, privKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, aParent, &privKey.PublicKey, aParentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, privKey
+}
+
+func Test_findCrlWorkerIssuerCertCDP(t *testing.T) {
+	selfIssued, selfIssuedKey := makeCAWithCDP(t, nil, nil, "http://root.example/root.crl")
+	intermediate, _ := makeCAWithCDP(t, selfIssued, selfIssuedKey, "http://root.example/intermediates.crl")
+
+	testcases := []struct {
+		name       string
+		cert       *x509.Certificate
+		expectCrls []string
+	}{
+		{
+			name:       "self-issued",
+			cert:       selfIssued,
+			expectCrls: []string{"http://root.example/root.crl"},
+		},
+		{
+			// The CDP names the parent's CRL, which this issuer doesn't sign
+			name:       "intermediate",
+			cert:       intermediate,
+			expectCrls: []string{},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			display := mpb.New(
+				mpb.WithOutput(ioutil.Discard),
+			)
+			storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+			issuersObj := loadCCADBWithCrls(t, tc.cert, nil)
+			issuer := storage.NewIssuer(tc.cert)
+			issuersObj.Enroll(issuer)
+
+			ae := AggregateEngine{
+				loadStorageDB: storageDB,
+				issuers:       issuersObj,
+				display:       display,
+			}
+
+			issuerChan := make(chan storage.Issuer, 1)
+			issuerChan <- issuer
+			close(issuerChan)
+			issuerCrls := types.NewConcurrentIssuerCrlMap()
+			ocspResultChan := make(chan types.IssuerOcspMap, 1)
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			ae.findCrlWorker(context.TODO(), &wg, issuerChan, issuerCrls, ocspResultChan, display.AddBar(1))
+
+			crls := []string{}
+			for crl := range issuerCrls.Map()[issuer.ID()] {
+				crls = append(crls, crl)
+			}
+			if !reflect.DeepEqual(crls, tc.expectCrls) {
+				t.Errorf("Expected CRLs %v, got %v", tc.expectCrls, crls)
+			}
+			if issuersObj.IsIssuerEnrolled(issuer) != (len(tc.expectCrls) > 0) {
+				t.Errorf("Expected enrolled=%v", len(tc.expectCrls) > 0)
+			}
+		})
+	}
+}
+
+func Test_aggregateCRLWorkerSkipsAttributeCertCRLs(t *testing.T) {
+	// RFC 5280, 5.2.5
+	idp, err := asn1.Marshal(struct {
+		OnlyContainsAttributeCerts bool `asn1:"optional,tag:5"`
+	}{true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, caPrivKey := makeCA(t)
+	thisUpdate := time.Now().UTC()
+	crlPath := writeTempCRL(t, "attribute", makeCRLWithExtensions(t, ca, caPrivKey, thisUpdate,
+		[]pkix.RevokedCertificate{{SerialNumber: big.NewInt(1), RevocationTime: thisUpdate}},
+		[]pkix.Extension{{Id: asn1.ObjectIdentifier{2, 5, 29, 28}, Critical: true, Value: idp}}))
+	defer os.Remove(crlPath)
+
+	defer func(aThreshold int64) {
+		crlcheck.StreamingThreshold = aThreshold
+	}(crlcheck.StreamingThreshold)
+
+	for _, threshold := range []int64{0, 1} {
+		crlcheck.StreamingThreshold = threshold
+
+		tmpDir, err := ioutil.TempDir("", "Test_aggregateCRLWorkerSkipsAttributeCertCRLs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		display := mpb.New(
+			mpb.WithOutput(ioutil.Discard),
+		)
+		crlUrl, _ := url.Parse("http://example.com/attribute.crl")
+		storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+		issuersObj := loadCCADBWithCrls(t, ca, []string{crlUrl.String()})
+		issuer := storage.NewIssuer(ca)
+
+		ae := AggregateEngine{
+			loadStorageDB:      storageDB,
+			saveStorage:        storage.NewLocalDiskBackend(permMode, tmpDir),
+			remoteCache:        storage.NewMockRemoteCache(),
+			issuers:            issuersObj,
+			display:            display,
+			auditor:            NewCrlAuditor(issuersObj),
+			checkCcadbCoverage: true,
+		}
+
+		workChan := make(chan types.IssuerCrlUrlPaths, 1)
+		workChan <- types.IssuerCrlUrlPaths{
+			Issuer:      issuer,
+			CrlUrlPaths: []types.UrlPath{{Url: *crlUrl, Path: crlPath}},
+		}
+		close(workChan)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		ae.aggregateCRLWorker(context.TODO(), &wg, workChan, make(chan issuerError, 1), display.AddBar(1))
+
+		if issuersObj.IsIssuerEnrolled(issuer) {
+			t.Errorf("Expected an attribute certificate CRL not to cover its issuer (streamed=%v)",
+				crlcheck.ShouldStream(crlPath))
+		}
+		if _, err := os.Stat(filepath.Join(tmpDir, issuer.ID())); !os.IsNotExist(err) {
+			t.Errorf("Expected no revoked serials file, got %v", err)
+		}
+		// Not that its CRLs were checked and listed nothing
+		if reason, err := issuersObj.GetEnrollmentReason(issuer); err != nil || reason != rootprogram.ReasonNoCrls {
+			t.Errorf("Expected reason %s, got %s (%v)", rootprogram.ReasonNoCrls, reason, err)
+		}
+
+		// The skipped CRL was fetched, so it isn't missing
+		if missing := issuersObj.GetMissingCrlUrls(issuer); len(missing) != 0 {
+			t.Errorf("Expected no missing CRLs, got %v", missing)
+		}
+		enrolledPath := filepath.Join(tmpDir, "enrolled.json")
+		if err = issuersObj.SaveIssuersList(enrolledPath); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(enrolledPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var enrolled []rootprogram.EnrolledIssuer
+		if err = json.Unmarshal(data, &enrolled); err != nil {
+			t.Fatal(err)
+		}
+		if len(enrolled) != 1 || !reflect.DeepEqual(enrolled[0].OutOfScopeCrlUrls, []string{crlUrl.String()}) ||
+			len(enrolled[0].MissingCrlUrls) != 0 {
+			t.Errorf("Expected %s listed as out of scope, not missing, got %+v", crlUrl, enrolled)
+		}
+	}
+}
+
+func Test_downloadCRLsMaxCrlsPerIssuer(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_downloadCRLsMaxCrlsPerIssuer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer func(aPath string) { *crlpath = aPath }(*crlpath)
+	*crlpath = tmpDir
+
+	ca, caPrivKey := makeCA(t)
+	thisUpdate := time.Now().UTC()
+	server := hostCRL(t, makeCRL(t, ca, caPrivKey, thisUpdate, thisUpdate.AddDate(0, 0, 1)))
+	defer server.Close()
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+	otherCa, otherCaPrivKey := makeCA(t)
+	otherIssuer := issuersObj.InsertIssuerFromCertAndPem(otherCa, "")
+	otherServer := hostCRL(t, makeCRL(t, otherCa, otherCaPrivKey, thisUpdate, thisUpdate.AddDate(0, 0, 1)))
+	defer otherServer.Close()
+
+	ae := AggregateEngine{
+		loadStorageDB:    storageDB,
+		saveStorage:      storage.NewMockBackend(),
+		remoteCache:      storage.NewMockRemoteCache(),
+		issuers:          issuersObj,
+		display:          display,
+		auditor:          NewCrlAuditor(issuersObj),
+		downloadThreads:  1,
+		aggregateThreads: 1,
+		maxCrlsPerIssuer: 2,
+	}
+
+	crls := map[string]bool{}
+	for i := 1; i <= 5; i++ {
+		crls[fmt.Sprintf("%s/crl-%d.crl", server.URL, i)] = true
+	}
+	issuerCrls := types.IssuerCrlMap{
+		issuer.ID():      crls,
+		otherIssuer.ID(): {otherServer.URL + "/other.crl": true},
+	}
+
+	resultChan, count := ae.downloadCRLs(context.TODO(), issuerCrls)
+	if count != 2 {
+		t.Fatalf("Expected both issuers to be downloaded, got %d", count)
+	}
+	results := map[string][]string{}
+	for result := range resultChan {
+		for _, urlPath := range result.CrlUrlPaths {
+			results[result.Issuer.ID()] = append(results[result.Issuer.ID()], urlPath.Url.String())
+		}
+	}
+	sort.Strings(results[issuer.ID()])
+	expected := []string{server.URL + "/crl-1.crl", server.URL + "/crl-2.crl"}
+	if !reflect.DeepEqual(results[issuer.ID()], expected) {
+		t.Errorf("Expected only the first 2 URLs, got %v", results[issuer.ID()])
+	}
+	if len(results[otherIssuer.ID()]) != 1 {
+		t.Errorf("Expected an issuer under the cap to be untouched, got %v", results[otherIssuer.ID()])
+	}
+	if !ae.truncatedIssuers[issuer.ID()] || ae.truncatedIssuers[otherIssuer.ID()] {
+		t.Errorf("Expected only the issuer over the cap to be recorded, got %v", ae.truncatedIssuers)
+	}
+
+	resultChan, count = ae.downloadCRLs(context.TODO(), issuerCrls)
+	ae.aggregateCRLs(context.TODO(), count, resultChan)
+
+	// Its CRLs all validated, but not all of them were checked
+	if reason, _ := issuersObj.GetEnrollmentReason(issuer); reason != rootprogram.ReasonTooManyCrls {
+		t.Errorf("Expected the truncated issuer not to be enrolled, got %s", reason)
+	}
+	if !issuersObj.IsIssuerEnrolled(otherIssuer) {
+		t.Error("Expected the other issuer to be enrolled")
+	}
+}
+
+// Serves a CRL only once release is closed, so downloads of it can be held up
+func hostHeldCRL(t *testing.T, crlBytes []byte, release <-chan struct{}) *httptest.Server {
+	t.Helper()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		if _, err := w.Write(crlBytes); err != nil {
+			t.Error(err)
+		}
+	})
+
+	return httptest.NewServer(handler)
+}
+
+// Sets up an engine with an issuer whose CRL downloads at once, and one whose
+// CRL download waits for release
+func makePipelineEngine(t *testing.T, release <-chan struct{}) (*AggregateEngine, types.IssuerCrlMap,
+	storage.Issuer, storage.Issuer, func()) {
+	t.Helper()
+	tmpDir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousCrlPath := *crlpath
+	*crlpath = tmpDir
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+	thisUpdate := time.Now().UTC()
+
+	fastCa, fastCaPrivKey := makeCA(t)
+	fastIssuer := issuersObj.InsertIssuerFromCertAndPem(fastCa, "")
+	fastServer := hostCRL(t, makeCRL(t, fastCa, fastCaPrivKey, thisUpdate, thisUpdate.AddDate(0, 0, 1)))
+
+	heldCa, heldCaPrivKey := makeCA(t)
+	heldIssuer := issuersObj.InsertIssuerFromCertAndPem(heldCa, "")
+	heldServer := hostHeldCRL(t, makeCRL(t, heldCa, heldCaPrivKey, thisUpdate, thisUpdate.AddDate(0, 0, 1)), release)
+
+	ae := &AggregateEngine{
+		loadStorageDB:    storageDB,
+		saveStorage:      storage.NewMockBackend(),
+		remoteCache:      storage.NewMockRemoteCache(),
+		issuers:          issuersObj,
+		display:          display,
+		auditor:          NewCrlAuditor(issuersObj),
+		downloadThreads:  2,
+		aggregateThreads: 1,
+	}
+	issuerCrls := types.IssuerCrlMap{
+		fastIssuer.ID(): {fastServer.URL + "/fast.crl": true},
+		heldIssuer.ID(): {heldServer.URL + "/held.crl": true},
+	}
+	cleanup := func() {
+		fastServer.Close()
+		heldServer.Close()
+		os.RemoveAll(tmpDir)
+		*crlpath = previousCrlPath
+	}
+	return ae, issuerCrls, fastIssuer, heldIssuer, cleanup
+}
+
+func Test_downloadCRLsPipelinesIntoAggregation(t *testing.T) {
+	release := make(chan struct{})
+	ae, issuerCrls, fastIssuer, heldIssuer, cleanup := makePipelineEngine(t, release)
+	defer cleanup()
+
+	crlPaths, count := ae.downloadCRLs(context.TODO(), issuerCrls)
+	aggregated := make(chan []issuerError)
+	go func() {
+		aggregated <- ae.aggregateCRLs(context.TODO(), count, crlPaths)
+	}()
+
+	// The fast issuer is aggregated while the other is still downloading
+	deadline := time.Now().Add(10 * time.Second)
+	for !ae.issuers.IsIssuerEnrolled(fastIssuer) {
+		if time.Now().After(deadline) {
+			close(release)
+			t.Fatal("Expected the downloaded issuer to be aggregated before the downloads finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-aggregated:
+		t.Fatal("Expected aggregation to wait for the held download")
+	default:
+	}
+
+	close(release)
+	if storeErrs := <-aggregated; len(storeErrs) != 0 {
+		t.Errorf("Expected no store errors, got %v", storeErrs)
+	}
+	if !ae.issuers.IsIssuerEnrolled(heldIssuer) {
+		t.Error("Expected the held issuer to be aggregated once downloaded")
+	}
+	if ae.downloadsStopped {
+		t.Error("Expected the downloads to have finished")
+	}
+}
+
+func Test_downloadCRLsStoppedWhileAggregating(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	ae, issuerCrls, fastIssuer, heldIssuer, cleanup := makePipelineEngine(t, release)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	crlPaths, count := ae.downloadCRLs(ctx, issuerCrls)
+	aggregated := make(chan []issuerError)
+	go func() {
+		aggregated <- ae.aggregateCRLs(ctx, count, crlPaths)
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !ae.issuers.IsIssuerEnrolled(fastIssuer) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the downloaded issuer to be aggregated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Stopping with a download outstanding returns once it's given up
+	cancel()
+	select {
+	case <-aggregated:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected aggregation to stop with the downloads")
+	}
+	if !ae.downloadsStopped {
+		t.Error("Expected the downloads to be recorded as stopped")
+	}
+	if ae.issuers.IsIssuerEnrolled(heldIssuer) {
+		t.Error("Expected the issuer whose download was stopped not to be enrolled")
+	}
+}
+
+func Test_downloadCRLsStoppedStillAggregatesDownloaded(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	ae, issuerCrls, fastIssuer, heldIssuer, cleanup := makePipelineEngine(t, release)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	crlPaths, count := ae.downloadCRLs(ctx, issuerCrls)
+
+	// Stop once one issuer is downloaded, before any is aggregated
+	deadline := time.Now().Add(10 * time.Second)
+	for len(crlPaths) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected an issuer to be downloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+
+	if storeErrs := ae.aggregateCRLs(ctx, count, crlPaths); len(storeErrs) != 0 {
+		t.Errorf("Expected no store errors, got %v", storeErrs)
+	}
+	if !ae.downloadsStopped {
+		t.Error("Expected the downloads to be recorded as stopped")
+	}
+	if !ae.issuers.IsIssuerEnrolled(fastIssuer) {
+		t.Error("Expected the issuer downloaded before the stop to be aggregated")
+	}
+	if ae.issuers.IsIssuerEnrolled(heldIssuer) {
+		t.Error("Expected the issuer whose download was stopped not to be enrolled")
+	}
+}
+
+func Test_aggregateCRLsStoppedAfterDownloads(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	ae, issuerCrls, fastIssuer, heldIssuer, cleanup := makePipelineEngine(t, release)
+	defer cleanup()
+
+	crlPaths, count := ae.downloadCRLs(context.Background(), issuerCrls)
+	<-ae.downloadsFinished
+
+	// Stopped once everything is downloaded, aggregation stops too
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ae.aggregateCRLs(ctx, count, crlPaths)
+	if ae.downloadsStopped {
+		t.Error("Expected the downloads to have finished")
+	}
+	if ae.issuers.IsIssuerEnrolled(fastIssuer) || ae.issuers.IsIssuerEnrolled(heldIssuer) {
+		t.Error("Expected no issuers aggregated once stopped")
+	}
+}
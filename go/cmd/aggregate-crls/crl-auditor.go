@@ -9,8 +9,8 @@ import (
 	"sync"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/mozilla/crlite/go/downloader"
+	"github.com/mozilla/crlite/go/logging"
 	"github.com/mozilla/crlite/go/rootprogram"
 	"github.com/mozilla/crlite/go/storage"
 )
@@ -24,6 +24,7 @@ var (
 	AuditKindOld                CrlAuditEntryKind = "Not Fresh, Warning"
 	AuditKindExpired            CrlAuditEntryKind = "Expired, Allowed"
 	AuditKindValid              CrlAuditEntryKind = "Valid, Processed"
+	AuditKindBadUrl             CrlAuditEntryKind = "Bad URL"
 )
 
 type CrlAuditEntryKind string
@@ -46,13 +47,17 @@ type CrlAuditor struct {
 	mutex   *sync.Mutex
 	issuers *rootprogram.MozIssuers
 	Entries []CrlAuditEntry
+	// Counts of how each download finished, by hostname, then by HTTP
+	// status code or error class
+	DownloadStatuses map[string]map[downloader.DownloadStatus]int `json:",omitempty"`
 }
 
 func NewCrlAuditor(issuers *rootprogram.MozIssuers) *CrlAuditor {
 	return &CrlAuditor{
-		mutex:   &sync.Mutex{},
-		issuers: issuers,
-		Entries: []CrlAuditEntry{},
+		mutex:            &sync.Mutex{},
+		issuers:          issuers,
+		Entries:          []CrlAuditEntry{},
+		DownloadStatuses: make(map[string]map[downloader.DownloadStatus]int),
 	}
 }
 
@@ -63,7 +68,7 @@ func (auditor *CrlAuditor) getSubject(identifier downloader.DownloadIdentifier)
 	}
 	subject, err := auditor.issuers.GetSubjectForIssuer(*issuer)
 	if err != nil {
-		glog.Warningf("Could not get subject for issuer %s: %v", issuer.ID(), err)
+		logging.Warningf("Could not get subject for issuer %s: %v", issuer.ID(), err)
 		return ""
 	}
 	return subject
@@ -80,6 +85,70 @@ func (auditor *CrlAuditor) WriteReport(fd io.Writer) error {
 	return enc.Encode(auditor)
 }
 
+// A CRL URL that was skipped because it couldn't be fetched as written
+type BadCrlUrl struct {
+	Issuer        string `json:"issuer"`
+	IssuerSubject string `json:"issuerSubject"`
+	Url           string `json:"url"`
+	Error         string `json:"error"`
+}
+
+// Returns the URLs recorded by BadUrl, in the order they were found
+func (auditor *CrlAuditor) GetBadUrls() []BadCrlUrl {
+	auditor.mutex.Lock()
+	defer auditor.mutex.Unlock()
+
+	badUrls := []BadCrlUrl{}
+	for _, entry := range auditor.Entries {
+		if entry.Kind != AuditKindBadUrl {
+			continue
+		}
+		badUrls = append(badUrls, BadCrlUrl{
+			Issuer:        entry.Issuer.ID(),
+			IssuerSubject: entry.IssuerSubject,
+			Url:           entry.Url,
+			Error:         entry.Errors[0],
+		})
+	}
+	return badUrls
+}
+
+func (auditor *CrlAuditor) GetDownloadStatuses(host string) map[downloader.DownloadStatus]int {
+	auditor.mutex.Lock()
+	defer auditor.mutex.Unlock()
+
+	counts := make(map[downloader.DownloadStatus]int, len(auditor.DownloadStatuses[host]))
+	for status, count := range auditor.DownloadStatuses[host] {
+		counts[status] = count
+	}
+	return counts
+}
+
+func (auditor *CrlAuditor) DownloadFinished(issuer downloader.DownloadIdentifier, crlUrl *url.URL, status downloader.DownloadStatus) {
+	auditor.mutex.Lock()
+	defer auditor.mutex.Unlock()
+
+	host := crlUrl.Hostname()
+	if _, ok := auditor.DownloadStatuses[host]; !ok {
+		auditor.DownloadStatuses[host] = make(map[downloader.DownloadStatus]int)
+	}
+	auditor.DownloadStatuses[host][status]++
+}
+
+func (auditor *CrlAuditor) BadUrl(issuer downloader.DownloadIdentifier, rawUrl string, err error) {
+	auditor.mutex.Lock()
+	defer auditor.mutex.Unlock()
+
+	auditor.Entries = append(auditor.Entries, CrlAuditEntry{
+		Timestamp:     time.Now().UTC(),
+		Kind:          AuditKindBadUrl,
+		Url:           rawUrl,
+		Issuer:        issuer,
+		IssuerSubject: auditor.getSubject(issuer),
+		Errors:        []string{err.Error()},
+	})
+}
+
 func (auditor *CrlAuditor) FailedDownload(issuer downloader.DownloadIdentifier, crlUrl *url.URL, dlTracer *downloader.DownloadTracer, err error) {
 	auditor.mutex.Lock()
 	defer auditor.mutex.Unlock()
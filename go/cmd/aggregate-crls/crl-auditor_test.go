@@ -2,15 +2,24 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/mozilla/crlite/go/downloader"
 	"github.com/mozilla/crlite/go/rootprogram"
 	"github.com/mozilla/crlite/go/storage"
+	"github.com/vbauerster/mpb/v5"
 )
 
 func assertEmptyList(t *testing.T, a *CrlAuditor) {
@@ -365,3 +374,95 @@ func Test_SeveralFailures(t *testing.T) {
 
 	assertAuditorReportHasEntries(t, auditor, 6)
 }
+
+type acceptingVerifier struct{}
+
+func (v *acceptingVerifier) IsValid(path string) error {
+	return nil
+}
+
+func Test_DownloadStatuses(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			fmt.Fprintln(w, "a CRL")
+		case "/forbidden":
+			w.WriteHeader(http.StatusForbidden)
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/slow":
+			select {
+			case <-time.After(10 * time.Second):
+			case <-r.Context().Done():
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	tmpDir, err := ioutil.TempDir("", "Test_DownloadStatuses")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	issuersObj := rootprogram.NewMozillaIssuers()
+	auditor := NewCrlAuditor(issuersObj)
+	issuer := issuersObj.NewTestIssuerFromSubjectString("Test Corporation SA")
+	display := mpb.New(mpb.WithOutput(ioutil.Discard))
+	opts := downloader.NewDownloadOptions()
+	opts.Timeout = 100 * time.Millisecond
+
+	serverUrl, _ := url.Parse(ts.URL)
+	// The same server under a second hostname
+	otherHostUrl, _ := url.Parse(strings.Replace(ts.URL, "127.0.0.1", "localhost", 1))
+
+	downloads := []struct {
+		base *url.URL
+		path string
+	}{
+		{serverUrl, "/ok"},
+		{serverUrl, "/ok"},
+		{serverUrl, "/forbidden"},
+		{serverUrl, "/missing"},
+		{serverUrl, "/unavailable"},
+		{serverUrl, "/slow"},
+		{otherHostUrl, "/missing"},
+	}
+	for i, dl := range downloads {
+		crlUrl := *dl.base
+		crlUrl.Path = dl.path
+		_, _ = downloader.DownloadAndVerifyFileSync(context.TODO(), &acceptingVerifier{}, auditor, &issuer,
+			display, crlUrl, filepath.Join(tmpDir, fmt.Sprintf("%d.crl", i)), 0, opts)
+	}
+
+	expected := map[downloader.DownloadStatus]int{
+		"200":                     2,
+		"403":                     1,
+		"404":                     1,
+		"503":                     1,
+		downloader.StatusTimedOut: 1,
+	}
+	if statuses := auditor.GetDownloadStatuses("127.0.0.1"); !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("Expected %v, got %v", expected, statuses)
+	}
+	expected = map[downloader.DownloadStatus]int{"404": 1}
+	if statuses := auditor.GetDownloadStatuses("localhost"); !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("Expected %v, got %v", expected, statuses)
+	}
+
+	var b bytes.Buffer
+	if err := auditor.WriteReport(&b); err != nil {
+		t.Fatal(err)
+	}
+	report := struct {
+		DownloadStatuses map[string]map[string]int
+	}{}
+	if err := json.Unmarshal(b.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.DownloadStatuses["127.0.0.1"]["timeout"] != 1 || report.DownloadStatuses["localhost"]["404"] != 1 {
+		t.Errorf("Unexpected statuses in the report: %v", report.DownloadStatuses)
+	}
+}
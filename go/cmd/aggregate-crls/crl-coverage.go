@@ -0,0 +1,49 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/mozilla/crlite/go/storage"
+)
+
+// Returns the CRL URLs CCADB lists for the issuer, after any overrides, which
+// aren't in aCovered, the URLs whose CRLs were fetched and validated. CRLs
+// come from the distribution points in CT, so an issuer can be enrolled
+// while some of CCADB's CRLs were never found, leaving its coverage partial.
+// Those in aOutOfScope, fetched but deliberately left out, are returned
+// apart, as they aren't missing.
+func (ae *AggregateEngine) missingCcadbCrls(aIssuer storage.Issuer, aCovered map[string]bool,
+	aOutOfScope map[string]bool) ([]string, []string) {
+	ccadbUrls, err := ae.issuers.GetCrlUrlsForIssuer(aIssuer)
+	if err != nil || len(ccadbUrls) == 0 {
+		return nil, nil
+	}
+
+	listed := make(map[string]bool, len(ccadbUrls))
+	for _, crlUrl := range ccadbUrls {
+		listed[crlUrl] = true
+	}
+
+	var missing, outOfScope []string
+	for crlUrl := range ae.crlOverrides.apply(aIssuer.ID(), listed) {
+		urlObj, err := parseCrlUrl(crlUrl)
+		if err != nil {
+			continue
+		}
+		// Only HTTP CRLs are ever fetched, so others can't count against
+		// coverage
+		if urlObj.Scheme != "http" && urlObj.Scheme != "https" {
+			continue
+		}
+		switch {
+		case aCovered[urlObj.String()]:
+		case aOutOfScope[urlObj.String()]:
+			outOfScope = append(outOfScope, urlObj.String())
+		default:
+			missing = append(missing, urlObj.String())
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(outOfScope)
+	return missing, outOfScope
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/pem"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/mozilla/crlite/go"
+	"github.com/mozilla/crlite/go/rootprogram"
+	"github.com/mozilla/crlite/go/storage"
+	"github.com/vbauerster/mpb/v5"
+)
+
+// Loads issuers from a CCADB CSV listing aCert with aCrlUrls
+func loadCCADBWithCrls(t *testing.T, aCert *x509.Certificate, aCrlUrls []string) *rootprogram.MozIssuers {
+	t.Helper()
+	fd, err := ioutil.TempFile("", "ccadb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fd.Name())
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: aCert.Raw})
+	writer := csv.NewWriter(fd)
+	if err = writer.WriteAll([][]string{
+		{"Certificate Name", "CRL URL(s)", "PEM"},
+		{"Coverage CA", strings.Join(aCrlUrls, ", "), "'" + string(certPem) + "'"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = fd.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	issuersObj := rootprogram.NewMozillaIssuers()
+	if err = issuersObj.LoadFromDisk(fd.Name()); err != nil {
+		t.Fatal(err)
+	}
+	return issuersObj
+}
+
+func Test_aggregateCRLWorkerCcadbCoverage(t *testing.T) {
+	ca, caPrivKey := makeCA(t)
+	crlPath := writeTempCRL(t, "covered", makeCRL(t, ca, caPrivKey, time.Now(), time.Now().AddDate(0, 0, 1)))
+	defer os.Remove(crlPath)
+
+	crlA, _ := url.Parse("http://crl.example.com/a.crl")
+	crlB, _ := url.Parse("http://crl.example.com/b.crl")
+	ccadbUrls := []string{crlA.String(), crlB.String(), "ldap://ldap.example.com/CN=CA"}
+
+	testcases := []struct {
+		name          string
+		urlPaths      []types.UrlPath
+		expectMissing []string
+		expectEnroll  bool
+	}{
+		{
+			name:         "both covered",
+			urlPaths:     []types.UrlPath{{Url: *crlA, Path: crlPath}, {Url: *crlB, Path: crlPath}},
+			expectEnroll: true,
+		},
+		{
+			// Nothing failed, but CT never showed the second distribution
+			// point, so the issuer is enrolled with partial coverage
+			name:          "one not found",
+			urlPaths:      []types.UrlPath{{Url: *crlA, Path: crlPath}},
+			expectMissing: []string{crlB.String()},
+			expectEnroll:  true,
+		},
+		{
+			name:          "one failed",
+			urlPaths:      []types.UrlPath{{Url: *crlA, Path: crlPath}, {Url: *crlB, Path: ""}},
+			expectMissing: []string{crlB.String()},
+			expectEnroll:  false,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir, err := ioutil.TempDir("", "Test_aggregateCRLWorkerCcadbCoverage")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			display := mpb.New(
+				mpb.WithOutput(ioutil.Discard),
+			)
+			storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+			issuersObj := loadCCADBWithCrls(t, ca, ccadbUrls)
+			issuer := storage.NewIssuer(ca)
+
+			ae := AggregateEngine{
+				loadStorageDB:      storageDB,
+				saveStorage:        storage.NewLocalDiskBackend(permMode, tmpDir),
+				remoteCache:        storage.NewMockRemoteCache(),
+				issuers:            issuersObj,
+				display:            display,
+				auditor:            NewCrlAuditor(issuersObj),
+				checkCcadbCoverage: true,
+			}
+
+			workChan := make(chan types.IssuerCrlUrlPaths, 1)
+			workChan <- types.IssuerCrlUrlPaths{Issuer: issuer, CrlUrlPaths: tc.urlPaths}
+			close(workChan)
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			ae.aggregateCRLWorker(context.TODO(), &wg, workChan, make(chan issuerError, 1), display.AddBar(1))
+
+			if issuersObj.IsIssuerEnrolled(issuer) != tc.expectEnroll {
+				t.Errorf("Expected enrolled=%v", tc.expectEnroll)
+			}
+			if missing := issuersObj.GetMissingCrlUrls(issuer); !reflect.DeepEqual(missing, tc.expectMissing) {
+				t.Errorf("Expected missing CRLs %v, got %v", tc.expectMissing, missing)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/mozilla/crlite/go/logging"
+)
+
+// Replacement CRL URLs, for when a CA's published URL is broken but a working
+// mirror exists. Each key is either an issuer ID, all of whose CRL URLs are
+// replaced, or an original CRL URL. A nil *crlOverrides replaces nothing.
+type crlOverrides struct {
+	byIssuer map[string]string
+	byUrl    map[string]string
+}
+
+// Loads a JSON object mapping issuer IDs or CRL URLs to replacement URLs
+func loadCrlOverrides(aPath string) (*crlOverrides, error) {
+	data, err := ioutil.ReadFile(aPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries map[string]string
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("Couldn't parse %s: %s", aPath, err)
+	}
+
+	overrides := &crlOverrides{
+		byIssuer: make(map[string]string),
+		byUrl:    make(map[string]string),
+	}
+	for key, replacement := range entries {
+		replacementUrl, err := url.Parse(replacement)
+		if err != nil || (replacementUrl.Scheme != "http" && replacementUrl.Scheme != "https") {
+			return nil, fmt.Errorf("Override for %s isn't an HTTP(S) URL: %q", key, replacement)
+		}
+		if strings.Contains(key, "://") {
+			overrides.byUrl[key] = replacement
+		} else {
+			overrides.byIssuer[key] = replacement
+		}
+	}
+	return overrides, nil
+}
+
+// Returns the CRL URLs to fetch for the issuer in place of aCrls
+func (o *crlOverrides) apply(aIssuerID string, aCrls map[string]bool) map[string]bool {
+	if o == nil {
+		return aCrls
+	}
+
+	if replacement, ok := o.byIssuer[aIssuerID]; ok {
+		logging.Infof("[%s] Overriding all %d CRL URLs with %s", aIssuerID, len(aCrls), replacement)
+		return map[string]bool{replacement: true}
+	}
+
+	result := make(map[string]bool, len(aCrls))
+	for crl := range aCrls {
+		if replacement, ok := o.byUrl[strings.TrimSpace(crl)]; ok {
+			logging.Infof("[%s] Overriding CRL URL %s with %s", aIssuerID, crl, replacement)
+			crl = replacement
+		}
+		result[crl] = true
+	}
+	return result
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+)
+
+func writeCrlOverrides(t *testing.T, aContent string) string {
+	t.Helper()
+	tmpfile, err := ioutil.TempFile("", "crlOverrides")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmpfile.WriteString(aContent); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return tmpfile.Name()
+}
+
+func sortedUrlStrings(t *testing.T, ae *AggregateEngine, aIssuerID string, aCrls ...string) []string {
+	t.Helper()
+	crls := make(map[string]bool)
+	for _, crl := range aCrls {
+		crls[crl] = true
+	}
+	var result []string
+	for _, u := range ae.crlUrlsForIssuer(aIssuerID, crls) {
+		result = append(result, u.String())
+	}
+	sort.Strings(result)
+	return result
+}
+
+func Test_crlOverrides(t *testing.T) {
+	path := writeCrlOverrides(t, `{
+		"overriddenIssuer": "https://mirror.example/issuer.crl",
+		"http://broken.example/a.crl": "http://mirror.example/a.crl"
+	}`)
+	defer os.Remove(path)
+
+	overrides, err := loadCrlOverrides(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ae := AggregateEngine{crlOverrides: overrides}
+
+	urls := sortedUrlStrings(t, &ae, "overriddenIssuer", "http://broken.example/1.crl", "http://broken.example/2.crl")
+	if len(urls) != 1 || urls[0] != "https://mirror.example/issuer.crl" {
+		t.Errorf("Expected the issuer override, got %v", urls)
+	}
+
+	urls = sortedUrlStrings(t, &ae, "otherIssuer", "http://broken.example/a.crl", "http://fine.example/b.crl")
+	if len(urls) != 2 || urls[0] != "http://fine.example/b.crl" || urls[1] != "http://mirror.example/a.crl" {
+		t.Errorf("Expected only the broken URL to be overridden, got %v", urls)
+	}
+
+	urls = sortedUrlStrings(t, &ae, "unaffectedIssuer", "http://fine.example/c.crl")
+	if len(urls) != 1 || urls[0] != "http://fine.example/c.crl" {
+		t.Errorf("Expected no override, got %v", urls)
+	}
+
+	noOverrides := AggregateEngine{}
+	urls = sortedUrlStrings(t, &noOverrides, "overriddenIssuer", "http://broken.example/a.crl")
+	if len(urls) != 1 || urls[0] != "http://broken.example/a.crl" {
+		t.Errorf("Expected no overrides without the flag, got %v", urls)
+	}
+}
+
+func Test_loadCrlOverridesInvalid(t *testing.T) {
+	for _, content := range []string{
+		`["not", "an", "object"]`,
+		`{"issuer": "not a url"}`,
+		`{"issuer": "ftp://mirror.example/a.crl"}`,
+	} {
+		path := writeCrlOverrides(t, content)
+		if _, err := loadCrlOverrides(path); err == nil {
+			t.Errorf("Expected %s to be rejected", content)
+		}
+		os.Remove(path)
+	}
+
+	if _, err := loadCrlOverrides("/nonexistent/overrides.json"); err == nil {
+		t.Error("Expected a missing file to be an error")
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/mozilla/crlite/go/logging"
+	"github.com/mozilla/crlite/go/rootprogram"
+)
+
+// Given as the reason an issuer was dropped when it's no longer in CCADB
+const reasonNotInCCADB rootprogram.EnrollmentReason = "not-in-ccadb"
+
+type enrollmentChange struct {
+	Issuer    string                       `json:"issuer"`
+	Subject   string                       `json:"subject,omitempty"`
+	WasReason rootprogram.EnrollmentReason `json:"wasReason,omitempty"`
+	Reason    rootprogram.EnrollmentReason `json:"reason"`
+}
+
+// How enrollment changed since the previous run, so that a CA which stops
+// publishing usable CRLs is noticed
+type enrollmentChanges struct {
+	NewlyEnrolled []enrollmentChange `json:"newlyEnrolled"`
+	Dropped       []enrollmentChange `json:"dropped"`
+}
+
+// Loads the previous run's enrolled issuers by ID, or nil if there was no
+// previous run
+func loadPreviousEnrollment(aPath string) (map[string]rootprogram.EnrolledIssuer, error) {
+	data, err := ioutil.ReadFile(aPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var list []rootprogram.EnrolledIssuer
+	if err = json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	previous := make(map[string]rootprogram.EnrolledIssuer, len(list))
+	for _, ei := range list {
+		// There's an entry per certificate, so an issuer can repeat
+		if _, exists := previous[ei.PubKeyHash]; !exists {
+			previous[ei.PubKeyHash] = ei
+		}
+	}
+	return previous, nil
+}
+
+func compareEnrollment(aPrevious map[string]rootprogram.EnrolledIssuer,
+	aIssuers *rootprogram.MozIssuers) enrollmentChanges {
+	changes := enrollmentChanges{
+		NewlyEnrolled: []enrollmentChange{},
+		Dropped:       []enrollmentChange{},
+	}
+
+	current := make(map[string]bool)
+	for _, issuer := range aIssuers.GetIssuers() {
+		current[issuer.ID()] = true
+		before, existed := aPrevious[issuer.ID()]
+		wasEnrolled := existed && before.Enrolled
+		enrolled := aIssuers.IsIssuerEnrolled(issuer)
+		if wasEnrolled == enrolled {
+			continue
+		}
+
+		reason, _ := aIssuers.GetEnrollmentReason(issuer)
+		subject, _ := aIssuers.GetSubjectForIssuer(issuer)
+		change := enrollmentChange{
+			Issuer:    issuer.ID(),
+			Subject:   subject,
+			WasReason: before.Reason,
+			Reason:    reason,
+		}
+		if enrolled {
+			changes.NewlyEnrolled = append(changes.NewlyEnrolled, change)
+		} else {
+			changes.Dropped = append(changes.Dropped, change)
+		}
+	}
+
+	for id, before := range aPrevious {
+		if before.Enrolled && !current[id] {
+			changes.Dropped = append(changes.Dropped, enrollmentChange{
+				Issuer:    id,
+				Subject:   before.Subject,
+				WasReason: before.Reason,
+				Reason:    reasonNotInCCADB,
+			})
+		}
+	}
+	sort.Slice(changes.Dropped, func(a, b int) bool {
+		return changes.Dropped[a].Issuer < changes.Dropped[b].Issuer
+	})
+
+	return changes
+}
+
+func (c enrollmentChanges) log() {
+	for _, change := range c.NewlyEnrolled {
+		logging.Infof("[%s] Newly enrolled since the previous run (%s)", change.Issuer, change.Subject)
+	}
+	for _, change := range c.Dropped {
+		logging.Warningf("[%s] No longer enrolled since the previous run (%s): %s", change.Issuer,
+			change.Subject, change.Reason)
+	}
+	logging.Infof("Since the previous run, %d issuers were newly enrolled and %d dropped",
+		len(c.NewlyEnrolled), len(c.Dropped))
+}
+
+func saveEnrollmentChanges(aPath string, aChanges enrollmentChanges) error {
+	fd, err := os.Create(aPath)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(fd)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(aChanges); err != nil {
+		fd.Close() // ignore error
+		return err
+	}
+
+	return fd.Close()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mozilla/crlite/go/rootprogram"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+func Test_compareEnrollment(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_compareEnrollment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	issuersObj := rootprogram.NewMozillaIssuers()
+	insert := func() storage.Issuer {
+		ca, _ := makeCA(t)
+		return issuersObj.InsertIssuerFromCertAndPem(ca, "")
+	}
+	stillEnrolled := insert()
+	gained := insert()
+	added := insert()
+	lost := insert()
+	stillUnenrolled := insert()
+	addedUnenrolled := insert()
+
+	previous := []rootprogram.EnrolledIssuer{
+		{PubKeyHash: stillEnrolled.ID(), Enrolled: true, Reason: rootprogram.ReasonEnrolled},
+		// A second certificate of the same issuer
+		{PubKeyHash: stillEnrolled.ID(), Enrolled: true, Reason: rootprogram.ReasonEnrolled},
+		{PubKeyHash: gained.ID(), Enrolled: false, Reason: rootprogram.ReasonNoCrls},
+		{PubKeyHash: lost.ID(), Enrolled: true, Reason: rootprogram.ReasonEnrolled},
+		{PubKeyHash: stillUnenrolled.ID(), Enrolled: false, Reason: rootprogram.ReasonSomeCrlsFailed},
+		{PubKeyHash: "removedFromCCADB", Subject: "CN=Removed", Enrolled: true, Reason: rootprogram.ReasonEnrolled},
+		{PubKeyHash: "removedUnenrolled", Enrolled: false, Reason: rootprogram.ReasonNoCrls},
+	}
+	data, err := json.Marshal(previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enrolledPath := filepath.Join(tmpDir, "enrolled.json")
+	if err = ioutil.WriteFile(enrolledPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, issuer := range []storage.Issuer{stillEnrolled, gained, added} {
+		issuersObj.Enroll(issuer)
+	}
+	issuersObj.MarkUnenrolled(lost, rootprogram.ReasonAllCrlsFailedDownload)
+	issuersObj.MarkUnenrolled(stillUnenrolled, rootprogram.ReasonSomeCrlsFailed)
+	issuersObj.MarkUnenrolled(addedUnenrolled, rootprogram.ReasonNoCrls)
+
+	previousEnrollment, err := loadPreviousEnrollment(enrolledPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := compareEnrollment(previousEnrollment, issuersObj)
+
+	newlyEnrolled := map[string]rootprogram.EnrollmentReason{}
+	for _, change := range changes.NewlyEnrolled {
+		newlyEnrolled[change.Issuer] = change.WasReason
+	}
+	expectedEnrolled := map[string]rootprogram.EnrollmentReason{
+		gained.ID(): rootprogram.ReasonNoCrls,
+		added.ID():  "",
+	}
+	if !reflect.DeepEqual(newlyEnrolled, expectedEnrolled) {
+		t.Errorf("Expected newly enrolled %v, got %v", expectedEnrolled, newlyEnrolled)
+	}
+
+	dropped := map[string]rootprogram.EnrollmentReason{}
+	for _, change := range changes.Dropped {
+		dropped[change.Issuer] = change.Reason
+	}
+	expectedDropped := map[string]rootprogram.EnrollmentReason{
+		lost.ID():          rootprogram.ReasonAllCrlsFailedDownload,
+		"removedFromCCADB": reasonNotInCCADB,
+	}
+	if !reflect.DeepEqual(dropped, expectedDropped) {
+		t.Errorf("Expected dropped %v, got %v", expectedDropped, dropped)
+	}
+
+	changesPath := filepath.Join(tmpDir, "changes.json")
+	if err = saveEnrollmentChanges(changesPath, changes); err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadFile(changesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved enrollmentChanges
+	if err = json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved, changes) {
+		t.Errorf("Expected %+v saved, got %+v", changes, saved)
+	}
+}
+
+func Test_loadPreviousEnrollment(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_loadPreviousEnrollment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// The first run has nothing to compare against
+	previous, err := loadPreviousEnrollment(filepath.Join(tmpDir, "missing.json"))
+	if previous != nil || err != nil {
+		t.Errorf("Expected nothing for a missing file, got %v: %v", previous, err)
+	}
+
+	invalidPath := filepath.Join(tmpDir, "invalid.json")
+	if err = ioutil.WriteFile(invalidPath, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = loadPreviousEnrollment(invalidPath); err == nil {
+		t.Error("Expected an error for an invalid file")
+	}
+}
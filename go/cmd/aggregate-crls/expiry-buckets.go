@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/mozilla/crlite/go/logging"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+// Revoked serials whose certificates aren't in the known-certificates cache
+// can't be dated, so they're kept apart and never pruned
+const unknownExpiryBucket = "unknown"
+
+var expiryBucketLayouts = map[string]string{
+	"month": "2006-01",
+	"day":   "2006-01-02",
+}
+
+func parseExpiryBucketPeriod(aPeriod string) (string, error) {
+	layout, ok := expiryBucketLayouts[aPeriod]
+	if !ok {
+		return "", fmt.Errorf("Unknown expiry bucket period %s, expected month or day", aPeriod)
+	}
+	return layout, nil
+}
+
+// Splits each issuer's revoked serials by the expiration date of their
+// certificates, so that buckets for periods that have passed can be dropped
+// without reprocessing. Each bucket is written through its own backend.
+type expiryBucketer struct {
+	layout      string
+	certDB      storage.CertDatabase
+	issuerDates map[string][]storage.ExpDate
+	newBackend  func(aBucket string) storage.StorageBackend
+}
+
+func newExpiryBucketer(aLayout string, aCertDB storage.CertDatabase,
+	aNewBackend func(aBucket string) storage.StorageBackend) (*expiryBucketer, error) {
+	issuerDates, err := aCertDB.GetIssuerAndDatesFromCache()
+	if err != nil {
+		return nil, err
+	}
+
+	bucketer := &expiryBucketer{
+		layout:      aLayout,
+		certDB:      aCertDB,
+		issuerDates: make(map[string][]storage.ExpDate, len(issuerDates)),
+		newBackend:  aNewBackend,
+	}
+	for _, issuerDate := range issuerDates {
+		sort.Sort(storage.ExpDateList(issuerDate.ExpDates))
+		bucketer.issuerDates[issuerDate.Issuer.ID()] = issuerDate.ExpDates
+	}
+	return bucketer, nil
+}
+
+// Returns the serials grouped by bucket name, each group in its original
+// order
+func (b *expiryBucketer) bucketSerials(aIssuer storage.Issuer, aSerials []storage.Serial) map[string][]storage.Serial {
+	// A serial found under several expiration dates takes the latest, so
+	// it's never pruned too early
+	expiryOfSerial := make(map[string]storage.ExpDate)
+	for _, expDate := range b.issuerDates[aIssuer.ID()] {
+		for _, serial := range b.certDB.GetKnownCertificates(expDate, aIssuer).Known() {
+			expiryOfSerial[serial.BinaryString()] = expDate
+		}
+	}
+
+	buckets := make(map[string][]storage.Serial)
+	for _, serial := range aSerials {
+		bucket := unknownExpiryBucket
+		if expDate, ok := expiryOfSerial[serial.BinaryString()]; ok {
+			bucket = expDate.ExpireTime().Format(b.layout)
+		}
+		buckets[bucket] = append(buckets[bucket], serial)
+	}
+	return buckets
+}
+
+func (b *expiryBucketer) store(ctx context.Context, aIssuer storage.Issuer, aSerials []storage.Serial) error {
+	buckets := b.bucketSerials(aIssuer, aSerials)
+	for bucket, serials := range buckets {
+		if err := b.newBackend(bucket).StoreKnownCertificateList(ctx, aIssuer, serials); err != nil {
+			return fmt.Errorf("Bucket %s: %s", bucket, err)
+		}
+	}
+	logging.V(1).Infof("[%s] Saved revoked serials into %d expiry buckets", aIssuer.ID(), len(buckets))
+	return nil
+}
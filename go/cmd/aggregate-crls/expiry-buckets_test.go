@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mozilla/crlite/go/storage"
+)
+
+func Test_parseExpiryBucketPeriod(t *testing.T) {
+	if layout, err := parseExpiryBucketPeriod("month"); err != nil || layout != "2006-01" {
+		t.Errorf("Expected a monthly layout, got %q, %v", layout, err)
+	}
+	if layout, err := parseExpiryBucketPeriod("day"); err != nil || layout != "2006-01-02" {
+		t.Errorf("Expected a daily layout, got %q, %v", layout, err)
+	}
+	if _, err := parseExpiryBucketPeriod("fortnight"); err == nil {
+		t.Error("Expected an unknown period to be an error")
+	}
+}
+
+func makeKnownSerials(t *testing.T, aCertDB storage.CertDatabase, aIssuer storage.Issuer, aExpDate string,
+	aSerials ...storage.Serial) {
+	t.Helper()
+	expDate, err := storage.NewExpDate(aExpDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	known := aCertDB.GetKnownCertificates(expDate, aIssuer)
+	for _, serial := range aSerials {
+		if _, err := known.WasUnknown(serial); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_expiryBucketerBucketSerials(t *testing.T) {
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuer := storage.NewIssuerFromString("bucketIssuer")
+	otherIssuer := storage.NewIssuerFromString("otherIssuer")
+
+	january := storage.NewSerialFromHex("01")
+	march := storage.NewSerialFromHex("02")
+	reissued := storage.NewSerialFromHex("03")
+	unknown := storage.NewSerialFromHex("04")
+	otherIssuers := storage.NewSerialFromHex("05")
+
+	makeKnownSerials(t, storageDB, issuer, "2030-01-15", january, reissued)
+	makeKnownSerials(t, storageDB, issuer, "2030-03-02-05", march)
+	makeKnownSerials(t, storageDB, issuer, "2030-04-30", reissued)
+	makeKnownSerials(t, storageDB, otherIssuer, "2030-01-15", otherIssuers)
+
+	bucketer, err := newExpiryBucketer("2006-01", storageDB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buckets := bucketer.bucketSerials(issuer, []storage.Serial{january, march, reissued, unknown, otherIssuers})
+	expected := map[string][]storage.Serial{
+		"2030-01":           {january},
+		"2030-03":           {march},
+		"2030-04":           {reissued},
+		unknownExpiryBucket: {unknown, otherIssuers},
+	}
+	if !reflect.DeepEqual(buckets, expected) {
+		t.Errorf("Expected %v, got %v", expected, buckets)
+	}
+
+	bucketer.layout = "2006-01-02"
+	buckets = bucketer.bucketSerials(issuer, []storage.Serial{march})
+	if _, ok := buckets["2030-03-02"]; !ok {
+		t.Errorf("Expected a daily bucket, got %v", buckets)
+	}
+}
+
+func Test_expiryBucketerStore(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_expiryBucketerStore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuer := storage.NewIssuerFromString("bucketIssuer")
+	makeKnownSerials(t, storageDB, issuer, "2030-01-15", storage.NewSerialFromHex("0a"))
+
+	bucketer, err := newExpiryBucketer("2006-01", storageDB, func(aBucket string) storage.StorageBackend {
+		return storage.NewLocalDiskBackend(permMode, filepath.Join(tmpDir, aBucket))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = bucketer.store(context.TODO(), issuer,
+		[]storage.Serial{storage.NewSerialFromHex("0a"), storage.NewSerialFromHex("0b")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for bucket, contents := range map[string]string{"2030-01": "0a", unknownExpiryBucket: "0b"} {
+		data, err := ioutil.ReadFile(filepath.Join(tmpDir, bucket, issuer.ID()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(string(data)) != contents {
+			t.Errorf("Expected bucket %s to hold %s, got %q", bucket, contents, data)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, issuer.ID())); !os.IsNotExist(err) {
+		t.Error("Expected no unbucketed revoked serial file")
+	}
+}
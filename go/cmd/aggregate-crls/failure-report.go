@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/mozilla/crlite/go"
+	"github.com/mozilla/crlite/go/rootprogram"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+// Exit status when more than -failfraction of the issuers failed, distinct
+// from flag errors (2) and fatal errors (255), so CI can tell them apart
+const exitFailFraction = 3
+
+// Reasons an issuer with CRLs ended up without usable revocations
+var failedIssuerReasons = map[rootprogram.EnrollmentReason]bool{
+	rootprogram.ReasonSomeCrlsFailed:          true,
+	rootprogram.ReasonAllCrlsFailedValidation: true,
+	rootprogram.ReasonAllCrlsFailedDownload:   true,
+	rootprogram.ReasonStoreFailed:             true,
+	rootprogram.ReasonTooManyCrls:             true,
+	rootprogram.ReasonNoIssuerCertificate:     true,
+}
+
+type failedIssuer struct {
+	Issuer    string                       `json:"issuer"`
+	SubjectDN string                       `json:"subjectDN"`
+	Reason    rootprogram.EnrollmentReason `json:"reason"`
+}
+
+type failureReport struct {
+	Threshold float64 `json:"threshold"`
+	Fraction  float64 `json:"fraction"`
+	// Issuers whose CRLs were aggregated; those revoked in OneCRL, or not
+	// reached before the run stopped, can't be enrolled and don't count
+	EnrollableIssuers int            `json:"enrollableIssuers"`
+	FailedIssuers     []failedIssuer `json:"failedIssuers"`
+	Exceeded          bool           `json:"exceeded"`
+}
+
+// Returns the issuers that had CRLs to aggregate, ordered by ID
+func issuersWithCrls(aCrls types.IssuerCrlMap) []storage.Issuer {
+	ids := make([]string, 0, len(aCrls))
+	for id, crls := range aCrls {
+		if len(crls) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	issuers := make([]storage.Issuer, len(ids))
+	for i, id := range ids {
+		issuers[i] = storage.NewIssuerFromString(id)
+	}
+	return issuers
+}
+
+func buildFailureReport(aIssuers *rootprogram.MozIssuers, aCandidates []storage.Issuer,
+	aThreshold float64) failureReport {
+	report := failureReport{
+		Threshold:     aThreshold,
+		FailedIssuers: []failedIssuer{},
+	}
+
+	for _, issuer := range aCandidates {
+		reason, err := aIssuers.GetEnrollmentReason(issuer)
+		if err != nil || reason == rootprogram.ReasonUnprocessed || reason == rootprogram.ReasonRevokedInOneCRL ||
+			reason == rootprogram.ReasonNoValidChain {
+			continue
+		}
+		report.EnrollableIssuers++
+
+		if !failedIssuerReasons[reason] {
+			continue
+		}
+		subject, _ := aIssuers.GetSubjectForIssuer(issuer)
+		report.FailedIssuers = append(report.FailedIssuers, failedIssuer{
+			Issuer:    issuer.ID(),
+			SubjectDN: subject,
+			Reason:    reason,
+		})
+	}
+
+	if report.EnrollableIssuers > 0 {
+		report.Fraction = float64(len(report.FailedIssuers)) / float64(report.EnrollableIssuers)
+	}
+	report.Exceeded = report.Fraction > aThreshold
+	return report
+}
+
+func (r failureReport) String() string {
+	return fmt.Sprintf("%d of %d issuers (%.3f) failed, the threshold is %.3f", len(r.FailedIssuers),
+		r.EnrollableIssuers, r.Fraction, r.Threshold)
+}
+
+func saveFailureReport(aPath string, aReport failureReport) error {
+	fd, err := os.Create(aPath)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(fd)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(aReport); err != nil {
+		fd.Close() // ignore error
+		return err
+	}
+
+	return fd.Close()
+}
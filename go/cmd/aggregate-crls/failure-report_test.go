@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/mozilla/crlite/go"
+	"github.com/mozilla/crlite/go/rootprogram"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+func makeFailureReportIssuers(t *testing.T) (*rootprogram.MozIssuers, []storage.Issuer) {
+	t.Helper()
+	issuersObj := rootprogram.NewMozillaIssuers()
+
+	var issuers []storage.Issuer
+	for _, name := range []string{"Enrolled A", "Enrolled B", "Enrolled C", "Empty"} {
+		issuer := issuersObj.NewTestIssuerFromSubjectString(name)
+		if name == "Empty" {
+			issuersObj.MarkUnenrolled(issuer, rootprogram.ReasonNoRevocations)
+		} else {
+			issuersObj.Enroll(issuer)
+		}
+		issuers = append(issuers, issuer)
+	}
+	for name, reason := range map[string]rootprogram.EnrollmentReason{
+		"Download Failed":   rootprogram.ReasonAllCrlsFailedDownload,
+		"Validation Failed": rootprogram.ReasonAllCrlsFailedValidation,
+	} {
+		issuer := issuersObj.NewTestIssuerFromSubjectString(name)
+		issuersObj.MarkUnenrolled(issuer, reason)
+		issuers = append(issuers, issuer)
+	}
+
+	// Neither counts towards the total
+	issuers = append(issuers, issuersObj.NewTestIssuerFromSubjectString("Unprocessed"))
+	issuers = append(issuers, storage.NewIssuerFromString("not in the program"))
+	return issuersObj, issuers
+}
+
+func Test_buildFailureReport(t *testing.T) {
+	issuersObj, issuers := makeFailureReportIssuers(t)
+
+	report := buildFailureReport(issuersObj, issuers, 0.5)
+	if report.EnrollableIssuers != 6 || len(report.FailedIssuers) != 2 {
+		t.Fatalf("Expected 2 of 6 issuers to fail, got %s", report)
+	}
+	if report.Exceeded {
+		t.Errorf("Expected a third to be below the threshold: %s", report)
+	}
+	reasons := map[string]rootprogram.EnrollmentReason{}
+	for _, failed := range report.FailedIssuers {
+		reasons[failed.SubjectDN] = failed.Reason
+	}
+	expected := map[string]rootprogram.EnrollmentReason{
+		"Download Failed":   rootprogram.ReasonAllCrlsFailedDownload,
+		"Validation Failed": rootprogram.ReasonAllCrlsFailedValidation,
+	}
+	if !reflect.DeepEqual(reasons, expected) {
+		t.Errorf("Expected failed issuers %v, got %v", expected, reasons)
+	}
+
+	report = buildFailureReport(issuersObj, issuers, 0.25)
+	if !report.Exceeded {
+		t.Errorf("Expected a third to exceed the threshold: %s", report)
+	}
+
+	// Exactly at the threshold is fine
+	report = buildFailureReport(issuersObj, issuers, 1.0/3.0)
+	if report.Exceeded {
+		t.Errorf("Expected the threshold itself not to be exceeded: %s", report)
+	}
+
+	report = buildFailureReport(issuersObj, nil, 0)
+	if report.Exceeded || report.EnrollableIssuers != 0 {
+		t.Errorf("Expected no issuers not to exceed any threshold: %s", report)
+	}
+}
+
+func Test_saveFailureReport(t *testing.T) {
+	issuersObj, issuers := makeFailureReportIssuers(t)
+
+	tmpfile, err := ioutil.TempFile("", "Test_saveFailureReport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if err = saveFailureReport(tmpfile.Name(), buildFailureReport(issuersObj, issuers, 0.25)); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["exceeded"] != true || decoded["enrollableIssuers"] != 6.0 {
+		t.Errorf("Unexpected report: %s", data)
+	}
+	if failed, ok := decoded["failedIssuers"].([]interface{}); !ok || len(failed) != 2 {
+		t.Errorf("Expected 2 failed issuers, got %s", data)
+	}
+}
+
+func Test_issuersWithCrls(t *testing.T) {
+	crls := types.IssuerCrlMap{
+		"issuerB": {"http://b.example/crl": true},
+		"issuerA": {"http://a.example/crl": true},
+		"issuerC": {},
+	}
+	issuers := issuersWithCrls(crls)
+	if len(issuers) != 2 || issuers[0].ID() != "issuerA" || issuers[1].ID() != "issuerB" {
+		t.Errorf("Expected issuerA and issuerB, got %v", issuers)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Parses an octal permission mode, which must grant the owner at least
+// aOwnerNeeds
+func parseFileMode(aOctal string, aOwnerNeeds os.FileMode) (os.FileMode, error) {
+	value, err := strconv.ParseUint(aOctal, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%s isn't an octal mode", aOctal)
+	}
+	mode := os.FileMode(value)
+	if mode&^os.ModePerm != 0 {
+		return 0, fmt.Errorf("%s has bits other than permissions", aOctal)
+	}
+	if mode&aOwnerNeeds != aOwnerNeeds {
+		return 0, fmt.Errorf("%s doesn't grant the owner %s", aOctal, aOwnerNeeds)
+	}
+	return mode, nil
+}
+
+// Sets permMode and permModeDir. Non-default modes also need a umask that
+// leaves their bits alone, so it's narrowed to clear only the bits neither
+// grants, which keeps files written with os.Create no more open than those.
+func setFileModes(aFileMode string, aDirMode string) error {
+	fileMode, err := parseFileMode(aFileMode, 0600)
+	if err != nil {
+		return fmt.Errorf("Flag filemode is invalid: %s", err)
+	}
+	dirMode, err := parseFileMode(aDirMode, 0700)
+	if err != nil {
+		return fmt.Errorf("Flag dirmode is invalid: %s", err)
+	}
+
+	permMode, permModeDir = fileMode, dirMode
+	if fileMode != defaultPermMode || dirMode != defaultPermModeDir {
+		setUmask(os.ModePerm &^ (fileMode | dirMode))
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/mozilla/crlite/go/rootprogram"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+func Test_parseFileMode(t *testing.T) {
+	testcases := []struct {
+		octal     string
+		expected  os.FileMode
+		expectErr bool
+	}{
+		{octal: "0644", expected: 0644},
+		{octal: "664", expected: 0664},
+		{octal: "0600", expected: 0600},
+		{octal: "0400", expectErr: true},
+		{octal: "0888", expectErr: true},
+		{octal: "rw-r--r--", expectErr: true},
+		{octal: "04755", expectErr: true},
+		{octal: "", expectErr: true},
+	}
+
+	for _, tc := range testcases {
+		mode, err := parseFileMode(tc.octal, 0600)
+		if tc.expectErr && err == nil {
+			t.Errorf("Expected %q to be rejected, got %o", tc.octal, mode)
+		}
+		if !tc.expectErr && (err != nil || mode != tc.expected) {
+			t.Errorf("Expected %q to be %o, got %o, %v", tc.octal, tc.expected, mode, err)
+		}
+	}
+
+	if _, err := parseFileMode("0644", 0700); err == nil {
+		t.Error("Expected a folder mode the owner can't search to be rejected")
+	}
+}
+
+func Test_setFileModes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows doesn't have Unix permissions")
+	}
+	tmpDir, err := ioutil.TempDir("", "Test_setFileModes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	previousUmask := setUmask(0022)
+	defer setUmask(previousUmask)
+	defer func() { permMode, permModeDir = defaultPermMode, defaultPermModeDir }()
+
+	if err = setFileModes("0664", "0770"); err != nil {
+		t.Fatal(err)
+	}
+
+	revokedDir := filepath.Join(tmpDir, "revoked")
+	if err = os.MkdirAll(revokedDir, permModeDir); err != nil {
+		t.Fatal(err)
+	}
+	backend := storage.NewLocalDiskBackend(permMode, revokedDir)
+	issuer := storage.NewIssuerFromString("issuer")
+	if err = backend.StoreKnownCertificateList(context.TODO(), issuer, serialsFromHex("01")); err != nil {
+		t.Fatal(err)
+	}
+	enrolledPath := filepath.Join(tmpDir, "enrolled.json")
+	if err = rootprogram.NewMozillaIssuers().SaveIssuersListWithMode(enrolledPath, permMode); err != nil {
+		t.Fatal(err)
+	}
+	otherPath := filepath.Join(tmpDir, "audit.json")
+	fd, err := os.Create(otherPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd.Close()
+
+	for path, expected := range map[string]os.FileMode{
+		revokedDir:                          0770,
+		filepath.Join(revokedDir, "issuer"): 0664,
+		enrolledPath:                        0664,
+		// No more open than the modes requested
+		otherPath: 0664,
+	} {
+		stat, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.Mode().Perm() != expected {
+			t.Errorf("Expected %s to have mode %o, got %o", path, expected, stat.Mode().Perm())
+		}
+	}
+
+	if err = setFileModes("0644", "0600"); err == nil {
+		t.Error("Expected a folder mode the owner can't search to be rejected")
+	}
+}
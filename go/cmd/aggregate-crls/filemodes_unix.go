@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Returns the previous umask
+func setUmask(aMask os.FileMode) os.FileMode {
+	return os.FileMode(syscall.Umask(int(aMask)))
+}
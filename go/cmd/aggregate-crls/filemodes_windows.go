@@ -0,0 +1,8 @@
+package main
+
+import "os"
+
+// Windows has no umask
+func setUmask(aMask os.FileMode) os.FileMode {
+	return 0
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// With -generational-output, the symlink under revokedpath to the last
+	// complete run's folder
+	latestGenerationLink = "latest"
+	generationLayout     = "20060102T150405Z"
+)
+
+// One run's folder of revoked serial files under a -generational-output
+// revokedpath. Consumers read through the latest symlink, which only moves
+// once the run has finished, so they never see a partial run.
+type outputGeneration struct {
+	root string
+	path string
+}
+
+func newOutputGeneration(aRoot string, aNow time.Time) (*outputGeneration, error) {
+	if err := os.MkdirAll(aRoot, permModeDir); err != nil {
+		return nil, err
+	}
+	generation := &outputGeneration{
+		root: aRoot,
+		path: filepath.Join(aRoot, aNow.UTC().Format(generationLayout)),
+	}
+	// Mkdir rather than MkdirAll, so a run never writes into an earlier one
+	if err := os.Mkdir(generation.path, permModeDir); err != nil {
+		return nil, err
+	}
+	return generation, nil
+}
+
+// The folder consumers currently read, which holds the previous run's files
+func (g *outputGeneration) latestPath() string {
+	return filepath.Join(g.root, latestGenerationLink)
+}
+
+// Atomically repoints the latest symlink at this generation, by renaming a
+// new symlink over it. The target is relative, so the root can be moved.
+func (g *outputGeneration) promote() error {
+	tmpLink := g.latestPath() + ".tmp"
+	if err := os.Remove(tmpLink); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Symlink(filepath.Base(g.path), tmpLink); err != nil {
+		return err
+	}
+	return os.Rename(tmpLink, g.latestPath())
+}
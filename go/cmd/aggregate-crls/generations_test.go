@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mozilla/crlite/go/storage"
+)
+
+func Test_outputGenerations(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_outputGenerations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	root := filepath.Join(tmpDir, "revoked")
+	issuer := storage.NewIssuerFromString("issuerA")
+
+	start := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+	runOnce := func(aNow time.Time, aSerial string, aSucceeded bool) *outputGeneration {
+		t.Helper()
+		generation, err := newOutputGeneration(root, aNow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backend := storage.NewLocalDiskBackend(permMode, generation.path)
+		err = backend.StoreKnownCertificateList(context.TODO(), issuer, serialsFromHex(aSerial))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if aSucceeded {
+			if err = generation.promote(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return generation
+	}
+	latestSerials := func() string {
+		t.Helper()
+		data, err := ioutil.ReadFile(filepath.Join(root, latestGenerationLink, issuer.ID()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	first := runOnce(start, "01", true)
+	if serials := latestSerials(); serials != "01\n" {
+		t.Errorf("Expected the first generation's serials, got %q", serials)
+	}
+
+	// A failed run leaves latest alone
+	failed := runOnce(start.Add(time.Hour), "02", false)
+	if serials := latestSerials(); serials != "01\n" {
+		t.Errorf("Expected latest to still be the first generation, got %q", serials)
+	}
+
+	third := runOnce(start.Add(2*time.Hour), "03", true)
+	if serials := latestSerials(); serials != "03\n" {
+		t.Errorf("Expected the third generation's serials, got %q", serials)
+	}
+	target, err := os.Readlink(filepath.Join(root, latestGenerationLink))
+	if err != nil || target != filepath.Base(third.path) {
+		t.Errorf("Expected latest to point at %s, got %s: %v", filepath.Base(third.path), target, err)
+	}
+
+	for _, generation := range []*outputGeneration{first, failed} {
+		if _, err := os.Stat(filepath.Join(generation.path, issuer.ID())); err != nil {
+			t.Errorf("Expected the older generation %s to remain: %v", generation.path, err)
+		}
+	}
+
+	// A run never reuses an earlier generation's folder
+	if _, err := newOutputGeneration(root, start); err == nil {
+		t.Error("Expected an existing generation not to be reused")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mozilla/crlite/go/crlcheck"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+// When a revoked certificate's key became invalid, per its CRL entry
+type invalidityEntry struct {
+	RevocationDate time.Time `json:"revocationDate"`
+	InvalidityDate time.Time `json:"invalidityDate"`
+}
+
+// Collects the invalidity dates of each issuer's revoked serials, so
+// consumers can tell when a revocation was effective from
+type invalidityDates struct {
+	mutex sync.Mutex
+	// Issuer ID to serial hex
+	dates map[string]map[string]invalidityEntry
+}
+
+func newInvalidityDates() *invalidityDates {
+	return &invalidityDates{
+		dates: make(map[string]map[string]invalidityEntry),
+	}
+}
+
+// Shards and overlapping CRLs can list a serial more than once, so the
+// earliest invalidity date given for it is kept
+func (id *invalidityDates) add(aIssuer storage.Issuer, aDates []crlcheck.InvalidityDate) {
+	if len(aDates) == 0 {
+		return
+	}
+
+	id.mutex.Lock()
+	defer id.mutex.Unlock()
+
+	serials, ok := id.dates[aIssuer.ID()]
+	if !ok {
+		serials = make(map[string]invalidityEntry)
+		id.dates[aIssuer.ID()] = serials
+	}
+	for _, date := range aDates {
+		serial := date.Serial.HexString()
+		if existing, ok := serials[serial]; ok && !date.InvalidityDate.Before(existing.InvalidityDate) {
+			continue
+		}
+		serials[serial] = invalidityEntry{
+			RevocationDate: date.RevocationTime,
+			InvalidityDate: date.InvalidityDate,
+		}
+	}
+}
+
+func saveInvalidityDates(aPath string, aDates *invalidityDates) error {
+	fd, err := os.Create(aPath)
+	if err != nil {
+		return err
+	}
+
+	aDates.mutex.Lock()
+	defer aDates.mutex.Unlock()
+
+	enc := json.NewEncoder(fd)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(aDates.dates); err != nil {
+		fd.Close() // ignore error
+		return err
+	}
+
+	return fd.Close()
+}
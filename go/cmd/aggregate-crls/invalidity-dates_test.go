@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency-go/asn1"
+	"github.com/google/certificate-transparency-go/x509/pkix"
+	"github.com/mozilla/crlite/go"
+	"github.com/mozilla/crlite/go/rootprogram"
+	"github.com/mozilla/crlite/go/storage"
+	"github.com/vbauerster/mpb/v5"
+)
+
+func Test_aggregateCRLWorkerInvalidityDates(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_aggregateCRLWorkerInvalidityDates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	ca, caPrivKey := makeCA(t)
+	revocationTime := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	compromised := revocationTime.AddDate(0, 0, -10)
+	invalidityValue, err := asn1.MarshalWithParams(compromised, "generalized")
+	if err != nil {
+		t.Fatal(err)
+	}
+	crlPath := writeTempCRL(t, "invalidity", makeCRLWithRevocations(t, ca, caPrivKey, time.Now(),
+		time.Now().AddDate(0, 0, 1), []pkix.RevokedCertificate{
+			{SerialNumber: big.NewInt(1), RevocationTime: revocationTime},
+			{
+				SerialNumber:   big.NewInt(2),
+				RevocationTime: revocationTime,
+				Extensions: []pkix.Extension{
+					{Id: asn1.ObjectIdentifier{2, 5, 29, 24}, Value: invalidityValue},
+				},
+			},
+		}))
+	defer os.Remove(crlPath)
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+	ae := AggregateEngine{
+		loadStorageDB:   storageDB,
+		saveStorage:     storage.NewLocalDiskBackend(permMode, tmpDir),
+		remoteCache:     storage.NewMockRemoteCache(),
+		issuers:         issuersObj,
+		display:         display,
+		auditor:         NewCrlAuditor(issuersObj),
+		invalidityDates: newInvalidityDates(),
+	}
+
+	crlUrl, _ := url.Parse("http://example.com/invalidity.crl")
+	workChan := make(chan types.IssuerCrlUrlPaths, 1)
+	workChan <- types.IssuerCrlUrlPaths{
+		Issuer:      issuer,
+		CrlUrlPaths: []types.UrlPath{{Url: *crlUrl, Path: crlPath}},
+	}
+	close(workChan)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	ae.aggregateCRLWorker(context.TODO(), &wg, workChan, make(chan issuerError, 1), display.AddBar(1))
+
+	// The serials file is as without invalidity dates
+	data, err := ioutil.ReadFile(filepath.Join(tmpDir, issuer.ID()))
+	if err != nil || string(data) != "01\n02\n" {
+		t.Errorf("Expected both serials saved, got %q: %v", data, err)
+	}
+
+	outPath := filepath.Join(tmpDir, "invalidity.json")
+	if err = saveInvalidityDates(outPath, ae.invalidityDates); err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved map[string]map[string]invalidityEntry
+	if err = json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]map[string]invalidityEntry{
+		issuer.ID(): {"02": {RevocationDate: revocationTime, InvalidityDate: compromised}},
+	}
+	if !reflect.DeepEqual(saved, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, saved)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mozilla/crlite/go/storage"
+)
+
+type timingStage int
+
+const (
+	// Downloading and verifying an issuer's CRLs
+	stageDownload timingStage = iota
+	// Parsing and validating them, and collecting the serials
+	stageProcess
+	// Saving the serials
+	stageStore
+)
+
+type issuerTiming struct {
+	Issuer          string  `json:"issuer,omitempty"`
+	DownloadSeconds float64 `json:"downloadSeconds"`
+	ProcessSeconds  float64 `json:"processSeconds"`
+	StoreSeconds    float64 `json:"storeSeconds"`
+}
+
+func (t issuerTiming) totalSeconds() float64 {
+	return t.DownloadSeconds + t.ProcessSeconds + t.StoreSeconds
+}
+
+// Wall-clock time spent on each issuer in each stage, for finding the issuers
+// that dominate a run. Callers measure with time.Since, which uses the
+// monotonic clock.
+type issuerTimings struct {
+	mutex   sync.Mutex
+	timings map[string]*issuerTiming
+}
+
+func newIssuerTimings() *issuerTimings {
+	return &issuerTimings{
+		timings: make(map[string]*issuerTiming),
+	}
+}
+
+func (it *issuerTimings) record(aIssuer storage.Issuer, aStage timingStage, aDuration time.Duration) {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	timing, ok := it.timings[aIssuer.ID()]
+	if !ok {
+		timing = &issuerTiming{Issuer: aIssuer.ID()}
+		it.timings[aIssuer.ID()] = timing
+	}
+	switch aStage {
+	case stageDownload:
+		timing.DownloadSeconds += aDuration.Seconds()
+	case stageProcess:
+		timing.ProcessSeconds += aDuration.Seconds()
+	case stageStore:
+		timing.StoreSeconds += aDuration.Seconds()
+	}
+}
+
+type timingReport struct {
+	Totals issuerTiming `json:"totals"`
+	// Slowest first
+	Issuers []issuerTiming `json:"issuers"`
+}
+
+func (it *issuerTimings) report() timingReport {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	report := timingReport{
+		Issuers: make([]issuerTiming, 0, len(it.timings)),
+	}
+	for _, timing := range it.timings {
+		report.Issuers = append(report.Issuers, *timing)
+		report.Totals.DownloadSeconds += timing.DownloadSeconds
+		report.Totals.ProcessSeconds += timing.ProcessSeconds
+		report.Totals.StoreSeconds += timing.StoreSeconds
+	}
+	sort.Slice(report.Issuers, func(i, j int) bool {
+		if report.Issuers[i].totalSeconds() != report.Issuers[j].totalSeconds() {
+			return report.Issuers[i].totalSeconds() > report.Issuers[j].totalSeconds()
+		}
+		return report.Issuers[i].Issuer < report.Issuers[j].Issuer
+	})
+	return report
+}
+
+func saveTimings(aPath string, aTimings *issuerTimings) error {
+	fd, err := os.Create(aPath)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(fd)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(aTimings.report()); err != nil {
+		fd.Close() // ignore error
+		return err
+	}
+
+	return fd.Close()
+}
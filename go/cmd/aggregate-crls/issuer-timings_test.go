@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mozilla/crlite/go"
+	"github.com/mozilla/crlite/go/rootprogram"
+	"github.com/mozilla/crlite/go/storage"
+	"github.com/vbauerster/mpb/v5"
+)
+
+func Test_issuerTimingsReport(t *testing.T) {
+	timings := newIssuerTimings()
+	fast := storage.NewIssuerFromString("fast")
+	slow := storage.NewIssuerFromString("slow")
+
+	timings.record(fast, stageDownload, time.Second)
+	timings.record(slow, stageDownload, 3*time.Second)
+	timings.record(slow, stageDownload, 2*time.Second)
+	timings.record(slow, stageProcess, time.Second)
+	timings.record(fast, stageStore, 500*time.Millisecond)
+
+	report := timings.report()
+	expected := []issuerTiming{
+		{Issuer: "slow", DownloadSeconds: 5, ProcessSeconds: 1},
+		{Issuer: "fast", DownloadSeconds: 1, StoreSeconds: 0.5},
+	}
+	if !reflect.DeepEqual(report.Issuers, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, report.Issuers)
+	}
+	if report.Totals != (issuerTiming{DownloadSeconds: 6, ProcessSeconds: 1, StoreSeconds: 0.5}) {
+		t.Errorf("Unexpected totals %+v", report.Totals)
+	}
+}
+
+func Test_workersRecordTimings(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_workersRecordTimings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	*crlpath = tmpDir
+	defer os.RemoveAll(tmpDir)
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+	ca, caPrivKey := makeCA(t)
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+	ae := AggregateEngine{
+		loadStorageDB: storageDB,
+		saveStorage:   storage.NewMockBackend(),
+		remoteCache:   storage.NewMockRemoteCache(),
+		issuers:       issuersObj,
+		display:       display,
+		auditor:       NewCrlAuditor(issuersObj),
+		timings:       newIssuerTimings(),
+	}
+
+	crlBytes := makeCRL(t, ca, caPrivKey, time.Now().AddDate(0, 0, -1), time.Now().AddDate(0, 0, 1))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(crlBytes)
+	}))
+	defer server.Close()
+	crlUrl, _ := url.Parse(server.URL + "/timed.crl")
+
+	crlsChan := make(chan types.IssuerCrlUrls, 1)
+	crlsChan <- types.IssuerCrlUrls{Issuer: issuer, Urls: []url.URL{*crlUrl}}
+	close(crlsChan)
+	resultChan := make(chan types.IssuerCrlUrlPaths, 1)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	ae.crlFetchWorker(context.TODO(), &wg, crlsChan, resultChan, display.AddBar(1))
+	close(resultChan)
+
+	wg.Add(1)
+	ae.aggregateCRLWorker(context.TODO(), &wg, resultChan, make(chan issuerError, 1), display.AddBar(1))
+	if !issuersObj.IsIssuerEnrolled(issuer) {
+		t.Fatal("Expected the issuer to be enrolled")
+	}
+
+	report := ae.timings.report()
+	if len(report.Issuers) != 1 {
+		t.Fatalf("Expected timings for one issuer, got %+v", report.Issuers)
+	}
+	timing := report.Issuers[0]
+	if timing.Issuer != issuer.ID() || timing.DownloadSeconds <= 0 || timing.ProcessSeconds <= 0 ||
+		timing.StoreSeconds <= 0 {
+		t.Errorf("Expected every stage to be timed, got %+v", timing)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"sort"
+
+	"github.com/mozilla/crlite/go"
+	"github.com/mozilla/crlite/go/logging"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+// Values of -crlsource
+const (
+	crlSourceDownload = "download"
+	crlSourceLocal    = "local"
+)
+
+// Finds the .crl files already in aCrlPath/<issuer>/ for each issuer in the
+// program, in place of identifyCrlsByIssuer, keyed by their paths
+func (ae *AggregateEngine) listLocalCRLs(aCrlPath string) (types.IssuerCrlMap, error) {
+	crlPath, err := filepath.Abs(aCrlPath)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(crlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	issuerCrls := make(types.IssuerCrlMap)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		issuerID := entry.Name()
+		if ae.issuerFilter != nil && !ae.issuerFilter[issuerID] {
+			continue
+		}
+		if !ae.issuers.IsIssuerInProgram(storage.NewIssuerFromString(issuerID)) {
+			logging.Infof("Skipping %s, which isn't named for an issuer in the program",
+				filepath.Join(crlPath, issuerID))
+			continue
+		}
+
+		paths, err := filepath.Glob(filepath.Join(crlPath, issuerID, "*.crl"))
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) == 0 {
+			continue
+		}
+		crls := make(map[string]bool, len(paths))
+		for _, path := range paths {
+			crls[path] = true
+		}
+		issuerCrls[issuerID] = crls
+	}
+	return issuerCrls, nil
+}
+
+// In place of downloadCRLs, passes on the files found by listLocalCRLs as
+// they are. Their URLs are file: URLs of their paths.
+func (ae *AggregateEngine) localCRLPaths(aIssuerCrls types.IssuerCrlMap) (<-chan types.IssuerCrlUrlPaths, int64) {
+	ae.progress.SetPhase("aggregate")
+
+	count := int64(len(aIssuerCrls))
+	resultChan := make(chan types.IssuerCrlUrlPaths, count)
+	for issuerID, crls := range aIssuerCrls {
+		issuer := storage.NewIssuerFromString(issuerID)
+
+		paths := make([]string, 0, len(crls))
+		for path := range crls {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		urlPaths := make([]types.UrlPath, 0, len(paths))
+		for _, path := range paths {
+			urlPaths = append(urlPaths, types.UrlPath{
+				Url:  url.URL{Scheme: "file", Path: filepath.ToSlash(path)},
+				Path: path,
+			})
+		}
+
+		subj, err := ae.issuers.GetSubjectForIssuer(issuer)
+		if err != nil {
+			logging.Error(err)
+		}
+
+		resultChan <- types.IssuerCrlUrlPaths{
+			Issuer:      issuer,
+			IssuerDN:    subj,
+			CrlUrlPaths: urlPaths,
+		}
+	}
+	close(resultChan)
+
+	ae.progress.SetIssuersTotal(count)
+	// Only the aggregate phase is left
+	ae.runProgress = newRunProgress(ae.display, count)
+	ae.runProgress.add(count * (runPhases - 1))
+	return resultChan, count
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency-go/x509/pkix"
+	"github.com/mozilla/crlite/go/rootprogram"
+	"github.com/mozilla/crlite/go/storage"
+	"github.com/vbauerster/mpb/v5"
+)
+
+func Test_localCRLs(t *testing.T) {
+	crlDir, err := ioutil.TempDir("", "Test_localCRLs-crls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(crlDir)
+	revokedDir, err := ioutil.TempDir("", "Test_localCRLs-revoked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(revokedDir)
+
+	issuersObj := rootprogram.NewMozillaIssuers()
+	ca, caPrivKey := makeCA(t)
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+	thisUpdate := time.Now().UTC()
+	write := func(aIssuerID string, aName string, aData []byte) {
+		if err := os.MkdirAll(filepath.Join(crlDir, aIssuerID), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(crlDir, aIssuerID, aName), aData, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(issuer.ID(), "shard-1.crl", makeCRLWithRevocations(t, ca, caPrivKey, thisUpdate, thisUpdate.AddDate(0, 0, 1),
+		[]pkix.RevokedCertificate{
+			{SerialNumber: big.NewInt(1), RevocationTime: thisUpdate},
+			{SerialNumber: big.NewInt(2), RevocationTime: thisUpdate},
+		}))
+	write(issuer.ID(), "shard-2.crl", makeCRLWithRevocations(t, ca, caPrivKey, thisUpdate, thisUpdate.AddDate(0, 0, 1),
+		[]pkix.RevokedCertificate{
+			{SerialNumber: big.NewInt(3), RevocationTime: thisUpdate},
+		}))
+	// Neither is read
+	write(issuer.ID(), "shard-3.crl.tmp", []byte("partial download"))
+	write("not-an-issuer", "other.crl", []byte("unrelated"))
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	auditor := NewCrlAuditor(issuersObj)
+	ae := AggregateEngine{
+		loadStorageDB:    storageDB,
+		saveStorage:      storage.NewLocalDiskBackend(permMode, revokedDir),
+		remoteCache:      storage.NewMockRemoteCache(),
+		issuers:          issuersObj,
+		display:          display,
+		auditor:          auditor,
+		aggregateThreads: 1,
+	}
+
+	issuerCrls, err := ae.listLocalCRLs(crlDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issuerCrls) != 1 || len(issuerCrls[issuer.ID()]) != 2 {
+		t.Fatalf("Expected the issuer's 2 CRLs, got %v", issuerCrls)
+	}
+
+	crlPaths, count := ae.localCRLPaths(issuerCrls)
+	if storeErrs := ae.aggregateCRLs(context.TODO(), count, crlPaths); len(storeErrs) != 0 {
+		t.Fatalf("Unexpected store errors: %v", storeErrs)
+	}
+
+	if !issuersObj.IsIssuerEnrolled(issuer) {
+		t.Error("Expected the issuer to be enrolled from its local CRLs")
+	}
+	serials, err := ae.saveStorage.(storage.KnownCertificateListLoader).LoadKnownCertificateList(context.TODO(), issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := serialsFromHex("01", "02", "03")
+	if len(serials) != len(expected) {
+		t.Fatalf("Expected serials %v, got %v", expected, serials)
+	}
+	for i := range expected {
+		if serials[i].Cmp(expected[i]) != 0 {
+			t.Errorf("Expected serials %v, got %v", expected, serials)
+		}
+	}
+
+	// Nothing was fetched
+	entries := auditor.GetEntries()
+	if len(entries) != 2 {
+		t.Errorf("Expected an audit entry for each CRL, got %+v", entries)
+	}
+	for _, entry := range entries {
+		if entry.Kind != AuditKindValid || !strings.HasPrefix(entry.Url, "file:///") {
+			t.Errorf("Expected only valid local CRLs, got %+v", entry)
+		}
+	}
+}
+
+func Test_listLocalCRLsFilter(t *testing.T) {
+	crlDir, err := ioutil.TempDir("", "Test_listLocalCRLsFilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(crlDir)
+
+	issuersObj := rootprogram.NewMozillaIssuers()
+	ca, _ := makeCA(t)
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+	if err = os.MkdirAll(filepath.Join(crlDir, issuer.ID()), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(crlDir, issuer.ID(), "a.crl"), []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ae := AggregateEngine{issuers: issuersObj, issuerFilter: map[string]bool{"someone-else": true}}
+	issuerCrls, err := ae.listLocalCRLs(crlDir)
+	if err != nil || len(issuerCrls) != 0 {
+		t.Errorf("Expected the filtered-out issuer to be skipped, got %v, %v", issuerCrls, err)
+	}
+
+	if _, err = ae.listLocalCRLs(filepath.Join(crlDir, "missing")); err == nil {
+		t.Error("Expected a missing crlpath to be an error")
+	}
+}
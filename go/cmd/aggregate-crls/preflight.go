@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mozilla/crlite/go/storage"
+)
+
+const bytesPerMiB = 1024 * 1024
+
+// Creates and removes a file in aDir, since the permissions alone don't say
+// whether, e.g., a read-only mount can be written
+func checkWritable(aDir string) error {
+	probe, err := ioutil.TempFile(aDir, ".aggregate-crls-preflight-")
+	if err != nil {
+		return fmt.Errorf("%s isn't writable: %s", aDir, err)
+	}
+	name := probe.Name()
+	if err = probe.Close(); err != nil {
+		os.Remove(name) // ignore error
+		return fmt.Errorf("%s isn't writable: %s", aDir, err)
+	}
+	return os.Remove(name)
+}
+
+func checkFreeSpace(aDir string, aMinMiB uint64) error {
+	available, err := availableBytes(aDir)
+	if err != nil {
+		return fmt.Errorf("Couldn't find the space available in %s: %s", aDir, err)
+	}
+	if available < aMinMiB*bytesPerMiB {
+		return fmt.Errorf("%s has %d MiB available, less than the minimum of %d MiB", aDir,
+			available/bytesPerMiB, aMinMiB)
+	}
+	return nil
+}
+
+// Checks every output folder can be written, and has at least aMinMiB
+// available if that's non-zero, so that a run fails at the start rather than
+// when it comes to save its output
+func preflightOutputDirs(aDirs []string, aMinMiB uint64) error {
+	checked := make(map[string]bool)
+	for _, dir := range aDirs {
+		if checked[dir] {
+			continue
+		}
+		checked[dir] = true
+
+		if err := checkWritable(dir); err != nil {
+			return err
+		}
+		if aMinMiB == 0 {
+			continue
+		}
+		if err := checkFreeSpace(dir, aMinMiB); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Whether the run processes the issuer: it's in the program and, if
+// -issuerfilter is set, listed there
+func (ae *AggregateEngine) wantsIssuer(aIssuer storage.Issuer) bool {
+	if !ae.issuers.IsIssuerInProgram(aIssuer) {
+		return false
+	}
+	return ae.issuerFilter == nil || ae.issuerFilter[aIssuer.ID()]
+}
+
+// Checks every issuer among aIssuers which the run processes has a
+// certificate to verify its CRLs with, so that inconsistencies between CCADB
+// and the issuer metadata fail the run before anything is downloaded. The
+// error lists every issuer without one.
+func (ae *AggregateEngine) checkIssuerCertificates(aIssuers []storage.Issuer) error {
+	var missing []string
+	for _, issuer := range aIssuers {
+		if !ae.wantsIssuer(issuer) {
+			continue
+		}
+		if _, err := ae.issuers.GetCertificateForIssuer(issuer); err != nil {
+			missing = append(missing, issuer.ID())
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return fmt.Errorf("%d issuers have no certificate: %s", len(missing), strings.Join(missing, ", "))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mozilla/crlite/go/storage"
+)
+
+func Test_preflightOutputDirs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_preflightOutputDirs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err = preflightOutputDirs([]string{tmpDir, tmpDir}, 1); err != nil {
+		t.Error(err)
+	}
+	entries, err := ioutil.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected the probe file to be removed, found %v", entries)
+	}
+
+	err = preflightOutputDirs([]string{tmpDir}, math.MaxUint64/bytesPerMiB)
+	if err == nil || !strings.Contains(err.Error(), "less than the minimum") {
+		t.Errorf("Expected too little space available, got %v", err)
+	}
+
+	missing := filepath.Join(tmpDir, "missing")
+	if err = preflightOutputDirs([]string{tmpDir, missing}, 0); err == nil {
+		t.Error("Expected a missing folder to fail")
+	}
+}
+
+func Test_preflightOutputDirsReadOnly(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_preflightOutputDirsReadOnly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	readOnly := filepath.Join(tmpDir, "readonly")
+	if err = os.Mkdir(readOnly, 0555); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(readOnly, 0755)
+	if fd, err := os.Create(filepath.Join(readOnly, "file")); err == nil {
+		fd.Close()
+		t.Skip("Permissions aren't enforced for this user")
+	}
+
+	err = preflightOutputDirs([]string{tmpDir, readOnly}, 0)
+	if err == nil || !strings.Contains(err.Error(), readOnly+" isn't writable") {
+		t.Errorf("Expected %s not to be writable, got %v", readOnly, err)
+	}
+}
+
+func Test_checkIssuerCertificates(t *testing.T) {
+	ae, storageDB := makeIdentifyEngine()
+
+	ca, _ := makeCA(t)
+	withCert := ae.issuers.InsertIssuerFromCertAndPem(ca, "")
+	addIdentifiableIssuer(t, storageDB, withCert, "http://example.com/ok.crl")
+	// In the issuer metadata and the program, but without its certificate
+	missing := ae.issuers.NewTestIssuerFromSubjectString("Missing Certificate")
+	addIdentifiableIssuer(t, storageDB, missing, "http://example.com/missing.crl")
+	alsoMissing := ae.issuers.NewTestIssuerFromSubjectString("Also Missing")
+	// In the issuer metadata only, so never processed
+	notInProgram := storage.NewIssuerFromString("notInProgram")
+	addIdentifiableIssuer(t, storageDB, notInProgram, "http://example.com/other.crl")
+
+	issuers := []storage.Issuer{withCert, missing, alsoMissing, notInProgram}
+	err := ae.checkIssuerCertificates(issuers)
+	if err == nil {
+		t.Fatal("Expected issuers without certificates to fail the check")
+	}
+	expected := "2 issuers have no certificate: Also Missing, Missing Certificate"
+	if err.Error() != expected {
+		t.Errorf("Expected %q, got %q", expected, err)
+	}
+
+	ae.issuerFilter = map[string]bool{withCert.ID(): true, missing.ID(): true}
+	if err = ae.checkIssuerCertificates(issuers); err == nil || !strings.Contains(err.Error(), "1 issuers") {
+		t.Errorf("Expected only the filtered-in issuer to be checked, got %v", err)
+	}
+
+	ae.issuerFilter = map[string]bool{withCert.ID(): true}
+	if err = ae.checkIssuerCertificates(issuers); err != nil {
+		t.Errorf("Expected the filtered-out issuers not to be checked, got %v", err)
+	}
+}
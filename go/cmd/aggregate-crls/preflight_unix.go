@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "syscall"
+
+func availableBytes(aDir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(aDir, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,7 @@
+package main
+
+import "fmt"
+
+func availableBytes(aDir string) (uint64, error) {
+	return 0, fmt.Errorf("not supported on Windows")
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"sync"
+
+	"github.com/mozilla/crlite/go/logging"
+)
+
+// Starts writing a CPU profile to aPath, returning the function that finishes
+// it. That can be called more than once, e.g. both before os.Exit and in a
+// defer.
+func startCPUProfile(aPath string) (func(), error) {
+	fd, err := os.Create(aPath)
+	if err != nil {
+		return nil, err
+	}
+	if err = runtimepprof.StartCPUProfile(fd); err != nil {
+		fd.Close() // ignore error
+		return nil, err
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			runtimepprof.StopCPUProfile()
+			if err := fd.Close(); err != nil {
+				logging.Warningf("Could not close CPU profile %s: %v", aPath, err)
+			}
+		})
+	}, nil
+}
+
+// Writes a heap profile of what's still in use to aPath
+func writeHeapProfile(aPath string) error {
+	fd, err := os.Create(aPath)
+	if err != nil {
+		return err
+	}
+	// Without this, the profile reflects the heap as of the last GC
+	runtime.GC()
+	if err = runtimepprof.WriteHeapProfile(fd); err != nil {
+		fd.Close() // ignore error
+		return fmt.Errorf("Couldn't write heap profile: %s", err)
+	}
+	return fd.Close()
+}
+
+// The net/http/pprof handlers, on their own mux so that they're only served
+// on -pprofaddr, and not alongside anything using the default mux
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_profilesProduced(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_profilesProduced")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cpuPath := filepath.Join(tmpDir, "cpu.pprof")
+	stop, err := startCPUProfile(cpuPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop()
+	// As before os.Exit and then in main's defer
+	stop()
+
+	memPath := filepath.Join(tmpDir, "mem.pprof")
+	if err = writeHeapProfile(memPath); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{cpuPath, memPath} {
+		stat, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.Size() == 0 {
+			t.Errorf("Expected %s to hold a profile", path)
+		}
+	}
+
+	if _, err = startCPUProfile(filepath.Join(tmpDir, "missing", "cpu.pprof")); err == nil {
+		t.Error("Expected an unwritable CPU profile path to be an error")
+	}
+}
+
+func Test_pprofHandler(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	pprofHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/debug/pprof/heap", nil))
+	if recorder.Code != http.StatusOK || recorder.Body.Len() == 0 {
+		t.Errorf("Expected a heap profile, got status %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	pprofHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected only pprof to be served, got status %d", recorder.Code)
+	}
+}
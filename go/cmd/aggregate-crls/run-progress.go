@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/vbauerster/mpb/v5"
+	"github.com/vbauerster/mpb/v5/decor"
+)
+
+// Identify, download, and aggregate each count once per issuer
+const runPhases = 3
+
+// Counts each issuer's progress through every phase against one total, fixed
+// when identification starts, so a multi-hour run has an overall readout and
+// ETA alongside each phase's own bar. Safe for concurrent use; a nil
+// runProgress counts nothing.
+type runProgress struct {
+	issuers int64
+	done    int64
+	bar     *mpb.Bar
+}
+
+func newRunProgress(aDisplay *mpb.Progress, aIssuers int64) *runProgress {
+	total := aIssuers * runPhases
+	return &runProgress{
+		issuers: aIssuers,
+		bar: aDisplay.AddBar(total,
+			mpb.PrependDecorators(
+				decor.Name("Total run"),
+			),
+			mpb.AppendDecorators(
+				decor.Percentage(),
+				decor.Name(""),
+				decor.AverageETA(decor.ET_STYLE_GO, decor.WC{W: 14}),
+				decor.CountersNoUnit("%d / %d", decor.WCSyncSpace),
+			),
+		),
+	}
+}
+
+// Counts one issuer through one phase
+func (p *runProgress) issuerPhaseDone() {
+	p.add(1)
+}
+
+// Called once the issuers with CRLs to download are known. Those without
+// skip the download and aggregate phases, which are counted as done.
+func (p *runProgress) issuersWithCrls(aCount int64) {
+	if p == nil || aCount >= p.issuers {
+		return
+	}
+	p.add((p.issuers - aCount) * (runPhases - 1))
+}
+
+func (p *runProgress) add(aWork int64) {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.done, aWork)
+	p.bar.IncrInt64(aWork)
+}
+
+func (p *runProgress) Done() int64 {
+	if p == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&p.done)
+}
+
+func (p *runProgress) Total() int64 {
+	if p == nil {
+		return 0
+	}
+	return p.issuers * runPhases
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mozilla/crlite/go"
+	"github.com/vbauerster/mpb/v5"
+)
+
+func Test_runProgress(t *testing.T) {
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+	progress := newRunProgress(display, 4)
+	if progress.Total() != 12 {
+		t.Fatalf("Expected 4 issuers through 3 phases, got %d", progress.Total())
+	}
+
+	for i := 0; i < 4; i++ {
+		progress.issuerPhaseDone()
+	}
+	// One issuer had no CRLs, so skips downloading and aggregating
+	progress.issuersWithCrls(3)
+	if progress.Done() != 6 {
+		t.Errorf("Expected 6 of 12 done after identifying, got %d", progress.Done())
+	}
+	for i := 0; i < 6; i++ {
+		progress.issuerPhaseDone()
+	}
+	if progress.Done() != progress.Total() {
+		t.Errorf("Expected the run to be complete, got %d of %d", progress.Done(), progress.Total())
+	}
+
+	var nilProgress *runProgress
+	nilProgress.issuerPhaseDone()
+	nilProgress.issuersWithCrls(1)
+	if nilProgress.Done() != 0 || nilProgress.Total() != 0 {
+		t.Error("Expected a nil runProgress to count nothing")
+	}
+}
+
+func Test_runProgressAcrossPhases(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_runProgressAcrossPhases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer func(aPath string) { *crlpath = aPath }(*crlpath)
+	*crlpath = tmpDir
+
+	threads := *ctconfig.NumThreads
+	*ctconfig.NumThreads = 1
+	defer func() {
+		*ctconfig.NumThreads = threads
+	}()
+
+	ae, storageDB := makeIdentifyEngine()
+	ae.downloadThreads = 2
+	ae.aggregateThreads = 2
+
+	thisUpdate := time.Now().UTC()
+	for i := 0; i < 2; i++ {
+		ca, caPrivKey := makeCA(t)
+		issuer := ae.issuers.InsertIssuerFromCertAndPem(ca, "")
+		server := hostCRL(t, makeCRL(t, ca, caPrivKey, thisUpdate, thisUpdate.AddDate(0, 0, 1)))
+		defer server.Close()
+		addIdentifiableIssuer(t, storageDB, issuer, server.URL+"/issuer.crl")
+	}
+	// Its only CRL URL is dropped, so it's never downloaded or aggregated
+	ca, _ := makeCA(t)
+	addIdentifiableIssuer(t, storageDB, ae.issuers.InsertIssuerFromCertAndPem(ca, ""), "not a URL")
+
+	crls, _ := ae.identifyCrlsByIssuer(context.TODO())
+	if ae.runProgress.Total() != 9 || ae.runProgress.Done() != 3 {
+		t.Errorf("Expected 3 of 9 done after identifying, got %d of %d", ae.runProgress.Done(),
+			ae.runProgress.Total())
+	}
+
+	// Aggregation would overlap the downloads, so finish them first
+	downloaded, count := ae.downloadCRLs(context.TODO(), crls)
+	crlPaths := make(chan types.IssuerCrlUrlPaths, count)
+	for result := range downloaded {
+		crlPaths <- result
+	}
+	close(crlPaths)
+	if ae.runProgress.Done() != 7 {
+		t.Errorf("Expected 7 of 9 done after downloading, got %d", ae.runProgress.Done())
+	}
+
+	ae.aggregateCRLs(context.TODO(), count, crlPaths)
+	if ae.runProgress.Done() != ae.runProgress.Total() {
+		t.Errorf("Expected the run to be complete, got %d of %d", ae.runProgress.Done(), ae.runProgress.Total())
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/mozilla/crlite/go"
+	"github.com/mozilla/crlite/go/logging"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+// The serials revoked since the previous run, and those no longer listed,
+// each in ascending order
+type serialDelta struct {
+	added   []storage.Serial
+	removed []storage.Serial
+}
+
+func diffSerials(aPrevious []storage.Serial, aCurrent []storage.Serial) serialDelta {
+	previous := types.NewSerialSet()
+	for _, serial := range aPrevious {
+		previous.Add(serial)
+	}
+	current := types.NewSerialSet()
+	for _, serial := range aCurrent {
+		current.Add(serial)
+	}
+
+	// Add reports whether each serial was missing from the other run, and
+	// skips any repeats
+	delta := serialDelta{
+		added:   []storage.Serial{},
+		removed: []storage.Serial{},
+	}
+	for _, serial := range aCurrent {
+		if previous.Add(serial) {
+			delta.added = append(delta.added, serial)
+		}
+	}
+	for _, serial := range aPrevious {
+		if current.Add(serial) {
+			delta.removed = append(delta.removed, serial)
+		}
+	}
+	sort.Sort(storage.SerialList(delta.added))
+	sort.Sort(storage.SerialList(delta.removed))
+	return delta
+}
+
+// Writes, for each enrolled issuer, how its revoked serials changed since the
+// file the previous run stored, as "+<hex>" and "-<hex>" lines. It must run
+// before the new serials are stored over the previous ones. Issuers that
+// aren't enrolled this run get no delta, as their previous files are kept.
+type deltaWriter struct {
+	previous storage.KnownCertificateListLoader
+	outPath  string
+}
+
+func (d *deltaWriter) store(ctx context.Context, aIssuer storage.Issuer, aSerials []storage.Serial) error {
+	previous, err := d.previous.LoadKnownCertificateList(ctx, aIssuer)
+	if os.IsNotExist(err) {
+		logging.Infof("[%s] No previous revoked serials, so all are new", aIssuer.ID())
+		previous = []storage.Serial{}
+	} else if err != nil {
+		return err
+	}
+
+	delta := diffSerials(previous, aSerials)
+	logging.Infof("[%s] %d revoked serials added and %d removed since the previous run", aIssuer.ID(),
+		len(delta.added), len(delta.removed))
+	return writeSerialDelta(filepath.Join(d.outPath, aIssuer.ID()), delta)
+}
+
+func writeSerialDelta(aPath string, aDelta serialDelta) error {
+	fd, err := os.Create(aPath)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(fd)
+	for _, group := range []struct {
+		prefix  string
+		serials []storage.Serial
+	}{{"+", aDelta.added}, {"-", aDelta.removed}} {
+		for _, serial := range group.serials {
+			if _, err = writer.WriteString(group.prefix + serial.HexString() + "\n"); err != nil {
+				fd.Close() // ignore error
+				return err
+			}
+		}
+	}
+	if err = writer.Flush(); err != nil {
+		fd.Close() // ignore error
+		return err
+	}
+
+	return fd.Close()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mozilla/crlite/go/storage"
+)
+
+func serialsFromHex(aHex ...string) []storage.Serial {
+	serials := []storage.Serial{}
+	for _, h := range aHex {
+		serials = append(serials, storage.NewSerialFromHex(h))
+	}
+	return serials
+}
+
+func Test_diffSerials(t *testing.T) {
+	delta := diffSerials(serialsFromHex("03", "01", "02"), serialsFromHex("04", "02", "03", "04", "05"))
+	if !reflect.DeepEqual(delta.added, serialsFromHex("04", "05")) {
+		t.Errorf("Expected 04 and 05 added, got %v", delta.added)
+	}
+	if !reflect.DeepEqual(delta.removed, serialsFromHex("01")) {
+		t.Errorf("Expected 01 removed, got %v", delta.removed)
+	}
+
+	delta = diffSerials(serialsFromHex("01"), serialsFromHex("01"))
+	if len(delta.added) != 0 || len(delta.removed) != 0 {
+		t.Errorf("Expected no changes, got %+v", delta)
+	}
+}
+
+func Test_deltaWriterBetweenRuns(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_deltaWriterBetweenRuns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	revokedPath := filepath.Join(tmpDir, "revoked")
+	deltaPath := filepath.Join(tmpDir, "delta")
+	if err = os.MkdirAll(deltaPath, permModeDir); err != nil {
+		t.Fatal(err)
+	}
+
+	backend := storage.NewLocalDiskBackend(permMode, revokedPath)
+	deltas := &deltaWriter{
+		previous: backend.(storage.KnownCertificateListLoader),
+		outPath:  deltaPath,
+	}
+	issuer := storage.NewIssuerFromString("issuerA")
+
+	readDelta := func() []string {
+		t.Helper()
+		data, err := ioutil.ReadFile(filepath.Join(deltaPath, issuer.ID()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+
+	// As aggregateCRLWorker does, the delta comes before the save
+	runOnce := func(aSerials []storage.Serial) {
+		t.Helper()
+		if err := deltas.store(context.TODO(), issuer, aSerials); err != nil {
+			t.Fatal(err)
+		}
+		if err := backend.StoreKnownCertificateList(context.TODO(), issuer, aSerials); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Everything is new on the first run
+	runOnce(serialsFromHex("0a", "0b"))
+	if lines := readDelta(); !reflect.DeepEqual(lines, []string{"+0a", "+0b"}) {
+		t.Errorf("Expected both serials added, got %v", lines)
+	}
+
+	runOnce(serialsFromHex("0b", "0d", "0c"))
+	if lines := readDelta(); !reflect.DeepEqual(lines, []string{"+0c", "+0d", "-0a"}) {
+		t.Errorf("Expected 0c and 0d added and 0a removed, got %v", lines)
+	}
+}
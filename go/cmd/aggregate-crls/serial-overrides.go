@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/mozilla/crlite/go/logging"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+// Serials to force into or out of issuers' revoked serials regardless of
+// their CRLs, for incident response, such as a revocation the CA hasn't yet
+// published. Both are by issuer ID. A nil *serialOverrides changes nothing.
+type serialOverrides struct {
+	revoked   map[string][]storage.Serial
+	unrevoked map[string]map[string]bool
+}
+
+// Loads a JSON object mapping issuer IDs to arrays of hex serials
+func loadSerialsByIssuer(aPath string) (map[string][]storage.Serial, error) {
+	data, err := ioutil.ReadFile(aPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries map[string][]string
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("Couldn't parse %s: %s", aPath, err)
+	}
+
+	result := make(map[string][]storage.Serial, len(entries))
+	for issuerID, hexSerials := range entries {
+		for _, hexSerial := range hexSerials {
+			serialBytes, err := hex.DecodeString(strings.TrimSpace(hexSerial))
+			if err != nil || len(serialBytes) == 0 {
+				return nil, fmt.Errorf("Serial for %s in %s isn't hex: %q", issuerID, aPath, hexSerial)
+			}
+			result[issuerID] = append(result[issuerID], storage.NewSerialFromDERBytes(serialBytes))
+		}
+	}
+	return result, nil
+}
+
+// Either path may be empty. It's an error to force a serial both ways.
+func loadSerialOverrides(aRevokedPath string, aUnrevokedPath string) (*serialOverrides, error) {
+	overrides := &serialOverrides{
+		revoked:   make(map[string][]storage.Serial),
+		unrevoked: make(map[string]map[string]bool),
+	}
+
+	var err error
+	if aRevokedPath != "" {
+		if overrides.revoked, err = loadSerialsByIssuer(aRevokedPath); err != nil {
+			return nil, err
+		}
+	}
+	if aUnrevokedPath != "" {
+		unrevoked, err := loadSerialsByIssuer(aUnrevokedPath)
+		if err != nil {
+			return nil, err
+		}
+		for issuerID, serials := range unrevoked {
+			overrides.unrevoked[issuerID] = make(map[string]bool, len(serials))
+			for _, serial := range serials {
+				overrides.unrevoked[issuerID][serial.BinaryString()] = true
+			}
+		}
+	}
+
+	for issuerID, serials := range overrides.revoked {
+		for _, serial := range serials {
+			if overrides.unrevoked[issuerID][serial.BinaryString()] {
+				return nil, fmt.Errorf("Serial %s of %s is forced both revoked and unrevoked",
+					serial.HexString(), issuerID)
+			}
+		}
+	}
+	return overrides, nil
+}
+
+func (o *serialOverrides) count() int {
+	count := 0
+	for _, serials := range o.revoked {
+		count += len(serials)
+	}
+	for _, serials := range o.unrevoked {
+		count += len(serials)
+	}
+	return count
+}
+
+// Returns the issuer's serials to save in place of aSerials, logging each
+// override applied
+func (o *serialOverrides) apply(aIssuerID string, aSerials []storage.Serial) []storage.Serial {
+	if o == nil {
+		return aSerials
+	}
+	unrevoked := o.unrevoked[aIssuerID]
+	revoked := o.revoked[aIssuerID]
+	if len(unrevoked) == 0 && len(revoked) == 0 {
+		return aSerials
+	}
+
+	result := make([]storage.Serial, 0, len(aSerials)+len(revoked))
+	present := make(map[string]bool, len(aSerials))
+	for _, serial := range aSerials {
+		if unrevoked[serial.BinaryString()] {
+			logging.Warningf("[%s] Override: dropping serial %s listed by its CRLs, as forced unrevoked",
+				aIssuerID, serial.HexString())
+			continue
+		}
+		present[serial.BinaryString()] = true
+		result = append(result, serial)
+	}
+
+	for _, serial := range revoked {
+		if present[serial.BinaryString()] {
+			logging.Infof("[%s] Override: serial %s forced revoked is already listed by its CRLs",
+				aIssuerID, serial.HexString())
+			continue
+		}
+		logging.Warningf("[%s] Override: adding serial %s not listed by its CRLs, as forced revoked",
+			aIssuerID, serial.HexString())
+		present[serial.BinaryString()] = true
+		result = append(result, serial)
+	}
+	return result
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency-go/x509/pkix"
+	"github.com/mozilla/crlite/go"
+	"github.com/mozilla/crlite/go/rootprogram"
+	"github.com/mozilla/crlite/go/storage"
+	"github.com/vbauerster/mpb/v5"
+)
+
+func Test_loadSerialOverrides(t *testing.T) {
+	revokedPath := writeCrlOverrides(t, `{"issuerA": ["01", "00FF"]}`)
+	defer os.Remove(revokedPath)
+	unrevokedPath := writeCrlOverrides(t, `{"issuerA": ["02"], "issuerB": ["03"]}`)
+	defer os.Remove(unrevokedPath)
+
+	overrides, err := loadSerialOverrides(revokedPath, unrevokedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if overrides.count() != 4 {
+		t.Errorf("Expected 4 overrides, got %d", overrides.count())
+	}
+
+	// 00FF, forced revoked, is already listed
+	result := overrides.apply("issuerA", serialsFromHex("02", "00FF"))
+	if expected := serialsFromHex("00FF", "01"); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected 02 dropped and 01 added, got %v", result)
+	}
+
+	// Other issuers are untouched
+	serials := serialsFromHex("02")
+	if result = overrides.apply("issuerC", serials); !reflect.DeepEqual(result, serials) {
+		t.Errorf("Expected no change, got %v", result)
+	}
+
+	var none *serialOverrides
+	if result = none.apply("issuerA", serials); !reflect.DeepEqual(result, serials) {
+		t.Errorf("Expected no change without overrides, got %v", result)
+	}
+}
+
+func Test_loadSerialOverridesInvalid(t *testing.T) {
+	revokedPath := writeCrlOverrides(t, `{"issuerA": ["01"]}`)
+	defer os.Remove(revokedPath)
+	// Serials are compared as CRLs give them, without redundant padding
+	bothPath := writeCrlOverrides(t, `{"issuerA": ["0001"]}`)
+	defer os.Remove(bothPath)
+	notHexPath := writeCrlOverrides(t, `{"issuerA": ["xyz"]}`)
+	defer os.Remove(notHexPath)
+
+	if _, err := loadSerialOverrides(revokedPath, bothPath); err == nil {
+		t.Error("Expected an error forcing a serial both ways")
+	}
+	if _, err := loadSerialOverrides(notHexPath, ""); err == nil {
+		t.Error("Expected an error for a serial that isn't hex")
+	}
+	if _, err := loadSerialOverrides("", filepath.Join(os.TempDir(), "nonexistent-overrides")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+func Test_aggregateCRLWorkerSerialOverrides(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_aggregateCRLWorkerSerialOverrides")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	ca, caPrivKey := makeCA(t)
+	crlPath := writeTempCRL(t, "overrides", makeCRLWithRevocations(t, ca, caPrivKey, time.Now(),
+		time.Now().AddDate(0, 0, 1), []pkix.RevokedCertificate{
+			{SerialNumber: big.NewInt(1), RevocationTime: time.Now()},
+			{SerialNumber: big.NewInt(2), RevocationTime: time.Now()},
+		}))
+	defer os.Remove(crlPath)
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+	storageDB, _ := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	issuersObj := rootprogram.NewMozillaIssuers()
+	issuer := issuersObj.InsertIssuerFromCertAndPem(ca, "")
+
+	overrides := &serialOverrides{
+		revoked:   map[string][]storage.Serial{issuer.ID(): {storage.NewSerialFromHex("03")}},
+		unrevoked: map[string]map[string]bool{issuer.ID(): {storage.NewSerialFromHex("01").BinaryString(): true}},
+	}
+
+	ae := AggregateEngine{
+		loadStorageDB:   storageDB,
+		saveStorage:     storage.NewLocalDiskBackend(permMode, tmpDir),
+		remoteCache:     storage.NewMockRemoteCache(),
+		issuers:         issuersObj,
+		display:         display,
+		auditor:         NewCrlAuditor(issuersObj),
+		serialOverrides: overrides,
+	}
+
+	crlUrl, _ := url.Parse("http://example.com/overrides.crl")
+	workChan := make(chan types.IssuerCrlUrlPaths, 1)
+	workChan <- types.IssuerCrlUrlPaths{
+		Issuer:      issuer,
+		CrlUrlPaths: []types.UrlPath{{Url: *crlUrl, Path: crlPath}},
+	}
+	close(workChan)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	ae.aggregateCRLWorker(context.TODO(), &wg, workChan, make(chan issuerError, 1), display.AddBar(1))
+
+	data, err := ioutil.ReadFile(filepath.Join(tmpDir, issuer.ID()))
+	if err != nil || string(data) != "02\n03\n" {
+		t.Errorf("Expected 01 removed and 03 added, got %q: %v", data, err)
+	}
+}
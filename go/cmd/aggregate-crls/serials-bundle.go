@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/mozilla/crlite/go/rootprogram"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+// Writes every enrolled issuer's serials, as just saved, into one bundle at
+// aPath, for tooling that keys issuers by their SPKI hash. Returns the number
+// of issuers written.
+func saveSerialsBundle(ctx context.Context, aPath string, aLoader storage.KnownCertificateListLoader,
+	aIssuers *rootprogram.MozIssuers) (int, error) {
+	fd, err := os.Create(aPath)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, issuer := range aIssuers.GetIssuers() {
+		if !aIssuers.IsIssuerEnrolled(issuer) {
+			continue
+		}
+		serials, err := aLoader.LoadKnownCertificateList(ctx, issuer)
+		if err != nil {
+			fd.Close() // ignore error
+			return 0, err
+		}
+		if err = storage.WriteSerialsBundle(fd, issuer.ID(), serials); err != nil {
+			fd.Close() // ignore error
+			return 0, err
+		}
+		count++
+	}
+
+	return count, fd.Close()
+}
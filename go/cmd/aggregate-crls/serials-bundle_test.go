@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mozilla/crlite/go/rootprogram"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+func Test_saveSerialsBundle(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_saveSerialsBundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	issuersObj := rootprogram.NewMozillaIssuers()
+	var issuers []storage.Issuer
+	for i := 0; i < 3; i++ {
+		ca, _ := makeCA(t)
+		issuers = append(issuers, issuersObj.InsertIssuerFromCertAndPem(ca, ""))
+	}
+	enrolled, empty, unenrolled := issuers[0], issuers[1], issuers[2]
+	issuersObj.Enroll(enrolled)
+	issuersObj.Enroll(empty)
+	issuersObj.MarkUnenrolled(unenrolled, rootprogram.ReasonNoCrls)
+
+	backend := storage.NewLocalDiskBackendWithSerialFormat(permMode, tmpDir, storage.SerialFormatSPKIBundle)
+	expected := map[string][]storage.Serial{
+		enrolled.ID(): serialsFromHex("01", "02"),
+		empty.ID():    {},
+	}
+	for _, issuer := range []storage.Issuer{enrolled, empty} {
+		if err = backend.StoreKnownCertificateList(context.TODO(), issuer, expected[issuer.ID()]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Left over from an earlier run
+	if err = backend.StoreKnownCertificateList(context.TODO(), unenrolled, serialsFromHex("03")); err != nil {
+		t.Fatal(err)
+	}
+
+	bundlePath := filepath.Join(tmpDir, storage.SerialsBundleName)
+	count, err := saveSerialsBundle(context.TODO(), bundlePath, backend.(storage.KnownCertificateListLoader),
+		issuersObj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 issuers, got %d", count)
+	}
+
+	fd, err := os.Open(bundlePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	bundle, err := storage.ReadSerialsBundle(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(bundle, expected) {
+		t.Errorf("Expected %v, got %v", expected, bundle)
+	}
+}
@@ -27,7 +27,7 @@ const (
 var (
 	enrolledpath = flag.String("enrolledpath", "<path>", "input enrolled issuers JSON")
 	knownpath    = flag.String("knownpath", "<dir>", "output directory for <issuer> files")
-	nobars       = flag.Bool("nobars", false, "disable display of download bars")
+	nobars       = flag.Bool("nobars", false, "disable display of progress bars, e.g. when logging under CI or cron")
 	ctconfig     = config.NewCTConfig()
 )
 
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/mozilla/crlite/go/storage"
+)
+
+// The filter file starts with this magic and a version byte, followed by a
+// uint32 count of issuers, then for each issuer, in ID order: a uint16 length
+// and the issuer ID, a uint8 hash count, a uint64 bit count, and the bits,
+// least significant first, padded to whole bytes. All integers are big-endian.
+const (
+	kFilterMagic = "CRLB"
+	// Version 2 keeps the double hashing step from being a multiple of the
+	// bit count; version 1 filters probed differently.
+	kFilterVersion = 2
+	// Guards against allocating for a corrupt bit count
+	kMaxFilterBits = 1 << 36
+)
+
+// A Bloom filter of one issuer's revoked serials
+type bloomFilter struct {
+	bits      []byte
+	bitCount  uint64
+	hashCount uint8
+}
+
+// Sizes a filter for aCount entries at false positive rate aRate, per
+// m = -n ln(p) / ln(2)^2 and k = (m/n) ln(2)
+func newBloomFilter(aCount int, aRate float64) *bloomFilter {
+	bitCount := uint64(8)
+	hashCount := uint8(1)
+	if aCount > 0 {
+		m := math.Ceil(-float64(aCount) * math.Log(aRate) / (math.Ln2 * math.Ln2))
+		if uint64(m) > bitCount {
+			bitCount = uint64(m)
+		}
+		k := math.Round(float64(bitCount) / float64(aCount) * math.Ln2)
+		if k > 1 {
+			hashCount = uint8(math.Min(k, math.MaxUint8))
+		}
+	}
+	return &bloomFilter{
+		bits:      make([]byte, (bitCount+7)/8),
+		bitCount:  bitCount,
+		hashCount: hashCount,
+	}
+}
+
+// Double hashing of SHA-256(issuer ID || serial), so that each serial sets
+// different bits in each issuer's filter
+func (f *bloomFilter) indexes(aIssuerID string, aSerial storage.Serial) []uint64 {
+	h := sha256.New()
+	_, _ = h.Write([]byte(aIssuerID))
+	_, _ = h.Write([]byte(aSerial.BinaryString()))
+	digest := h.Sum(nil)
+	return f.probes(binary.BigEndian.Uint64(digest[0:8]), binary.BigEndian.Uint64(digest[8:16]))
+}
+
+// The hash count indexes h1 + i*h2. The step is kept in [1, bitCount), as a
+// multiple of the bit count would probe the one bit every time.
+func (f *bloomFilter) probes(h1 uint64, h2 uint64) []uint64 {
+	if f.bitCount > 1 {
+		h2 = h2%(f.bitCount-1) + 1
+	}
+
+	indexes := make([]uint64, f.hashCount)
+	for i := range indexes {
+		indexes[i] = (h1 + uint64(i)*h2) % f.bitCount
+	}
+	return indexes
+}
+
+func (f *bloomFilter) add(aIssuerID string, aSerial storage.Serial) {
+	for _, index := range f.indexes(aIssuerID, aSerial) {
+		f.bits[index/8] |= 1 << (index % 8)
+	}
+}
+
+func (f *bloomFilter) contains(aIssuerID string, aSerial storage.Serial) bool {
+	for _, index := range f.indexes(aIssuerID, aSerial) {
+		if f.bits[index/8]&(1<<(index%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// The expected false positive rate holding aCount entries,
+// (1 - e^(-kn/m))^k
+func (f *bloomFilter) falsePositiveRate(aCount int) float64 {
+	k := float64(f.hashCount)
+	return math.Pow(1-math.Exp(-k*float64(aCount)/float64(f.bitCount)), k)
+}
+
+// Each issuer's filter, by issuer ID
+type issuerFilters map[string]*bloomFilter
+
+// Whether aSerial may be revoked by aIssuer. False positives occur at about
+// the filter's rate; false negatives never do.
+func (fs issuerFilters) mayBeRevoked(aIssuer storage.Issuer, aSerial storage.Serial) bool {
+	filter, ok := fs[aIssuer.ID()]
+	return ok && filter.contains(aIssuer.ID(), aSerial)
+}
+
+func (fs issuerFilters) write(w io.Writer) error {
+	ids := make([]string, 0, len(fs))
+	for id := range fs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(kFilterMagic); err != nil {
+		return err
+	}
+	header := []interface{}{uint8(kFilterVersion), uint32(len(ids))}
+	for _, field := range header {
+		if err := binary.Write(bw, binary.BigEndian, field); err != nil {
+			return err
+		}
+	}
+	for _, id := range ids {
+		filter := fs[id]
+		if len(id) > math.MaxUint16 {
+			return fmt.Errorf("Issuer ID too long: %s", id)
+		}
+		fields := []interface{}{uint16(len(id)), []byte(id), filter.hashCount, filter.bitCount, filter.bits}
+		for _, field := range fields {
+			if err := binary.Write(bw, binary.BigEndian, field); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+func readIssuerFilters(r io.Reader) (issuerFilters, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(kFilterMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != kFilterMagic {
+		return nil, fmt.Errorf("Not a filter file")
+	}
+	var version uint8
+	var count uint32
+	if err := binary.Read(br, binary.BigEndian, &version); err != nil {
+		return nil, err
+	}
+	if version != kFilterVersion {
+		return nil, fmt.Errorf("Unsupported filter version %d", version)
+	}
+	if err := binary.Read(br, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+
+	fs := make(issuerFilters)
+	for i := uint32(0); i < count; i++ {
+		var idLen uint16
+		if err := binary.Read(br, binary.BigEndian, &idLen); err != nil {
+			return nil, err
+		}
+		id := make([]byte, idLen)
+		if _, err := io.ReadFull(br, id); err != nil {
+			return nil, err
+		}
+		filter := &bloomFilter{}
+		if err := binary.Read(br, binary.BigEndian, &filter.hashCount); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(br, binary.BigEndian, &filter.bitCount); err != nil {
+			return nil, err
+		}
+		if filter.bitCount == 0 || filter.hashCount == 0 || filter.bitCount > kMaxFilterBits {
+			return nil, fmt.Errorf("Invalid filter for %s: %d bits, %d hashes", id, filter.bitCount, filter.hashCount)
+		}
+		filter.bits = make([]byte, (filter.bitCount+7)/8)
+		if _, err := io.ReadFull(br, filter.bits); err != nil {
+			return nil, err
+		}
+		fs[string(id)] = filter
+	}
+	return fs, nil
+}
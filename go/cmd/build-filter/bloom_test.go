@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/mozilla/crlite/go/storage"
+)
+
+func serialFromInt(aValue uint64) storage.Serial {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, aValue)
+	return storage.NewSerialFromDERBytes(b)
+}
+
+func Test_bloomFilter(t *testing.T) {
+	const count = 10000
+	const rate = 0.01
+	filter := newBloomFilter(count, rate)
+	for i := uint64(0); i < count; i++ {
+		filter.add("issuer", serialFromInt(i))
+	}
+
+	for i := uint64(0); i < count; i++ {
+		if !filter.contains("issuer", serialFromInt(i)) {
+			t.Fatalf("False negative for %d", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := uint64(count); i < 2*count; i++ {
+		if filter.contains("issuer", serialFromInt(i)) {
+			falsePositives++
+		}
+	}
+	// Allow for chance well beyond the expected 100
+	if falsePositives > 2*count*rate {
+		t.Errorf("Expected a false positive rate near %g, got %d in %d", rate, falsePositives, count)
+	}
+	if expected := filter.falsePositiveRate(count); expected > 1.1*rate {
+		t.Errorf("Expected the filter sized for %g, but it's expected to give %g", rate, expected)
+	}
+
+	// The issuer is part of each serial's hash
+	otherIssuerHits := 0
+	for i := uint64(0); i < count; i++ {
+		if filter.contains("otherIssuer", serialFromInt(i)) {
+			otherIssuerHits++
+		}
+	}
+	if otherIssuerHits > 2*count*rate {
+		t.Errorf("Expected few hits for another issuer, got %d", otherIssuerHits)
+	}
+}
+
+func Test_bloomFilterEmpty(t *testing.T) {
+	filter := newBloomFilter(0, 0.01)
+	if filter.contains("issuer", serialFromInt(1)) {
+		t.Error("Expected an empty filter to contain nothing")
+	}
+}
+
+func Test_bloomFilterZeroStep(t *testing.T) {
+	filter := newBloomFilter(100, 0.01)
+	if filter.hashCount < 2 {
+		t.Fatalf("Expected several hashes, got %d", filter.hashCount)
+	}
+
+	for _, h2 := range []uint64{0, filter.bitCount, 3 * filter.bitCount} {
+		seen := make(map[uint64]bool)
+		for _, index := range filter.probes(12345, h2) {
+			if index >= filter.bitCount {
+				t.Fatalf("Index %d out of range for %d bits", index, filter.bitCount)
+			}
+			seen[index] = true
+		}
+		if len(seen) != int(filter.hashCount) {
+			t.Errorf("Expected %d distinct bits for step %d, got %d", filter.hashCount, h2, len(seen))
+		}
+	}
+}
+
+func Test_issuerFiltersRoundTrip(t *testing.T) {
+	issuer := storage.NewIssuerFromString("issuerA")
+	filters := issuerFilters{
+		"issuerA": newBloomFilter(2, 0.01),
+		"issuerB": newBloomFilter(0, 0.01),
+	}
+	filters["issuerA"].add("issuerA", serialFromInt(1))
+	filters["issuerA"].add("issuerA", serialFromInt(2))
+
+	var buf bytes.Buffer
+	if err := filters.write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readIssuerFilters(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 2 {
+		t.Fatalf("Expected 2 filters, got %d", len(read))
+	}
+	if !read.mayBeRevoked(issuer, serialFromInt(1)) || !read.mayBeRevoked(issuer, serialFromInt(2)) {
+		t.Error("Expected the added serials to be found")
+	}
+	if read.mayBeRevoked(storage.NewIssuerFromString("unknown"), serialFromInt(1)) {
+		t.Error("Expected nothing for an issuer without a filter")
+	}
+
+	if _, err = readIssuerFilters(bytes.NewReader(buf.Bytes()[:buf.Len()-1])); err == nil {
+		t.Error("Expected an error for a truncated filter")
+	}
+	if _, err = readIssuerFilters(bytes.NewReader([]byte("nope"))); err == nil {
+		t.Error("Expected an error for a file without the magic")
+	}
+}
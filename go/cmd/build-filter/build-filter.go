@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+var (
+	revokedpath  = flag.String("revokedpath", "<path>", "input folder of revoked serial files of the form <issuer>, as written by aggregate-crls with -output-backend=disk, with or without -compress-output")
+	serialformat = flag.String("serialformat", "default", "format of the revoked serial files: default (hex lines), binary, or spki-bundle")
+	filterout    = flag.String("filterout", "<path>", "output filter file of a Bloom filter of each issuer's revoked serials")
+	metaout      = flag.String("metaout", "<path>", "output JSON file of the filter's issuer and serial counts and false positive rate")
+	fprate       = flag.Float64("fprate", 0.001, "target false positive rate of each issuer's filter, between 0 and 1 exclusive")
+)
+
+type filterMetadata struct {
+	IssuerCount int   `json:"issuerCount"`
+	SerialCount int   `json:"serialCount"`
+	FilterBytes int64 `json:"filterBytes"`
+	// The rate requested, and the highest any issuer's filter is expected to
+	// have, which can be a little above it as filters have whole bits and
+	// hashes
+	TargetFalsePositiveRate  float64 `json:"targetFalsePositiveRate"`
+	MaximumFalsePositiveRate float64 `json:"maximumFalsePositiveRate"`
+}
+
+// The issuer IDs with serial files in aDir. Folders, such as those of
+// -expirybuckets or -generational-output, aren't read, nor is the combined
+// file of -serialformat spki-bundle. Files of -compress-output are listed by
+// their issuer.
+func listIssuers(aDir string) ([]storage.Issuer, error) {
+	entries, err := ioutil.ReadDir(aDir)
+	if err != nil {
+		return nil, err
+	}
+	issuers := []storage.Issuer{}
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() && entry.Name() != storage.SerialsBundleName {
+			id := strings.TrimSuffix(entry.Name(), storage.CompressedSuffix)
+			issuers = append(issuers, storage.NewIssuerFromString(id))
+		}
+	}
+	return issuers, nil
+}
+
+func buildFilters(ctx context.Context, aLoader storage.KnownCertificateListLoader, aIssuers []storage.Issuer,
+	aRate float64) (issuerFilters, filterMetadata, error) {
+	filters := make(issuerFilters, len(aIssuers))
+	meta := filterMetadata{
+		IssuerCount:             len(aIssuers),
+		TargetFalsePositiveRate: aRate,
+	}
+
+	for _, issuer := range aIssuers {
+		serials, err := aLoader.LoadKnownCertificateList(ctx, issuer)
+		if err != nil {
+			return nil, meta, fmt.Errorf("Couldn't load serials of %s: %s", issuer.ID(), err)
+		}
+
+		filter := newBloomFilter(len(serials), aRate)
+		for _, serial := range serials {
+			filter.add(issuer.ID(), serial)
+		}
+		filters[issuer.ID()] = filter
+
+		meta.SerialCount += len(serials)
+		if rate := filter.falsePositiveRate(len(serials)); rate > meta.MaximumFalsePositiveRate {
+			meta.MaximumFalsePositiveRate = rate
+		}
+		glog.V(1).Infof("[%s] %d serials in %d bytes with %d hashes", issuer.ID(), len(serials),
+			len(filter.bits), filter.hashCount)
+	}
+	return filters, meta, nil
+}
+
+func saveFilters(aPath string, aFilters issuerFilters) (int64, error) {
+	fd, err := os.Create(aPath)
+	if err != nil {
+		return 0, err
+	}
+	if err = aFilters.write(fd); err != nil {
+		fd.Close() // ignore error
+		return 0, err
+	}
+	stat, err := fd.Stat()
+	if err != nil {
+		fd.Close() // ignore error
+		return 0, err
+	}
+	return stat.Size(), fd.Close()
+}
+
+func saveMetadata(aPath string, aMeta filterMetadata) error {
+	fd, err := os.Create(aPath)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(fd)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(aMeta); err != nil {
+		fd.Close() // ignore error
+		return err
+	}
+
+	return fd.Close()
+}
+
+func main() {
+	flag.Parse()
+	defer glog.Flush()
+
+	if *revokedpath == "<path>" || *filterout == "<path>" {
+		glog.Errorf("Flags revokedpath and filterout must both be set")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *fprate <= 0 || *fprate >= 1 {
+		glog.Errorf("Flag fprate is invalid: %f is not between 0 and 1 exclusive", *fprate)
+		flag.Usage()
+		os.Exit(2)
+	}
+	format, err := storage.ParseSerialFormat(*serialformat)
+	if err != nil {
+		glog.Errorf("Flag serialformat is invalid: %s", err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	issuers, err := listIssuers(*revokedpath)
+	if err != nil {
+		glog.Fatalf("Unable to list the revoked serial files: %s", err)
+	}
+	backend := storage.NewLocalDiskBackendWithSerialFormat(0644, *revokedpath, format)
+	filters, meta, err := buildFilters(context.Background(), backend.(storage.KnownCertificateListLoader),
+		issuers, *fprate)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	if meta.FilterBytes, err = saveFilters(*filterout, filters); err != nil {
+		glog.Fatalf("Unable to save the filter: %s", err)
+	}
+	glog.Infof("Built filters of %d serials from %d issuers, %d bytes, with a false positive rate of at most %g",
+		meta.SerialCount, meta.IssuerCount, meta.FilterBytes, meta.MaximumFalsePositiveRate)
+
+	if *metaout != "<path>" {
+		if err = saveMetadata(*metaout, meta); err != nil {
+			glog.Fatalf("Unable to save the filter metadata: %s", err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mozilla/crlite/go/storage"
+)
+
+func Test_buildFiltersFromRevokedPath(t *testing.T) {
+	for _, format := range []storage.SerialFormat{storage.SerialFormatDefault, storage.SerialFormatBinary,
+		storage.SerialFormatSPKIBundle} {
+		tmpDir, err := ioutil.TempDir("", "Test_buildFiltersFromRevokedPath")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tmpDir)
+		revokedDir := filepath.Join(tmpDir, "revoked")
+		if err = os.Mkdir(revokedDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		// Expiry bucket folders aren't read
+		if err = os.Mkdir(filepath.Join(revokedDir, "2030-01"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		backend := storage.NewLocalDiskBackendWithSerialFormat(0644, revokedDir, format)
+		issuerA := storage.NewIssuerFromString("issuerA")
+		issuerB := storage.NewIssuerFromString("issuerB")
+		serialsA := []storage.Serial{serialFromInt(1), serialFromInt(2), serialFromInt(3)}
+		if err = backend.StoreKnownCertificateList(context.TODO(), issuerA, serialsA); err != nil {
+			t.Fatal(err)
+		}
+		// Compressed files are listed by their issuer
+		compressed := storage.NewLocalDiskBackendWithCompression(0644, revokedDir, format, true)
+		if err = compressed.StoreKnownCertificateList(context.TODO(), issuerB, []storage.Serial{}); err != nil {
+			t.Fatal(err)
+		}
+		// Nor is the combined spki-bundle file
+		if err = ioutil.WriteFile(filepath.Join(revokedDir, storage.SerialsBundleName), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		issuers, err := listIssuers(revokedDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(issuers) != 2 || issuers[0].ID() != issuerA.ID() || issuers[1].ID() != issuerB.ID() {
+			t.Fatalf("%s: Expected issuers A and B, got %v", format, issuers)
+		}
+
+		filters, meta, err := buildFilters(context.TODO(), backend.(storage.KnownCertificateListLoader), issuers, 0.01)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.IssuerCount != 2 || meta.SerialCount != 3 || meta.TargetFalsePositiveRate != 0.01 ||
+			meta.MaximumFalsePositiveRate <= 0 || meta.MaximumFalsePositiveRate > 0.02 {
+			t.Errorf("%s: Unexpected metadata %+v", format, meta)
+		}
+
+		filterPath := filepath.Join(tmpDir, "filter")
+		if meta.FilterBytes, err = saveFilters(filterPath, filters); err != nil {
+			t.Fatal(err)
+		}
+		metaPath := filepath.Join(tmpDir, "filter.json")
+		if err = saveMetadata(metaPath, meta); err != nil {
+			t.Fatal(err)
+		}
+
+		fd, err := os.Open(filterPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, err := readIssuerFilters(fd)
+		fd.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, serial := range serialsA {
+			if !read.mayBeRevoked(issuerA, serial) {
+				t.Errorf("%s: Expected %s to be in the filter", format, serial.HexString())
+			}
+		}
+		if read.mayBeRevoked(issuerB, serialFromInt(1)) {
+			t.Errorf("%s: Expected nothing in the empty issuer's filter", format)
+		}
+
+		data, err := ioutil.ReadFile(metaPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var savedMeta filterMetadata
+		if err = json.Unmarshal(data, &savedMeta); err != nil {
+			t.Fatal(err)
+		}
+		stat, err := os.Stat(filterPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if savedMeta != meta || savedMeta.FilterBytes != stat.Size() {
+			t.Errorf("%s: Expected metadata %+v of a %d byte filter, got %+v", format, meta, stat.Size(), savedMeta)
+		}
+	}
+}
+
+func Test_buildFiltersMissingIssuer(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_buildFiltersMissingIssuer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	backend := storage.NewLocalDiskBackend(0644, tmpDir)
+	_, _, err = buildFilters(context.TODO(), backend.(storage.KnownCertificateListLoader),
+		[]storage.Issuer{storage.NewIssuerFromString("missing")}, 0.01)
+	if err == nil {
+		t.Error("Expected an error for an issuer without serials")
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/mozilla/crlite/go/rootprogram"
+)
+
+var (
+	outputdir    = flag.String("outputdir", "<path>", "input folder written by an aggregate-crls run, holding its enrolledpath and auditpath files")
+	maxage       = flag.Duration("maxage", 26*time.Hour, "how long ago the newest output may have been written before it's stale")
+	enrolledname = flag.String("enrolledname", "enrolled.json", "name in outputdir of the aggregate-crls enrolledpath file")
+	statsname    = flag.String("statsname", "crl-audit.json", "name in outputdir of the aggregate-crls auditpath file, with the run's CRL statistics")
+)
+
+// Exit codes, so monitoring can tell a stale output from a broken check
+const (
+	exitStale = 1
+	exitUsage = 2
+)
+
+// What was found of an aggregate-crls run's outputs
+type freshness struct {
+	// The most recently written output, and when
+	Newest   string
+	Modified time.Time
+	Age      time.Duration
+	// How many issuers the enrolled file lists, and are enrolled
+	Issuers  int
+	Enrolled int
+}
+
+func (f *freshness) isStale(aMaxAge time.Duration) bool {
+	return f.Age > aMaxAge
+}
+
+// Loads the enrolled issuers file, which must be a complete, non-empty list
+func readEnrolled(aPath string) ([]rootprogram.EnrolledIssuer, error) {
+	data, err := ioutil.ReadFile(aPath)
+	if err != nil {
+		return nil, err
+	}
+	var issuers []rootprogram.EnrolledIssuer
+	if err := json.Unmarshal(data, &issuers); err != nil {
+		return nil, fmt.Errorf("%s isn't a list of enrolled issuers: %s", aPath, err)
+	}
+	if len(issuers) == 0 {
+		return nil, fmt.Errorf("%s lists no issuers", aPath)
+	}
+	return issuers, nil
+}
+
+// Checks the stats file is complete JSON
+func readStats(aPath string) error {
+	data, err := ioutil.ReadFile(aPath)
+	if err != nil {
+		return err
+	}
+	var stats map[string]interface{}
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return fmt.Errorf("%s isn't a JSON object: %s", aPath, err)
+	}
+	return nil
+}
+
+// Reads the outputs in aDir, returning an error if either is missing or
+// unreadable, as a run that wrote them would have left them whole
+func checkFreshness(aDir string, aEnrolledName string, aStatsName string, aNow time.Time) (*freshness, error) {
+	enrolledPath := filepath.Join(aDir, aEnrolledName)
+	statsPath := filepath.Join(aDir, aStatsName)
+
+	issuers, err := readEnrolled(enrolledPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := readStats(statsPath); err != nil {
+		return nil, err
+	}
+
+	result := &freshness{
+		Issuers: len(issuers),
+	}
+	for _, ei := range issuers {
+		if ei.Enrolled {
+			result.Enrolled++
+		}
+	}
+
+	for _, path := range []string{enrolledPath, statsPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.ModTime().After(result.Modified) {
+			result.Newest = path
+			result.Modified = info.ModTime()
+		}
+	}
+	result.Age = aNow.Sub(result.Modified)
+	return result, nil
+}
+
+func main() {
+	flag.Parse()
+	defer glog.Flush()
+
+	if *outputdir == "<path>" {
+		glog.Errorf("Flag outputdir must be set")
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	if *maxage <= 0 {
+		glog.Errorf("Flag maxage must be positive")
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+
+	result, err := checkFreshness(*outputdir, *enrolledname, *statsname, time.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "CRITICAL: %s\n", err)
+		glog.Flush()
+		os.Exit(exitStale)
+	}
+
+	age := result.Age.Truncate(time.Second)
+	if result.isStale(*maxage) {
+		fmt.Fprintf(os.Stderr, "CRITICAL: newest output %s was written %s ago, at %s, which is more than maxage %s\n",
+			result.Newest, age, result.Modified.UTC().Format(time.RFC3339), *maxage)
+		glog.Flush()
+		os.Exit(exitStale)
+	}
+	fmt.Printf("OK: newest output %s was written %s ago; %d of %d issuers enrolled\n",
+		result.Newest, age, result.Enrolled, result.Issuers)
+}
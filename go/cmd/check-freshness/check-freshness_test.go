@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mozilla/crlite/go/rootprogram"
+)
+
+// Writes the outputs of an aggregate-crls run to aDir, last modified at the
+// given times
+func writeOutputs(t *testing.T, aDir string, aEnrolledTime time.Time, aStatsTime time.Time) {
+	t.Helper()
+	enrolled, err := json.Marshal([]rootprogram.EnrolledIssuer{
+		{PubKeyHash: "enrolled", Enrolled: true, Reason: rootprogram.ReasonEnrolled},
+		{PubKeyHash: "notEnrolled", Enrolled: false, Reason: rootprogram.ReasonNoCrls},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range map[string][]byte{
+		"enrolled.json":  enrolled,
+		"crl-audit.json": []byte(`{"Entries":[]}`),
+	} {
+		if err := ioutil.WriteFile(filepath.Join(aDir, name), contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chtimes(filepath.Join(aDir, "enrolled.json"), aEnrolledTime, aEnrolledTime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(aDir, "crl-audit.json"), aStatsTime, aStatsTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_checkFreshness(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_checkFreshness")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	now := time.Date(2020, time.June, 2, 12, 0, 0, 0, time.UTC)
+
+	// Fresh, with the stats file written last
+	writeOutputs(t, tmpDir, now.Add(-3*time.Hour), now.Add(-2*time.Hour))
+	result, err := checkFreshness(tmpDir, "enrolled.json", "crl-audit.json", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Newest != filepath.Join(tmpDir, "crl-audit.json") || result.Age != 2*time.Hour {
+		t.Errorf("Expected crl-audit.json to be newest, 2h old, got %s, %s", result.Newest, result.Age)
+	}
+	if result.isStale(26 * time.Hour) {
+		t.Error("Expected a 2h old output to be fresh")
+	}
+	if result.Issuers != 2 || result.Enrolled != 1 {
+		t.Errorf("Expected 1 of 2 issuers enrolled, got %d of %d", result.Enrolled, result.Issuers)
+	}
+
+	// Stale
+	writeOutputs(t, tmpDir, now.Add(-30*time.Hour), now.Add(-48*time.Hour))
+	result, err = checkFreshness(tmpDir, "enrolled.json", "crl-audit.json", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Newest != filepath.Join(tmpDir, "enrolled.json") || result.Age != 30*time.Hour {
+		t.Errorf("Expected enrolled.json to be newest, 30h old, got %s, %s", result.Newest, result.Age)
+	}
+	if !result.isStale(26 * time.Hour) {
+		t.Error("Expected a 30h old output to be stale")
+	}
+	if result.isStale(31 * time.Hour) {
+		t.Error("Expected a 30h old output to be fresh with a 31h maximum age")
+	}
+}
+
+func Test_checkFreshnessBrokenOutputs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_checkFreshnessBrokenOutputs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	now := time.Now()
+	if _, err := checkFreshness(tmpDir, "enrolled.json", "crl-audit.json", now); err == nil {
+		t.Error("Expected missing outputs to be an error")
+	}
+
+	writeOutputs(t, tmpDir, now, now)
+	if _, err := checkFreshness(tmpDir, "enrolled.json", "missing.json", now); err == nil {
+		t.Error("Expected a missing stats file to be an error")
+	}
+
+	for name, contents := range map[string]string{
+		"enrolled.json":  `[{"pubKeyHash":"trunc`,
+		"crl-audit.json": `{"Entries":[`,
+	} {
+		writeOutputs(t, tmpDir, now, now)
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := checkFreshness(tmpDir, "enrolled.json", "crl-audit.json", now)
+		if err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("Expected a truncated %s to be an error naming it, got %v", name, err)
+		}
+	}
+
+	writeOutputs(t, tmpDir, now, now)
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "enrolled.json"), []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checkFreshness(tmpDir, "enrolled.json", "crl-audit.json", now); err == nil {
+		t.Error("Expected an empty enrolled list to be an error")
+	}
+}
@@ -38,7 +38,7 @@ import (
 
 var (
 	ctconfig = config.NewCTConfig()
-	nobars   = flag.Bool("nobars", false, "disable display of download bars")
+	nobars   = flag.Bool("nobars", false, "disable display of progress bars, e.g. when logging under CI or cron")
 )
 
 func certIsFilteredOut(aCert *x509.Certificate) bool {
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/golang/glog"
+	"github.com/mozilla/crlite/go/rootprogram"
+)
+
+var (
+	beforefile = flag.String("before", "<path>", "input issuers JSON from before the change")
+	afterfile  = flag.String("after", "<path>", "input issuers JSON from after the change")
+	jsonout    = flag.Bool("json", false, "print the differences as JSON")
+)
+
+// The issuers in one file, keyed by ID. Lists written by GetIssuers hold only
+// IDs, so enrollment can't be compared against them.
+type issuerSet struct {
+	issuers       map[string]rootprogram.EnrolledIssuer
+	hasEnrollment bool
+}
+
+type issuerSummary struct {
+	ID       string                       `json:"id"`
+	Subject  string                       `json:"subject,omitempty"`
+	Enrolled bool                         `json:"enrolled"`
+	Reason   rootprogram.EnrollmentReason `json:"reason,omitempty"`
+}
+
+type issuerFlip struct {
+	ID          string                       `json:"id"`
+	Subject     string                       `json:"subject,omitempty"`
+	WasEnrolled bool                         `json:"wasEnrolled"`
+	Enrolled    bool                         `json:"enrolled"`
+	WasReason   rootprogram.EnrollmentReason `json:"wasReason,omitempty"`
+	Reason      rootprogram.EnrollmentReason `json:"reason,omitempty"`
+}
+
+type issuerDiff struct {
+	Added     []issuerSummary `json:"added"`
+	Removed   []issuerSummary `json:"removed"`
+	Flipped   []issuerFlip    `json:"flipped"`
+	Unchanged []issuerSummary `json:"unchanged"`
+}
+
+func parseIssuerSet(aData []byte) (*issuerSet, error) {
+	set := &issuerSet{
+		issuers: make(map[string]rootprogram.EnrolledIssuer),
+	}
+
+	var enrolledList []rootprogram.EnrolledIssuer
+	if err := json.Unmarshal(aData, &enrolledList); err == nil {
+		set.hasEnrollment = true
+		for _, ei := range enrolledList {
+			// SaveIssuersList writes one entry per certificate, so an
+			// issuer can repeat
+			if _, exists := set.issuers[ei.PubKeyHash]; !exists {
+				set.issuers[ei.PubKeyHash] = ei
+			}
+		}
+		return set, nil
+	}
+
+	var idList []string
+	if err := json.Unmarshal(aData, &idList); err != nil {
+		return nil, fmt.Errorf("Not a list of issuers: %s", err)
+	}
+	for _, id := range idList {
+		set.issuers[id] = rootprogram.EnrolledIssuer{PubKeyHash: id}
+	}
+	return set, nil
+}
+
+func loadIssuerSet(aPath string) (*issuerSet, error) {
+	data, err := ioutil.ReadFile(aPath)
+	if err != nil {
+		return nil, err
+	}
+	set, err := parseIssuerSet(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", aPath, err)
+	}
+	return set, nil
+}
+
+func summarize(aIssuer rootprogram.EnrolledIssuer) issuerSummary {
+	return issuerSummary{
+		ID:       aIssuer.PubKeyHash,
+		Subject:  aIssuer.Subject,
+		Enrolled: aIssuer.Enrolled,
+		Reason:   aIssuer.Reason,
+	}
+}
+
+func sortedIDs(aIssuers map[string]rootprogram.EnrolledIssuer) []string {
+	ids := make([]string, 0, len(aIssuers))
+	for id := range aIssuers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func diffIssuers(aBefore *issuerSet, aAfter *issuerSet) issuerDiff {
+	diff := issuerDiff{
+		Added:     []issuerSummary{},
+		Removed:   []issuerSummary{},
+		Flipped:   []issuerFlip{},
+		Unchanged: []issuerSummary{},
+	}
+	compareEnrollment := aBefore.hasEnrollment && aAfter.hasEnrollment
+
+	for _, id := range sortedIDs(aBefore.issuers) {
+		if _, ok := aAfter.issuers[id]; !ok {
+			diff.Removed = append(diff.Removed, summarize(aBefore.issuers[id]))
+		}
+	}
+
+	for _, id := range sortedIDs(aAfter.issuers) {
+		after := aAfter.issuers[id]
+		before, ok := aBefore.issuers[id]
+		if !ok {
+			diff.Added = append(diff.Added, summarize(after))
+			continue
+		}
+
+		if compareEnrollment && before.Enrolled != after.Enrolled {
+			diff.Flipped = append(diff.Flipped, issuerFlip{
+				ID:          id,
+				Subject:     after.Subject,
+				WasEnrolled: before.Enrolled,
+				Enrolled:    after.Enrolled,
+				WasReason:   before.Reason,
+				Reason:      after.Reason,
+			})
+			continue
+		}
+
+		diff.Unchanged = append(diff.Unchanged, summarize(after))
+	}
+
+	return diff
+}
+
+func enrolledString(aEnrolled bool) string {
+	if aEnrolled {
+		return "enrolled"
+	}
+	return "not enrolled"
+}
+
+func (d issuerDiff) writeText(w io.Writer) error {
+	var err error
+	printf := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+
+	printf("Added (%d):\n", len(d.Added))
+	for _, s := range d.Added {
+		printf("  + %s %s (%s)\n", s.ID, s.Subject, enrolledString(s.Enrolled))
+	}
+	printf("Removed (%d):\n", len(d.Removed))
+	for _, s := range d.Removed {
+		printf("  - %s %s (%s)\n", s.ID, s.Subject, enrolledString(s.Enrolled))
+	}
+	printf("Enrollment changed (%d):\n", len(d.Flipped))
+	for _, f := range d.Flipped {
+		printf("  ~ %s %s: %s -> %s (%s)\n", f.ID, f.Subject, enrolledString(f.WasEnrolled),
+			enrolledString(f.Enrolled), f.Reason)
+	}
+	printf("Unchanged (%d):\n", len(d.Unchanged))
+	for _, s := range d.Unchanged {
+		printf("    %s %s\n", s.ID, s.Subject)
+	}
+	return err
+}
+
+func main() {
+	flag.Parse()
+	defer glog.Flush()
+
+	if *beforefile == "<path>" || *afterfile == "<path>" {
+		glog.Errorf("Flags before and after must both be set")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	before, err := loadIssuerSet(*beforefile)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	after, err := loadIssuerSet(*afterfile)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if !before.hasEnrollment || !after.hasEnrollment {
+		glog.Warningf("At least one input lists only issuer IDs, so enrollment changes are not reported")
+	}
+
+	diff := diffIssuers(before, after)
+
+	if *jsonout {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", " ")
+		err = enc.Encode(diff)
+	} else {
+		err = diff.writeText(os.Stdout)
+	}
+	if err != nil {
+		glog.Fatal(err)
+	}
+}
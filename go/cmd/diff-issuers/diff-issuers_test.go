@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mozilla/crlite/go/rootprogram"
+)
+
+func writeIssuersFile(t *testing.T, aDir string, aName string, aContents interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(aContents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(aDir, aName)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func summaryIDs(aList []issuerSummary) []string {
+	ids := []string{}
+	for _, s := range aList {
+		ids = append(ids, s.ID)
+	}
+	return ids
+}
+
+func Test_diffIssuers(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_diffIssuers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	beforePath := writeIssuersFile(t, tmpDir, "before.json", []rootprogram.EnrolledIssuer{
+		{PubKeyHash: "removed", Subject: "CN=Removed", Enrolled: true, Reason: rootprogram.ReasonEnrolled},
+		{PubKeyHash: "gained", Subject: "CN=Gained", Enrolled: false, Reason: rootprogram.ReasonNoCrls},
+		{PubKeyHash: "lost", Subject: "CN=Lost", Enrolled: true, Reason: rootprogram.ReasonEnrolled},
+		{PubKeyHash: "same", Subject: "CN=Same", Enrolled: true, Reason: rootprogram.ReasonEnrolled},
+		// A second certificate for the same issuer
+		{PubKeyHash: "same", Subject: "CN=Same", Enrolled: true, Reason: rootprogram.ReasonEnrolled},
+	})
+	afterPath := writeIssuersFile(t, tmpDir, "after.json", []rootprogram.EnrolledIssuer{
+		{PubKeyHash: "added", Subject: "CN=Added", Enrolled: true, Reason: rootprogram.ReasonEnrolled},
+		{PubKeyHash: "gained", Subject: "CN=Gained", Enrolled: true, Reason: rootprogram.ReasonEnrolled},
+		{PubKeyHash: "lost", Subject: "CN=Lost", Enrolled: false, Reason: rootprogram.ReasonSomeCrlsFailed},
+		{PubKeyHash: "same", Subject: "CN=Same", Enrolled: true, Reason: rootprogram.ReasonEnrolled},
+	})
+
+	before, err := loadIssuerSet(beforePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := loadIssuerSet(afterPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff := diffIssuers(before, after)
+
+	if !reflect.DeepEqual(summaryIDs(diff.Added), []string{"added"}) {
+		t.Errorf("Unexpected added issuers: %+v", diff.Added)
+	}
+	if !reflect.DeepEqual(summaryIDs(diff.Removed), []string{"removed"}) {
+		t.Errorf("Unexpected removed issuers: %+v", diff.Removed)
+	}
+	if !reflect.DeepEqual(summaryIDs(diff.Unchanged), []string{"same"}) {
+		t.Errorf("Unexpected unchanged issuers: %+v", diff.Unchanged)
+	}
+
+	expectedFlips := []issuerFlip{
+		{ID: "gained", Subject: "CN=Gained", WasEnrolled: false, Enrolled: true,
+			WasReason: rootprogram.ReasonNoCrls, Reason: rootprogram.ReasonEnrolled},
+		{ID: "lost", Subject: "CN=Lost", WasEnrolled: true, Enrolled: false,
+			WasReason: rootprogram.ReasonEnrolled, Reason: rootprogram.ReasonSomeCrlsFailed},
+	}
+	if !reflect.DeepEqual(diff.Flipped, expectedFlips) {
+		t.Errorf("Unexpected enrollment changes: %+v", diff.Flipped)
+	}
+
+	var text bytes.Buffer
+	if err := diff.writeText(&text); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"Added (1):\n  + added CN=Added (enrolled)\n",
+		"Removed (1):\n  - removed CN=Removed (enrolled)\n",
+		"  ~ lost CN=Lost: enrolled -> not enrolled (some-crls-failed)\n",
+		"Unchanged (1):\n    same CN=Same\n",
+	} {
+		if !strings.Contains(text.String(), line) {
+			t.Errorf("Expected %q in the output:\n%s", line, text.String())
+		}
+	}
+
+	encoded, err := json.Marshal(diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded issuerDiff
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, diff) {
+		t.Errorf("JSON output did not round-trip: %s", encoded)
+	}
+}
+
+func Test_diffIssuersIDOnly(t *testing.T) {
+	before, err := parseIssuerSet([]byte(`["kept", "removed"]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.hasEnrollment {
+		t.Error("An ID list has no enrollment information")
+	}
+
+	after, err := parseIssuerSet([]byte(`[{"pubKeyHash": "kept", "enrolled": true}, {"pubKeyHash": "added"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff := diffIssuers(before, after)
+	if !reflect.DeepEqual(summaryIDs(diff.Added), []string{"added"}) ||
+		!reflect.DeepEqual(summaryIDs(diff.Removed), []string{"removed"}) ||
+		!reflect.DeepEqual(summaryIDs(diff.Unchanged), []string{"kept"}) ||
+		len(diff.Flipped) != 0 {
+		t.Errorf("Unexpected diff: %+v", diff)
+	}
+}
+
+func Test_parseIssuerSetInvalid(t *testing.T) {
+	if _, err := parseIssuerSet([]byte(`{"not": "a list"}`)); err == nil {
+		t.Error("Expected an error for a non-list")
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/mozilla/crlite/go/config"
+	"github.com/mozilla/crlite/go/engine"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+var (
+	snapshotout = flag.String("snapshotout", "<path>", "output JSON file of each issuer's expiration dates, CRL and OCSP URLs, and issuer DNs, for aggregate-crls -metadatasnapshot")
+	ctconfig    = config.NewCTConfig()
+)
+
+func main() {
+	ctconfig.Init()
+	defer glog.Flush()
+
+	if *snapshotout == "<path>" {
+		glog.Errorf("Flag snapshotout is not set")
+		ctconfig.Usage()
+		os.Exit(2)
+	}
+
+	storageDB, _, _ := engine.GetConfiguredStorage(context.Background(), ctconfig)
+	snapshot, err := storage.NewMetadataSnapshot(storageDB)
+	if err != nil {
+		glog.Fatalf("Unable to read issuer metadata from the cache: %s", err)
+	}
+	if err = snapshot.Save(*snapshotout); err != nil {
+		glog.Fatalf("Unable to save the metadata snapshot to %s: %s", *snapshotout, err)
+	}
+	glog.Infof("Saved metadata for %d issuers to %s", len(snapshot.Issuers), *snapshotout)
+}
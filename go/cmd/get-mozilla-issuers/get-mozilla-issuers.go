@@ -1,20 +1,27 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"os"
 
 	"github.com/golang/glog"
+	"github.com/mozilla/crlite/go/config"
 	"github.com/mozilla/crlite/go/rootprogram"
 )
 
 var (
-	outfile = flag.String("out", "<stdout>", "output json dictionary of issuers")
-	inccadb = flag.String("ccadb", "<path>", "input CCADB CSV path")
+	outfile  = flag.String("out", "<stdout>", "output json dictionary of issuers")
+	ccadburl = flag.String("ccadburl", "<url>", "input CCADB CSV URL")
+	retries  = flag.Uint("ccadbretries", rootprogram.DefaultLoadRetries, "times to retry fetching CCADB over the network, with backoff, before giving up")
+	skipbad  = flag.Bool("ccadbskipinvalid", false, "leave out and log CCADB rows whose certificates can't be decoded, rather than failing to load CCADB")
+	inactive = flag.Bool("includeinactive", false, "keep CCADB certificates that are revoked or expired, which are otherwise excluded")
+	inccadbs config.StringList
 )
 
 func main() {
+	flag.Var(&inccadbs, "ccadb", "input CCADB CSV path; repeat to merge several, later files overriding earlier ones per issuer")
 	flag.Parse()
 
 	var err error
@@ -22,9 +29,14 @@ func main() {
 	defer glog.Flush()
 
 	mozIssuers := rootprogram.NewMozillaIssuers()
-
-	if *inccadb != "<path>" {
-		err = mozIssuers.LoadFromDisk(*inccadb)
+	mozIssuers.IncludeInactive = *inactive
+	mozIssuers.SkipInvalidRows = *skipbad
+	mozIssuers.LoadRetries = *retries
+
+	if len(inccadbs) > 0 {
+		err = mozIssuers.LoadFromDiskMerge(inccadbs...)
+	} else if *ccadburl != "<url>" {
+		err = mozIssuers.LoadFromURL(context.Background(), *ccadburl)
 	} else {
 		err = mozIssuers.Load()
 	}
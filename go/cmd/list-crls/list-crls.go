@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/mozilla/crlite/go/rootprogram"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+var (
+	ccadbfile = flag.String("ccadb", "<path>", "input CCADB CSV path")
+	issuerid  = flag.String("issuer", "", "ID of the issuer to list CRL URLs for, as in aggregate-crls' output")
+	inactive  = flag.Bool("includeinactive", false, "keep CCADB certificates that are revoked or expired, which are otherwise excluded")
+	jsonout   = flag.Bool("json", false, "print the issuer and its CRL URLs as JSON")
+)
+
+type issuerCrls struct {
+	Issuer    string   `json:"issuer"`
+	SubjectDN string   `json:"subjectDN"`
+	CrlUrls   []string `json:"crlUrls"`
+}
+
+func findIssuerCrls(aIssuers *rootprogram.MozIssuers, aIssuerID string) (*issuerCrls, error) {
+	issuer := storage.NewIssuerFromString(aIssuerID)
+	subject, err := aIssuers.GetSubjectForIssuer(issuer)
+	if err != nil {
+		return nil, err
+	}
+	urls, err := aIssuers.GetCrlUrlsForIssuer(issuer)
+	if err != nil {
+		return nil, err
+	}
+	return &issuerCrls{
+		Issuer:    aIssuerID,
+		SubjectDN: subject,
+		CrlUrls:   urls,
+	}, nil
+}
+
+// One URL per line, so the output can be piped straight to a downloader
+func (ic *issuerCrls) writeText(w io.Writer) error {
+	for _, crlUrl := range ic.CrlUrls {
+		if _, err := fmt.Fprintln(w, crlUrl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	defer glog.Flush()
+
+	if *ccadbfile == "<path>" || *issuerid == "" {
+		glog.Errorf("Flags ccadb and issuer must be set")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	mozIssuers := rootprogram.NewMozillaIssuers()
+	mozIssuers.IncludeInactive = *inactive
+	if err := mozIssuers.LoadFromDisk(*ccadbfile); err != nil {
+		glog.Fatalf("Could not load CCADB %s: %s", *ccadbfile, err)
+	}
+
+	crls, err := findIssuerCrls(mozIssuers, *issuerid)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if len(crls.CrlUrls) == 0 {
+		glog.Warningf("CCADB lists no CRL URLs for %s (%s)", crls.Issuer, crls.SubjectDN)
+	}
+
+	if *jsonout {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", " ")
+		err = enc.Encode(crls)
+	} else {
+		err = crls.writeText(os.Stdout)
+	}
+	if err != nil {
+		glog.Fatal(err)
+	}
+}
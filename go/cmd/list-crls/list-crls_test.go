@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/csv"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mozilla/crlite/go/rootprogram"
+)
+
+// Writes a CCADB CSV with one issuer, whose row lists aCrlUrls
+func writeCCADB(t *testing.T, aCrlUrls string) (string, *x509.Certificate) {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Multiple DPs CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &privKey.PublicKey, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes})
+
+	fd, err := ioutil.TempFile("", "ccadb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := csv.NewWriter(fd)
+	if err = writer.WriteAll([][]string{
+		{"Certificate Name", "CRL URL(s)", "PEM"},
+		{"Multiple DPs CA", aCrlUrls, "'" + string(certPem) + "'"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = fd.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return fd.Name(), cert
+}
+
+func Test_findIssuerCrls(t *testing.T) {
+	path, cert := writeCCADB(t, "http://crl.example.com/a.crl, http://crl.example.com/b.crl\nldap://ldap.example.com/CN=CA")
+	defer os.Remove(path)
+
+	mozIssuers := rootprogram.NewMozillaIssuers()
+	if err := mozIssuers.LoadFromDisk(path); err != nil {
+		t.Fatal(err)
+	}
+	issuers := mozIssuers.GetIssuers()
+	if len(issuers) != 1 {
+		t.Fatalf("Expected one issuer, got %v", issuers)
+	}
+
+	crls, err := findIssuerCrls(mozIssuers, issuers[0].ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"http://crl.example.com/a.crl",
+		"http://crl.example.com/b.crl",
+		"ldap://ldap.example.com/CN=CA",
+	}
+	if !reflect.DeepEqual(crls.CrlUrls, expected) {
+		t.Errorf("Expected %v, got %v", expected, crls.CrlUrls)
+	}
+	if crls.SubjectDN != cert.Subject.String() {
+		t.Errorf("Expected subject %s, got %s", cert.Subject.String(), crls.SubjectDN)
+	}
+
+	var buf bytes.Buffer
+	if err = crls.writeText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "http://crl.example.com/a.crl\nhttp://crl.example.com/b.crl\nldap://ldap.example.com/CN=CA\n" {
+		t.Errorf("Unexpected text output %q", buf.String())
+	}
+
+	if _, err = findIssuerCrls(mozIssuers, "unknown"); err == nil {
+		t.Error("Expected an unknown issuer to be an error")
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/mozilla/crlite/go/crlcheck"
+	"github.com/mozilla/crlite/go/rootprogram"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+var (
+	crlfile    = flag.String("crl", "<path>", "input CRL file, in DER, PEM, or gzip form")
+	issuerfile = flag.String("issuer", "<path>", "input issuer certificate, in DER or PEM form")
+	ccadbfile  = flag.String("ccadb", "<path>", "input CCADB CSV path to find the issuer in, with -issuerid")
+	issuerid   = flag.String("issuerid", "", "ID of the issuer to find in -ccadb")
+	crlsigalgs = flag.String("crlsigalgs", "", "comma-separated CRL signature algorithms to accept, e.g. SHA256-RSA,ECDSA-SHA256; empty accepts any")
+	jsonout    = flag.Bool("json", false, "print the report as JSON")
+
+	extensionNames = map[string]string{
+		"2.5.29.20":         "cRLNumber",
+		"2.5.29.27":         "deltaCRLIndicator",
+		"2.5.29.28":         "issuingDistributionPoint",
+		"2.5.29.35":         "authorityKeyIdentifier",
+		"2.5.29.46":         "freshestCRL",
+		"1.3.6.1.5.5.7.1.1": "authorityInfoAccess",
+	}
+)
+
+type extensionSummary struct {
+	OID      string `json:"oid"`
+	Name     string `json:"name,omitempty"`
+	Critical bool   `json:"critical"`
+}
+
+type crlReport struct {
+	Path               string             `json:"path"`
+	SHA256             string             `json:"sha256"`
+	Issuer             string             `json:"issuer"`
+	ThisUpdate         time.Time          `json:"thisUpdate"`
+	NextUpdate         time.Time          `json:"nextUpdate"`
+	CRLNumber          string             `json:"crlNumber,omitempty"`
+	Entries            int                `json:"entries"`
+	SignatureAlgorithm string             `json:"signatureAlgorithm"`
+	Indirect           bool               `json:"indirect"`
+	Extensions         []extensionSummary `json:"extensions"`
+	// Entries which belong to the issuer, fewer than Entries only for an
+	// indirect CRL
+	IssuerSerials int    `json:"issuerSerials"`
+	Valid         bool   `json:"valid"`
+	Error         string `json:"error,omitempty"`
+}
+
+func loadCertificate(aPath string) (*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(aPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("Unexpected PEM block type %s", block.Type)
+		}
+		data = block.Bytes
+	}
+	return x509.ParseCertificate(data)
+}
+
+// Loads the CRL at aPath and runs it through the same checks as
+// aggregate-crls. A CRL which can be read but fails validation is reported
+// with Valid unset, rather than as an error.
+func buildReport(aPath string, aIssuerCert *x509.Certificate, aSigners crlcheck.SignerLookup) (*crlReport, error) {
+	crl, shasum, err := crlcheck.LoadCRL(aPath)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &crlReport{
+		Path:               aPath,
+		SHA256:             hex.EncodeToString(shasum),
+		Issuer:             crl.TBSCertList.Issuer.String(),
+		ThisUpdate:         crl.TBSCertList.ThisUpdate,
+		NextUpdate:         crl.TBSCertList.NextUpdate,
+		Entries:            len(crl.TBSCertList.RevokedCertificates),
+		SignatureAlgorithm: x509.SignatureAlgorithmFromAI(crl.SignatureAlgorithm).String(),
+		Extensions:         []extensionSummary{},
+	}
+
+	for _, ext := range crl.TBSCertList.Extensions {
+		report.Extensions = append(report.Extensions, extensionSummary{
+			OID:      ext.Id.String(),
+			Name:     extensionNames[ext.Id.String()],
+			Critical: ext.Critical,
+		})
+	}
+
+	fail := func(err error) (*crlReport, error) {
+		report.Error = err.Error()
+		return report, nil
+	}
+
+	number, err := crlcheck.CRLNumber(crl)
+	if err != nil {
+		return fail(err)
+	}
+	if number != nil {
+		report.CRLNumber = number.String()
+	}
+	if report.Indirect, err = crlcheck.IsIndirectCRL(crl); err != nil {
+		return fail(err)
+	}
+	if err = crlcheck.CheckCRL(crl, aIssuerCert, aSigners); err != nil {
+		return fail(err)
+	}
+
+	serials, _, err := crlcheck.ProcessCRL(crl, aIssuerCert)
+	if err != nil {
+		return fail(err)
+	}
+	report.IssuerSerials = len(serials)
+	report.Valid = true
+	return report, nil
+}
+
+func (r *crlReport) writeText(w io.Writer) error {
+	nextUpdate := "none"
+	if !r.NextUpdate.IsZero() {
+		nextUpdate = r.NextUpdate.String()
+	}
+	crlNumber := "none"
+	if r.CRLNumber != "" {
+		crlNumber = r.CRLNumber
+	}
+
+	lines := []string{
+		fmt.Sprintf("CRL:                 %s", r.Path),
+		fmt.Sprintf("SHA-256:             %s", r.SHA256),
+		fmt.Sprintf("Issuer:              %s", r.Issuer),
+		fmt.Sprintf("This Update:         %s", r.ThisUpdate),
+		fmt.Sprintf("Next Update:         %s", nextUpdate),
+		fmt.Sprintf("CRL Number:          %s", crlNumber),
+		fmt.Sprintf("Entries:             %d", r.Entries),
+		fmt.Sprintf("Signature Algorithm: %s", r.SignatureAlgorithm),
+		fmt.Sprintf("Indirect:            %t", r.Indirect),
+		fmt.Sprintf("Extensions:          %d", len(r.Extensions)),
+	}
+	for _, ext := range r.Extensions {
+		line := "  " + ext.OID
+		if ext.Name != "" {
+			line += " (" + ext.Name + ")"
+		}
+		if ext.Critical {
+			line += " critical"
+		}
+		lines = append(lines, line)
+	}
+	if r.Valid {
+		lines = append(lines,
+			fmt.Sprintf("Issuer Serials:      %d", r.IssuerSerials),
+			"Result:              valid")
+	} else {
+		lines = append(lines, fmt.Sprintf("Result:              invalid: %s", r.Error))
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	defer glog.Flush()
+
+	if *crlfile == "<path>" {
+		glog.Errorf("Flag crl must be set")
+		flag.Usage()
+		os.Exit(2)
+	}
+	haveIssuerFile := *issuerfile != "<path>"
+	haveCCADBIssuer := *ccadbfile != "<path>" && *issuerid != ""
+	if haveIssuerFile == haveCCADBIssuer {
+		glog.Errorf("Exactly one of issuer, or ccadb with issuerid, must be set")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	sigAlgs, err := crlcheck.ParseSignatureAlgorithms(*crlsigalgs)
+	if err != nil {
+		glog.Errorf("Flag crlsigalgs is invalid: %s", err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	crlcheck.AllowedSignatureAlgorithms = sigAlgs
+
+	var issuerCert *x509.Certificate
+	var signers crlcheck.SignerLookup
+	if haveIssuerFile {
+		issuerCert, err = loadCertificate(*issuerfile)
+		if err != nil {
+			glog.Fatalf("Could not load issuer certificate %s: %s", *issuerfile, err)
+		}
+	} else {
+		mozIssuers := rootprogram.NewMozillaIssuers()
+		if err = mozIssuers.LoadFromDisk(*ccadbfile); err != nil {
+			glog.Fatalf("Could not load CCADB %s: %s", *ccadbfile, err)
+		}
+		issuerCert, err = mozIssuers.GetCertificateForIssuer(storage.NewIssuerFromString(*issuerid))
+		if err != nil {
+			glog.Fatalf("Could not find issuer %s: %s", *issuerid, err)
+		}
+		signers = mozIssuers
+	}
+
+	report, err := buildReport(*crlfile, issuerCert, signers)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	if *jsonout {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", " ")
+		err = enc.Encode(report)
+	} else {
+		err = report.writeText(os.Stdout)
+	}
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	if !report.Valid {
+		os.Exit(1)
+	}
+}
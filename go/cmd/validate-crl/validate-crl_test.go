@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/certificate-transparency-go/x509/pkix"
+)
+
+func makeCA(t *testing.T) (*x509.Certificate, interface{}) {
+	t.Helper()
+	caTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().Unix()),
+		Subject: pkix.Name{
+			CommonName: "Honest Achmed's Used Certificates and CRLs",
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		SubjectKeyId:          []byte{1, 2, 3, 4},
+	}
+
+	caPrivKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caBytes, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caPrivKey.PublicKey, caPrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := x509.ParseCertificate(caBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ca, caPrivKey
+}
+
+func writeTempFile(t *testing.T, prefix string, data []byte) string {
+	t.Helper()
+	fd, err := ioutil.TempFile("", prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fd.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := fd.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return fd.Name()
+}
+
+func Test_buildReport(t *testing.T) {
+	ca, caPrivKey := makeCA(t)
+
+	thisUpdate := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	nextUpdate := time.Date(2020, time.February, 1, 0, 0, 0, 0, time.UTC)
+	crlBytes, err := ca.CreateCRL(rand.Reader, caPrivKey, []pkix.RevokedCertificate{
+		{SerialNumber: big.NewInt(7), RevocationTime: thisUpdate},
+		{SerialNumber: big.NewInt(42), RevocationTime: thisUpdate},
+	}, thisUpdate, nextUpdate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crlPath := writeTempFile(t, "validateCrl", crlBytes)
+	defer os.Remove(crlPath)
+
+	report, err := buildReport(crlPath, ca, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid {
+		t.Fatalf("Expected the CRL to be valid: %s", report.Error)
+	}
+	if report.Entries != 2 || report.IssuerSerials != 2 {
+		t.Errorf("Expected 2 entries and serials, got %d and %d", report.Entries, report.IssuerSerials)
+	}
+	if !report.ThisUpdate.Equal(thisUpdate) || !report.NextUpdate.Equal(nextUpdate) {
+		t.Errorf("Unexpected validity %s to %s", report.ThisUpdate, report.NextUpdate)
+	}
+	if report.SignatureAlgorithm != "ECDSA-SHA256" {
+		t.Errorf("Unexpected signature algorithm %s", report.SignatureAlgorithm)
+	}
+	if len(report.Extensions) != 1 || report.Extensions[0].Name != "authorityKeyIdentifier" {
+		t.Errorf("Expected only an AKI extension, got %+v", report.Extensions)
+	}
+
+	var buf bytes.Buffer
+	if err := report.writeText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Result:              valid") {
+		t.Errorf("Expected a valid result, got:\n%s", buf.String())
+	}
+
+	otherCa, _ := makeCA(t)
+	report, err = buildReport(crlPath, otherCa, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Valid || !strings.Contains(report.Error, "verification failure") {
+		t.Errorf("Expected a signature failure, got valid=%t error=%s", report.Valid, report.Error)
+	}
+	if report.Entries != 2 {
+		t.Errorf("Expected details to be reported for an invalid CRL, got %d entries", report.Entries)
+	}
+
+	garbagePath := writeTempFile(t, "validateCrlGarbage", []byte("not a CRL"))
+	defer os.Remove(garbagePath)
+	if _, err := buildReport(garbagePath, ca, nil); err == nil {
+		t.Error("Expected an unparseable CRL to be an error")
+	}
+}
+
+func Test_loadCertificate(t *testing.T) {
+	ca, _ := makeCA(t)
+
+	derPath := writeTempFile(t, "issuerDer", ca.Raw)
+	defer os.Remove(derPath)
+	pemPath := writeTempFile(t, "issuerPem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}))
+	defer os.Remove(pemPath)
+
+	for _, path := range []string{derPath, pemPath} {
+		cert, err := loadCertificate(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(cert.Raw, ca.Raw) {
+			t.Errorf("Loaded a different certificate from %s", path)
+		}
+	}
+
+	keyPath := writeTempFile(t, "issuerKey", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: ca.Raw}))
+	defer os.Remove(keyPath)
+	if _, err := loadCertificate(keyPath); err == nil {
+		t.Error("Expected a non-certificate PEM block to be rejected")
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/mozilla/crlite/go/crlcheck"
+	"github.com/mozilla/crlite/go/rootprogram"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+const (
+	statusValid   = "valid"
+	statusStale   = "stale"
+	statusInvalid = "invalid"
+)
+
+var (
+	crlpath     = flag.String("crlpath", "<path>", "root of folders of the form /<path>/<issuer> containing .crl files, as written by aggregate-crls")
+	ccadbfile   = flag.String("ccadb", "<path>", "input CCADB CSV path to find the issuers in")
+	inactive    = flag.Bool("includeinactive", false, "keep CCADB certificates that are revoked or expired, which are otherwise excluded")
+	crlsigalgs  = flag.String("crlsigalgs", "", "comma-separated CRL signature algorithms to accept, e.g. SHA256-RSA,ECDSA-SHA256; empty accepts any")
+	maxlocalage = flag.Duration("maxlocalage", 336*time.Hour, "age of a CRL without a nextUpdate after which it's stale, matching aggregate-crls")
+	jsonout     = flag.Bool("json", false, "print the report as JSON")
+)
+
+type fileResult struct {
+	Path   string `json:"path"`
+	Issuer string `json:"issuer"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type verifyReport struct {
+	Files   []fileResult `json:"files"`
+	Valid   int          `json:"valid"`
+	Stale   int          `json:"stale"`
+	Invalid int          `json:"invalid"`
+}
+
+// Checks the CRL at aPath as aggregate-crls would, and whether it's still
+// fresh at aNow
+func verifyFile(aPath string, aIssuerCert *x509.Certificate, aSigners crlcheck.SignerLookup, aNow time.Time,
+	aMaxLocalAge time.Duration) (string, error) {
+	crl, _, err := crlcheck.LoadAndCheckSignatureOfCRL(aPath, aIssuerCert, aSigners)
+	if err != nil {
+		return statusInvalid, err
+	}
+
+	_, validity, err := crlcheck.ProcessCRL(crl, aIssuerCert)
+	if err != nil {
+		return statusInvalid, err
+	}
+
+	fi, err := os.Stat(aPath)
+	if err != nil {
+		return statusInvalid, err
+	}
+	if validity.IsStaleAt(aNow, fi.ModTime(), aMaxLocalAge) {
+		if validity.HasNextUpdate() {
+			return statusStale, fmt.Errorf("Past its nextUpdate of %s", validity.NextUpdate)
+		}
+		return statusStale, fmt.Errorf("No nextUpdate, and last modified %s", fi.ModTime())
+	}
+	return statusValid, nil
+}
+
+// Walks aRoot/<issuer>/*.crl, verifying each CRL against its issuer's
+// certificate from aIssuers
+func verifyCrlPath(aRoot string, aIssuers *rootprogram.MozIssuers, aNow time.Time,
+	aMaxLocalAge time.Duration) (*verifyReport, error) {
+	dirs, err := ioutil.ReadDir(aRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &verifyReport{
+		Files: []fileResult{},
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		paths, err := filepath.Glob(filepath.Join(aRoot, dir.Name(), "*.crl"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(paths)
+
+		issuer := storage.NewIssuerFromString(dir.Name())
+		issuerCert, certErr := aIssuers.GetCertificateForIssuer(issuer)
+
+		for _, path := range paths {
+			result := fileResult{
+				Path:   path,
+				Issuer: issuer.ID(),
+			}
+
+			if certErr != nil {
+				result.Status = statusInvalid
+				err = fmt.Errorf("Couldn't find the issuer certificate: %s", certErr)
+			} else {
+				result.Status, err = verifyFile(path, issuerCert, aIssuers, aNow, aMaxLocalAge)
+			}
+			if err != nil {
+				result.Error = err.Error()
+			}
+
+			switch result.Status {
+			case statusValid:
+				report.Valid++
+			case statusStale:
+				report.Stale++
+			default:
+				report.Invalid++
+			}
+			report.Files = append(report.Files, result)
+		}
+	}
+	return report, nil
+}
+
+func (r *verifyReport) Failed() bool {
+	return r.Stale > 0 || r.Invalid > 0
+}
+
+// Lists only the files that failed, then the totals
+func (r *verifyReport) writeText(w io.Writer) error {
+	for _, result := range r.Files {
+		if result.Status == statusValid {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s (issuer=%s): %s\n", result.Path, result.Status, result.Issuer,
+			result.Error); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "Verified %d CRLs: %d valid, %d stale, %d invalid\n", len(r.Files), r.Valid, r.Stale,
+		r.Invalid)
+	return err
+}
+
+func main() {
+	flag.Parse()
+	defer glog.Flush()
+
+	if *crlpath == "<path>" || *ccadbfile == "<path>" {
+		glog.Errorf("Flags crlpath and ccadb must be set")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	sigAlgs, err := crlcheck.ParseSignatureAlgorithms(*crlsigalgs)
+	if err != nil {
+		glog.Errorf("Flag crlsigalgs is invalid: %s", err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	crlcheck.AllowedSignatureAlgorithms = sigAlgs
+
+	mozIssuers := rootprogram.NewMozillaIssuers()
+	mozIssuers.IncludeInactive = *inactive
+	if err = mozIssuers.LoadFromDisk(*ccadbfile); err != nil {
+		glog.Fatalf("Could not load CCADB %s: %s", *ccadbfile, err)
+	}
+
+	report, err := verifyCrlPath(*crlpath, mozIssuers, time.Now(), *maxlocalage)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	if *jsonout {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", " ")
+		err = enc.Encode(report)
+	} else {
+		err = report.writeText(os.Stdout)
+	}
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	if report.Failed() {
+		os.Exit(1)
+	}
+}
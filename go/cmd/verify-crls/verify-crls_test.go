@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/certificate-transparency-go/x509/pkix"
+	"github.com/mozilla/crlite/go/rootprogram"
+)
+
+func makeCA(t *testing.T) (*x509.Certificate, interface{}) {
+	t.Helper()
+	caTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().Unix()),
+		Subject: pkix.Name{
+			CommonName: "Honest Achmed's Used Certificates and CRLs",
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		SubjectKeyId:          []byte{1, 2, 3, 4},
+	}
+
+	caPrivKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caBytes, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caPrivKey.PublicKey, caPrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := x509.ParseCertificate(caBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ca, caPrivKey
+}
+
+func writeCRL(t *testing.T, aPath string, ca *x509.Certificate, caPrivKey interface{}, thisUpdate time.Time,
+	nextUpdate time.Time) {
+	t.Helper()
+	crlBytes, err := ca.CreateCRL(rand.Reader, caPrivKey, []pkix.RevokedCertificate{
+		{SerialNumber: big.NewInt(7), RevocationTime: thisUpdate},
+	}, thisUpdate, nextUpdate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(aPath, crlBytes, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_verifyCrlPath(t *testing.T) {
+	root, err := ioutil.TempDir("", "verifyCrls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	mozIssuers := rootprogram.NewMozillaIssuers()
+	ca, caPrivKey := makeCA(t)
+	issuer := mozIssuers.InsertIssuerFromCertAndPem(ca, "")
+	otherCa, otherPrivKey := makeCA(t)
+
+	issuerDir := filepath.Join(root, issuer.ID())
+	unknownDir := filepath.Join(root, "unknownIssuer")
+	for _, dir := range []string{issuerDir, unknownDir} {
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	writeCRL(t, filepath.Join(issuerDir, "a-fresh.crl"), ca, caPrivKey, now.AddDate(0, 0, -1), now.AddDate(0, 0, 6))
+	writeCRL(t, filepath.Join(issuerDir, "b-stale.crl"), ca, caPrivKey, now.AddDate(0, 0, -8), now.AddDate(0, 0, -1))
+	writeCRL(t, filepath.Join(issuerDir, "c-rotated.crl"), otherCa, otherPrivKey, now.AddDate(0, 0, -1),
+		now.AddDate(0, 0, 6))
+	if err = ioutil.WriteFile(filepath.Join(issuerDir, "d-corrupt.crl"), []byte("not a CRL"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeCRL(t, filepath.Join(unknownDir, "e-orphan.crl"), ca, caPrivKey, now.AddDate(0, 0, -1), now.AddDate(0, 0, 6))
+	// Neither a .crl file nor in an issuer folder, so ignored
+	if err = ioutil.WriteFile(filepath.Join(issuerDir, "a-fresh.crl.partial"), []byte("junk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(root, "stray.crl"), []byte("junk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := verifyCrlPath(root, mozIssuers, now, 336*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"a-fresh.crl":   statusValid,
+		"b-stale.crl":   statusStale,
+		"c-rotated.crl": statusInvalid,
+		"d-corrupt.crl": statusInvalid,
+		"e-orphan.crl":  statusInvalid,
+	}
+	if len(report.Files) != len(expected) {
+		t.Fatalf("Expected %d files, got %+v", len(expected), report.Files)
+	}
+	for _, result := range report.Files {
+		if status := expected[filepath.Base(result.Path)]; result.Status != status {
+			t.Errorf("Expected %s to be %s, got %s: %s", result.Path, status, result.Status, result.Error)
+		}
+		if result.Status != statusValid && result.Error == "" {
+			t.Errorf("Expected an error for %s", result.Path)
+		}
+	}
+	if report.Valid != 1 || report.Stale != 1 || report.Invalid != 3 || !report.Failed() {
+		t.Errorf("Unexpected totals %d valid, %d stale, %d invalid", report.Valid, report.Stale, report.Invalid)
+	}
+
+	var buf bytes.Buffer
+	if err = report.writeText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "a-fresh.crl") {
+		t.Errorf("Expected only failures to be listed, got:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "Verified 5 CRLs: 1 valid, 1 stale, 3 invalid") {
+		t.Errorf("Expected a summary, got:\n%s", buf.String())
+	}
+
+	if _, err = verifyCrlPath(filepath.Join(root, "missing"), mozIssuers, now, 336*time.Hour); err == nil {
+		t.Error("Expected a missing crlpath to be an error")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"os/user"
 	"strconv"
+	"strings"
 
 	"github.com/golang/glog"
 	"gopkg.in/ini.v1"
@@ -122,6 +123,18 @@ func confString(p *string, section *ini.Section, key string, def string) {
 	}
 }
 
+// StringList is a flag.Value collecting every use of a repeatable flag, in order
+type StringList []string
+
+func (l *StringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *StringList) Set(aValue string) error {
+	*l = append(*l, aValue)
+	return nil
+}
+
 func NewCTConfig() *CTConfig {
 	return &CTConfig{
 		Offset:              new(uint64),
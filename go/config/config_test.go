@@ -1,8 +1,11 @@
 package config
 
 import (
-	"gopkg.in/ini.v1"
+	"flag"
+	"reflect"
 	"testing"
+
+	"gopkg.in/ini.v1"
 )
 
 func Test_Defaults(t *testing.T) {
@@ -84,3 +87,19 @@ func Test_SectionOverride(t *testing.T) {
 		t.Errorf("Expected the value 935939539593953, got %v", u)
 	}
 }
+
+func Test_StringList(t *testing.T) {
+	var list StringList
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(&list, "item", "repeatable")
+
+	if err := fs.Parse([]string{"-item", "a", "-item=b", "-item", "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]string(list), []string{"a", "b", "a"}) {
+		t.Errorf("Unexpected list: %v", list)
+	}
+	if list.String() != "a,b,a" {
+		t.Errorf("Unexpected string: %s", list.String())
+	}
+}
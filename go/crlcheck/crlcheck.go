@@ -0,0 +1,374 @@
+package crlcheck
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/google/certificate-transparency-go/asn1"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/certificate-transparency-go/x509/pkix"
+	"github.com/mozilla/crlite/go"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+const PemHeaderPrefix = "-----BEGIN"
+
+// Transforms a CRL file's contents before they're decoded, for deployments
+// whose CRLs arrive encrypted or wrapped in an envelope
+type CrlPreprocessor func(raw []byte) ([]byte, error)
+
+var (
+	// Applied to every CRL file as it's read; nil leaves the contents as-is
+	Preprocessor CrlPreprocessor
+
+	// A nil map accepts any signature algorithm
+	AllowedSignatureAlgorithms map[x509.SignatureAlgorithm]bool
+
+	// UNSAFE: accepts CRLs without verifying their signatures, so anyone can
+	// forge revocations. Only for testing against locally-generated CRLs
+	// signed by keys that aren't in CCADB.
+	InsecureSkipSignature bool
+
+	// How far in the future a CRL's thisUpdate may be, for clock skew
+	// between the CA and here, before the CRL is rejected; 0 for no limit
+	MaxClockSkew = 24 * time.Hour
+
+	// The most bytes a gzip-wrapped CRL may decompress to, so a small file
+	// can't expand to exhaust memory; 0 for no limit
+	MaxDecompressedSize int64 = 1024 * 1024 * 1024
+
+	oidExtensionCRLNumber = asn1.ObjectIdentifier{2, 5, 29, 20}
+
+	// Swapped out by tests to make the parser panic
+	parseDERCRL = x509.ParseDERCRL
+)
+
+// Some CAs serve their CRLs gzip-wrapped, either as .crl.gz files or with a
+// Content-Encoding the HTTP client didn't negotiate, so check the magic bytes.
+func decompressIfGzipped(aData []byte) ([]byte, error) {
+	if len(aData) < 2 || aData[0] != 0x1f || aData[1] != 0x8b {
+		return aData, nil
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(aData))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(limitDecompressed(reader))
+}
+
+// Wraps aReader, the output of a decompressor, to fail once it has given
+// more than MaxDecompressedSize bytes
+func limitDecompressed(aReader io.Reader) *decompressedLimitReader {
+	if MaxDecompressedSize <= 0 {
+		return &decompressedLimitReader{r: aReader}
+	}
+	return &decompressedLimitReader{
+		r:     io.LimitReader(aReader, MaxDecompressedSize+1),
+		limit: MaxDecompressedSize,
+	}
+}
+
+type decompressedLimitReader struct {
+	r     io.Reader
+	limit int64
+	read  int64
+	// Set once the limit is exceeded, as readers such as bufio may not pass
+	// the error on
+	err error
+}
+
+func (l *decompressedLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.limit > 0 && l.read > l.limit {
+		l.err = fmt.Errorf("CRL decompresses to more than %d bytes", l.limit)
+		return n, l.err
+	}
+	return n, err
+}
+
+// Parses a comma-separated list of signature algorithm names, as printed by
+// x509.SignatureAlgorithm, into an allowlist. An empty list gives nil.
+func ParseSignatureAlgorithms(aList string) (map[x509.SignatureAlgorithm]bool, error) {
+	if strings.TrimSpace(aList) == "" {
+		return nil, nil
+	}
+
+	allowed := make(map[x509.SignatureAlgorithm]bool)
+	for _, name := range strings.Split(aList, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for algo := x509.MD2WithRSA; algo <= x509.SHA512WithRSAPSS; algo++ {
+			if strings.EqualFold(algo.String(), name) {
+				allowed[algo] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Unknown signature algorithm: %s", name)
+		}
+	}
+	return allowed, nil
+}
+
+func checkCRLSignatureAlgorithm(aCRL *pkix.CertificateList) error {
+	return checkSignatureAlgorithm(aCRL.SignatureAlgorithm)
+}
+
+func checkSignatureAlgorithm(aAlgorithm pkix.AlgorithmIdentifier) error {
+	if AllowedSignatureAlgorithms == nil {
+		return nil
+	}
+
+	algo := x509.SignatureAlgorithmFromAI(aAlgorithm)
+	if !AllowedSignatureAlgorithms[algo] {
+		if algo == x509.UnknownSignatureAlgorithm {
+			return fmt.Errorf("%s is not in the allowlist", aAlgorithm.Algorithm)
+		}
+		return fmt.Errorf("%s is not in the allowlist", algo)
+	}
+	return nil
+}
+
+// Some CAs publish PEM-armored CRLs, possibly with leading text or
+// whitespace, so unwrap to DER. Bytes without a PEM header pass through as DER.
+func decodeIfPEM(aData []byte) ([]byte, error) {
+	if !bytes.Contains(aData, []byte(PemHeaderPrefix)) {
+		return aData, nil
+	}
+
+	block, _ := pem.Decode(aData)
+	if block == nil {
+		return nil, fmt.Errorf("Malformed PEM")
+	}
+	if block.Type != "X509 CRL" {
+		return nil, fmt.Errorf("Unexpected PEM block type %s", block.Type)
+	}
+	return block.Bytes, nil
+}
+
+// Reads a CRL in DER, PEM, or gzip form, after any Preprocessor, without
+// checking it. Returns the CRL with the SHA-256 digest of its DER encoding.
+func LoadCRL(aPath string) (*pkix.CertificateList, []byte, error) {
+	crlBytes, err := ioutil.ReadFile(aPath)
+	if err != nil {
+		return nil, []byte{}, fmt.Errorf("Error reading CRL, will not process revocations: %s", err)
+	}
+
+	if Preprocessor != nil {
+		crlBytes, err = Preprocessor(crlBytes)
+		if err != nil {
+			return nil, []byte{}, fmt.Errorf("Error preprocessing CRL, will not process revocations: %s", err)
+		}
+	}
+
+	crlBytes, err = decompressIfGzipped(crlBytes)
+	if err != nil {
+		return nil, []byte{}, fmt.Errorf("Error decompressing CRL, will not process revocations: %s", err)
+	}
+
+	crlBytes, err = decodeIfPEM(crlBytes)
+	if err != nil {
+		return nil, []byte{}, fmt.Errorf("Error decoding PEM CRL, will not process revocations: %s", err)
+	}
+
+	crl, err := parseCRL(crlBytes)
+	if err != nil {
+		return nil, []byte{}, fmt.Errorf("Error parsing, will not process revocations: %s", err)
+	}
+
+	shasum := sha256.Sum256(crlBytes)
+	return crl, shasum[:], nil
+}
+
+// The ASN.1 decoders can panic on malformed input rather than return an
+// error. Deferred, this turns such a panic into aErr, so that one bad CRL
+// fails like any other rather than taking down the worker processing it.
+func recoverMalformed(aErr *error) {
+	if r := recover(); r != nil {
+		*aErr = fmt.Errorf("Malformed CRL caused a panic while decoding: %v", r)
+	}
+}
+
+func parseCRL(aDER []byte) (crl *pkix.CertificateList, err error) {
+	defer recoverMalformed(&err)
+	return parseDERCRL(aDER)
+}
+
+func decodeTBSCertList(aRaw []byte) (list *types.TBSCertificateListWithRawSerials, err error) {
+	defer recoverMalformed(&err)
+	return types.DecodeRawTBSCertList(aRaw)
+}
+
+// Identifies aCert in logs by the SHA-256 of its DER encoding, in the form
+// CCADB lists it, and its Subject Key Identifier. When a CA rotates keys, this
+// shows whether CCADB has caught up with the certificate now signing CRLs.
+func CertFingerprint(aCert *x509.Certificate) string {
+	digest := sha256.Sum256(aCert.Raw)
+	ski := "none"
+	if len(aCert.SubjectKeyId) > 0 {
+		ski = fmt.Sprintf("%X", aCert.SubjectKeyId)
+	}
+	return fmt.Sprintf("sha256=%X ski=%s", digest, ski)
+}
+
+// Checks the CRL's signature algorithm against the allowlist and its
+// signature against aIssuerCert, or a signer found through aSigners.
+func CheckCRL(aCRL *pkix.CertificateList, aIssuerCert *x509.Certificate, aSigners SignerLookup) error {
+	if err := checkCRLSignatureAlgorithm(aCRL); err != nil {
+		return fmt.Errorf("Disallowed signature algorithm on CRL, will not process revocations: %s", err)
+	}
+
+	if InsecureSkipSignature {
+		return nil
+	}
+
+	if err := checkCRLSignature(aCRL, aIssuerCert, aSigners); err != nil {
+		return fmt.Errorf("Invalid signature on CRL, will not process revocations: %s", err)
+	}
+	return nil
+}
+
+// LoadCRL followed by CheckCRL
+func LoadAndCheckSignatureOfCRL(aPath string, aIssuerCert *x509.Certificate,
+	aSigners SignerLookup) (*pkix.CertificateList, []byte, error) {
+	crl, shasum, err := LoadCRL(aPath)
+	if err != nil {
+		return nil, []byte{}, err
+	}
+
+	if err = CheckCRL(crl, aIssuerCert, aSigners); err != nil {
+		return nil, []byte{}, err
+	}
+	return crl, shasum, nil
+}
+
+type Validity struct {
+	ThisUpdate time.Time
+	NextUpdate time.Time
+	// nil if the CRL has no CRL Number extension
+	Number *big.Int
+}
+
+// A malformed CRL number is left unset here; ProcessCRL reports it.
+func NewValidity(aCRL *pkix.CertificateList) Validity {
+	number, _ := CRLNumber(aCRL)
+	return Validity{
+		ThisUpdate: aCRL.TBSCertList.ThisUpdate,
+		NextUpdate: aCRL.TBSCertList.NextUpdate,
+		Number:     number,
+	}
+}
+
+func (v Validity) HasNextUpdate() bool {
+	return !v.NextUpdate.IsZero()
+}
+
+// Rejects a CRL whose nextUpdate precedes its thisUpdate, or whose
+// thisUpdate is more than MaxClockSkew after aNow, as neither can be trusted
+func (v Validity) Check(aNow time.Time) error {
+	if v.HasNextUpdate() && v.NextUpdate.Before(v.ThisUpdate) {
+		return fmt.Errorf("CRL's nextUpdate %s is before its thisUpdate %s", v.NextUpdate, v.ThisUpdate)
+	}
+	if MaxClockSkew > 0 && v.ThisUpdate.After(aNow.Add(MaxClockSkew)) {
+		return fmt.Errorf("CRL's thisUpdate %s is more than %s in the future", v.ThisUpdate, MaxClockSkew)
+	}
+	return nil
+}
+
+// A CRL's nextUpdate is authoritative for when the CA will replace it, so it
+// decides staleness whenever present. Otherwise, fall back to how long ago the
+// local copy was last modified.
+func (v Validity) IsStaleAt(aNow time.Time, aLocalDate time.Time, aMaxLocalAge time.Duration) bool {
+	if v.HasNextUpdate() {
+		return aNow.After(v.NextUpdate)
+	}
+	return aNow.Sub(aLocalDate) > aMaxLocalAge
+}
+
+// Decodes the CRL's entries and returns the serials revoked for aIssuerCert,
+// along with the CRL's validity period.
+func ProcessCRL(aCRL *pkix.CertificateList, aIssuerCert *x509.Certificate) ([]storage.Serial, Validity, error) {
+	revokedList, err := decodeTBSCertList(aCRL.TBSCertList.Raw)
+	if err != nil {
+		return []storage.Serial{}, Validity{}, fmt.Errorf("CRL list couldn't be decoded: %s", err)
+	}
+
+	serials, err := serialsForIssuer(aCRL, revokedList, aIssuerCert)
+	if err != nil {
+		return []storage.Serial{}, Validity{}, fmt.Errorf("CRL entries couldn't be attributed: %s", err)
+	}
+
+	number, err := CRLNumber(aCRL)
+	if err != nil {
+		return []storage.Serial{}, Validity{}, err
+	}
+
+	validity := Validity{
+		ThisUpdate: revokedList.ThisUpdate,
+		NextUpdate: revokedList.NextUpdate,
+		Number:     number,
+	}
+	if err = validity.Check(time.Now()); err != nil {
+		return []storage.Serial{}, Validity{}, err
+	}
+
+	return serials, validity, nil
+}
+
+// Returns the CRL Number extension's value (RFC 5280, 5.2.3), or nil if there
+// isn't one
+func CRLNumber(aCRL *pkix.CertificateList) (*big.Int, error) {
+	for _, ext := range aCRL.TBSCertList.Extensions {
+		if !ext.Id.Equal(oidExtensionCRLNumber) {
+			continue
+		}
+		var number *big.Int
+		if _, err := asn1.Unmarshal(ext.Value, &number); err != nil {
+			return nil, fmt.Errorf("Malformed CRL number: %s", err)
+		}
+		return number, nil
+	}
+	return nil, nil
+}
+
+// CAs number their CRLs in increasing order, so a CRL numbered below the
+// previous one from the same URL is a rollback or replay. CRLs without a
+// number can't be compared, and neither can a previous CRL with a malformed
+// one.
+func CheckCRLNumberNotRegressed(aCRL *pkix.CertificateList, aPrevious *pkix.CertificateList) error {
+	number, err := CRLNumber(aCRL)
+	if err != nil {
+		return err
+	}
+	previous, err := CRLNumber(aPrevious)
+	if err != nil {
+		return nil
+	}
+	return CheckNumberNotRegressed(number, previous)
+}
+
+// As CheckCRLNumberNotRegressed, given the CRL numbers, either of which may be
+// nil
+func CheckNumberNotRegressed(number *big.Int, previous *big.Int) error {
+	if number == nil || previous == nil {
+		return nil
+	}
+
+	if number.Cmp(previous) < 0 {
+		return fmt.Errorf("CRL number %s is lower than the previous CRL's %s", number, previous)
+	}
+	return nil
+}
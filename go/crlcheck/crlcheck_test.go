@@ -0,0 +1,675 @@
+package crlcheck
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency-go/asn1"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/certificate-transparency-go/x509/pkix"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+func makeCA(t *testing.T) (*x509.Certificate, interface{}) {
+	t.Helper()
+	return makeCAWithKeyId(t, nil)
+}
+
+// CRLs made by a CA with a Subject Key Identifier carry it as their AKI
+func makeCAWithKeyId(t *testing.T, keyId []byte) (*x509.Certificate, interface{}) {
+	t.Helper()
+	return makeCAWith(t, func(aTemplate *x509.Certificate) {
+		aTemplate.SubjectKeyId = keyId
+	})
+}
+
+// Makes a CA from the default template, as changed by aModify
+func makeCAWith(t *testing.T, aModify func(*x509.Certificate)) (*x509.Certificate, interface{}) {
+	t.Helper()
+	caTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().Unix()),
+		Subject: pkix.Name{
+			CommonName: "Honest Achmed's Used Certificates and CRLs",
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		IsCA:                  true,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+	}
+	aModify(caTemplate)
+
+	caPrivKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caBytes, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caPrivKey.PublicKey, caPrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := x509.ParseCertificate(caBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ca, caPrivKey
+}
+
+func makeCRL(t *testing.T, ca *x509.Certificate, caPrivKey interface{}, thisUpdate time.Time, nextUpdate time.Time) []byte {
+	t.Helper()
+	return makeCRLWithRevocations(t, ca, caPrivKey, thisUpdate, nextUpdate, []pkix.RevokedCertificate{})
+}
+
+func makeCRLWithRevocations(t *testing.T, ca *x509.Certificate, caPrivKey interface{}, thisUpdate time.Time,
+	nextUpdate time.Time, revokedCerts []pkix.RevokedCertificate) []byte {
+	t.Helper()
+
+	crlBytes, err := ca.CreateCRL(rand.Reader, caPrivKey, revokedCerts, thisUpdate, nextUpdate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return crlBytes
+}
+
+func writeTempCRL(t *testing.T, prefix string, crlBytes []byte) string {
+	t.Helper()
+	fd, err := ioutil.TempFile("", prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fd.Write(crlBytes); err != nil {
+		t.Fatal(err)
+	}
+	if err := fd.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return fd.Name()
+}
+
+func Test_LoadAndCheckSignatureOfCRL(t *testing.T) {
+	thisUpdate := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	nextUpdate := time.Date(2020, time.February, 1, 0, 0, 0, 0, time.UTC)
+
+	ca, caPrivKey := makeCA(t)
+
+	crlBytes := makeCRL(t, ca, caPrivKey, thisUpdate, nextUpdate)
+
+	crlPath, err := ioutil.TempFile("", "loadAndCheckSignatureOfCRL")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Remove(crlPath.Name())
+
+	if _, err := crlPath.Write(crlBytes); err != nil {
+		t.Fatal(err)
+	}
+	if err := crlPath.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	list, sha256sum, err := LoadAndCheckSignatureOfCRL(crlPath.Name(), ca, nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if list.TBSCertList.ThisUpdate != thisUpdate {
+		t.Error("This Update didn't match")
+	}
+
+	if list.TBSCertList.NextUpdate != nextUpdate {
+		t.Error("This Update didn't match")
+	}
+
+	if len(sha256sum) != 32 {
+		t.Error("Expected a 32-byte sha256 digest")
+	}
+
+	otherCa, _ := makeCA(t)
+	_, _, err = LoadAndCheckSignatureOfCRL(crlPath.Name(), otherCa, nil)
+	if !strings.Contains(err.Error(), "verification failure") {
+		t.Error(err)
+	}
+}
+
+func Test_LoadAndCheckSignatureOfCRLKeyUsage(t *testing.T) {
+	thisUpdate := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	nextUpdate := time.Date(2020, time.February, 1, 0, 0, 0, 0, time.UTC)
+
+	testcases := []struct {
+		name      string
+		keyUsage  x509.KeyUsage
+		expectErr bool
+	}{
+		{name: "cRLSign", keyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign},
+		{name: "no cRLSign", keyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign, expectErr: true},
+		{name: "no key usage extension"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ca, caPrivKey := makeCAWith(t, func(aTemplate *x509.Certificate) {
+				aTemplate.KeyUsage = tc.keyUsage
+			})
+			crlPath := writeTempCRL(t, "Test_LoadAndCheckSignatureOfCRLKeyUsage",
+				makeCRL(t, ca, caPrivKey, thisUpdate, nextUpdate))
+			defer os.Remove(crlPath)
+
+			_, _, err := LoadAndCheckSignatureOfCRL(crlPath, ca, nil)
+			_, streamErr := StreamCRL(crlPath, ca, nil, true)
+			for _, err := range []error{err, streamErr} {
+				if tc.expectErr && (err == nil || !strings.Contains(err.Error(), "lacks the cRLSign key usage")) {
+					t.Errorf("Expected the missing cRLSign key usage to be reported, got %v", err)
+				}
+				if !tc.expectErr && err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func Test_LoadCRLRecoversFromPanic(t *testing.T) {
+	thisUpdate := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	nextUpdate := time.Date(2020, time.February, 1, 0, 0, 0, 0, time.UTC)
+
+	ca, caPrivKey := makeCA(t)
+	crlBytes := makeCRL(t, ca, caPrivKey, thisUpdate, nextUpdate)
+	goodPath := writeTempCRL(t, "Test_LoadCRLRecoversFromPanic", crlBytes)
+	defer os.Remove(goodPath)
+	// A SEQUENCE claiming more content than there is
+	malformedPath := writeTempCRL(t, "Test_LoadCRLRecoversFromPanic", []byte{0x30, 0x84, 0xff, 0xff, 0xff, 0xff, 0x30})
+	defer os.Remove(malformedPath)
+
+	// Stands in for a decoder that indexes past the end of its input
+	defer func() { parseDERCRL = x509.ParseDERCRL }()
+	parseDERCRL = func(aDER []byte) (*pkix.CertificateList, error) {
+		if aDER[1] == 0x84 {
+			length := int(aDER[2])<<24 | int(aDER[3])<<16 | int(aDER[4])<<8 | int(aDER[5])
+			_ = aDER[6+length-1]
+		}
+		return x509.ParseDERCRL(aDER)
+	}
+
+	_, _, err := LoadAndCheckSignatureOfCRL(malformedPath, ca, nil)
+	if err == nil || !strings.Contains(err.Error(), "panic") {
+		t.Fatalf("Expected the panic as an error, got %v", err)
+	}
+
+	// The next CRL is unaffected
+	crl, _, err := LoadAndCheckSignatureOfCRL(goodPath, ca, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crl.TBSCertList.ThisUpdate != thisUpdate {
+		t.Error("This Update didn't match")
+	}
+}
+
+func gzipBytes(t *testing.T, aData []byte) []byte {
+	t.Helper()
+	var gzipped bytes.Buffer
+	gzWriter := gzip.NewWriter(&gzipped)
+	if _, err := gzWriter.Write(aData); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return gzipped.Bytes()
+}
+
+func Test_LoadCRLDecompressionLimit(t *testing.T) {
+	thisUpdate := time.Now().UTC()
+	ca, caPrivKey := makeCA(t)
+	crlBytes := makeCRL(t, ca, caPrivKey, thisUpdate, thisUpdate.AddDate(0, 0, 7))
+	crlPath := writeTempCRL(t, "Test_LoadCRLDecompressionLimit", gzipBytes(t, crlBytes))
+	defer os.Remove(crlPath)
+	// A few kilobytes that expand to a megabyte
+	bomb := gzipBytes(t, make([]byte, 1024*1024))
+	if len(bomb) > 8*1024 {
+		t.Fatalf("Expected the bomb to compress well, got %d bytes", len(bomb))
+	}
+	bombPath := writeTempCRL(t, "Test_LoadCRLDecompressionLimit", bomb)
+	defer os.Remove(bombPath)
+
+	defer func(aMax int64) { MaxDecompressedSize = aMax }(MaxDecompressedSize)
+	MaxDecompressedSize = int64(len(crlBytes))
+
+	if _, _, err := LoadCRL(crlPath); err != nil {
+		t.Errorf("Expected a CRL of exactly the limit to load, got %s", err)
+	}
+	if _, err := StreamCRL(crlPath, ca, nil, true); err != nil {
+		t.Errorf("Expected a CRL of exactly the limit to stream, got %s", err)
+	}
+
+	for _, path := range []string{crlPath, bombPath} {
+		MaxDecompressedSize = int64(len(crlBytes)) - 1
+		_, _, err := LoadCRL(path)
+		if err == nil || !strings.Contains(err.Error(), "decompresses to more than") {
+			t.Errorf("Expected LoadCRL of %s to fail over the limit, got %v", path, err)
+		}
+		_, err = StreamCRL(path, ca, nil, true)
+		if err == nil || !strings.Contains(err.Error(), "decompresses to more than") {
+			t.Errorf("Expected StreamCRL of %s to fail over the limit, got %v", path, err)
+		}
+	}
+}
+
+func Test_decodeTBSCertListMalformed(t *testing.T) {
+	for _, der := range [][]byte{
+		{},
+		{0x30},
+		{0x30, 0x84, 0xff, 0xff, 0xff, 0xff},
+		{0x30, 0x03, 0x02, 0x01},
+	} {
+		if _, err := decodeTBSCertList(der); err == nil {
+			t.Errorf("Expected an error decoding %x", der)
+		}
+	}
+}
+
+func Test_CertFingerprint(t *testing.T) {
+	ca, _ := makeCAWithKeyId(t, []byte{0x0a, 0x1b})
+	digest := sha256.Sum256(ca.Raw)
+	expected := "sha256=" + strings.ToUpper(hex.EncodeToString(digest[:])) + " ski=0A1B"
+	if fp := CertFingerprint(ca); fp != expected {
+		t.Errorf("Expected %s, got %s", expected, fp)
+	}
+
+	ca, _ = makeCA(t)
+	if fp := CertFingerprint(ca); !strings.HasSuffix(fp, " ski=none") {
+		t.Errorf("Expected no SKI, got %s", fp)
+	}
+}
+
+func Test_Preprocessor(t *testing.T) {
+	defer func() {
+		Preprocessor = nil
+	}()
+
+	thisUpdate := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	nextUpdate := time.Date(2020, time.February, 1, 0, 0, 0, 0, time.UTC)
+
+	ca, caPrivKey := makeCA(t)
+	crlBytes := makeCRLWithRevocations(t, ca, caPrivKey, thisUpdate, nextUpdate,
+		[]pkix.RevokedCertificate{{SerialNumber: big.NewInt(7), RevocationTime: thisUpdate}})
+	wrappedPath := writeTempCRL(t, "preprocessor", []byte(base64.StdEncoding.EncodeToString(crlBytes)))
+	defer os.Remove(wrappedPath)
+
+	if _, _, err := LoadAndCheckSignatureOfCRL(wrappedPath, ca, nil); err == nil {
+		t.Fatal("Expected the wrapped CRL not to parse without a preprocessor")
+	}
+
+	Preprocessor = func(raw []byte) ([]byte, error) {
+		return base64.StdEncoding.DecodeString(string(raw))
+	}
+	crl, shasum, err := LoadAndCheckSignatureOfCRL(wrappedPath, ca, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedSum := sha256.Sum256(crlBytes)
+	if !bytes.Equal(shasum, expectedSum[:]) {
+		t.Errorf("Expected the digest of the unwrapped CRL, got %x", shasum)
+	}
+	serials, _, err := ProcessCRL(crl, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(serials) != 1 || serials[0].String() != storage.NewSerialFromHex("07").String() {
+		t.Errorf("Expected serial 7, got %v", serials)
+	}
+
+	Preprocessor = func(raw []byte) ([]byte, error) {
+		return nil, fmt.Errorf("no key to decrypt with")
+	}
+	_, _, err = LoadAndCheckSignatureOfCRL(wrappedPath, ca, nil)
+	if err == nil || !strings.Contains(err.Error(), "no key to decrypt with") {
+		t.Errorf("Expected the preprocessor's error, got %v", err)
+	}
+}
+
+func Test_InsecureSkipSignature(t *testing.T) {
+	defer func() {
+		InsecureSkipSignature = false
+	}()
+
+	thisUpdate := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	nextUpdate := time.Date(2020, time.February, 1, 0, 0, 0, 0, time.UTC)
+
+	// Signed by a throwaway key, but checked against some other issuer
+	throwawayCa, throwawayKey := makeCA(t)
+	crlBytes := makeCRLWithRevocations(t, throwawayCa, throwawayKey, thisUpdate, nextUpdate,
+		[]pkix.RevokedCertificate{
+			{SerialNumber: big.NewInt(7), RevocationTime: thisUpdate},
+			{SerialNumber: big.NewInt(42), RevocationTime: thisUpdate},
+		})
+	crlPath := writeTempCRL(t, "insecureSkipSignature", crlBytes)
+	defer os.Remove(crlPath)
+
+	ca, _ := makeCA(t)
+	if _, _, err := LoadAndCheckSignatureOfCRL(crlPath, ca, nil); err == nil {
+		t.Fatal("Expected a signature failure by default")
+	}
+
+	InsecureSkipSignature = true
+	crl, _, err := LoadAndCheckSignatureOfCRL(crlPath, ca, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serials, _, err := ProcessCRL(crl, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []storage.Serial{storage.NewSerialFromHex("07"), storage.NewSerialFromHex("2a")}
+	if len(serials) != len(expected) {
+		t.Fatalf("Expected %d serials, got %v", len(expected), serials)
+	}
+	for i, serial := range serials {
+		if serial.String() != expected[i].String() {
+			t.Errorf("Expected serial %s, got %s", expected[i], serial)
+		}
+	}
+}
+
+func Test_ValidityIsStaleAt(t *testing.T) {
+	now := time.Now()
+	freshLocalDate := now.Add(-1 * time.Hour)
+	maxLocalAge := 24 * time.Hour
+	oldLocalDate := now.Add(-2 * maxLocalAge)
+
+	pastNextUpdate := Validity{
+		ThisUpdate: now.AddDate(0, 0, -2),
+		NextUpdate: now.AddDate(0, 0, -1),
+	}
+	if !pastNextUpdate.IsStaleAt(now, freshLocalDate, maxLocalAge) {
+		t.Error("A CRL past its nextUpdate should be stale even if the local file is fresh")
+	}
+
+	futureNextUpdate := Validity{
+		ThisUpdate: now.AddDate(0, 0, -30),
+		NextUpdate: now.AddDate(0, 0, 1),
+	}
+	if futureNextUpdate.IsStaleAt(now, oldLocalDate, maxLocalAge) {
+		t.Error("A CRL before its nextUpdate should not be stale even if the local file is old")
+	}
+
+	noNextUpdate := Validity{
+		ThisUpdate: now.AddDate(0, 0, -30),
+	}
+	if noNextUpdate.IsStaleAt(now, freshLocalDate, maxLocalAge) {
+		t.Error("Without a nextUpdate, a fresh local file should not be stale")
+	}
+	if !noNextUpdate.IsStaleAt(now, oldLocalDate, maxLocalAge) {
+		t.Error("Without a nextUpdate, an old local file should be stale")
+	}
+}
+
+func Test_ValidityCheck(t *testing.T) {
+	now := time.Now()
+	testcases := []struct {
+		name      string
+		validity  Validity
+		expectErr bool
+	}{
+		{"ordinary", Validity{ThisUpdate: now.Add(-time.Hour), NextUpdate: now.AddDate(0, 0, 7)}, false},
+		{"no nextUpdate", Validity{ThisUpdate: now.Add(-time.Hour)}, false},
+		{"equal dates", Validity{ThisUpdate: now, NextUpdate: now}, false},
+		{"inverted dates", Validity{ThisUpdate: now.Add(-time.Hour), NextUpdate: now.Add(-2 * time.Hour)}, true},
+		{"within clock skew", Validity{ThisUpdate: now.Add(MaxClockSkew - time.Minute)}, false},
+		{"future-dated", Validity{ThisUpdate: now.Add(MaxClockSkew + time.Minute)}, true},
+	}
+	for _, tc := range testcases {
+		if err := tc.validity.Check(now); (err != nil) != tc.expectErr {
+			t.Errorf("%s: expected an error %v, got %v", tc.name, tc.expectErr, err)
+		}
+	}
+
+	defer func(aSkew time.Duration) { MaxClockSkew = aSkew }(MaxClockSkew)
+	MaxClockSkew = 0
+	if err := (Validity{ThisUpdate: now.AddDate(1, 0, 0)}).Check(now); err != nil {
+		t.Errorf("Expected no limit on thisUpdate with a MaxClockSkew of 0, got %v", err)
+	}
+}
+
+func Test_ProcessCRLRejectsBadDates(t *testing.T) {
+	ca, caPrivKey := makeCA(t)
+	now := time.Now().UTC()
+
+	testcases := []struct {
+		name       string
+		thisUpdate time.Time
+		nextUpdate time.Time
+	}{
+		{"inverted dates", now, now.AddDate(0, 0, -1)},
+		{"future-dated", now.Add(MaxClockSkew + time.Hour), now.Add(MaxClockSkew).AddDate(0, 0, 7)},
+	}
+	for _, tc := range testcases {
+		crlPath := writeTempCRL(t, "badDates", makeCRL(t, ca, caPrivKey, tc.thisUpdate, tc.nextUpdate))
+		defer os.Remove(crlPath)
+
+		crl, _, err := LoadAndCheckSignatureOfCRL(crlPath, ca, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err = ProcessCRL(crl, ca); err == nil {
+			t.Errorf("%s: expected ProcessCRL to reject the CRL", tc.name)
+		}
+		if _, err = StreamCRL(crlPath, ca, nil, true); err == nil {
+			t.Errorf("%s: expected StreamCRL to reject the CRL", tc.name)
+		}
+	}
+}
+
+// CreateCRL always picks SHA-256 for an ECDSA key, so assemble a SHA-1 CRL
+// by hand.
+func makeSHA1CRL(t *testing.T, ca *x509.Certificate, caPrivKey interface{}, thisUpdate time.Time,
+	nextUpdate time.Time) []byte {
+	t.Helper()
+
+	sigAlgo := pkix.AlgorithmIdentifier{
+		Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}, // ecdsa-with-SHA1
+	}
+
+	tbsCertList := pkix.TBSCertificateList{
+		Version:    1,
+		Signature:  sigAlgo,
+		Issuer:     ca.Subject.ToRDNSequence(),
+		ThisUpdate: thisUpdate.UTC(),
+		NextUpdate: nextUpdate.UTC(),
+	}
+
+	tbsBytes, err := asn1.Marshal(tbsCertList)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha1.Sum(tbsBytes)
+	signature, err := caPrivKey.(*ecdsa.PrivateKey).Sign(rand.Reader, digest[:], crypto.SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crlBytes, err := asn1.Marshal(pkix.CertificateList{
+		TBSCertList:        tbsCertList,
+		SignatureAlgorithm: sigAlgo,
+		SignatureValue:     asn1.BitString{Bytes: signature, BitLength: len(signature) * 8},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return crlBytes
+}
+
+func Test_LoadAndCheckSignatureOfCRLAlgorithms(t *testing.T) {
+	thisUpdate := time.Now().AddDate(0, 0, -1)
+	nextUpdate := time.Now().AddDate(0, 0, 1)
+
+	ca, caPrivKey := makeCA(t)
+	sha1Path := writeTempCRL(t, "sha1CRL", makeSHA1CRL(t, ca, caPrivKey, thisUpdate, nextUpdate))
+	defer os.Remove(sha1Path)
+	sha256Path := writeTempCRL(t, "sha256CRL", makeCRL(t, ca, caPrivKey, thisUpdate, nextUpdate))
+	defer os.Remove(sha256Path)
+
+	defer func() {
+		AllowedSignatureAlgorithms = nil
+	}()
+
+	// The default accepts anything with a valid signature
+	AllowedSignatureAlgorithms = nil
+	for _, path := range []string{sha1Path, sha256Path} {
+		if _, _, err := LoadAndCheckSignatureOfCRL(path, ca, nil); err != nil {
+			t.Errorf("Expected %s to be accepted by default: %s", path, err)
+		}
+	}
+
+	var err error
+	AllowedSignatureAlgorithms, err = ParseSignatureAlgorithms("ecdsa-sha256, SHA256-RSA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := LoadAndCheckSignatureOfCRL(sha256Path, ca, nil); err != nil {
+		t.Errorf("Expected the SHA-256 CRL to be accepted: %s", err)
+	}
+
+	_, _, err = LoadAndCheckSignatureOfCRL(sha1Path, ca, nil)
+	if err == nil {
+		t.Fatal("Expected the SHA-1 CRL to be rejected")
+	}
+	if !strings.Contains(err.Error(), "ECDSA-SHA1 is not in the allowlist") {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func Test_ParseSignatureAlgorithms(t *testing.T) {
+	allowed, err := ParseSignatureAlgorithms("")
+	if err != nil || allowed != nil {
+		t.Errorf("Expected an empty list to be permissive, got %v, %s", allowed, err)
+	}
+
+	if _, err = ParseSignatureAlgorithms("SHA256-RSA,ROT13"); err == nil {
+		t.Error("Expected an unknown algorithm to be an error")
+	}
+}
+
+func Test_pemCRL(t *testing.T) {
+	ca, caPrivKey := makeCA(t)
+
+	thisUpdate := time.Now().UTC()
+	derBytes := makeCRLWithRevocations(t, ca, caPrivKey, thisUpdate, thisUpdate.AddDate(0, 0, 1),
+		[]pkix.RevokedCertificate{
+			{SerialNumber: big.NewInt(7), RevocationTime: thisUpdate},
+			{SerialNumber: big.NewInt(42), RevocationTime: thisUpdate},
+		})
+	pemBytes := append([]byte("\n"), pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: derBytes})...)
+
+	derPath := writeTempCRL(t, "derCrl", derBytes)
+	defer os.Remove(derPath)
+	pemPath := writeTempCRL(t, "pemCrl", pemBytes)
+	defer os.Remove(pemPath)
+
+	loadSerials := func(path string) ([]storage.Serial, []byte) {
+		t.Helper()
+		crl, shasum, err := LoadAndCheckSignatureOfCRL(path, ca, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serials, _, err := ProcessCRL(crl, ca)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return serials, shasum
+	}
+
+	derSerials, derSum := loadSerials(derPath)
+	pemSerials, pemSum := loadSerials(pemPath)
+
+	if len(derSerials) != 2 {
+		t.Errorf("Expected 2 serials, got %v", derSerials)
+	}
+	if !reflect.DeepEqual(derSerials, pemSerials) {
+		t.Errorf("Expected identical serials, DER=%v PEM=%v", derSerials, pemSerials)
+	}
+	if !bytes.Equal(derSum, pemSum) {
+		t.Error("Expected the PEM CRL to hash the same as its DER form")
+	}
+
+	wrongTypePath := writeTempCRL(t, "pemCert",
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes}))
+	defer os.Remove(wrongTypePath)
+	if _, _, err := LoadAndCheckSignatureOfCRL(wrongTypePath, ca, nil); err == nil {
+		t.Error("Expected a non-CRL PEM block to be rejected")
+	}
+}
+
+func numberedCRL(t *testing.T, number int64) *pkix.CertificateList {
+	t.Helper()
+	value, err := asn1.Marshal(big.NewInt(number))
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl := &pkix.CertificateList{}
+	crl.TBSCertList.Extensions = []pkix.Extension{{Id: oidExtensionCRLNumber, Value: value}}
+	return crl
+}
+
+func Test_CRLNumber(t *testing.T) {
+	if number, err := CRLNumber(&pkix.CertificateList{}); err != nil || number != nil {
+		t.Errorf("Expected no CRL number, got %v, %v", number, err)
+	}
+
+	if number, err := CRLNumber(numberedCRL(t, 1234)); err != nil || number.Int64() != 1234 {
+		t.Errorf("Expected CRL number 1234, got %v, %v", number, err)
+	}
+
+	malformed := &pkix.CertificateList{}
+	malformed.TBSCertList.Extensions = []pkix.Extension{{Id: oidExtensionCRLNumber, Value: []byte{0x05}}}
+	if _, err := CRLNumber(malformed); err == nil {
+		t.Error("Expected a malformed CRL number to be an error")
+	}
+}
+
+func Test_CheckCRLNumberNotRegressed(t *testing.T) {
+	unnumbered := &pkix.CertificateList{}
+
+	if err := CheckCRLNumberNotRegressed(numberedCRL(t, 5), numberedCRL(t, 5)); err != nil {
+		t.Errorf("Expected an unchanged number to pass: %s", err)
+	}
+	if err := CheckCRLNumberNotRegressed(numberedCRL(t, 6), numberedCRL(t, 5)); err != nil {
+		t.Errorf("Expected an increased number to pass: %s", err)
+	}
+	err := CheckCRLNumberNotRegressed(numberedCRL(t, 4), numberedCRL(t, 5))
+	if err == nil || !strings.Contains(err.Error(), "lower than the previous") {
+		t.Errorf("Expected a decreased number to fail, got %v", err)
+	}
+	if err := CheckCRLNumberNotRegressed(unnumbered, numberedCRL(t, 5)); err != nil {
+		t.Errorf("Expected an unnumbered CRL to pass: %s", err)
+	}
+	if err := CheckCRLNumberNotRegressed(numberedCRL(t, 4), unnumbered); err != nil {
+		t.Errorf("Expected an unnumbered previous CRL to pass: %s", err)
+	}
+}
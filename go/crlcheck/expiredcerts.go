@@ -0,0 +1,30 @@
+package crlcheck
+
+import (
+	"time"
+
+	"github.com/google/certificate-transparency-go/asn1"
+	"github.com/google/certificate-transparency-go/x509/pkix"
+)
+
+var oidExtensionExpiredCertsOnCRL = asn1.ObjectIdentifier{2, 5, 29, 60}
+
+// Returns the date from the CRL's expiredCertsOnCRL extension (X.509,
+// 9.6.2.9), if it has one: revoked certificates which expired on or after it
+// stay listed. CRLs without it may drop entries once their certificates
+// expire. The date only widens what's listed, so a malformed one is treated
+// as absent.
+func ExpiredCertsOnCRL(aCRL *pkix.CertificateList) (time.Time, bool) {
+	for _, ext := range aCRL.TBSCertList.Extensions {
+		if !ext.Id.Equal(oidExtensionExpiredCertsOnCRL) {
+			continue
+		}
+		var date time.Time
+		rest, err := asn1.UnmarshalWithParams(ext.Value, &date, "generalized")
+		if err != nil || len(rest) > 0 {
+			return time.Time{}, false
+		}
+		return date, true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,36 @@
+package crlcheck
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency-go/asn1"
+	"github.com/google/certificate-transparency-go/x509/pkix"
+)
+
+func Test_ExpiredCertsOnCRL(t *testing.T) {
+	if _, ok := ExpiredCertsOnCRL(&pkix.CertificateList{}); ok {
+		t.Error("Expected a CRL without the extension not to have the date")
+	}
+
+	date := time.Date(2019, time.March, 1, 12, 0, 0, 0, time.UTC)
+	value, err := asn1.MarshalWithParams(date, "generalized")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value[0] != asn1.TagGeneralizedTime {
+		t.Fatalf("Expected a GeneralizedTime, got tag %d", value[0])
+	}
+	crl := &pkix.CertificateList{}
+	crl.TBSCertList.Extensions = []pkix.Extension{{Id: oidExtensionExpiredCertsOnCRL, Value: value}}
+	if parsed, ok := ExpiredCertsOnCRL(crl); !ok || !parsed.Equal(date) {
+		t.Errorf("Expected %s, got %s, %v", date, parsed, ok)
+	}
+
+	for _, malformed := range [][]byte{{0x05, 0x00}, append(value, 0x00)} {
+		crl.TBSCertList.Extensions = []pkix.Extension{{Id: oidExtensionExpiredCertsOnCRL, Value: malformed}}
+		if _, ok := ExpiredCertsOnCRL(crl); ok {
+			t.Errorf("Expected malformed value %x to be treated as absent", malformed)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package crlcheck
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/google/certificate-transparency-go/asn1"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/certificate-transparency-go/x509/pkix"
+	"github.com/mozilla/crlite/go"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+var (
+	oidExtensionIssuingDistributionPoint = asn1.ObjectIdentifier{2, 5, 29, 28}
+	oidExtensionCertificateIssuer        = asn1.ObjectIdentifier{2, 5, 29, 29}
+	oidExtensionAuthorityKeyId           = asn1.ObjectIdentifier{2, 5, 29, 35}
+)
+
+const kGeneralNameDirectoryNameTag = 4
+
+// Finds CA certificates for locating the signer of a CRL, when that isn't the
+// issuer whose revocations are being collected.
+type SignerLookup interface {
+	GetCertificatesForSubject(aRawSubject []byte) []*x509.Certificate
+	GetCertificateForIssuerByAKI(aKeyId []byte) (*x509.Certificate, error)
+}
+
+// RFC 5280, 4.2.1.1
+type authorityKeyIdentifier struct {
+	KeyIdentifier []byte `asn1:"optional,tag:0"`
+}
+
+// Returns the key identifier from the CRL's Authority Key Identifier
+// extension, or nil if there isn't one
+func AuthorityKeyId(aCRL *pkix.CertificateList) []byte {
+	for _, ext := range aCRL.TBSCertList.Extensions {
+		if !ext.Id.Equal(oidExtensionAuthorityKeyId) {
+			continue
+		}
+		var aki authorityKeyIdentifier
+		if _, err := asn1.Unmarshal(ext.Value, &aki); err != nil {
+			return nil
+		}
+		return aki.KeyIdentifier
+	}
+	return nil
+}
+
+// RFC 5280, 5.2.5
+type issuingDistributionPoint struct {
+	DistributionPoint          asn1.RawValue  `asn1:"optional,tag:0"`
+	OnlyContainsUserCerts      bool           `asn1:"optional,tag:1"`
+	OnlyContainsCACerts        bool           `asn1:"optional,tag:2"`
+	OnlySomeReasons            asn1.BitString `asn1:"optional,tag:3"`
+	IndirectCRL                bool           `asn1:"optional,tag:4"`
+	OnlyContainsAttributeCerts bool           `asn1:"optional,tag:5"`
+}
+
+// Returns the CRL's Issuing Distribution Point, or nil if it hasn't one
+func parseIssuingDistributionPoint(aCRL *pkix.CertificateList) (*issuingDistributionPoint, error) {
+	for _, ext := range aCRL.TBSCertList.Extensions {
+		if !ext.Id.Equal(oidExtensionIssuingDistributionPoint) {
+			continue
+		}
+		var idp issuingDistributionPoint
+		if _, err := asn1.Unmarshal(ext.Value, &idp); err != nil {
+			return nil, fmt.Errorf("Malformed issuing distribution point: %s", err)
+		}
+		return &idp, nil
+	}
+	return nil, nil
+}
+
+// Reports whether the CRL's Issuing Distribution Point marks it as indirect
+func IsIndirectCRL(aCRL *pkix.CertificateList) (bool, error) {
+	idp, err := parseIssuingDistributionPoint(aCRL)
+	if err != nil || idp == nil {
+		return false, err
+	}
+	return idp.IndirectCRL, nil
+}
+
+// Reports whether the CRL's Issuing Distribution Point scopes it to attribute
+// certificates only. Such a CRL says nothing about the issuer's public key
+// certificates, even when it's empty.
+func IsAttributeCertCRL(aCRL *pkix.CertificateList) (bool, error) {
+	idp, err := parseIssuingDistributionPoint(aCRL)
+	if err != nil || idp == nil {
+		return false, err
+	}
+	return idp.OnlyContainsAttributeCerts, nil
+}
+
+// Returns the DER directoryName from a Certificate Issuer entry extension
+// (RFC 5280, 5.3.3)
+func directoryNameFromCertificateIssuer(aValue []byte) ([]byte, error) {
+	var names []asn1.RawValue
+	if _, err := asn1.Unmarshal(aValue, &names); err != nil {
+		return nil, fmt.Errorf("Malformed certificate issuer: %s", err)
+	}
+	for _, name := range names {
+		if name.Class == asn1.ClassContextSpecific && name.Tag == kGeneralNameDirectoryNameTag {
+			return name.Bytes, nil
+		}
+	}
+	return nil, fmt.Errorf("Certificate issuer has no directoryName")
+}
+
+// Checks the CRL's signature against the issuer's own certificate, or else
+// against another certificate with the CRL's issuer name. That's allowed for
+// an indirect CRL, or when the CA has rotated to a new key under the same name
+// (RFC 5280, 6.3.3 (f)). Among those, the certificate named by the CRL's
+// Authority Key Identifier is preferred. A CRL under the issuer's name whose
+// AKI names another key is that key's, so it's rejected for this issuer.
+func checkCRLSignature(aCRL *pkix.CertificateList, aIssuerCert *x509.Certificate,
+	aSigners SignerLookup) error {
+	check := func(aSigner *x509.Certificate) error {
+		if err := checkCanSignCRLs(aSigner); err != nil {
+			return err
+		}
+		return aSigner.CheckCRLSignature(aCRL)
+	}
+	err := check(aIssuerCert)
+	if err == nil || aSigners == nil {
+		return err
+	}
+
+	tbsCertList, decodeErr := decodeTBSCertList(aCRL.TBSCertList.Raw)
+	if decodeErr != nil {
+		return err
+	}
+
+	return checkOtherSigners(err, aCRL, tbsCertList.Issuer.FullBytes, aIssuerCert, aSigners, check)
+}
+
+// A certificate whose key usage doesn't include cRLSign mustn't sign CRLs
+// (RFC 5280, 4.2.1.3), so a CRL that verifies against it was signed with a
+// key that isn't this CA's CRL signing key. Certificates without the key
+// usage extension are unrestricted.
+func checkCanSignCRLs(aSigner *x509.Certificate) error {
+	if aSigner.KeyUsage != 0 && aSigner.KeyUsage&x509.KeyUsageCRLSign == 0 {
+		return fmt.Errorf("Certificate %s lacks the cRLSign key usage", CertFingerprint(aSigner))
+	}
+	return nil
+}
+
+// Having failed with aErr to check the signature against aIssuerCert, tries
+// the CRL's other possible signers with aCheck. Only aCRL's extensions are
+// used, so a streamed CRL can pass just those.
+func checkOtherSigners(aErr error, aCRL *pkix.CertificateList, aCrlIssuer []byte, aIssuerCert *x509.Certificate,
+	aSigners SignerLookup, aCheck func(*x509.Certificate) error) error {
+	indirect, indirectErr := IsIndirectCRL(aCRL)
+	if indirectErr != nil {
+		return aErr
+	}
+	sameName := bytes.Equal(aCrlIssuer, aIssuerCert.RawSubject)
+	if !indirect && !sameName {
+		return aErr
+	}
+
+	aki := AuthorityKeyId(aCRL)
+	if sameName && aki != nil && len(aIssuerCert.SubjectKeyId) > 0 && !bytes.Equal(aki, aIssuerCert.SubjectKeyId) {
+		// Its entries would otherwise be credited to this issuer
+		return fmt.Errorf("CRL is for the key %x under the issuer's name, not the issuer's %x, and %s",
+			aki, aIssuerCert.SubjectKeyId, aErr)
+	}
+
+	if aki != nil {
+		signer, akiErr := aSigners.GetCertificateForIssuerByAKI(aki)
+		if akiErr == nil && bytes.Equal(signer.RawSubject, aCrlIssuer) && aCheck(signer) == nil {
+			return nil
+		}
+	}
+
+	for _, signer := range aSigners.GetCertificatesForSubject(aCrlIssuer) {
+		if aCheck(signer) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("No known signer for CRL, and %s", aErr)
+}
+
+// Returns only the serials which belong to aIssuerCert
+func serialsForIssuer(aCRL *pkix.CertificateList, aRevokedList *types.TBSCertificateListWithRawSerials,
+	aIssuerCert *x509.Certificate) ([]storage.Serial, error) {
+	serials := make([]storage.Serial, 0, len(aRevokedList.RevokedCertificates))
+	err := visitEntriesForIssuer(aCRL, aRevokedList, aIssuerCert, func(aEntry *types.RevokedCertificateWithRawSerial) {
+		serials = append(serials, storage.NewSerialFromDERBytes(aEntry.SerialNumber.Bytes))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return serials, nil
+}
+
+// Calls aVisit with each entry which belongs to aIssuerCert. In an indirect
+// CRL, each entry's issuer is given by the most recent Certificate Issuer
+// entry extension, defaulting to the CRL issuer. Direct CRLs are wholly
+// attributed to aIssuerCert.
+func visitEntriesForIssuer(aCRL *pkix.CertificateList, aRevokedList *types.TBSCertificateListWithRawSerials,
+	aIssuerCert *x509.Certificate, aVisit func(*types.RevokedCertificateWithRawSerial)) error {
+	indirect, err := IsIndirectCRL(aCRL)
+	if err != nil {
+		return err
+	}
+
+	entryIssuer := aRevokedList.Issuer.FullBytes
+	for i := range aRevokedList.RevokedCertificates {
+		ent := &aRevokedList.RevokedCertificates[i]
+		if indirect {
+			entryIssuer, err = issuerOfEntry(*ent, entryIssuer)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(entryIssuer, aIssuerCert.RawSubject) {
+				continue
+			}
+		}
+		aVisit(ent)
+	}
+	return nil
+}
+
+// Returns the issuer of an indirect CRL's entry: that named by its Certificate
+// Issuer extension, or else aPrevious, the issuer of the entry before it
+func issuerOfEntry(aEntry types.RevokedCertificateWithRawSerial, aPrevious []byte) ([]byte, error) {
+	issuer := aPrevious
+	for _, ext := range aEntry.Extensions {
+		// The entry extensions are decoded with encoding/asn1
+		if !asn1.ObjectIdentifier(ext.Id).Equal(oidExtensionCertificateIssuer) {
+			continue
+		}
+		var err error
+		issuer, err = directoryNameFromCertificateIssuer(ext.Value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return issuer, nil
+}
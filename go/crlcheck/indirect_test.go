@@ -0,0 +1,333 @@
+package crlcheck
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"math/big"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency-go/asn1"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/certificate-transparency-go/x509/pkix"
+	"github.com/mozilla/crlite/go/rootprogram"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+type indirectEntry struct {
+	serial int64
+	// If set, adds a Certificate Issuer extension naming this CA
+	certIssuer *x509.Certificate
+}
+
+func makeCRLSignedBy(t *testing.T, signer *x509.Certificate, signerKey interface{}, indirect bool,
+	entries []indirectEntry) []byte {
+	t.Helper()
+
+	now := time.Now().UTC()
+	sigAlgo := pkix.AlgorithmIdentifier{
+		Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}, // ecdsa-with-SHA256
+	}
+
+	revoked := []pkix.RevokedCertificate{}
+	for _, e := range entries {
+		rc := pkix.RevokedCertificate{
+			SerialNumber:   big.NewInt(e.serial),
+			RevocationTime: now,
+		}
+		if e.certIssuer != nil {
+			value, err := asn1.Marshal([]asn1.RawValue{{
+				Class:      asn1.ClassContextSpecific,
+				Tag:        kGeneralNameDirectoryNameTag,
+				IsCompound: true,
+				Bytes:      e.certIssuer.RawSubject,
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rc.Extensions = []pkix.Extension{{Id: asn1.ObjectIdentifier(oidExtensionCertificateIssuer), Value: value}}
+		}
+		revoked = append(revoked, rc)
+	}
+
+	tbsCertList := pkix.TBSCertificateList{
+		Version:             1,
+		Signature:           sigAlgo,
+		Issuer:              signer.Subject.ToRDNSequence(),
+		ThisUpdate:          now,
+		NextUpdate:          now.AddDate(0, 0, 1),
+		RevokedCertificates: revoked,
+	}
+
+	if indirect {
+		idp, err := asn1.Marshal(issuingDistributionPoint{IndirectCRL: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tbsCertList.Extensions = []pkix.Extension{{Id: oidExtensionIssuingDistributionPoint, Critical: true, Value: idp}}
+	}
+
+	tbsBytes, err := asn1.Marshal(tbsCertList)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256(tbsBytes)
+	signature, err := signerKey.(*ecdsa.PrivateKey).Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crlBytes, err := asn1.Marshal(pkix.CertificateList{
+		TBSCertList:        tbsCertList,
+		SignatureAlgorithm: sigAlgo,
+		SignatureValue:     asn1.BitString{Bytes: signature, BitLength: len(signature) * 8},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crlBytes
+}
+
+func serialsAsHex(serials []storage.Serial) []string {
+	list := []string{}
+	for _, s := range serials {
+		list = append(list, s.HexString())
+	}
+	return list
+}
+
+func Test_indirectCRL(t *testing.T) {
+	issuersObj := rootprogram.NewMozillaIssuers()
+
+	signerCa, signerKey := makeCA(t)
+	issuersObj.InsertIssuerFromCertAndPem(signerCa, "")
+
+	// makeCA names every CA alike, so give this one its own subject
+	otherCa, _ := makeCA(t)
+	otherCa.RawSubject, _ = asn1.Marshal(pkix.Name{CommonName: "Another CA"}.ToRDNSequence())
+	issuersObj.InsertIssuerFromCertAndPem(otherCa, "")
+
+	crlBytes := makeCRLSignedBy(t, signerCa, signerKey, true, []indirectEntry{
+		{serial: 1},
+		{serial: 2, certIssuer: otherCa},
+		{serial: 3},
+		{serial: 4, certIssuer: signerCa},
+	})
+	crlPath := writeTempCRL(t, "indirectCrl", crlBytes)
+	defer os.Remove(crlPath)
+
+	if _, _, err := LoadAndCheckSignatureOfCRL(crlPath, otherCa, nil); err == nil {
+		t.Error("Without a signer lookup, the indirect CRL should not verify for the other CA")
+	}
+
+	crl, _, err := LoadAndCheckSignatureOfCRL(crlPath, otherCa, issuersObj)
+	if err != nil {
+		t.Fatalf("The indirect CRL should verify via its signer: %s", err)
+	}
+
+	otherSerials, _, err := ProcessCRL(crl, otherCa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(serialsAsHex(otherSerials), []string{"02", "03"}) {
+		t.Errorf("Expected serials 2 and 3 for the other CA, got %v", serialsAsHex(otherSerials))
+	}
+
+	signerSerials, _, err := ProcessCRL(crl, signerCa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(serialsAsHex(signerSerials), []string{"01", "04"}) {
+		t.Errorf("Expected serials 1 and 4 for the signer, got %v", serialsAsHex(signerSerials))
+	}
+}
+
+func Test_directCRLFromOtherSigner(t *testing.T) {
+	issuersObj := rootprogram.NewMozillaIssuers()
+
+	signerCa, signerKey := makeCA(t)
+	issuersObj.InsertIssuerFromCertAndPem(signerCa, "")
+	otherCa, _ := makeCA(t)
+	otherCa.RawSubject, _ = asn1.Marshal(pkix.Name{CommonName: "Another CA"}.ToRDNSequence())
+	issuersObj.InsertIssuerFromCertAndPem(otherCa, "")
+
+	crlBytes := makeCRLSignedBy(t, signerCa, signerKey, false, []indirectEntry{{serial: 1}})
+	crlPath := writeTempCRL(t, "directCrl", crlBytes)
+	defer os.Remove(crlPath)
+
+	if _, _, err := LoadAndCheckSignatureOfCRL(crlPath, otherCa, issuersObj); err == nil {
+		t.Error("A direct CRL must be signed by the issuer itself")
+	}
+
+	crl, _, err := LoadAndCheckSignatureOfCRL(crlPath, signerCa, issuersObj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serials, _, err := ProcessCRL(crl, signerCa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(serialsAsHex(serials), []string{"01"}) {
+		t.Errorf("Expected serial 1, got %v", serialsAsHex(serials))
+	}
+}
+
+type recordingSignerLookup struct {
+	inner          SignerLookup
+	subjectLookups int
+	keyIdLookups   int
+}
+
+func (r *recordingSignerLookup) GetCertificatesForSubject(aRawSubject []byte) []*x509.Certificate {
+	r.subjectLookups++
+	return r.inner.GetCertificatesForSubject(aRawSubject)
+}
+
+func (r *recordingSignerLookup) GetCertificateForIssuerByAKI(aKeyId []byte) (*x509.Certificate, error) {
+	r.keyIdLookups++
+	return r.inner.GetCertificateForIssuerByAKI(aKeyId)
+}
+
+func Test_sameNameIssuersCRL(t *testing.T) {
+	issuersObj := rootprogram.NewMozillaIssuers()
+
+	// Two issuers sharing a name but not a key, as after a CA rotates its
+	// key. The CRL signed with the new key is only the new key's.
+	oldCa, _ := makeCAWithKeyId(t, []byte{0x01, 0x01})
+	issuersObj.InsertIssuerFromCertAndPem(oldCa, "")
+	newCa, newKey := makeCAWithKeyId(t, []byte{0x02, 0x02})
+	issuersObj.InsertIssuerFromCertAndPem(newCa, "")
+
+	thisUpdate := time.Now().UTC()
+	crlBytes := makeCRLWithRevocations(t, newCa, newKey, thisUpdate, thisUpdate.AddDate(0, 0, 1),
+		[]pkix.RevokedCertificate{{SerialNumber: big.NewInt(7), RevocationTime: thisUpdate}})
+	crlPath := writeTempCRL(t, "sameNameCrl", crlBytes)
+	defer os.Remove(crlPath)
+
+	lookup := &recordingSignerLookup{inner: issuersObj}
+	if _, _, err := LoadAndCheckSignatureOfCRL(crlPath, oldCa, lookup); err == nil {
+		t.Error("A CRL whose AKI names the other key mustn't be credited to the old one")
+	}
+	if lookup.keyIdLookups != 0 || lookup.subjectLookups != 0 {
+		t.Errorf("Expected the CRL rejected without a signer search, got %d AKI and %d subject lookups",
+			lookup.keyIdLookups, lookup.subjectLookups)
+	}
+	if _, err := StreamCRL(crlPath, oldCa, lookup, true); err == nil {
+		t.Error("A streamed CRL whose AKI names the other key mustn't be credited to the old one")
+	}
+
+	crl, _, err := LoadAndCheckSignatureOfCRL(crlPath, newCa, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serials, _, err := ProcessCRL(crl, newCa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(serialsAsHex(serials), []string{"07"}) {
+		t.Errorf("Expected serial 7, got %v", serialsAsHex(serials))
+	}
+}
+
+func Test_rotatedKeyCRL(t *testing.T) {
+	issuersObj := rootprogram.NewMozillaIssuers()
+
+	// A CA that rotated its key, keeping its name, and signs its CRL with
+	// the new key, where the old certificate has no SKI to tell them apart
+	oldCa, _ := makeCAWithKeyId(t, nil)
+	issuersObj.InsertIssuerFromCertAndPem(oldCa, "")
+	newCa, newKey := makeCAWithKeyId(t, []byte{0x02, 0x02})
+	issuersObj.InsertIssuerFromCertAndPem(newCa, "")
+
+	thisUpdate := time.Now().UTC()
+	crlBytes := makeCRLWithRevocations(t, newCa, newKey, thisUpdate, thisUpdate.AddDate(0, 0, 1),
+		[]pkix.RevokedCertificate{{SerialNumber: big.NewInt(7), RevocationTime: thisUpdate}})
+	crlPath := writeTempCRL(t, "rotatedCrl", crlBytes)
+	defer os.Remove(crlPath)
+
+	if _, _, err := LoadAndCheckSignatureOfCRL(crlPath, oldCa, nil); err == nil {
+		t.Error("Without a signer lookup, the old key can't verify the CRL")
+	}
+
+	lookup := &recordingSignerLookup{inner: issuersObj}
+	crl, _, err := LoadAndCheckSignatureOfCRL(crlPath, oldCa, lookup)
+	if err != nil {
+		t.Fatalf("The CRL should verify with the key its AKI names: %s", err)
+	}
+	if lookup.keyIdLookups != 1 || lookup.subjectLookups != 0 {
+		t.Errorf("Expected the AKI to find the signer without a subject search, got %d AKI and %d subject lookups",
+			lookup.keyIdLookups, lookup.subjectLookups)
+	}
+
+	serials, _, err := ProcessCRL(crl, oldCa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(serialsAsHex(serials), []string{"07"}) {
+		t.Errorf("Expected serial 7, got %v", serialsAsHex(serials))
+	}
+}
+
+func Test_rotatedKeyCRLUnknownAKI(t *testing.T) {
+	issuersObj := rootprogram.NewMozillaIssuers()
+
+	oldCa, _ := makeCAWithKeyId(t, nil)
+	issuersObj.InsertIssuerFromCertAndPem(oldCa, "")
+	newCa, newKey := makeCAWithKeyId(t, []byte{0x02, 0x02})
+	issuersObj.InsertIssuerFromCertAndPem(newCa, "")
+	// Same name again, but not the CRL's signer
+	_, unrelatedKey := makeCAWithKeyId(t, []byte{0x03, 0x03})
+
+	thisUpdate := time.Now().UTC()
+
+	// Signed by a key that isn't in the program, but naming one that is
+	newCa.SubjectKeyId = []byte{0x02, 0x02}
+	forgedBytes := makeCRL(t, newCa, unrelatedKey, thisUpdate, thisUpdate.AddDate(0, 0, 1))
+	forgedPath := writeTempCRL(t, "forgedCrl", forgedBytes)
+	defer os.Remove(forgedPath)
+
+	if _, _, err := LoadAndCheckSignatureOfCRL(forgedPath, oldCa, issuersObj); err == nil {
+		t.Error("A CRL signed by an unknown key must not verify")
+	}
+
+	// The right signer, found by subject despite an AKI that's not in the program
+	newCa.SubjectKeyId = []byte{0x09, 0x09}
+	crlBytes := makeCRL(t, newCa, newKey, thisUpdate, thisUpdate.AddDate(0, 0, 1))
+	crlPath := writeTempCRL(t, "unknownAkiCrl", crlBytes)
+	defer os.Remove(crlPath)
+
+	if _, _, err := LoadAndCheckSignatureOfCRL(crlPath, oldCa, issuersObj); err != nil {
+		t.Errorf("Expected a fallback to the subject search: %s", err)
+	}
+}
+
+func Test_IsAttributeCertCRL(t *testing.T) {
+	if attributeOnly, err := IsAttributeCertCRL(&pkix.CertificateList{}); err != nil || attributeOnly {
+		t.Errorf("Expected a CRL without an IDP to cover all certificates, got %v, %v", attributeOnly, err)
+	}
+
+	for _, idp := range []issuingDistributionPoint{{}, {OnlyContainsUserCerts: true}, {IndirectCRL: true},
+		{OnlyContainsAttributeCerts: true}} {
+		value, err := asn1.Marshal(idp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		crl := &pkix.CertificateList{}
+		crl.TBSCertList.Extensions = []pkix.Extension{{Id: oidExtensionIssuingDistributionPoint, Value: value}}
+		attributeOnly, err := IsAttributeCertCRL(crl)
+		if err != nil || attributeOnly != idp.OnlyContainsAttributeCerts {
+			t.Errorf("For %+v, expected %v, got %v, %v", idp, idp.OnlyContainsAttributeCerts, attributeOnly, err)
+		}
+	}
+
+	malformed := &pkix.CertificateList{}
+	malformed.TBSCertList.Extensions = []pkix.Extension{{Id: oidExtensionIssuingDistributionPoint, Value: []byte{0x05}}}
+	if _, err := IsAttributeCertCRL(malformed); err == nil {
+		t.Error("Expected a malformed IDP to be an error")
+	}
+}
@@ -0,0 +1,65 @@
+package crlcheck
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/certificate-transparency-go/asn1"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/certificate-transparency-go/x509/pkix"
+	"github.com/mozilla/crlite/go"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+var oidExtensionInvalidityDate = asn1.ObjectIdentifier{2, 5, 29, 24}
+
+// A revoked serial whose CRL entry gives the time its key was compromised, or
+// it otherwise became invalid, which may be well before it was revoked
+// (RFC 5280, 5.3.2)
+type InvalidityDate struct {
+	Serial         storage.Serial
+	RevocationTime time.Time
+	InvalidityDate time.Time
+}
+
+// Returns the entry's invalidity date, if it has one. The date is only
+// advisory, so a malformed one is treated as absent.
+func entryInvalidityDate(aEntry *types.RevokedCertificateWithRawSerial) (InvalidityDate, bool) {
+	for _, ext := range aEntry.Extensions {
+		// The entry extensions are decoded with encoding/asn1
+		if !asn1.ObjectIdentifier(ext.Id).Equal(oidExtensionInvalidityDate) {
+			continue
+		}
+		var invalidityDate time.Time
+		if _, err := asn1.Unmarshal(ext.Value, &invalidityDate); err != nil {
+			return InvalidityDate{}, false
+		}
+		return InvalidityDate{
+			Serial:         storage.NewSerialFromDERBytes(aEntry.SerialNumber.Bytes),
+			RevocationTime: aEntry.RevocationTime,
+			InvalidityDate: invalidityDate,
+		}, true
+	}
+	return InvalidityDate{}, false
+}
+
+// Returns the invalidity dates of the CRL's entries for aIssuerCert which have
+// them. This decodes the entries again, so is separate from ProcessCRL, which
+// most runs need alone.
+func InvalidityDates(aCRL *pkix.CertificateList, aIssuerCert *x509.Certificate) ([]InvalidityDate, error) {
+	revokedList, err := decodeTBSCertList(aCRL.TBSCertList.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("CRL list couldn't be decoded: %s", err)
+	}
+
+	var dates []InvalidityDate
+	err = visitEntriesForIssuer(aCRL, revokedList, aIssuerCert, func(aEntry *types.RevokedCertificateWithRawSerial) {
+		if date, ok := entryInvalidityDate(aEntry); ok {
+			dates = append(dates, date)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("CRL entries couldn't be attributed: %s", err)
+	}
+	return dates, nil
+}
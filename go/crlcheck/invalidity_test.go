@@ -0,0 +1,75 @@
+package crlcheck
+
+import (
+	"math/big"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency-go/asn1"
+	"github.com/google/certificate-transparency-go/x509/pkix"
+)
+
+func Test_InvalidityDates(t *testing.T) {
+	ca, caPrivKey := makeCA(t)
+	revocationTime := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	// The key was compromised a month before the CA heard of it
+	compromised := revocationTime.AddDate(0, -1, 0)
+
+	invalidityValue, err := asn1.MarshalWithParams(compromised, "generalized")
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked := []pkix.RevokedCertificate{
+		{SerialNumber: big.NewInt(1), RevocationTime: revocationTime},
+		{
+			SerialNumber:   big.NewInt(2),
+			RevocationTime: revocationTime,
+			Extensions:     []pkix.Extension{{Id: oidExtensionInvalidityDate, Value: invalidityValue}},
+		},
+		{
+			SerialNumber:   big.NewInt(3),
+			RevocationTime: revocationTime,
+			Extensions:     []pkix.Extension{{Id: oidExtensionInvalidityDate, Value: []byte{0x18, 0x01}}},
+		},
+	}
+	crlBytes := makeCRLWithRevocations(t, ca, caPrivKey, revocationTime, revocationTime.AddDate(0, 0, 7), revoked)
+	crlPath := writeTempCRL(t, "invalidityDates", crlBytes)
+	defer os.Remove(crlPath)
+
+	crl, _, err := LoadAndCheckSignatureOfCRL(crlPath, ca, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// By default the revocation is all there is, as before
+	serials, _, err := ProcessCRL(crl, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(serialsAsHex(serials), []string{"01", "02", "03"}) {
+		t.Errorf("Expected all three serials, got %v", serialsAsHex(serials))
+	}
+
+	// Only the well-formed invalidity date is given
+	dates, err := InvalidityDates(crl, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dates) != 1 || dates[0].Serial.HexString() != "02" || !dates[0].InvalidityDate.Equal(compromised) ||
+		!dates[0].RevocationTime.Equal(revocationTime) {
+		t.Fatalf("Expected serial 02 invalid from %s, got %+v", compromised, dates)
+	}
+	if !dates[0].InvalidityDate.Before(dates[0].RevocationTime) {
+		t.Error("Expected the invalidity date to precede the revocation")
+	}
+
+	streamed, err := StreamCRL(crlPath, ca, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(streamed.InvalidityDates, dates) {
+		t.Errorf("Expected streaming to give %+v, got %+v", dates, streamed.InvalidityDates)
+	}
+}
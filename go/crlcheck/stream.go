@@ -0,0 +1,575 @@
+package crlcheck
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/google/certificate-transparency-go/asn1"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/certificate-transparency-go/x509/pkix"
+	"github.com/mozilla/crlite/go"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+const (
+	kTagInteger         = 2
+	kTagSequence        = 16
+	kTagUTCTime         = 23
+	kTagGeneralizedTime = 24
+	kTagCrlExtensions   = 0
+
+	// The largest single DER element StreamCRL will hold in memory. Only the
+	// revokedCertificates list grows with the number of revocations, and that
+	// is read one entry at a time.
+	kMaxStreamedElementSize = 16 * 1024 * 1024
+)
+
+// CRL files over this many bytes are read by StreamCRL rather than whole, as
+// the largest CAs' CRLs can otherwise exhaust memory. 0 never streams.
+var StreamingThreshold int64 = 256 * 1024 * 1024
+
+// Reports whether the CRL at aPath is over StreamingThreshold and in a form
+// StreamCRL reads: DER, or gzipped DER. PEM CRLs, and any CRL when there's a
+// Preprocessor, are always read whole.
+func ShouldStream(aPath string) bool {
+	if StreamingThreshold <= 0 || Preprocessor != nil {
+		return false
+	}
+
+	fd, err := os.Open(aPath)
+	if err != nil {
+		return false
+	}
+	defer fd.Close()
+
+	info, err := fd.Stat()
+	if err != nil || info.Size() <= StreamingThreshold {
+		return false
+	}
+
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(fd, magic); err != nil {
+		return false
+	}
+	return magic[0] == 0x30 || (magic[0] == 0x1f && magic[1] == 0x8b)
+}
+
+// What StreamCRL keeps of a CRL
+type StreamedCRL struct {
+	Validity Validity
+	// Of the DER encoding, as from LoadCRL
+	SHA256 []byte
+	// Those revoked for the issuer, if requested
+	Serials []storage.Serial
+	// Of those serials whose entries have one
+	InvalidityDates []InvalidityDate
+	// As from IsAttributeCertCRL
+	AttributeCertsOnly bool
+	// As from ExpiredCertsOnCRL, the zero time if it hasn't one
+	ExpiredCertsOnCRL time.Time
+
+	// The most bytes of the CRL held in memory at once
+	peakBuffered int
+}
+
+// The hash and key type each supported signature algorithm signs with, for
+// checking a signature against a digest computed while streaming
+type streamedSignatureAlgorithm struct {
+	hash  crypto.Hash
+	ecdsa bool
+	pss   bool
+}
+
+var streamedSignatureAlgorithms = map[x509.SignatureAlgorithm]streamedSignatureAlgorithm{
+	x509.SHA1WithRSA:      {hash: crypto.SHA1},
+	x509.SHA256WithRSA:    {hash: crypto.SHA256},
+	x509.SHA384WithRSA:    {hash: crypto.SHA384},
+	x509.SHA512WithRSA:    {hash: crypto.SHA512},
+	x509.SHA256WithRSAPSS: {hash: crypto.SHA256, pss: true},
+	x509.SHA384WithRSAPSS: {hash: crypto.SHA384, pss: true},
+	x509.SHA512WithRSAPSS: {hash: crypto.SHA512, pss: true},
+	x509.ECDSAWithSHA1:    {hash: crypto.SHA1, ecdsa: true},
+	x509.ECDSAWithSHA256:  {hash: crypto.SHA256, ecdsa: true},
+	x509.ECDSAWithSHA384:  {hash: crypto.SHA384, ecdsa: true},
+	x509.ECDSAWithSHA512:  {hash: crypto.SHA512, ecdsa: true},
+}
+
+// Reads DER elements one at a time, so that only the current one is in memory
+type derStream struct {
+	r      *bufio.Reader
+	offset int64
+	// While set, receives every byte read
+	tbs          io.Writer
+	peakBuffered int
+}
+
+type derHeader struct {
+	class    int
+	tag      int
+	compound bool
+	length   int64
+	raw      []byte
+}
+
+func (h derHeader) is(aClass int, aTag int) bool {
+	return h.class == aClass && h.tag == aTag
+}
+
+func (s *derStream) readFull(aLength int64) ([]byte, error) {
+	if aLength > kMaxStreamedElementSize {
+		return nil, fmt.Errorf("DER element of %d bytes at offset %d is too large", aLength, s.offset)
+	}
+	buf := make([]byte, aLength)
+	if _, err := io.ReadFull(s.r, buf); err != nil {
+		return nil, err
+	}
+	s.offset += aLength
+	if len(buf) > s.peakBuffered {
+		s.peakBuffered = len(buf)
+	}
+	if s.tbs != nil {
+		_, _ = s.tbs.Write(buf)
+	}
+	return buf, nil
+}
+
+// Reads a tag and definite length, as DER requires
+func (s *derStream) readHeader() (derHeader, error) {
+	buf, err := s.readFull(2)
+	if err != nil {
+		return derHeader{}, err
+	}
+	h := derHeader{
+		class:    int(buf[0] >> 6),
+		tag:      int(buf[0] & 0x1f),
+		compound: buf[0]&0x20 != 0,
+		raw:      buf,
+	}
+	if h.tag == 0x1f {
+		return derHeader{}, fmt.Errorf("Unsupported high tag number at offset %d", s.offset)
+	}
+
+	if buf[1] < 0x80 {
+		h.length = int64(buf[1])
+		return h, nil
+	}
+	numBytes := int64(buf[1] & 0x7f)
+	if numBytes == 0 || numBytes > 7 {
+		return derHeader{}, fmt.Errorf("Unsupported length encoding at offset %d", s.offset)
+	}
+	lengthBytes, err := s.readFull(numBytes)
+	if err != nil {
+		return derHeader{}, err
+	}
+	for _, b := range lengthBytes {
+		h.length = h.length<<8 | int64(b)
+	}
+	h.raw = append(h.raw, lengthBytes...)
+	return h, nil
+}
+
+// Reads a whole element, returning its header and full encoding
+func (s *derStream) readElement() (derHeader, []byte, error) {
+	h, err := s.readHeader()
+	if err != nil {
+		return derHeader{}, nil, err
+	}
+	content, err := s.readFull(h.length)
+	if err != nil {
+		return derHeader{}, nil, err
+	}
+	return h, append(h.raw, content...), nil
+}
+
+// The parts of a CRL StreamCRL checks once it has read them all
+type streamedParts struct {
+	tbsAlgorithm pkix.AlgorithmIdentifier
+	issuer       []byte
+	thisUpdate   time.Time
+	nextUpdate   time.Time
+	extensions   []pkix.Extension
+	algorithm    pkix.AlgorithmIdentifier
+	signature    []byte
+	// Of the TBSCertList, with tbsAlgorithm's hash; nil if that isn't
+	// supported
+	tbsDigest []byte
+
+	// Those revoked in the CRL, with the indexes of those whose entry
+	// issuer isn't the issuer being collected for
+	serials []storage.Serial
+	foreign []int
+	// With the indexes of their serials
+	invalidityDates   []InvalidityDate
+	invalidityIndexes []int
+	// The first entry issuer which couldn't be decoded, which only matters
+	// if the CRL is indirect
+	attributionErr error
+}
+
+// As parse, but returning any panic from decoding as an error
+func (s *derStream) parseRecovering(aIssuerCert *x509.Certificate, aCollectSerials bool) (parts *streamedParts, err error) {
+	defer recoverMalformed(&err)
+	return s.parse(aIssuerCert, aCollectSerials)
+}
+
+func (s *derStream) parse(aIssuerCert *x509.Certificate, aCollectSerials bool) (*streamedParts, error) {
+	parts := &streamedParts{}
+
+	outer, err := s.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	if !outer.is(asn1.ClassUniversal, kTagSequence) {
+		return nil, fmt.Errorf("CRL is not a SEQUENCE")
+	}
+	end := s.offset + outer.length
+
+	// The signature algorithm, and so the hash, isn't known until a few
+	// elements in, so hold on to the TBSCertList's start until then
+	var tbsStart bytes.Buffer
+	s.tbs = &tbsStart
+	tbs, err := s.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	if !tbs.is(asn1.ClassUniversal, kTagSequence) {
+		return nil, fmt.Errorf("TBSCertList is not a SEQUENCE")
+	}
+	tbsEnd := s.offset + tbs.length
+
+	h, element, err := s.readElement()
+	if err != nil {
+		return nil, err
+	}
+	if h.is(asn1.ClassUniversal, kTagInteger) {
+		if h, element, err = s.readElement(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err = asn1.Unmarshal(element, &parts.tbsAlgorithm); err != nil {
+		return nil, fmt.Errorf("Malformed signature algorithm: %s", err)
+	}
+	var tbsHash hash.Hash
+	if details, ok := streamedSignatureAlgorithms[x509.SignatureAlgorithmFromAI(parts.tbsAlgorithm)]; ok {
+		tbsHash = details.hash.New()
+		_, _ = tbsHash.Write(tbsStart.Bytes())
+		s.tbs = tbsHash
+	} else {
+		s.tbs = nil
+	}
+
+	if _, parts.issuer, err = s.readElement(); err != nil {
+		return nil, err
+	}
+	if _, element, err = s.readElement(); err != nil {
+		return nil, err
+	}
+	if _, err = asn1.Unmarshal(element, &parts.thisUpdate); err != nil {
+		return nil, fmt.Errorf("Malformed thisUpdate: %s", err)
+	}
+
+	for s.offset < tbsEnd {
+		h, err := s.readHeader()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case h.is(asn1.ClassUniversal, kTagUTCTime) || h.is(asn1.ClassUniversal, kTagGeneralizedTime):
+			content, err := s.readFull(h.length)
+			if err != nil {
+				return nil, err
+			}
+			if _, err = asn1.Unmarshal(append(h.raw, content...), &parts.nextUpdate); err != nil {
+				return nil, fmt.Errorf("Malformed nextUpdate: %s", err)
+			}
+		case h.is(asn1.ClassUniversal, kTagSequence):
+			if err = s.readEntries(parts, s.offset+h.length, aIssuerCert, aCollectSerials); err != nil {
+				return nil, err
+			}
+		case h.is(asn1.ClassContextSpecific, kTagCrlExtensions):
+			content, err := s.readFull(h.length)
+			if err != nil {
+				return nil, err
+			}
+			if _, err = asn1.Unmarshal(content, &parts.extensions); err != nil {
+				return nil, fmt.Errorf("Malformed CRL extensions: %s", err)
+			}
+		default:
+			return nil, fmt.Errorf("Unexpected element with tag %d in TBSCertList", h.tag)
+		}
+	}
+	if s.offset != tbsEnd {
+		return nil, fmt.Errorf("TBSCertList overruns its length")
+	}
+	s.tbs = nil
+	if tbsHash != nil {
+		parts.tbsDigest = tbsHash.Sum(nil)
+	}
+
+	if _, element, err = s.readElement(); err != nil {
+		return nil, err
+	}
+	if _, err = asn1.Unmarshal(element, &parts.algorithm); err != nil {
+		return nil, fmt.Errorf("Malformed signature algorithm: %s", err)
+	}
+	if _, element, err = s.readElement(); err != nil {
+		return nil, err
+	}
+	var signature asn1.BitString
+	if _, err = asn1.Unmarshal(element, &signature); err != nil {
+		return nil, fmt.Errorf("Malformed signature: %s", err)
+	}
+	parts.signature = signature.RightAlign()
+
+	if s.offset != end {
+		return nil, fmt.Errorf("CRL overruns its length")
+	}
+	if _, err = s.r.ReadByte(); err != io.EOF {
+		return nil, fmt.Errorf("Trailing data after CRL")
+	}
+	return parts, nil
+}
+
+// Reads revokedCertificates, which ends at aEnd, one entry at a time. Whether
+// the CRL is indirect isn't known until its extensions, which come after, so
+// each entry's issuer is tracked regardless.
+func (s *derStream) readEntries(aParts *streamedParts, aEnd int64, aIssuerCert *x509.Certificate,
+	aCollectSerials bool) error {
+	entryIssuer := aParts.issuer
+	for s.offset < aEnd {
+		_, element, err := s.readElement()
+		if err != nil {
+			return err
+		}
+		entry, err := types.DecodeRawRevokedCertificate(element)
+		if err != nil {
+			return fmt.Errorf("CRL entry couldn't be decoded: %s", err)
+		}
+		if !aCollectSerials {
+			continue
+		}
+
+		if aParts.attributionErr == nil {
+			entryIssuer, err = issuerOfEntry(*entry, entryIssuer)
+			if err != nil {
+				aParts.attributionErr = err
+			} else if !bytes.Equal(entryIssuer, aIssuerCert.RawSubject) {
+				aParts.foreign = append(aParts.foreign, len(aParts.serials))
+			}
+		}
+		if date, ok := entryInvalidityDate(entry); ok {
+			aParts.invalidityDates = append(aParts.invalidityDates, date)
+			aParts.invalidityIndexes = append(aParts.invalidityIndexes, len(aParts.serials))
+		}
+		aParts.serials = append(aParts.serials, storage.NewSerialFromDERBytes(entry.SerialNumber.Bytes))
+	}
+	if s.offset != aEnd {
+		return fmt.Errorf("Revoked certificates overrun their length")
+	}
+	return nil
+}
+
+// Checks a signature over aDigest, computed with aAlgorithm's hash
+func checkSignatureOfDigest(aCert *x509.Certificate, aAlgorithm streamedSignatureAlgorithm,
+	aDigest []byte, aSignature []byte) error {
+	switch pub := aCert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if aAlgorithm.ecdsa {
+			return fmt.Errorf("ECDSA signature with an RSA key")
+		}
+		if aAlgorithm.pss {
+			return rsa.VerifyPSS(pub, aAlgorithm.hash, aDigest, aSignature,
+				&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return rsa.VerifyPKCS1v15(pub, aAlgorithm.hash, aDigest, aSignature)
+	case *ecdsa.PublicKey:
+		if !aAlgorithm.ecdsa {
+			return fmt.Errorf("RSA signature with an ECDSA key")
+		}
+		var sig struct {
+			R, S *big.Int
+		}
+		rest, err := asn1.Unmarshal(aSignature, &sig)
+		if err != nil || len(rest) > 0 {
+			return fmt.Errorf("Malformed ECDSA signature")
+		}
+		if sig.R.Sign() <= 0 || sig.S.Sign() <= 0 || !ecdsa.Verify(pub, aDigest, sig.R, sig.S) {
+			return fmt.Errorf("ECDSA verification failure")
+		}
+		return nil
+	}
+	return fmt.Errorf("Unsupported public key type %T", aCert.PublicKey)
+}
+
+// As CheckCRL, for a streamed CRL, whose extensions are in aCRL
+func (p *streamedParts) check(aCRL *pkix.CertificateList, aIssuerCert *x509.Certificate,
+	aSigners SignerLookup) error {
+	if err := checkSignatureAlgorithm(p.algorithm); err != nil {
+		return fmt.Errorf("Disallowed signature algorithm on CRL, will not process revocations: %s", err)
+	}
+
+	if InsecureSkipSignature {
+		return nil
+	}
+
+	algo := x509.SignatureAlgorithmFromAI(p.algorithm)
+	details, ok := streamedSignatureAlgorithms[algo]
+	if !ok || p.tbsDigest == nil {
+		return fmt.Errorf("Invalid signature on CRL, will not process revocations: %s can't be checked when streaming",
+			algo)
+	}
+	if x509.SignatureAlgorithmFromAI(p.tbsAlgorithm) != algo {
+		return fmt.Errorf("Invalid signature on CRL, will not process revocations: %s differs from the TBSCertList's %s",
+			algo, x509.SignatureAlgorithmFromAI(p.tbsAlgorithm))
+	}
+
+	verify := func(aSigner *x509.Certificate) error {
+		if err := checkCanSignCRLs(aSigner); err != nil {
+			return err
+		}
+		return checkSignatureOfDigest(aSigner, details, p.tbsDigest, p.signature)
+	}
+	err := verify(aIssuerCert)
+	if err != nil && aSigners != nil {
+		err = checkOtherSigners(err, aCRL, p.issuer, aIssuerCert, aSigners, verify)
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid signature on CRL, will not process revocations: %s", err)
+	}
+	return nil
+}
+
+// As LoadAndCheckSignatureOfCRL followed by ProcessCRL, but reading the CRL
+// at aPath one entry at a time, so memory doesn't grow with its size beyond
+// the serials themselves. Those are only collected if aCollectSerials is set.
+func StreamCRL(aPath string, aIssuerCert *x509.Certificate, aSigners SignerLookup,
+	aCollectSerials bool) (*StreamedCRL, error) {
+	fd, err := os.Open(aPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading CRL, will not process revocations: %s", err)
+	}
+	defer fd.Close()
+
+	var input io.Reader = bufio.NewReader(fd)
+	var decompressed *decompressedLimitReader
+	if magic, err := input.(*bufio.Reader).Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzReader, err := gzip.NewReader(input)
+		if err != nil {
+			return nil, fmt.Errorf("Error decompressing CRL, will not process revocations: %s", err)
+		}
+		defer gzReader.Close()
+		decompressed = limitDecompressed(gzReader)
+		input = decompressed
+	}
+
+	derDigest := sha256.New()
+	stream := &derStream{r: bufio.NewReader(io.TeeReader(input, derDigest))}
+	parts, err := stream.parseRecovering(aIssuerCert, aCollectSerials)
+	if decompressed != nil && decompressed.err != nil {
+		// Rather than the parser's error on the input it cut short
+		err = decompressed.err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing, will not process revocations: %s", err)
+	}
+
+	// Enough of a CertificateList for the extension helpers
+	crl := &pkix.CertificateList{TBSCertList: pkix.TBSCertificateList{Extensions: parts.extensions}}
+	if err = parts.check(crl, aIssuerCert, aSigners); err != nil {
+		return nil, err
+	}
+
+	number, err := CRLNumber(crl)
+	if err != nil {
+		return nil, err
+	}
+
+	serials := parts.serials
+	invalidityDates := parts.invalidityDates
+	indirect, err := IsIndirectCRL(crl)
+	if err != nil {
+		return nil, fmt.Errorf("CRL entries couldn't be attributed: %s", err)
+	}
+	if indirect {
+		if parts.attributionErr != nil {
+			return nil, fmt.Errorf("CRL entries couldn't be attributed: %s", parts.attributionErr)
+		}
+		invalidityDates = invalidityDatesWithout(invalidityDates, parts.invalidityIndexes, parts.foreign)
+		serials = withoutIndexes(serials, parts.foreign)
+	}
+	attributeCertsOnly, err := IsAttributeCertCRL(crl)
+	if err != nil {
+		return nil, err
+	}
+
+	validity := Validity{
+		ThisUpdate: parts.thisUpdate,
+		NextUpdate: parts.nextUpdate,
+		Number:     number,
+	}
+	if err = validity.Check(time.Now()); err != nil {
+		return nil, err
+	}
+
+	expiredCertsOnCRL, _ := ExpiredCertsOnCRL(crl)
+
+	return &StreamedCRL{
+		Validity:           validity,
+		SHA256:             derDigest.Sum(nil),
+		Serials:            serials,
+		InvalidityDates:    invalidityDates,
+		AttributeCertsOnly: attributeCertsOnly,
+		ExpiredCertsOnCRL:  expiredCertsOnCRL,
+		peakBuffered:       stream.peakBuffered,
+	}, nil
+}
+
+// Removes the invalidity dates of the serials at aForeign, given the index of
+// each date's serial in aIndexes. Both are ascending.
+func invalidityDatesWithout(aDates []InvalidityDate, aIndexes []int, aForeign []int) []InvalidityDate {
+	if len(aForeign) == 0 {
+		return aDates
+	}
+	var kept []InvalidityDate
+	next := 0
+	for i, date := range aDates {
+		for next < len(aForeign) && aForeign[next] < aIndexes[i] {
+			next++
+		}
+		if next < len(aForeign) && aForeign[next] == aIndexes[i] {
+			continue
+		}
+		kept = append(kept, date)
+	}
+	return kept
+}
+
+// Removes the serials at aIndexes, which are ascending, in place
+func withoutIndexes(aSerials []storage.Serial, aIndexes []int) []storage.Serial {
+	if len(aIndexes) == 0 {
+		return aSerials
+	}
+	kept := aSerials[:0]
+	next := 0
+	for i, serial := range aSerials {
+		if next < len(aIndexes) && aIndexes[next] == i {
+			next++
+			continue
+		}
+		kept = append(kept, serial)
+	}
+	return kept
+}
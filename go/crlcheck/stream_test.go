@@ -0,0 +1,200 @@
+package crlcheck
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/rsa"
+	"math/big"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency-go/asn1"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/certificate-transparency-go/x509/pkix"
+	"github.com/mozilla/crlite/go/rootprogram"
+)
+
+func makeRSACA(t *testing.T) (*x509.Certificate, interface{}) {
+	t.Helper()
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().Unix()),
+		Subject:               pkix.Name{CommonName: "RSA CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+	}
+	caPrivKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caBytes, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caPrivKey.PublicKey, caPrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca, caPrivKey
+}
+
+// Checks that streaming aCrlBytes gives what reading them whole does
+func checkStreamedMatchesLoaded(t *testing.T, aCrlBytes []byte, aIssuerCert *x509.Certificate,
+	aSigners SignerLookup) *StreamedCRL {
+	t.Helper()
+	crlPath := writeTempCRL(t, "streamedCrl", aCrlBytes)
+	defer os.Remove(crlPath)
+
+	crl, sha256sum, err := LoadAndCheckSignatureOfCRL(crlPath, aIssuerCert, aSigners)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serials, validity, err := ProcessCRL(crl, aIssuerCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	streamed, err := StreamCRL(crlPath, aIssuerCert, aSigners, true)
+	if err != nil {
+		t.Fatalf("Expected the CRL to stream: %s", err)
+	}
+	if !reflect.DeepEqual(serialsAsHex(streamed.Serials), serialsAsHex(serials)) {
+		t.Errorf("Expected serials %v, got %v", serialsAsHex(serials), serialsAsHex(streamed.Serials))
+	}
+	if !streamed.Validity.ThisUpdate.Equal(validity.ThisUpdate) || !streamed.Validity.NextUpdate.Equal(validity.NextUpdate) ||
+		!reflect.DeepEqual(streamed.Validity.Number, validity.Number) {
+		t.Errorf("Expected validity %+v, got %+v", validity, streamed.Validity)
+	}
+	if !bytes.Equal(streamed.SHA256, sha256sum) {
+		t.Errorf("Expected digest %x, got %x", sha256sum, streamed.SHA256)
+	}
+	return streamed
+}
+
+func Test_StreamCRLLarge(t *testing.T) {
+	ca, caPrivKey := makeCA(t)
+	now := time.Now().UTC()
+	revoked := make([]pkix.RevokedCertificate, 50000)
+	for i := range revoked {
+		revoked[i] = pkix.RevokedCertificate{SerialNumber: big.NewInt(int64(i + 1)), RevocationTime: now}
+	}
+	crlBytes := makeCRLWithRevocations(t, ca, caPrivKey, now, now.AddDate(0, 0, 7), revoked)
+
+	streamed := checkStreamedMatchesLoaded(t, crlBytes, ca, nil)
+	if len(streamed.Serials) != len(revoked) {
+		t.Errorf("Expected %d serials, got %d", len(revoked), len(streamed.Serials))
+	}
+	// Holding no more than an entry or name at a time, however large the CRL
+	if streamed.peakBuffered > 1024 || len(crlBytes) < 1024*1024 {
+		t.Errorf("Expected at most 1KiB of a %d byte CRL in memory at once, held %d", len(crlBytes),
+			streamed.peakBuffered)
+	}
+
+	var gzipped bytes.Buffer
+	gzWriter := gzip.NewWriter(&gzipped)
+	if _, err := gzWriter.Write(crlBytes); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checkStreamedMatchesLoaded(t, gzipped.Bytes(), ca, nil)
+
+	// Without collecting the serials, only the CRL's validity and digest
+	crlPath := writeTempCRL(t, "streamedCrl", crlBytes)
+	defer os.Remove(crlPath)
+	summary, err := StreamCRL(crlPath, ca, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Serials) != 0 || !bytes.Equal(summary.SHA256, streamed.SHA256) {
+		t.Errorf("Expected only the digest, got %d serials and digest %x", len(summary.Serials), summary.SHA256)
+	}
+}
+
+func Test_StreamCRLSignatures(t *testing.T) {
+	now := time.Now().UTC()
+	revoked := []pkix.RevokedCertificate{{SerialNumber: big.NewInt(42), RevocationTime: now}}
+
+	rsaCa, rsaKey := makeRSACA(t)
+	rsaCrl := makeCRLWithRevocations(t, rsaCa, rsaKey, now, now.AddDate(0, 0, 7), revoked)
+	checkStreamedMatchesLoaded(t, rsaCrl, rsaCa, nil)
+
+	ca, caPrivKey := makeCA(t)
+	checkStreamedMatchesLoaded(t, makeSHA1CRL(t, ca, caPrivKey, now, now.AddDate(0, 0, 7)), ca, nil)
+
+	ecCrl := makeCRLWithRevocations(t, ca, caPrivKey, now, now.AddDate(0, 0, 7), revoked)
+	for name, test := range map[string]struct {
+		crl    []byte
+		issuer *x509.Certificate
+	}{
+		"wrong issuer": {ecCrl, rsaCa},
+		"tampered":     {bytes.Replace(ecCrl, []byte{0x02, 0x01, 42}, []byte{0x02, 0x01, 43}, 1), ca},
+		"truncated":    {ecCrl[:len(ecCrl)-1], ca},
+		"trailing":     {append(append([]byte{}, ecCrl...), 0), ca},
+	} {
+		crlPath := writeTempCRL(t, "streamedCrl", test.crl)
+		defer os.Remove(crlPath)
+		if _, err := StreamCRL(crlPath, test.issuer, nil, true); err == nil {
+			t.Errorf("Expected the %s CRL to fail", name)
+		}
+	}
+}
+
+func Test_StreamCRLIndirect(t *testing.T) {
+	issuersObj := rootprogram.NewMozillaIssuers()
+
+	signerCa, signerKey := makeCA(t)
+	issuersObj.InsertIssuerFromCertAndPem(signerCa, "")
+	otherCa, _ := makeCA(t)
+	otherCa.RawSubject, _ = asn1.Marshal(pkix.Name{CommonName: "Another CA"}.ToRDNSequence())
+	issuersObj.InsertIssuerFromCertAndPem(otherCa, "")
+
+	crlBytes := makeCRLSignedBy(t, signerCa, signerKey, true, []indirectEntry{
+		{serial: 1},
+		{serial: 2, certIssuer: otherCa},
+		{serial: 3},
+		{serial: 4, certIssuer: signerCa},
+	})
+
+	streamed := checkStreamedMatchesLoaded(t, crlBytes, otherCa, issuersObj)
+	if !reflect.DeepEqual(serialsAsHex(streamed.Serials), []string{"02", "03"}) {
+		t.Errorf("Expected serials 2 and 3 for the other CA, got %v", serialsAsHex(streamed.Serials))
+	}
+	checkStreamedMatchesLoaded(t, crlBytes, signerCa, issuersObj)
+}
+
+func Test_ShouldStream(t *testing.T) {
+	ca, caPrivKey := makeCA(t)
+	crlBytes := makeCRL(t, ca, caPrivKey, time.Now(), time.Now().AddDate(0, 0, 1))
+	crlPath := writeTempCRL(t, "shouldStream", crlBytes)
+	defer os.Remove(crlPath)
+	pemPath := writeTempCRL(t, "shouldStream", []byte("-----BEGIN X509 CRL-----\n"+
+		string(bytes.Repeat([]byte("A"), len(crlBytes)))))
+	defer os.Remove(pemPath)
+
+	defer func(aThreshold int64) {
+		StreamingThreshold = aThreshold
+	}(StreamingThreshold)
+
+	StreamingThreshold = int64(len(crlBytes))
+	if ShouldStream(crlPath) {
+		t.Error("Expected a CRL at the threshold to be read whole")
+	}
+	StreamingThreshold = int64(len(crlBytes)) - 1
+	if !ShouldStream(crlPath) {
+		t.Error("Expected a CRL over the threshold to be streamed")
+	}
+	if ShouldStream(pemPath) {
+		t.Error("Expected a PEM CRL to be read whole")
+	}
+	StreamingThreshold = 0
+	if ShouldStream(crlPath) {
+		t.Error("Expected a threshold of 0 never to stream")
+	}
+}
@@ -12,4 +12,7 @@ type DownloadAuditor interface {
 	FailedDownload(identifier DownloadIdentifier, crlUrl *url.URL, dlTracer *DownloadTracer, err error)
 	FailedVerifyUrl(identifier DownloadIdentifier, crlUrl *url.URL, dlTracer *DownloadTracer, err error)
 	FailedVerifyPath(identifier DownloadIdentifier, crlUrl *url.URL, crlPath string, err error)
+	// Called once per download with the status it finished with, whether or
+	// not it succeeded
+	DownloadFinished(identifier DownloadIdentifier, crlUrl *url.URL, status DownloadStatus)
 }
@@ -0,0 +1,155 @@
+package downloader
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultMaxDownloadSize int64 = 1024 * 1024 * 1024
+)
+
+// Identifies CRLite to CAs, with the module version when the binary was built
+// from a tagged release
+var DefaultUserAgent = "crlite-aggregator/" + buildVersion()
+
+func buildVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+type DownloadOptions struct {
+	// Maximum number of bytes to accept for a single file. Zero or less
+	// disables the limit.
+	MaxSize int64
+
+	// Deadline for each download attempt, from connecting through reading
+	// the body. A timed-out attempt counts against the retries. Zero or less
+	// disables it.
+	Timeout time.Duration
+
+	// Sent as the User-Agent header of every request
+	UserAgent string
+
+	// Set by SetProxy and AddTLSRootsFromPEM. When nil, requests use
+	// http.DefaultTransport, which honors HTTP_PROXY, HTTPS_PROXY and
+	// NO_PROXY.
+	transport http.RoundTripper
+
+	// Set by SetFetcher, by lowercase URL scheme
+	fetchers map[string]Fetcher
+}
+
+func NewDownloadOptions() DownloadOptions {
+	return DownloadOptions{
+		MaxSize:   DefaultMaxDownloadSize,
+		UserAgent: DefaultUserAgent,
+	}
+}
+
+// ParseProxyURL checks that aProxy names an http, https or socks5 proxy.
+func ParseProxyURL(aProxy string) (*url.URL, error) {
+	proxyUrl, err := url.Parse(aProxy)
+	if err != nil {
+		return nil, err
+	}
+	switch proxyUrl.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("Unsupported proxy scheme %q, expected http, https or socks5", proxyUrl.Scheme)
+	}
+	if proxyUrl.Host == "" {
+		return nil, fmt.Errorf("No proxy host in %s", aProxy)
+	}
+	return proxyUrl, nil
+}
+
+// SetProxy sends every request through aProxy, overriding the proxy
+// environment variables, including NO_PROXY. TLS to an https origin is
+// tunneled through the proxy and verified against the origin as usual; an
+// https proxy's own certificate is verified too.
+func (o *DownloadOptions) SetProxy(aProxy *url.URL) {
+	transport := o.cloneTransport()
+	transport.Proxy = http.ProxyURL(aProxy)
+	o.transport = transport
+}
+
+// AddTLSRootsFromPEM trusts the root certificates in the PEM file at aPath,
+// as well as the system's, for TLS to CRL servers and to an https proxy, such
+// as when a TLS-intercepting proxy re-signs them. Verification is otherwise
+// unchanged.
+func (o *DownloadOptions) AddTLSRootsFromPEM(aPath string) error {
+	data, err := ioutil.ReadFile(aPath)
+	if err != nil {
+		return err
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(data) {
+		return fmt.Errorf("No certificates found in %s", aPath)
+	}
+
+	transport := o.cloneTransport()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.RootCAs = roots
+	o.transport = transport
+	return nil
+}
+
+// Returns a copy of the transport to modify, so that copies of these
+// options made earlier aren't affected
+func (o *DownloadOptions) cloneTransport() *http.Transport {
+	if transport, ok := o.transport.(*http.Transport); ok {
+		return transport.Clone()
+	}
+	return http.DefaultTransport.(*http.Transport).Clone()
+}
+
+// SetFetcher routes URLs with the given scheme, such as "ldap", to aFetcher
+// instead of HTTP. Copies of these options made earlier aren't affected.
+func (o *DownloadOptions) SetFetcher(aScheme string, aFetcher Fetcher) {
+	fetchers := make(map[string]Fetcher, len(o.fetchers)+1)
+	for scheme, fetcher := range o.fetchers {
+		fetchers[scheme] = fetcher
+	}
+	fetchers[strings.ToLower(aScheme)] = aFetcher
+	o.fetchers = fetchers
+}
+
+// Returns a context that enforces Timeout on top of ctx
+func (o DownloadOptions) attemptContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, o.Timeout)
+}
+
+func (o DownloadOptions) newRequest(ctx context.Context, aMethod string, aUrl url.URL) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, aMethod, aUrl.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("X-Automated-Tool", "https://github.com/mozilla/crlite")
+	if o.UserAgent != "" {
+		req.Header.Set("User-Agent", o.UserAgent)
+	}
+	return req, nil
+}
+
+func (o DownloadOptions) httpClient() *http.Client {
+	return &http.Client{Transport: o.transport}
+}
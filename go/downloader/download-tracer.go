@@ -2,13 +2,30 @@ package downloader
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
 	"net/http/httptrace"
+	"strings"
+	"sync"
 
-	"github.com/golang/glog"
+	"github.com/mozilla/crlite/go/logging"
 )
 
 type DownloadTracer struct {
 	DNSDone []httptrace.DNSDoneInfo
+
+	// Connection details of the most recent attempt, for diagnosing failures
+	// that only occur over one IP version or with one TLS configuration. The
+	// transport can call these hooks from its dialing goroutines.
+	mutex        sync.Mutex
+	connectFails []string
+	remoteAddr   string
+	tlsDone      bool
+	tlsState     tls.ConnectionState
+	tlsErr       error
 }
 
 func NewDownloadTracer() *DownloadTracer {
@@ -18,13 +35,44 @@ func NewDownloadTracer() *DownloadTracer {
 }
 
 func (da *DownloadTracer) dnsDone(ddi httptrace.DNSDoneInfo) {
-	glog.V(1).Infof("DNS result: %+v", ddi)
+	logging.V(1).Infof("DNS result: %+v", ddi)
 	da.DNSDone = append(da.DNSDone, ddi)
 }
 
+// A TLS handshake can fail before there's a connection for gotConn, so the
+// address is taken from the dial too
+func (da *DownloadTracer) connectDone(network, addr string, err error) {
+	da.mutex.Lock()
+	defer da.mutex.Unlock()
+	if err != nil {
+		logging.V(1).Infof("Connect to %s failed: %s", addr, err)
+		da.connectFails = append(da.connectFails, fmt.Sprintf("connect to %s over %s failed: %s",
+			addr, ipVersion(addr), err))
+		return
+	}
+	da.remoteAddr = addr
+}
+
+func (da *DownloadTracer) gotConn(gci httptrace.GotConnInfo) {
+	da.mutex.Lock()
+	defer da.mutex.Unlock()
+	da.remoteAddr = gci.Conn.RemoteAddr().String()
+}
+
+func (da *DownloadTracer) tlsHandshakeDone(state tls.ConnectionState, err error) {
+	da.mutex.Lock()
+	defer da.mutex.Unlock()
+	da.tlsDone = true
+	da.tlsState = state
+	da.tlsErr = err
+}
+
 func (da *DownloadTracer) Configure(ctx context.Context) context.Context {
 	traceObj := &httptrace.ClientTrace{
-		DNSDone: da.dnsDone,
+		DNSDone:          da.dnsDone,
+		ConnectDone:      da.connectDone,
+		GotConn:          da.gotConn,
+		TLSHandshakeDone: da.tlsHandshakeDone,
 	}
 
 	return httptrace.WithClientTrace(ctx, traceObj)
@@ -49,3 +97,81 @@ func (da *DownloadTracer) Errors() []string {
 	}
 	return results
 }
+
+// Describes the connections made, for appending to download errors: the
+// address connected to and its IP version, any failed connects, and the
+// outcome of the TLS handshake, with the certificate the server presented
+func (da *DownloadTracer) Diagnostics() string {
+	da.mutex.Lock()
+	defer da.mutex.Unlock()
+
+	parts := append([]string{}, da.connectFails...)
+	if da.remoteAddr != "" {
+		parts = append(parts, fmt.Sprintf("connected to %s over %s", da.remoteAddr, ipVersion(da.remoteAddr)))
+	}
+	if da.tlsDone {
+		// The version isn't reported for a handshake that failed, and the
+		// error itself is already in the download error
+		tlsInfo := "TLS handshake failed"
+		if da.tlsErr == nil {
+			tlsInfo = "TLS " + tlsVersionName(da.tlsState.Version)
+		}
+		if subject := peerSubject(da.tlsState, da.tlsErr); subject != "" {
+			tlsInfo += fmt.Sprintf(" with certificate subject [%s]", subject)
+		}
+		parts = append(parts, tlsInfo)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func ipVersion(aAddr string) string {
+	host, _, err := net.SplitHostPort(aAddr)
+	if err != nil {
+		host = aAddr
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return "unknown IP version"
+	case ip.To4() != nil:
+		return "IPv4"
+	default:
+		return "IPv6"
+	}
+}
+
+func tlsVersionName(aVersion uint16) string {
+	switch aVersion {
+	case tls.VersionTLS10:
+		return "1.0"
+	case tls.VersionTLS11:
+		return "1.1"
+	case tls.VersionTLS12:
+		return "1.2"
+	case tls.VersionTLS13:
+		return "1.3"
+	default:
+		return fmt.Sprintf("0x%04x", aVersion)
+	}
+}
+
+// A handshake that fails verification doesn't record the peer's
+// certificates, but the verification error carries the offending one
+func peerSubject(aState tls.ConnectionState, aErr error) string {
+	if len(aState.PeerCertificates) > 0 {
+		return aState.PeerCertificates[0].Subject.String()
+	}
+
+	var hostnameErr x509.HostnameError
+	var authorityErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	switch {
+	case errors.As(aErr, &hostnameErr) && hostnameErr.Certificate != nil:
+		return hostnameErr.Certificate.Subject.String()
+	case errors.As(aErr, &authorityErr) && authorityErr.Cert != nil:
+		return authorityErr.Cert.Subject.String()
+	case errors.As(aErr, &invalidErr) && invalidErr.Cert != nil:
+		return invalidErr.Cert.Subject.String()
+	}
+	return ""
+}
@@ -2,8 +2,16 @@ package downloader
 
 import (
 	"context"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/vbauerster/mpb/v5"
 )
 
 func Test_DownloadTracerBlank(t *testing.T) {
@@ -62,3 +70,76 @@ func Test_SingleLookupError(t *testing.T) {
 		t.Error("Should have no DNS results!")
 	}
 }
+
+func Test_DiagnosticsOfTLSError(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("data"))
+	}))
+	defer ts.Close()
+
+	// The server's certificate is trusted, but names 127.0.0.1 rather than
+	// localhost
+	opts := NewDownloadOptions()
+	opts.transport = ts.Client().Transport
+	testUrl, _ := url.Parse(ts.URL)
+	testUrl.Host = "localhost:" + testUrl.Port()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+	ok, err := DownloadAndVerifyFileSync(context.TODO(), &testVerifier{}, &testAuditor{}, &testIdentifier{},
+		display, *testUrl, filepath.Join(tmpDir, "file"), 0, opts)
+	if ok || err == nil {
+		t.Fatal("Expected the download to fail")
+	}
+
+	for _, expected := range []string{
+		"connected to 127.0.0.1:" + testUrl.Port() + " over IPv4",
+		"TLS handshake failed with certificate subject [" + ts.Certificate().Subject.String() + "]",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q in the error, got: %s", expected, err)
+		}
+	}
+}
+
+func Test_DiagnosticsOfTLSConnection(t *testing.T) {
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	dla := NewDownloadTracer()
+	req, err := http.NewRequestWithContext(dla.Configure(context.Background()), http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	expected := "connected to " + ts.Listener.Addr().String() + " over IPv4, TLS 1.3 with certificate subject [" +
+		ts.Certificate().Subject.String() + "]"
+	if dla.Diagnostics() != expected {
+		t.Errorf("Expected %q, got %q", expected, dla.Diagnostics())
+	}
+}
+
+func Test_ipVersion(t *testing.T) {
+	for addr, expected := range map[string]string{
+		"192.0.2.1:443":    "IPv4",
+		"[2001:db8::1]:80": "IPv6",
+		"[::1]:443":        "IPv6",
+		"example.com:443":  "unknown IP version",
+	} {
+		if result := ipVersion(addr); result != expected {
+			t.Errorf("%s: expected %s, got %s", addr, expected, result)
+		}
+	}
+}
@@ -2,15 +2,18 @@ package downloader
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/golang/glog"
+	"github.com/mozilla/crlite/go/logging"
 	"github.com/vbauerster/mpb/v5"
 	"github.com/vbauerster/mpb/v5/decor"
 )
@@ -23,6 +26,7 @@ const (
 	UpToDate DownloadAction = 2
 )
 
+// GetSizeAndDateOfFile returns the size and modification time of a local file.
 func GetSizeAndDateOfFile(path string) (int64, time.Time, error) {
 	curFile, err := os.Open(path)
 	if err != nil {
@@ -37,80 +41,245 @@ func GetSizeAndDateOfFile(path string) (int64, time.Time, error) {
 	return stat.Size(), stat.ModTime(), nil
 }
 
-func determineAction(client *http.Client, crlUrl url.URL, path string) (DownloadAction, int64, int64) {
-	szOnDisk, localDate, err := GetSizeAndDateOfFile(path)
+// GetRemoteSizeAndDate issues a HEAD request for crlUrl and returns its
+// Content-Length and Last-Modified, for comparison with GetSizeAndDateOfFile.
+// It's an error for the server to omit either header.
+func GetRemoteSizeAndDate(ctx context.Context, crlUrl url.URL, opts DownloadOptions) (int64, time.Time, error) {
+	ctx, cancel := opts.attemptContext(ctx)
+	defer cancel()
+
+	req, err := opts.newRequest(ctx, "HEAD", crlUrl)
 	if err != nil {
-		glog.V(1).Infof("[%s] CREATE: File not on disk: %s ", crlUrl.String(), err)
-		return Create, 0, 0
+		return 0, time.Time{}, err
 	}
-	req, err := http.NewRequest("HEAD", crlUrl.String(), nil)
+
+	resp, err := opts.httpClient().Do(req)
 	if err != nil {
-		return Create, szOnDisk, 0
+		return 0, time.Time{}, err
 	}
-	req.Header.Add("X-Automated-Tool", "https://github.com/mozilla/crlite")
+	resp.Body.Close()
 
-	resp, err := client.Do(req)
+	if resp.StatusCode != http.StatusOK {
+		return 0, time.Time{}, fmt.Errorf("Non-OK status: %s", resp.Status)
+	}
+
+	size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
 	if err != nil {
-		return Create, szOnDisk, 0
+		return 0, time.Time{}, fmt.Errorf("No content length: %s [%s]", err, resp.Header.Get("Content-Length"))
+	}
+
+	lastMod, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("Invalid last-modified: %s [%s]", err, resp.Header.Get("Last-Modified"))
+	}
+
+	return size, lastMod, nil
+}
+
+// Downloads are written to a partial file next to their destination, and
+// only renamed into place once complete, so an interrupted download can be
+// resumed by a later attempt. The validator file holds the ETag or
+// Last-Modified the partial file was fetched under.
+func partialPath(path string) string {
+	return path + ".partial"
+}
+
+func validatorPath(path string) string {
+	return partialPath(path) + ".validator"
+}
+
+// Returns the strong ETag of resp if there is one, otherwise its
+// Last-Modified, suitable for an If-Range header. Weak ETags can't be used to
+// resume.
+func responseValidator(resp *http.Response) string {
+	if eTag := resp.Header.Get("Etag"); eTag != "" && !strings.HasPrefix(eTag, "W/") {
+		return eTag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+func discardPartial(path string) {
+	for _, p := range []string{partialPath(path), validatorPath(path)} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			logging.Warningf("Couldn't remove %s: %s", p, err)
+		}
+	}
+}
+
+// Parses the first byte position out of a "bytes first-last/length"
+// Content-Range header
+func contentRangeStart(contentRange string) (int64, error) {
+	var start, end int64
+	var length string
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%s", &start, &end, &length); err != nil {
+		return 0, fmt.Errorf("Invalid Content-Range [%s]: %s", contentRange, err)
+	}
+	return start, nil
+}
+
+func determineAction(ctx context.Context, opts DownloadOptions, crlUrl url.URL,
+	path string, resumePath string) (DownloadAction, int64, int64, string) {
+	szOnDisk, localDate, err := GetSizeAndDateOfFile(path)
+	haveFile := err == nil
+	szPartial, _, partialErr := GetSizeAndDateOfFile(partialPath(resumePath))
+	if !haveFile && partialErr != nil {
+		logging.V(1).Infof("[%s] CREATE: File not on disk: %s ", crlUrl.String(), err)
+		return Create, 0, 0, ""
+	}
+	req, err := opts.newRequest(ctx, "HEAD", crlUrl)
+	if err != nil {
+		return Create, 0, 0, ""
+	}
+
+	resp, err := opts.httpClient().Do(req)
+	if err != nil {
+		return Create, 0, 0, ""
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		logging.V(1).Infof("[%s] CREATE: HEAD status %s", crlUrl.String(), resp.Status)
+		return Create, 0, 0, ""
 	}
 
 	eTag := resp.Header.Get("Etag")
 	lastMod, err := http.ParseTime(resp.Header.Get("Last-Modified"))
 	if err != nil {
-		glog.V(1).Infof("[%s] CREATE: Invalid last-modified: %s [%s]", crlUrl.String(), err, resp.Header.Get("Last-Modified"))
-		return Create, szOnDisk, 0
+		logging.V(1).Infof("[%s] CREATE: Invalid last-modified: %s [%s]", crlUrl.String(), err, resp.Header.Get("Last-Modified"))
+		return Create, 0, 0, ""
 	}
 	szOnServer, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
 	if err != nil {
-		glog.V(1).Infof("[%s] CREATE: No content length: %s [%s]", crlUrl.String(), err, resp.Header.Get("Content-Length"))
-		return Create, szOnDisk, 0
+		logging.V(1).Infof("[%s] CREATE: No content length: %s [%s]", crlUrl.String(), err, resp.Header.Get("Content-Length"))
+		return Create, 0, 0, ""
+	}
+
+	if haveFile {
+		if localDate.Before(lastMod) {
+			logging.V(1).Infof("[%s] Local Date is before last modified header date, assuming out-of-date", crlUrl.String())
+		} else if szOnServer == szOnDisk {
+			logging.V(1).Infof("[%s] UP TO DATE", crlUrl.String())
+			return UpToDate, szOnDisk, szOnServer, ""
+		}
 	}
 
-	if localDate.Before(lastMod) {
-		glog.V(1).Infof("[%s] CREATE: Local Date is before last modified header date, assuming out-of-date", crlUrl.String())
-		return Create, szOnDisk, szOnServer
+	if partialErr == nil && szPartial > 0 && szPartial < szOnServer {
+		validator := responseValidator(resp)
+		savedValidator, err := ioutil.ReadFile(validatorPath(resumePath))
+		switch {
+		case resp.Header.Get("Accept-Ranges") != "bytes":
+			logging.V(1).Infof("[%s] Accept-Ranges not supported, unable to resume", crlUrl.String())
+		case err != nil || validator == "" || string(savedValidator) != validator:
+			logging.V(1).Infof("[%s] Remote file changed since the partial download, unable to resume", crlUrl.String())
+		default:
+			logging.V(1).Infof("[%s] RESUME: { Partially on disk: %d, Last-Modified: %s, Etag: %s, Length: %d }", crlUrl.String(), szPartial, lastMod.String(), eTag, szOnServer)
+			return Resume, szPartial, szOnServer, validator
+		}
 	}
 
-	if szOnServer == szOnDisk {
-		glog.V(1).Infof("[%s] UP TO DATE", crlUrl.String())
-		return UpToDate, szOnDisk, szOnServer
+	logging.V(1).Infof("[%s] CREATE: Fallthrough", crlUrl.String())
+	return Create, 0, szOnServer, ""
+}
+
+var ErrDownloadTooLarge = errors.New("Download exceeds the maximum size")
+var ErrDownloadTimedOut = errors.New("Download attempt timed out")
+
+// The outcome of a download, for tallying: the HTTP status code of the final
+// response, such as "200" or "404", or the class of error that prevented one.
+type DownloadStatus string
+
+const (
+	StatusTimedOut     DownloadStatus = "timeout"
+	StatusCancelled    DownloadStatus = "cancelled"
+	StatusNetworkError DownloadStatus = "network-error"
+	// A Fetcher, rather than HTTP, retrieved the file
+	StatusFetched DownloadStatus = "fetched"
+	// The URL needs a Fetcher, but none is registered for its scheme
+	StatusUnsupportedScheme DownloadStatus = "unsupported-scheme"
+)
+
+// Makes one attempt, bounded by opts.Timeout
+func downloadAttempt(ctx context.Context, display *mpb.Progress, crlUrl url.URL, path string,
+	resumePath string, opts DownloadOptions) (DownloadStatus, error) {
+	fetcher, err := opts.fetcherFor(crlUrl)
+	if err != nil {
+		return StatusUnsupportedScheme, err
 	}
 
-	if szOnServer > szOnDisk {
-		if resp.Header.Get("Accept-Ranges") == "bytes" {
-			glog.V(1).Infof("[%s] RESUME: { Already on disk: %d %s, Last-Modified: %s, Etag: %s, Length: %d }", crlUrl.String(), szOnDisk, localDate.String(), lastMod.String(), eTag, szOnServer)
-			return Resume, szOnDisk, szOnServer
-		}
+	attemptCtx, cancel := opts.attemptContext(ctx)
+	defer cancel()
 
-		glog.V(1).Infof("[%s] Accept-Ranges not supported, unable to resume", crlUrl.String())
+	var code int
+	if fetcher != nil {
+		err = fetch(attemptCtx, fetcher, crlUrl, path, opts)
+		if err == nil {
+			return StatusFetched, nil
+		}
+	} else {
+		code, err = download(attemptCtx, display, crlUrl, path, resumePath, opts)
 	}
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return StatusCancelled, err
+	case err != nil && attemptCtx.Err() == context.DeadlineExceeded:
+		return StatusTimedOut, fmt.Errorf("%w after %s: %s", ErrDownloadTimedOut, opts.Timeout, err)
+	case code == 0:
+		return StatusNetworkError, err
+	}
+	return DownloadStatus(strconv.Itoa(code)), err
+}
+
+// Reports the bytes of one in-flight download, as an *mpb.Bar does
+type progressBar interface {
+	ProxyReader(r io.Reader) io.ReadCloser
+	SetCurrent(current int64)
+	SetTotal(total int64, complete bool)
+	Abort(drop bool)
+}
 
-	glog.V(1).Infof("[%s] CREATE: Fallthrough", crlUrl.String())
-	return Create, szOnDisk, szOnServer
+// Adds a bar to display for a download of aTotal bytes, or 0 if that isn't
+// known, which is removed once the download completes or fails. Swapped out
+// by tests.
+var newProgressBar = func(display *mpb.Progress, crlUrl url.URL, aTotal int64) progressBar {
+	return display.AddBar(aTotal,
+		mpb.PrependDecorators(
+			decor.Name(crlUrl.String()),
+		),
+		mpb.AppendDecorators(
+			decor.AverageETA(decor.ET_STYLE_GO, decor.WC{W: 14}),
+			decor.CountersKibiByte(" %6.1f / %6.1f"),
+		),
+		mpb.BarRemoveOnComplete(),
+	)
 }
 
-func download(ctx context.Context, display *mpb.Progress, crlUrl url.URL, path string) error {
-	client := &http.Client{}
+// Downloads crlUrl to path by way of the partial file of resumePath, which
+// can be resumed by later attempts. Returns the status code of the final
+// response, or 0 if there wasn't one.
+func download(ctx context.Context, display *mpb.Progress, crlUrl url.URL, path string,
+	resumePath string, opts DownloadOptions) (int, error) {
+	client := opts.httpClient()
 
-	action, offset, size := determineAction(client, crlUrl, path)
+	action, offset, size, validator := determineAction(ctx, opts, crlUrl, path, resumePath)
 
 	if action == UpToDate {
-		return nil
+		return http.StatusOK, nil
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", crlUrl.String(), nil)
+	req, err := opts.newRequest(ctx, "GET", crlUrl)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	req.Header.Add("X-Automated-Tool", "https://github.com/mozilla/crlite")
 	if action == Resume {
-		req.Header.Add("Content-Range", fmt.Sprintf("bytes: %d-%d/%d", offset, size, offset-size))
+		req.Header.Add("Range", fmt.Sprintf("bytes=%d-", offset))
+		// If the file changed since the HEAD, the server sends all of it
+		req.Header.Add("If-Range", validator)
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer resp.Body.Close()
 
@@ -118,101 +287,194 @@ func download(ctx context.Context, display *mpb.Progress, crlUrl url.URL, path s
 	switch resp.StatusCode {
 	case http.StatusPartialContent:
 		// Depending on what the server responds with, we may have to go back to Create
+		if action != Resume {
+			return resp.StatusCode, fmt.Errorf("Unrequested partial content: %s", resp.Header.Get("Content-Range"))
+		}
+		start, err := contentRangeStart(resp.Header.Get("Content-Range"))
+		if err != nil || start != offset {
+			// Start over on the next attempt
+			discardPartial(resumePath)
+			return resp.StatusCode, fmt.Errorf("Couldn't resume at offset %d: Content-Range [%s]", offset,
+				resp.Header.Get("Content-Range"))
+		}
 		outFileParams = os.O_APPEND | os.O_WRONLY
 		action = Resume
-		glog.V(1).Infof("[%s] Successfully resumed download at offset %d", crlUrl.String(), offset)
+		logging.V(1).Infof("[%s] Successfully resumed download at offset %d", crlUrl.String(), offset)
 	case http.StatusOK:
 		outFileParams = os.O_TRUNC | os.O_CREATE | os.O_WRONLY
 		action = Create
 	default:
-		return fmt.Errorf("Non-OK status: %s", resp.Status)
+		return resp.StatusCode, fmt.Errorf("Non-OK status: %s", resp.Status)
+	}
+
+	var existingBytes int64
+	if action == Resume {
+		existingBytes = offset
 	}
 
-	outFile, err := os.OpenFile(path, outFileParams, 0644)
+	if opts.MaxSize > 0 && resp.ContentLength > 0 && existingBytes+resp.ContentLength > opts.MaxSize {
+		return resp.StatusCode, fmt.Errorf("%w: Content-Length %d (with %d already local) is over the limit of %d",
+			ErrDownloadTooLarge, resp.ContentLength, existingBytes, opts.MaxSize)
+	}
+
+	outFile, err := os.OpenFile(partialPath(resumePath), outFileParams, 0644)
 	if err != nil {
-		return err
+		return resp.StatusCode, err
 	}
 	defer outFile.Close()
 
+	if action == Create {
+		if validator = responseValidator(resp); validator != "" {
+			err = ioutil.WriteFile(validatorPath(resumePath), []byte(validator), 0644)
+		} else {
+			err = os.Remove(validatorPath(resumePath))
+		}
+		if err != nil && !os.IsNotExist(err) {
+			logging.Warningf("[%s] Couldn't record the validator of %s, it won't be resumable: %s",
+				crlUrl.String(), path, err)
+		}
+	}
+
 	if ctx.Err() != nil {
-		return ctx.Err()
+		return resp.StatusCode, ctx.Err()
 	}
 
-	// Fpr partial content, resp.ContentLength will
-	// be the partial length.
-	progBar := display.AddBar(resp.ContentLength,
-		mpb.PrependDecorators(
-			decor.Name(crlUrl.String()),
-		),
-		mpb.AppendDecorators(
-			decor.AverageETA(decor.ET_STYLE_GO, decor.WC{W: 14}),
-			decor.CountersKibiByte(" %6.1f / %6.1f"),
-		),
-		mpb.BarRemoveOnComplete(),
-	)
+	// For partial content, resp.ContentLength is only what's left, so a
+	// resumed download's bar counts the bytes already local too
+	var barTotal int64
+	if resp.ContentLength > 0 {
+		barTotal = existingBytes + resp.ContentLength
+	}
+	progBar := newProgressBar(display, crlUrl, barTotal)
+	if existingBytes > 0 {
+		progBar.SetCurrent(existingBytes)
+	}
 
 	defer progBar.Abort(true)
 
 	defer resp.Body.Close()
-	reader := progBar.ProxyReader(resp.Body)
+	var reader io.Reader = progBar.ProxyReader(resp.Body)
+
+	// Servers can omit or misstate Content-Length, so enforce the limit on
+	// the stream itself too. Read one byte past it to detect the overrun.
+	if opts.MaxSize > 0 {
+		reader = io.LimitReader(reader, opts.MaxSize-existingBytes+1)
+	}
 
-	// and copy from reader, propagating errors
+	// and copy from reader, propagating errors. The partial file is kept, so
+	// the next attempt can pick up from where this one stopped.
 	totalBytes, err := io.Copy(outFile, reader)
 	if err != nil {
-		return err
+		return resp.StatusCode, err
+	}
+
+	if opts.MaxSize > 0 && existingBytes+totalBytes > opts.MaxSize {
+		discardPartial(resumePath)
+		return resp.StatusCode, fmt.Errorf("%w: aborted after %d bytes (with %d already local), the limit is %d",
+			ErrDownloadTooLarge, totalBytes, existingBytes, opts.MaxSize)
 	}
 
 	// Sometimes ContentLength is crazy far off.
-	progBar.SetTotal(totalBytes, true)
+	progBar.SetTotal(existingBytes+totalBytes, true)
 
 	if action == Create && size != 0 && totalBytes != size {
-		glog.Warningf("[%s] Didn't seem to download the right number of bytes, expected=%d got %d",
+		logging.Warningf("[%s] Didn't seem to download the right number of bytes, expected=%d got %d",
 			crlUrl.String(), size, totalBytes)
 	}
 
 	if action == Resume && size != 0 && totalBytes+offset != size {
-		glog.Warningf("[%s] Didn't seem to download the right number of bytes, expected=%d got %d with %d already local",
+		logging.Warningf("[%s] Didn't seem to download the right number of bytes, expected=%d got %d with %d already local",
 			crlUrl.String(), size, totalBytes, offset)
 	}
 
+	if err := outFile.Close(); err != nil {
+		return resp.StatusCode, err
+	}
+	if err := moveFile(partialPath(resumePath), path); err != nil {
+		return resp.StatusCode, err
+	}
+	discardPartial(resumePath)
+
 	lastModStr := resp.Header.Get("Last-Modified")
 	// http.TimeFormat is 29 characters
 	if len(lastModStr) < 16 {
-		glog.Infof("[%s] No compliant reported last-modified time, file may expire early: [%s]", crlUrl.String(), lastModStr)
-		return nil
+		logging.Infof("[%s] No compliant reported last-modified time, file may expire early: [%s]", crlUrl.String(), lastModStr)
+		return resp.StatusCode, nil
 	}
 
 	lastMod, err := http.ParseTime(resp.Header.Get("Last-Modified"))
 	if err != nil {
-		glog.Warningf("[%s] Couldn't parse modified time: %s [%s]", crlUrl.String(), err, lastModStr)
-		return nil
+		logging.Warningf("[%s] Couldn't parse modified time: %s [%s]", crlUrl.String(), err, lastModStr)
+		return resp.StatusCode, nil
 	}
 
 	if err := os.Chtimes(path, lastMod, lastMod); err != nil {
-		glog.Warningf("Couldn't set modified time: %s", err)
+		logging.Warningf("Couldn't set modified time: %s", err)
 	}
-	return nil
+	return resp.StatusCode, nil
 }
 
+// Returns the status of the final attempt along with its error, if any.
 func DownloadFileSync(ctx context.Context, display *mpb.Progress, crlUrl url.URL,
-	path string, maxRetries uint) error {
-	glog.V(1).Infof("Downloading %s from %s", path, crlUrl.String())
+	path string, maxRetries uint, opts DownloadOptions) (DownloadStatus, error) {
+	return downloadFileSync(ctx, display, crlUrl, path, path, maxRetries, opts)
+}
+
+// Whether a failed download's partial file is of no use to a later attempt,
+// as retrying won't succeed
+func isUnrecoverable(aStatus DownloadStatus, aErr error) bool {
+	if errors.Is(aErr, ErrDownloadTooLarge) || errors.Is(aErr, ErrUnsupportedScheme) {
+		return true
+	}
+	code, err := strconv.Atoi(string(aStatus))
+	return err == nil && code >= 400 && code < 500 &&
+		code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+}
+
+// As DownloadFileSync, but keeping the partial download beside resumePath
+// rather than path, so that downloads to differing paths, such as unique tmp
+// files, can resume each other. The partial download is removed once it
+// completes, or if it fails in a way retrying won't fix.
+func downloadFileSync(ctx context.Context, display *mpb.Progress, crlUrl url.URL,
+	path string, resumePath string, maxRetries uint, opts DownloadOptions) (DownloadStatus, error) {
+	status, err := downloadWithRetries(ctx, display, crlUrl, path, resumePath, maxRetries, opts)
+	if err != nil && ctx.Err() == nil && isUnrecoverable(status, err) {
+		discardPartial(resumePath)
+	}
+	return status, err
+}
+
+func downloadWithRetries(ctx context.Context, display *mpb.Progress, crlUrl url.URL,
+	path string, resumePath string, maxRetries uint, opts DownloadOptions) (DownloadStatus, error) {
+	logging.V(1).Infof("Downloading %s from %s", path, crlUrl.String())
 
 	var err error
+	var status DownloadStatus
 	var i uint
 
 	for ; i <= maxRetries; i++ {
 		select {
 		case <-ctx.Done():
-			glog.Infof("Signal caught, stopping threads at next opportunity.")
-			return nil
+			logging.Infof("Signal caught, stopping threads at next opportunity.")
+			return StatusCancelled, ctx.Err()
 		default:
-			err = download(ctx, display, crlUrl, path)
+			status, err = downloadAttempt(ctx, display, crlUrl, path, resumePath, opts)
 			if err == nil {
-				return nil
+				return status, nil
+			}
+			if ctx.Err() != nil {
+				// The in-flight request was aborted, don't retry
+				return StatusCancelled, ctx.Err()
+			}
+			if errors.Is(err, ErrDownloadTooLarge) {
+				// Retrying won't make the file any smaller
+				return status, err
+			}
+			if errors.Is(err, ErrUnsupportedScheme) {
+				return status, err
 			}
 		}
-		glog.Infof("Failed to download %s (%d/%d): %s", path, i, maxRetries, err)
+		logging.Infof("Failed to download %s (%d/%d): %s", path, i, maxRetries, err)
 	}
-	return err
+	return status, err
 }
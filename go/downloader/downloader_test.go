@@ -3,13 +3,16 @@ package downloader
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
@@ -33,7 +36,7 @@ func Test_DownloadNotFound(t *testing.T) {
 
 	url, _ := url.Parse(ts.URL)
 
-	err = DownloadFileSync(context.TODO(), display, *url, tmpfile.Name(), 3)
+	_, err = DownloadFileSync(context.TODO(), display, *url, tmpfile.Name(), 3, NewDownloadOptions())
 	if err.Error() != "Non-OK status: 404 Not Found" {
 		t.Error(err)
 	}
@@ -57,7 +60,7 @@ func Test_DownloadOK(t *testing.T) {
 
 	url, _ := url.Parse(ts.URL)
 
-	err = DownloadFileSync(context.TODO(), display, *url, tmpfile.Name(), 1)
+	_, err = DownloadFileSync(context.TODO(), display, *url, tmpfile.Name(), 1, NewDownloadOptions())
 	if err != nil {
 		t.Error(err)
 	}
@@ -111,7 +114,7 @@ func Test_DownloadFailureWithoutRetry(t *testing.T) {
 
 	url, _ := url.Parse(ts.URL)
 
-	err = DownloadFileSync(context.TODO(), display, *url, tmpfile.Name(), 0)
+	_, err = DownloadFileSync(context.TODO(), display, *url, tmpfile.Name(), 0, NewDownloadOptions())
 	if err == nil {
 		t.Error("Should have failed")
 	}
@@ -133,7 +136,7 @@ func Test_DownloadFailureWithRetry(t *testing.T) {
 
 	url, _ := url.Parse(ts.URL)
 
-	err = DownloadFileSync(context.TODO(), display, *url, tmpfile.Name(), 1)
+	_, err = DownloadFileSync(context.TODO(), display, *url, tmpfile.Name(), 1, NewDownloadOptions())
 	if err != nil {
 		t.Error(err)
 	}
@@ -149,109 +152,542 @@ func Test_DownloadFailureWithRetry(t *testing.T) {
 	}
 }
 
-func Test_DownloadResumeNotSupported(t *testing.T) {
-	testcontent := []byte("download resume not supported test file's content\n")
-
-	// Prepare a partially-downloaded file
-	alreadydownloaded := testcontent[:4]
-	downloadedfile, err := ioutil.TempFile("", "Test_DownloadResumeNotSupported.down")
-	if err != nil {
+func writePartialDownload(t *testing.T, path string, content []byte, validator string) {
+	t.Helper()
+	if err := ioutil.WriteFile(partialPath(path), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(validatorPath(path), []byte(validator), 0644); err != nil {
 		t.Fatal(err)
 	}
-	defer os.Remove(downloadedfile.Name())
+}
 
-	if _, err := downloadedfile.Write(alreadydownloaded); err != nil {
+func checkDownloadCompleted(t *testing.T, path string, expected []byte) {
+	t.Helper()
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
 		t.Fatal(err)
 	}
-	if err := downloadedfile.Close(); err != nil {
+	if !bytes.Equal(content, expected) {
+		t.Errorf("File contents not correct: %s", string(content))
+	}
+	for _, p := range []string{partialPath(path), validatorPath(path)} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be cleaned up, got %v", p, err)
+		}
+	}
+}
+
+func Test_DownloadResumeNotSupported(t *testing.T) {
+	testcontent := []byte("download resume not supported test file's content\n")
+	modTime := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	dir, err := ioutil.TempDir("", "Test_DownloadResumeNotSupported")
+	if err != nil {
 		t.Fatal(err)
 	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file.crl")
 
 	// Server always returns the whole file
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			t.Errorf("Unexpected range request: %s", r.Header.Get("Range"))
+		}
+		w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(testcontent)))
 		_, _ = w.Write(testcontent)
 	}))
 	defer ts.Close()
 
+	// Prepare a partially-downloaded file
+	writePartialDownload(t, path, testcontent[:4], modTime.Format(http.TimeFormat))
+
 	display := mpb.New(
 		mpb.WithOutput(ioutil.Discard),
 	)
 
 	url, _ := url.Parse(ts.URL)
 
-	err = DownloadFileSync(context.TODO(), display, *url, downloadedfile.Name(), 1)
+	_, err = DownloadFileSync(context.TODO(), display, *url, path, 1, NewDownloadOptions())
 	if err != nil {
 		t.Error(err)
 	}
 
-	// Check results
-	content, err := ioutil.ReadFile(downloadedfile.Name())
-	if err != nil {
-		t.Error(err)
-	}
+	checkDownloadCompleted(t, path, testcontent)
+}
 
-	if bytes.Equal(content, testcontent) == false {
-		t.Logf("File contents: %s", string(content))
-		t.Error("File contents not appended")
+// Serves content with range support, aborting the first full response
+// partway through the body
+type truncatingHandler struct {
+	mu        sync.Mutex // guards the fields below
+	content   []byte
+	eTag      string
+	truncated bool
+	ranges    []string
+}
+
+func (h *truncatingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	w.Header().Set("Etag", h.eTag)
+	if r.Method == http.MethodGet && r.Header.Get("Range") != "" {
+		h.ranges = append(h.ranges, r.Header.Get("Range"))
+	}
+	if r.Method == http.MethodGet && !h.truncated {
+		h.truncated = true
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", strconv.Itoa(len(h.content)))
+		_, _ = w.Write(h.content[:len(h.content)/2])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
 	}
+	http.ServeContent(w, r, "file.crl", time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+		bytes.NewReader(h.content))
 }
 
 func Test_DownloadResume(t *testing.T) {
-	testcontent := []byte("download resume test file's content\n")
+	testcontent := bytes.Repeat([]byte("download resume test file's content\n"), 100)
 
 	dir, err := ioutil.TempDir("", "Test_DownloadResume")
 	if err != nil {
 		t.Fatal(err)
 	}
-	t.Logf("Dir: %s", dir)
 	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file.crl")
+
+	handler := &truncatingHandler{content: testcontent, eTag: `"v1"`, truncated: true}
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	url, _ := url.Parse(ts.URL)
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
 
-	err = ioutil.WriteFile(filepath.Join(dir, "Test_DownloadNotFound.file"), testcontent, 0644)
+	// A partial download of the same version resumes where it stopped
+	writePartialDownload(t, path, testcontent[:4], `"v1"`)
+	_, err = DownloadFileSync(context.TODO(), display, *url, path, 1, NewDownloadOptions())
 	if err != nil {
+		t.Error(err)
+	}
+	checkDownloadCompleted(t, path, testcontent)
+	if len(handler.ranges) != 1 || handler.ranges[0] != "bytes=4-" {
+		t.Errorf("Expected one resumed request, got %v", handler.ranges)
+	}
+
+	// A partial download of an older version starts over
+	if err := os.Remove(path); err != nil {
 		t.Fatal(err)
 	}
+	handler.ranges = nil
+	writePartialDownload(t, path, []byte("stale"), `"v0"`)
+	_, err = DownloadFileSync(context.TODO(), display, *url, path, 1, NewDownloadOptions())
+	if err != nil {
+		t.Error(err)
+	}
+	checkDownloadCompleted(t, path, testcontent)
+	if len(handler.ranges) != 0 {
+		t.Errorf("Expected no resumed requests, got %v", handler.ranges)
+	}
+}
 
-	// Start the server
-	ts := httptest.NewServer(http.FileServer(http.Dir(dir)))
-	defer ts.Close()
+// Records what a download reports to its progress bar
+type fakeProgressBar struct {
+	mu       sync.Mutex // guards the fields below
+	total    int64
+	current  int64
+	complete bool
+	aborted  bool
+}
 
-	// Prepare a partially-downloaded file
-	alreadydownloaded := testcontent[:4]
-	downloadedfile, err := ioutil.TempFile("", "Test_DownloadNotFound.down")
+func (b *fakeProgressBar) ProxyReader(r io.Reader) io.ReadCloser {
+	return ioutil.NopCloser(&fakeProgressReader{r, b})
+}
+
+func (b *fakeProgressBar) SetCurrent(current int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.current = current
+}
+
+func (b *fakeProgressBar) SetTotal(total int64, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.total, b.complete = total, complete
+}
+
+func (b *fakeProgressBar) Abort(drop bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.aborted = true
+}
+
+type fakeProgressReader struct {
+	r   io.Reader
+	bar *fakeProgressBar
+}
+
+func (r *fakeProgressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.bar.mu.Lock()
+	r.bar.current += int64(n)
+	r.bar.mu.Unlock()
+	return n, err
+}
+
+func Test_DownloadProgress(t *testing.T) {
+	testcontent := bytes.Repeat([]byte("download progress test file's content\n"), 100)
+
+	dir, err := ioutil.TempDir("", "Test_DownloadProgress")
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer os.Remove(downloadedfile.Name())
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file.crl")
+
+	handler := &truncatingHandler{content: testcontent, eTag: `"v1"`, truncated: true}
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	crlUrl, _ := url.Parse(ts.URL)
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
 
-	if _, err := downloadedfile.Write(alreadydownloaded); err != nil {
+	var bars []*fakeProgressBar
+	var initialTotals []int64
+	defer func(aOriginal func(*mpb.Progress, url.URL, int64) progressBar) {
+		newProgressBar = aOriginal
+	}(newProgressBar)
+	newProgressBar = func(_ *mpb.Progress, _ url.URL, aTotal int64) progressBar {
+		bar := &fakeProgressBar{total: aTotal}
+		bars = append(bars, bar)
+		initialTotals = append(initialTotals, aTotal)
+		return bar
+	}
+
+	// A resumed download reports its progress through the whole file
+	writePartialDownload(t, path, testcontent[:4], `"v1"`)
+	if _, err = DownloadFileSync(context.TODO(), display, *crlUrl, path, 0, NewDownloadOptions()); err != nil {
 		t.Fatal(err)
 	}
-	if err := downloadedfile.Close(); err != nil {
+	checkDownloadCompleted(t, path, testcontent)
+
+	if len(bars) != 1 {
+		t.Fatalf("Expected one progress bar, got %d", len(bars))
+	}
+	bar := bars[0]
+	if initialTotals[0] != int64(len(testcontent)) {
+		t.Errorf("Expected a total of %d bytes, got %d", len(testcontent), initialTotals[0])
+	}
+	if bar.current != int64(len(testcontent)) || bar.total != int64(len(testcontent)) || !bar.complete {
+		t.Errorf("Expected %d of %d bytes and completion, got %d of %d, complete=%v", len(testcontent),
+			len(testcontent), bar.current, bar.total, bar.complete)
+	}
+	if !bar.aborted {
+		t.Error("Expected the bar to be removed once the download finished")
+	}
+}
+
+func Test_DownloadResumeTruncated(t *testing.T) {
+	testcontent := bytes.Repeat([]byte("truncated download test file's content\n"), 100)
+
+	dir, err := ioutil.TempDir("", "Test_DownloadResumeTruncated")
+	if err != nil {
 		t.Fatal(err)
 	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file.crl")
+
+	handler := &truncatingHandler{content: testcontent, eTag: `"v1"`}
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
 
-	// Download
 	url, _ := url.Parse(ts.URL)
-	url.Path = "Test_DownloadNotFound.file"
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	// Without retries, the truncated download is left behind
+	_, err = DownloadFileSync(context.TODO(), display, *url, path, 0, NewDownloadOptions())
+	if err == nil {
+		t.Fatal("Expected the truncated download to fail")
+	}
+	partial, err := ioutil.ReadFile(partialPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(partial) == 0 || !bytes.Equal(partial, testcontent[:len(partial)]) {
+		t.Fatalf("Expected a prefix of the content to be kept, got %d bytes", len(partial))
+	}
+
+	// and the next run continues it to completion
+	_, err = DownloadFileSync(context.TODO(), display, *url, path, 0, NewDownloadOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDownloadCompleted(t, path, testcontent)
+	expectedRange := fmt.Sprintf("bytes=%d-", len(partial))
+	if len(handler.ranges) != 1 || handler.ranges[0] != expectedRange {
+		t.Errorf("Expected one request for %s, got %v", expectedRange, handler.ranges)
+	}
+}
+
+func Test_DownloadOversizedContentLength(t *testing.T) {
+	bodyRead := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1048576")
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			bodyRead = true
+		}
+	}))
+	defer ts.Close()
 
 	display := mpb.New(
 		mpb.WithOutput(ioutil.Discard),
 	)
 
-	err = DownloadFileSync(context.TODO(), display, *url, downloadedfile.Name(), 1)
+	tmpfile, err := ioutil.TempFile("", "Test_DownloadOversizedContentLength")
 	if err != nil {
 		t.Error(err)
 	}
+	defer os.Remove(tmpfile.Name())
 
-	// Check result
-	content, err := ioutil.ReadFile(downloadedfile.Name())
+	url, _ := url.Parse(ts.URL)
+
+	opts := NewDownloadOptions()
+	opts.MaxSize = 1024
+
+	_, err = DownloadFileSync(context.TODO(), display, *url, tmpfile.Name(), 3, opts)
+	if !errors.Is(err, ErrDownloadTooLarge) {
+		t.Errorf("Expected a too-large error, got %v", err)
+	}
+	if !bodyRead {
+		t.Error("Expected a GET to have been attempted")
+	}
+
+	content, err := ioutil.ReadFile(tmpfile.Name())
 	if err != nil {
 		t.Error(err)
 	}
+	if len(content) != 0 {
+		t.Errorf("Nothing should have been written, got %d bytes", len(content))
+	}
+}
+
+func Test_DownloadOversizedStream(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Flushing before writing forces a chunked response with no Content-Length
+		w.(http.Flusher).Flush()
+		_, _ = w.Write(bytes.Repeat([]byte("A"), 4096))
+	}))
+	defer ts.Close()
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	tmpfile, err := ioutil.TempFile("", "Test_DownloadOversizedStream")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	url, _ := url.Parse(ts.URL)
+
+	opts := NewDownloadOptions()
+	opts.MaxSize = 1024
+
+	_, err = DownloadFileSync(context.TODO(), display, *url, tmpfile.Name(), 3, opts)
+	if !errors.Is(err, ErrDownloadTooLarge) {
+		t.Errorf("Expected a too-large error, got %v", err)
+	}
+
+	opts.MaxSize = 4096
+	_, err = DownloadFileSync(context.TODO(), display, *url, tmpfile.Name(), 3, opts)
+	if err != nil {
+		t.Errorf("A file exactly at the limit should be fine: %v", err)
+	}
+}
+
+func Test_DownloadCancelledMidTransfer(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000000")
+		if r.Method == "HEAD" {
+			return
+		}
+		_, _ = w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		// Stall the rest of the body until the test is over
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	tmpfile, err := ioutil.TempFile("", "Test_DownloadCancelledMidTransfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer discardPartial(tmpfile.Name())
+
+	url, _ := url.Parse(ts.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	_, err = DownloadFileSync(ctx, display, *url, tmpfile.Name(), 3, NewDownloadOptions())
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancellation error, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Cancellation should abort promptly, took %s", time.Since(start))
+	}
+}
 
-	if bytes.Equal(content, testcontent) == false {
-		t.Logf("File contents: %s", string(content))
-		t.Error("File contents not appended")
+func Test_DownloadAttemptTimeout(t *testing.T) {
+	for _, midBody := range []bool{false, true} {
+		var mutex sync.Mutex
+		gets := 0
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "HEAD" {
+				return
+			}
+			mutex.Lock()
+			gets++
+			mutex.Unlock()
+
+			if midBody {
+				w.Header().Set("Content-Length", "1000000")
+				_, _ = w.Write([]byte("partial"))
+				w.(http.Flusher).Flush()
+			}
+			// Sleep well past the attempt timeout
+			select {
+			case <-time.After(10 * time.Second):
+			case <-r.Context().Done():
+			}
+		}))
+
+		display := mpb.New(
+			mpb.WithOutput(ioutil.Discard),
+		)
+
+		tmpfile, err := ioutil.TempFile("", "Test_DownloadAttemptTimeout")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		url, _ := url.Parse(ts.URL)
+		opts := NewDownloadOptions()
+		opts.Timeout = 100 * time.Millisecond
+
+		start := time.Now()
+		_, err = DownloadFileSync(context.Background(), display, *url, tmpfile.Name(), 2, opts)
+		elapsed := time.Since(start)
+
+		if !errors.Is(err, ErrDownloadTimedOut) {
+			t.Errorf("midBody=%v: Expected a timeout error, got %v", midBody, err)
+		}
+		if elapsed > 3*time.Second {
+			t.Errorf("midBody=%v: Timed-out attempts should fail promptly, took %s", midBody, elapsed)
+		}
+		mutex.Lock()
+		if gets != 3 {
+			t.Errorf("midBody=%v: Expected each of the 3 attempts to time out, got %d requests", midBody, gets)
+		}
+		mutex.Unlock()
+
+		ts.Close()
+		os.Remove(tmpfile.Name())
+		discardPartial(tmpfile.Name())
+	}
+}
+
+func Test_DownloadAlreadyCancelled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Should not have made a request")
+	}))
+	defer ts.Close()
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	url, _ := url.Parse(ts.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := DownloadFileSync(ctx, display, *url, "/nonexistent", 3, NewDownloadOptions())
+	if err != context.Canceled {
+		t.Errorf("Expected a cancellation error, got %v", err)
+	}
+}
+
+func Test_DownloadFileSyncStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			fmt.Fprintln(w, "Hello, client")
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	dir, err := ioutil.TempDir("", "Test_DownloadFileSyncStatus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file.crl")
+
+	serverUrl, _ := url.Parse(ts.URL)
+	for urlPath, expected := range map[string]DownloadStatus{
+		"/ok":          "200",
+		"/missing":     "404",
+		"/unavailable": "503",
+	} {
+		crlUrl := *serverUrl
+		crlUrl.Path = urlPath
+		status, _ := DownloadFileSync(context.TODO(), display, crlUrl, path, 1, NewDownloadOptions())
+		if status != expected {
+			t.Errorf("%s: Expected status %s, got %s", urlPath, expected, status)
+		}
+	}
+
+	// Nothing is listening once the server is closed
+	ts.Close()
+	status, err := DownloadFileSync(context.TODO(), display, *serverUrl, path+".2", 0, NewDownloadOptions())
+	if err == nil || status != StatusNetworkError {
+		t.Errorf("Expected a network error, got %s: %v", status, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if status, _ := DownloadFileSync(ctx, display, *serverUrl, path, 0, NewDownloadOptions()); status != StatusCancelled {
+		t.Errorf("Expected a cancelled status, got %s", status)
 	}
 }
 
@@ -311,3 +747,45 @@ func Test_GetSizeAndDateOfFile(t *testing.T) {
 		t.Error("Timestamp more than a second ago")
 	}
 }
+
+func Test_GetRemoteSizeAndDate(t *testing.T) {
+	lastMod := time.Date(2020, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "HEAD" {
+			t.Errorf("Expected a HEAD request, got %s", r.Method)
+		}
+		switch r.URL.Path {
+		case "/ok":
+			w.Header().Set("Content-Length", "1234")
+			w.Header().Set("Last-Modified", lastMod.Format(http.TimeFormat))
+		case "/nodate":
+			w.Header().Set("Content-Length", "1234")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	okUrl, _ := url.Parse(ts.URL + "/ok")
+	size, date, err := GetRemoteSizeAndDate(context.TODO(), *okUrl, NewDownloadOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 1234 {
+		t.Errorf("Expected a size of 1234, got %d", size)
+	}
+	if !date.Equal(lastMod) {
+		t.Errorf("Expected a date of %s, got %s", lastMod, date)
+	}
+
+	noDateUrl, _ := url.Parse(ts.URL + "/nodate")
+	if _, _, err = GetRemoteSizeAndDate(context.TODO(), *noDateUrl, NewDownloadOptions()); err == nil {
+		t.Error("Expected an error without Last-Modified")
+	}
+
+	notFoundUrl, _ := url.Parse(ts.URL + "/missing")
+	if _, _, err = GetRemoteSizeAndDate(context.TODO(), *notFoundUrl, NewDownloadOptions()); err == nil {
+		t.Error("Expected an error for a 404")
+	}
+}
@@ -0,0 +1,90 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/mozilla/crlite/go/logging"
+)
+
+// Retrieves files over a protocol other than HTTP(S). Register one for a URL
+// scheme with DownloadOptions.SetFetcher.
+type Fetcher interface {
+	// Writes the resource at aUrl to aWriter. For LDAP, that's the
+	// certificateRevocationList;binary attribute of the entry the URL names.
+	Fetch(ctx context.Context, aUrl url.URL, aWriter io.Writer) error
+}
+
+var ErrUnsupportedScheme = errors.New("No fetcher for URL scheme")
+
+// Schemes that CRL distribution points use, but that can only be fetched
+// through a Fetcher
+var fetcherOnlySchemes = map[string]bool{
+	"ldap":  true,
+	"ldaps": true,
+}
+
+// Returns the fetcher to use in place of HTTP for crlUrl, or nil to use HTTP.
+// It's an error if crlUrl's scheme needs a fetcher and none is registered.
+func (o DownloadOptions) fetcherFor(crlUrl url.URL) (Fetcher, error) {
+	scheme := strings.ToLower(crlUrl.Scheme)
+	if fetcher, ok := o.fetchers[scheme]; ok {
+		return fetcher, nil
+	}
+	if fetcherOnlySchemes[scheme] {
+		return nil, fmt.Errorf("%w %s", ErrUnsupportedScheme, scheme)
+	}
+	return nil, nil
+}
+
+// Fetches crlUrl to path with aFetcher, enforcing opts.MaxSize. Unlike HTTP
+// downloads, these can't be resumed, so path is replaced each time.
+func fetch(ctx context.Context, aFetcher Fetcher, crlUrl url.URL, path string, opts DownloadOptions) error {
+	outFile, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	var writer io.Writer = outFile
+	limited := &limitedWriter{w: outFile, remaining: opts.MaxSize}
+	if opts.MaxSize > 0 {
+		writer = limited
+	}
+
+	err = aFetcher.Fetch(ctx, crlUrl, writer)
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	if limited.exceeded {
+		err = fmt.Errorf("%w: more than %d bytes", ErrDownloadTooLarge, opts.MaxSize)
+	}
+	if err != nil {
+		if removeErr := os.Remove(path); removeErr != nil && !os.IsNotExist(removeErr) {
+			logging.Warningf("[%s] Couldn't remove the failed fetch at %s: %s", crlUrl.String(), path, removeErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// Fails writes once more than remaining bytes have been written
+type limitedWriter struct {
+	w         io.Writer
+	remaining int64
+	exceeded  bool
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > lw.remaining {
+		lw.exceeded = true
+		return 0, ErrDownloadTooLarge
+	}
+	n, err := lw.w.Write(p)
+	lw.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,123 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vbauerster/mpb/v5"
+)
+
+// Serves CRLs from a map keyed by the URL's DN, as an LDAP directory would,
+// failing the first failures fetches
+type mockLDAPFetcher struct {
+	entries  map[string][]byte
+	failures int
+	fetches  int
+}
+
+func (f *mockLDAPFetcher) Fetch(ctx context.Context, aUrl url.URL, aWriter io.Writer) error {
+	f.fetches++
+	if f.fetches <= f.failures {
+		return fmt.Errorf("LDAP server busy")
+	}
+	data, ok := f.entries[aUrl.Path]
+	if !ok {
+		return fmt.Errorf("No such object: %s", aUrl.Path)
+	}
+	_, err := aWriter.Write(data)
+	return err
+}
+
+func Test_DownloadFileSyncFetcher(t *testing.T) {
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	dir, err := ioutil.TempDir("", "Test_DownloadFileSyncFetcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file.crl")
+
+	crlUrl, _ := url.Parse("ldap://ldap.example.com/CN=Example%20CA,O=Example?certificateRevocationList;binary")
+	fetcher := &mockLDAPFetcher{
+		entries:  map[string][]byte{"/CN=Example CA,O=Example": []byte("CRL bytes")},
+		failures: 1,
+	}
+
+	// Without a fetcher, LDAP fails immediately
+	status, err := DownloadFileSync(context.TODO(), display, *crlUrl, path, 3, NewDownloadOptions())
+	if !errors.Is(err, ErrUnsupportedScheme) || status != StatusUnsupportedScheme {
+		t.Errorf("Expected an unsupported scheme, got %s: %v", status, err)
+	}
+
+	opts := NewDownloadOptions()
+	opts.SetFetcher("LDAP", fetcher)
+	status, err = DownloadFileSync(context.TODO(), display, *crlUrl, path, 1, opts)
+	if err != nil || status != StatusFetched {
+		t.Fatalf("Expected the fetch to succeed on its retry, got %s: %v", status, err)
+	}
+	if fetcher.fetches != 2 {
+		t.Errorf("Expected 2 fetches, got %d", fetcher.fetches)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil || string(data) != "CRL bytes" {
+		t.Errorf("Expected the CRL to be written, got %q: %v", data, err)
+	}
+
+	// Other schemes still use HTTP, so the fetcher isn't consulted
+	httpUrl, _ := url.Parse("http://127.0.0.1:1/file.crl")
+	status, _ = DownloadFileSync(context.TODO(), display, *httpUrl, path+".http", 0, opts)
+	if status != StatusNetworkError || fetcher.fetches != 2 {
+		t.Errorf("Expected an HTTP network error, got %s after %d fetches", status, fetcher.fetches)
+	}
+
+	missingUrl, _ := url.Parse("ldap://ldap.example.com/CN=Missing")
+	status, err = DownloadFileSync(context.TODO(), display, *missingUrl, path+".missing", 0, opts)
+	if err == nil || status != StatusNetworkError {
+		t.Errorf("Expected a failed fetch, got %s: %v", status, err)
+	}
+	if _, err = os.Stat(path + ".missing"); !os.IsNotExist(err) {
+		t.Errorf("Expected the failed fetch to be removed, got %v", err)
+	}
+}
+
+func Test_DownloadFileSyncFetcherMaxSize(t *testing.T) {
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	dir, err := ioutil.TempDir("", "Test_DownloadFileSyncFetcherMaxSize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file.crl")
+
+	crlUrl, _ := url.Parse("ldaps://ldap.example.com/CN=Big")
+	fetcher := &mockLDAPFetcher{
+		entries: map[string][]byte{"/CN=Big": []byte("0123456789")},
+	}
+	opts := NewDownloadOptions()
+	opts.SetFetcher("ldaps", fetcher)
+	opts.MaxSize = 5
+
+	_, err = DownloadFileSync(context.TODO(), display, *crlUrl, path, 3, opts)
+	if !errors.Is(err, ErrDownloadTooLarge) {
+		t.Errorf("Expected the fetch to be too large, got %v", err)
+	}
+	if fetcher.fetches != 1 {
+		t.Errorf("Expected no retries, got %d fetches", fetcher.fetches)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the oversized fetch to be removed, got %v", err)
+	}
+}
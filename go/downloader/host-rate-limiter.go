@@ -0,0 +1,64 @@
+package downloader
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// HostRateLimiter is a token bucket, holding a single token, per hostname.
+// Each Wait takes a token, and tokens refill at the configured rate, so
+// requests to the same host are spaced at least 1/rate apart.
+type HostRateLimiter struct {
+	mutex       *sync.Mutex
+	interval    time.Duration
+	nextAllowed map[string]time.Time
+}
+
+// A ratePerSecond of zero or less yields a limiter which never waits.
+func NewHostRateLimiter(ratePerSecond float64) *HostRateLimiter {
+	var interval time.Duration
+	if ratePerSecond > 0 {
+		interval = time.Duration(float64(time.Second) / ratePerSecond)
+	}
+	return &HostRateLimiter{
+		mutex:       &sync.Mutex{},
+		interval:    interval,
+		nextAllowed: make(map[string]time.Time),
+	}
+}
+
+func (l *HostRateLimiter) reserve(host string) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	slot, ok := l.nextAllowed[host]
+	if !ok || slot.Before(now) {
+		slot = now
+	}
+	l.nextAllowed[host] = slot.Add(l.interval)
+	return slot.Sub(now)
+}
+
+// Blocks until a request to host is permitted, or ctx is done.
+func (l *HostRateLimiter) Wait(ctx context.Context, host string) error {
+	if l.interval <= 0 {
+		return nil
+	}
+
+	delay := l.reserve(host)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
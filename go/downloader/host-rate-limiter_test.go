@@ -0,0 +1,65 @@
+package downloader
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_HostRateLimiterSpacing(t *testing.T) {
+	limiter := NewHostRateLimiter(10)
+	interval := 100 * time.Millisecond
+
+	start := time.Now()
+	if err := limiter.Wait(context.TODO(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	first := time.Since(start)
+	if first >= interval {
+		t.Errorf("The first request shouldn't wait, took %s", first)
+	}
+
+	if err := limiter.Wait(context.TODO(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	second := time.Since(start)
+	if second < interval {
+		t.Errorf("Requests to the same host should be spaced by %s, got %s", interval, second)
+	}
+
+	otherStart := time.Now()
+	if err := limiter.Wait(context.TODO(), "example.org"); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(otherStart) >= interval {
+		t.Error("Other hosts should not be limited")
+	}
+}
+
+func Test_HostRateLimiterUnlimited(t *testing.T) {
+	limiter := NewHostRateLimiter(0)
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		if err := limiter.Wait(context.TODO(), "example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if time.Since(start) > time.Second {
+		t.Error("An unlimited limiter shouldn't wait")
+	}
+}
+
+func Test_HostRateLimiterCancel(t *testing.T) {
+	limiter := NewHostRateLimiter(0.001)
+
+	if err := limiter.Wait(context.TODO(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.Wait(ctx, "example.com"); err != context.Canceled {
+		t.Errorf("Expected a cancellation, got %v", err)
+	}
+}
@@ -0,0 +1,26 @@
+package downloader
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// WaitJitter blocks for a random time up to aMax, or until ctx is done, so
+// that workers starting together spread their requests out. An aMax of zero
+// or less doesn't wait.
+func WaitJitter(ctx context.Context, aMax time.Duration) error {
+	if aMax <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(aMax))))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,37 @@
+package downloader
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_WaitJitterDisabled(t *testing.T) {
+	start := time.Now()
+	if err := WaitJitter(context.Background(), 0); err != nil {
+		t.Error(err)
+	}
+	if time.Since(start) > 50*time.Millisecond {
+		t.Error("Expected no wait without jitter")
+	}
+}
+
+func Test_WaitJitterBounded(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		start := time.Now()
+		if err := WaitJitter(context.Background(), 20*time.Millisecond); err != nil {
+			t.Error(err)
+		}
+		if time.Since(start) > time.Second {
+			t.Errorf("Waited %s, more than the maximum", time.Since(start))
+		}
+	}
+}
+
+func Test_WaitJitterCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := WaitJitter(ctx, time.Hour); err != context.Canceled {
+		t.Errorf("Expected cancellation, got %v", err)
+	}
+}
@@ -2,33 +2,144 @@ package downloader
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/golang/glog"
+	"github.com/mozilla/crlite/go/logging"
 	"github.com/vbauerster/mpb/v5"
 )
 
+const (
+	tmpFileExtension = ".tmp"
+	// The most the downloader adds to a download's final name, for its tmp
+	// file, which is more than for its partial download and validator
+	MaxSuffixLength = len(".2147483647-ffffffff" + tmpFileExtension)
+)
+
+// Replaced in tests to simulate renames across filesystems
+var renameFile = os.Rename
+
+// Renames aSrc to aDst. If they're on different filesystems, such as when
+// part of the path is a symlink to another mount, aSrc is instead copied to
+// a tmp file beside aDst, synced, and renamed into place, so aDst is still
+// replaced atomically.
+func moveFile(aSrc string, aDst string) error {
+	err := renameFile(aSrc, aDst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	logging.V(1).Infof("Moving %s to %s across filesystems by copying", aSrc, aDst)
+
+	tmpPath := tmpPathFor(aDst)
+	if err = copyAndSync(aSrc, tmpPath); err != nil {
+		os.Remove(tmpPath) // ignore error
+		return err
+	}
+	if err = renameFile(tmpPath, aDst); err != nil {
+		os.Remove(tmpPath) // ignore error
+		return err
+	}
+	return os.Remove(aSrc)
+}
+
+func copyAndSync(aSrc string, aDst string) error {
+	in, err := os.Open(aSrc)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(aDst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close() // ignore error
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		out.Close() // ignore error
+		return err
+	}
+	return out.Close()
+}
+
 type DownloadVerifier interface {
 	IsValid(path string) error
 }
 
+// A tmp path beside aFinalPath unique to this process and download, so that
+// overlapping runs never write to, or verify, each other's partial downloads
+func tmpPathFor(aFinalPath string) string {
+	nonce := make([]byte, 4)
+	_, _ = rand.Read(nonce)
+	return fmt.Sprintf("%s.%d-%x%s", aFinalPath, os.Getpid(), nonce, tmpFileExtension)
+}
+
+// Whether aName is a tmp file, or a partial download and its validator
+func isDownloadLeftover(aName string) bool {
+	for _, suffix := range []string{tmpFileExtension, partialPath(""), validatorPath("")} {
+		if strings.HasSuffix(aName, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Removes the tmp files and partial downloads in aDir left behind by
+// interrupted downloads, such as those of a run that was killed, returning
+// how many were removed. Only those not modified within aOlderThan are taken
+// to be orphaned, as younger ones may be another run's download in progress,
+// or one a later attempt could resume.
+func RemoveOrphanedTmpFiles(aDir string, aOlderThan time.Duration) (int, error) {
+	entries, err := ioutil.ReadDir(aDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	removed := 0
+	cutoff := time.Now().Add(-aOlderThan)
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() || !isDownloadLeftover(entry.Name()) ||
+			entry.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(aDir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
 /*
  * Returns: Boolean of whether the data at finalPath is now valid, and any error. It is possible
  * that err != nil and yet finalPath is valid, so callers should rely on the boolean and merely
  * log the error as needed.
  */
 func DownloadAndVerifyFileSync(ctx context.Context, verifyFunc DownloadVerifier, auditor DownloadAuditor,
-	identifier DownloadIdentifier, display *mpb.Progress, crlUrl url.URL, finalPath string, maxRetries uint) (bool, error) {
+	identifier DownloadIdentifier, display *mpb.Progress, crlUrl url.URL, finalPath string, maxRetries uint,
+	opts DownloadOptions) (bool, error) {
 	dlTracer := NewDownloadTracer()
 	auditCtx := dlTracer.Configure(ctx)
 
-	tmpPath := fmt.Sprintf("%s.tmp", finalPath)
+	tmpPath := tmpPathFor(finalPath)
 	defer func() {
 		removeErr := os.Remove(tmpPath)
 		if removeErr != nil && !os.IsNotExist(removeErr) {
-			glog.Warningf("[%s] Failed to remove invalid tmp file %s: %s", identifier.ID(), tmpPath, removeErr)
+			logging.Warningf("[%s] Failed to remove invalid tmp file %s: %s", identifier.ID(), tmpPath, removeErr)
 		}
 	}()
 
@@ -42,14 +153,28 @@ func DownloadAndVerifyFileSync(ctx context.Context, verifyFunc DownloadVerifier,
 		// and it will be handled later in aggregate-crls if it is relevant at that stage.
 		combinedError := fmt.Errorf("[%s] Couldn't verify already-on-disk path %s. Local error=%s, Caused by=%s",
 			identifier.ID(), finalPath, existingValidErr, err)
-		glog.Error(combinedError)
+		logging.Error(combinedError)
 		return false, combinedError
 	}
 
-	dlErr := DownloadFileSync(auditCtx, display, crlUrl, tmpPath, maxRetries)
+	// The partial download is kept beside finalPath, so a later attempt or
+	// run, with its own tmpPath, can resume it
+	status, dlErr := downloadFileSync(auditCtx, display, crlUrl, tmpPath, finalPath, maxRetries, opts)
+	auditor.DownloadFinished(identifier, &crlUrl, status)
+	if dlErr != nil && ctx.Err() != nil {
+		// Cancelled, which isn't the CA's fault, so don't audit it
+		logging.Infof("[%s] Download from %s cancelled: %s", identifier.ID(), crlUrl.String(), dlErr)
+		return attemptFallbackToExistingFile(dlErr)
+	}
 	if dlErr != nil {
+		// When the server never responded, how it was reached is the likely
+		// culprit, such as a broken IPv6 route or a bad certificate
+		noResponse := status == StatusNetworkError || status == StatusTimedOut
+		if diagnostics := dlTracer.Diagnostics(); noResponse && diagnostics != "" {
+			dlErr = fmt.Errorf("%w (%s)", dlErr, diagnostics)
+		}
 		auditor.FailedDownload(identifier, &crlUrl, dlTracer, dlErr)
-		glog.Warningf("[%s] Failed to download from %s to tmp file %s: %s", identifier.ID(), crlUrl.String(), tmpPath, dlErr)
+		logging.Warningf("[%s] Failed to download from %s to tmp file %s: %s", identifier.ID(), crlUrl.String(), tmpPath, dlErr)
 
 		return attemptFallbackToExistingFile(dlErr)
 	}
@@ -61,9 +186,9 @@ func DownloadAndVerifyFileSync(ctx context.Context, verifyFunc DownloadVerifier,
 		return attemptFallbackToExistingFile(dlValidErr)
 	}
 
-	renameErr := os.Rename(tmpPath, finalPath)
+	renameErr := moveFile(tmpPath, finalPath)
 	if renameErr != nil {
-		glog.Errorf("[%s] Couldn't rename %s to %s: %s", identifier.ID(), tmpPath, finalPath, renameErr)
+		logging.Errorf("[%s] Couldn't rename %s to %s: %s", identifier.ID(), tmpPath, finalPath, renameErr)
 
 		return attemptFallbackToExistingFile(renameErr)
 	}
@@ -1,6 +1,7 @@
 package downloader
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
@@ -8,8 +9,11 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/vbauerster/mpb/v5"
 )
@@ -41,6 +45,20 @@ func (ta *testAuditor) FailedVerifyUrl(issuer DownloadIdentifier, crlUrl *url.UR
 }
 func (ta *testAuditor) FailedVerifyPath(issuer DownloadIdentifier, crlUrl *url.URL, crlPath string, err error) {
 }
+func (ta *testAuditor) DownloadFinished(issuer DownloadIdentifier, crlUrl *url.URL, status DownloadStatus) {
+}
+
+// Downloads to aFinalPath leave none of their tmp files behind
+func checkNoTmpFiles(t *testing.T, aFinalPath string) {
+	t.Helper()
+	matches, err := filepath.Glob(aFinalPath + ".*" + tmpFileExtension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) > 0 {
+		t.Errorf("tmpfile not cleaned up: %v", matches)
+	}
+}
 
 func Test_NotFoundNotLocal(t *testing.T) {
 	ts := httptest.NewServer(http.NotFoundHandler())
@@ -62,7 +80,7 @@ func Test_NotFoundNotLocal(t *testing.T) {
 
 	dataAtPathIsValid, err := DownloadAndVerifyFileSync(ctx, &testVerifier{}, &testAuditor{},
 		&testIdentifier{}, display, *testUrl,
-		tmpfile.Name(), 1)
+		tmpfile.Name(), 1, NewDownloadOptions())
 
 	if err == nil {
 		t.Error("Expected error")
@@ -74,10 +92,7 @@ func Test_NotFoundNotLocal(t *testing.T) {
 		t.Error(err)
 	}
 
-	_, statErr := os.Stat(fmt.Sprintf("%s.tmp", tmpfile.Name()))
-	if statErr == nil {
-		t.Error("tmpfile not cleaned up")
-	}
+	checkNoTmpFiles(t, tmpfile.Name())
 }
 
 func Test_NotFoundButIsLocal(t *testing.T) {
@@ -101,7 +116,7 @@ func Test_NotFoundButIsLocal(t *testing.T) {
 
 	dataAtPathIsValid, err := DownloadAndVerifyFileSync(ctx, &testVerifier{}, &testAuditor{},
 		&testIdentifier{}, display, *testUrl,
-		tmpfile.Name(), 1)
+		tmpfile.Name(), 1, NewDownloadOptions())
 
 	if err == nil {
 		t.Error("Expected error")
@@ -113,10 +128,7 @@ func Test_NotFoundButIsLocal(t *testing.T) {
 		t.Error(err)
 	}
 
-	_, statErr := os.Stat(fmt.Sprintf("%s.tmp", tmpfile.Name()))
-	if statErr == nil {
-		t.Error("tmpfile not cleaned up")
-	}
+	checkNoTmpFiles(t, tmpfile.Name())
 }
 
 func Test_FoundRemoteButNotLocal(t *testing.T) {
@@ -141,7 +153,7 @@ func Test_FoundRemoteButNotLocal(t *testing.T) {
 
 	dataAtPathIsValid, err := DownloadAndVerifyFileSync(ctx, &testVerifier{}, &testAuditor{},
 		&testIdentifier{}, display, *testUrl,
-		tmpfile.Name(), 1)
+		tmpfile.Name(), 1, NewDownloadOptions())
 
 	if err != nil {
 		t.Errorf("Expected no error but got %s", err)
@@ -149,10 +161,7 @@ func Test_FoundRemoteButNotLocal(t *testing.T) {
 	if !dataAtPathIsValid {
 		t.Error("Expected dataAtPathIsValid")
 	}
-	_, statErr := os.Stat(fmt.Sprintf("%s.tmp", tmpfile.Name()))
-	if statErr == nil {
-		t.Error("tmpfile not cleaned up")
-	}
+	checkNoTmpFiles(t, tmpfile.Name())
 }
 
 func Test_FoundRemoteAndAlsoLocal(t *testing.T) {
@@ -178,7 +187,7 @@ func Test_FoundRemoteAndAlsoLocal(t *testing.T) {
 
 	dataAtPathIsValid, err := DownloadAndVerifyFileSync(ctx, &testVerifier{}, &testAuditor{},
 		&testIdentifier{}, display, *testUrl,
-		tmpfile.Name(), 1)
+		tmpfile.Name(), 1, NewDownloadOptions())
 
 	if err != nil {
 		t.Errorf("Expected no error but got %s", err)
@@ -186,8 +195,204 @@ func Test_FoundRemoteAndAlsoLocal(t *testing.T) {
 	if !dataAtPathIsValid {
 		t.Error("Expected dataAtPathIsValid")
 	}
-	_, statErr := os.Stat(fmt.Sprintf("%s.tmp", tmpfile.Name()))
-	if statErr == nil {
-		t.Error("tmpfile not cleaned up")
+	checkNoTmpFiles(t, tmpfile.Name())
+}
+
+func Test_tmpPathFor(t *testing.T) {
+	first := tmpPathFor("/crls/a.crl")
+	second := tmpPathFor("/crls/a.crl")
+	if first == second {
+		t.Errorf("Expected each download its own tmp path, got %s twice", first)
+	}
+	if !strings.HasPrefix(first, fmt.Sprintf("/crls/a.crl.%d-", os.Getpid())) || !strings.HasSuffix(first, tmpFileExtension) {
+		t.Errorf("Unexpected tmp path %s", first)
+	}
+	for _, path := range []string{first, partialPath("/crls/a.crl"), validatorPath("/crls/a.crl")} {
+		if len(path)-len("/crls/a.crl") > MaxSuffixLength {
+			t.Errorf("%s adds more than %d bytes", path, MaxSuffixLength)
+		}
+	}
+}
+
+func Test_RemoveOrphanedTmpFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "Test_RemoveOrphanedTmpFiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := time.Now().Add(-2 * time.Hour)
+	files := map[string]bool{
+		// Orphaned, from older runs with and without unique suffixes
+		"a.crl.tmp":               true,
+		"a.crl.1234-0a0b0c0d.tmp": true,
+		// Another run's download in progress
+		"b.crl.5678-01020304.tmp": false,
+		// Partial downloads no later attempt resumed
+		"c.crl.partial":           true,
+		"c.crl.partial.validator": true,
+		// A partial download a later attempt may yet resume
+		"d.crl.partial":           false,
+		"d.crl.partial.validator": false,
+		// Not tmp files
+		"a.crl": false,
+	}
+	for name, orphaned := range files {
+		path := filepath.Join(dir, name)
+		if err = ioutil.WriteFile(path, []byte("partial"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if orphaned || name == "a.crl" {
+			if err = os.Chtimes(path, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	removed, err := RemoveOrphanedTmpFiles(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 4 {
+		t.Errorf("Expected 4 orphaned tmp files removed, got %d", removed)
+	}
+	for name, orphaned := range files {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if orphaned && !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed", name)
+		}
+		if !orphaned && err != nil {
+			t.Errorf("Expected %s to remain: %s", name, err)
+		}
+	}
+
+	if removed, err = RemoveOrphanedTmpFiles(filepath.Join(dir, "missing"), time.Hour); removed != 0 || err != nil {
+		t.Errorf("Expected a missing folder to have nothing to remove, got %d: %v", removed, err)
+	}
+}
+
+func Test_DownloadAndVerifyResumesAcrossCalls(t *testing.T) {
+	testcontent := bytes.Repeat([]byte("resumed across calls test file's content\n"), 100)
+
+	dir, err := ioutil.TempDir("", "Test_DownloadAndVerifyResumesAcrossCalls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	finalPath := filepath.Join(dir, "file.crl")
+
+	handler := &truncatingHandler{content: testcontent, eTag: `"v1"`}
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	url, _ := url.Parse(ts.URL)
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	// The first call is cut off, leaving its partial download beside the
+	// final path rather than its own tmp path
+	ok, err := DownloadAndVerifyFileSync(context.TODO(), &testVerifier{}, &testAuditor{},
+		&testIdentifier{}, display, *url, finalPath, 0, NewDownloadOptions())
+	if ok || err == nil {
+		t.Fatalf("Expected the truncated download to fail, got %v, %v", ok, err)
+	}
+	for _, p := range []string{partialPath(finalPath), validatorPath(finalPath)} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("Expected %s to be kept to resume: %s", p, err)
+		}
+	}
+
+	// The next, with a different tmp path, resumes it
+	ok, err = DownloadAndVerifyFileSync(context.TODO(), &testVerifier{}, &testAuditor{},
+		&testIdentifier{}, display, *url, finalPath, 0, NewDownloadOptions())
+	if !ok || err != nil {
+		t.Fatalf("Expected the resumed download to succeed, got %v, %v", ok, err)
+	}
+	checkDownloadCompleted(t, finalPath, testcontent)
+	checkNoTmpFiles(t, finalPath)
+	if expected := fmt.Sprintf("bytes=%d-", len(testcontent)/2); len(handler.ranges) != 1 ||
+		handler.ranges[0] != expected {
+		t.Errorf("Expected one request resuming with %s, got %v", expected, handler.ranges)
+	}
+}
+
+func Test_DownloadAndVerifyDiscardsUnresumablePartial(t *testing.T) {
+	dir, err := ioutil.TempDir("", "Test_DownloadAndVerifyDiscardsUnresumablePartial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	finalPath := filepath.Join(dir, "file.crl")
+
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	url, _ := url.Parse(ts.URL)
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	writePartialDownload(t, finalPath, []byte("partial"), `"v1"`)
+	ok, err := DownloadAndVerifyFileSync(context.TODO(), &testVerifier{}, &testAuditor{},
+		&testIdentifier{}, display, *url, finalPath, 1, NewDownloadOptions())
+	if ok || err == nil {
+		t.Fatalf("Expected the missing CRL to fail, got %v, %v", ok, err)
+	}
+	for _, p := range []string{partialPath(finalPath), validatorPath(finalPath)} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", p, err)
+		}
+	}
+	checkNoTmpFiles(t, finalPath)
+}
+
+func Test_DownloadAcrossFilesystems(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Hello, client")
+	}))
+	defer ts.Close()
+
+	tmpDir, err := ioutil.TempDir("", "Test_DownloadAcrossFilesystems")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	finalPath := filepath.Join(tmpDir, "file.crl")
+
+	// The first rename into place fails as it would across mounts, while a
+	// rename from within the destination's directory works
+	crossDevice := 0
+	renameFile = func(aOld string, aNew string) error {
+		if aNew == finalPath && crossDevice == 0 {
+			crossDevice++
+			return &os.LinkError{Op: "rename", Old: aOld, New: aNew, Err: syscall.EXDEV}
+		}
+		return os.Rename(aOld, aNew)
+	}
+	defer func() { renameFile = os.Rename }()
+
+	display := mpb.New(
+		mpb.WithOutput(ioutil.Discard),
+	)
+
+	testUrl, _ := url.Parse(ts.URL)
+	dataAtPathIsValid, err := DownloadAndVerifyFileSync(context.TODO(), &testVerifier{}, &testAuditor{},
+		&testIdentifier{}, display, *testUrl, finalPath, 1, NewDownloadOptions())
+	if err != nil || !dataAtPathIsValid {
+		t.Fatalf("Expected the download to be kept, got %v", err)
+	}
+	if crossDevice == 0 {
+		t.Error("Expected the cross-device path to be taken")
+	}
+
+	data, err := ioutil.ReadFile(finalPath)
+	if err != nil || string(data) != "Hello, client\n" {
+		t.Errorf("Expected the downloaded data, got %q: %v", data, err)
+	}
+	checkNoTmpFiles(t, finalPath)
+	entries, err := ioutil.ReadDir(tmpDir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("Expected only the final file left, got %d entries: %v", len(entries), err)
 	}
 }
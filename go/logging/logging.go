@@ -0,0 +1,173 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Package logging is a thin shim over glog that can instead emit one JSON
+// object per line, for ingestion by log aggregators. Until EnableJSON is
+// called, every function behaves exactly like its glog counterpart.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+type entry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"msg"`
+	Issuer  string    `json:"issuer,omitempty"`
+	Url     string    `json:"url,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+var (
+	mutex      sync.Mutex
+	jsonOutput io.Writer
+
+	// Most call sites lead with "[<issuer ID or URL>]"
+	subjectPrefix = regexp.MustCompile(`^\[([^\]]+)\]\s*`)
+)
+
+// EnableJSON routes all subsequent logging to w as JSON lines. A nil w
+// restores glog.
+func EnableJSON(w io.Writer) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	jsonOutput = w
+}
+
+func jsonEnabled() bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return jsonOutput != nil
+}
+
+func emit(level string, message string, args []interface{}) {
+	ent := entry{
+		Time:    time.Now().UTC(),
+		Level:   level,
+		Message: message,
+	}
+
+	if match := subjectPrefix.FindStringSubmatch(message); match != nil {
+		if u, err := url.Parse(match[1]); err == nil && u.Scheme != "" && u.Host != "" {
+			ent.Url = match[1]
+		} else {
+			ent.Issuer = match[1]
+		}
+	}
+
+	for _, arg := range args {
+		if err, ok := arg.(error); ok {
+			ent.Error = err.Error()
+			break
+		}
+	}
+
+	data, err := json.Marshal(ent)
+	if err != nil {
+		data = []byte(fmt.Sprintf(`{"level":"error","msg":"Could not encode log entry: %s"}`, err))
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, _ = jsonOutput.Write(append(data, '\n'))
+}
+
+func Info(args ...interface{}) {
+	if jsonEnabled() {
+		emit("info", fmt.Sprint(args...), args)
+		return
+	}
+	glog.InfoDepth(1, args...)
+}
+
+func Infof(format string, args ...interface{}) {
+	if jsonEnabled() {
+		emit("info", fmt.Sprintf(format, args...), args)
+		return
+	}
+	glog.InfoDepth(1, fmt.Sprintf(format, args...))
+}
+
+func Warning(args ...interface{}) {
+	if jsonEnabled() {
+		emit("warning", fmt.Sprint(args...), args)
+		return
+	}
+	glog.WarningDepth(1, args...)
+}
+
+func Warningf(format string, args ...interface{}) {
+	if jsonEnabled() {
+		emit("warning", fmt.Sprintf(format, args...), args)
+		return
+	}
+	glog.WarningDepth(1, fmt.Sprintf(format, args...))
+}
+
+func Error(args ...interface{}) {
+	if jsonEnabled() {
+		emit("error", fmt.Sprint(args...), args)
+		return
+	}
+	glog.ErrorDepth(1, args...)
+}
+
+func Errorf(format string, args ...interface{}) {
+	if jsonEnabled() {
+		emit("error", fmt.Sprintf(format, args...), args)
+		return
+	}
+	glog.ErrorDepth(1, fmt.Sprintf(format, args...))
+}
+
+// Fatal logs, then exits with the same status glog uses.
+func Fatal(args ...interface{}) {
+	if jsonEnabled() {
+		emit("fatal", fmt.Sprint(args...), args)
+		glog.Flush()
+		os.Exit(255)
+	}
+	glog.FatalDepth(1, args...)
+}
+
+func Fatalf(format string, args ...interface{}) {
+	if jsonEnabled() {
+		emit("fatal", fmt.Sprintf(format, args...), args)
+		glog.Flush()
+		os.Exit(255)
+	}
+	glog.FatalDepth(1, fmt.Sprintf(format, args...))
+}
+
+func Flush() {
+	glog.Flush()
+}
+
+// Verbose mirrors glog.Verbose, honoring the -v flag in both modes.
+type Verbose bool
+
+func V(level glog.Level) Verbose {
+	return Verbose(glog.V(level))
+}
+
+func (v Verbose) Infof(format string, args ...interface{}) {
+	if !v {
+		return
+	}
+	if jsonEnabled() {
+		emit("info", fmt.Sprintf(format, args...), args)
+		return
+	}
+	glog.InfoDepth(1, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func captureJSON(t *testing.T, logFunc func()) []entry {
+	t.Helper()
+
+	var buf bytes.Buffer
+	EnableJSON(&buf)
+	defer EnableJSON(nil)
+
+	logFunc()
+
+	var entries []entry
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var ent entry
+		if err := json.Unmarshal([]byte(line), &ent); err != nil {
+			t.Fatalf("Line isn't JSON: %s: %s", line, err)
+		}
+		entries = append(entries, ent)
+	}
+	return entries
+}
+
+func Test_JSONFields(t *testing.T) {
+	entries := captureJSON(t, func() {
+		Warningf("[%s] Problem downloading: %s", "http://example.com/ca.crl", fmt.Errorf("Non-OK status: 404"))
+		Infof("[%s] Saving %d revoked serials", "issuerID", 3)
+		Error("plain message")
+		V(0).Infof("verbose %d", 0)
+		V(99).Infof("too verbose")
+	})
+
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 entries, got %+v", entries)
+	}
+
+	if entries[0].Level != "warning" || entries[0].Url != "http://example.com/ca.crl" ||
+		entries[0].Error != "Non-OK status: 404" || entries[0].Issuer != "" {
+		t.Errorf("Unexpected entry: %+v", entries[0])
+	}
+
+	if entries[1].Level != "info" || entries[1].Issuer != "issuerID" || entries[1].Url != "" ||
+		entries[1].Message != "[issuerID] Saving 3 revoked serials" {
+		t.Errorf("Unexpected entry: %+v", entries[1])
+	}
+
+	if entries[2].Level != "error" || entries[2].Message != "plain message" || entries[2].Issuer != "" {
+		t.Errorf("Unexpected entry: %+v", entries[2])
+	}
+
+	if entries[3].Message != "verbose 0" || entries[3].Time.IsZero() {
+		t.Errorf("Unexpected entry: %+v", entries[3])
+	}
+}
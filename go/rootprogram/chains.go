@@ -0,0 +1,92 @@
+package rootprogram
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/golang/glog"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+// Reads a PEM bundle of trusted root certificates, such as CCADB's report of
+// the roots included in Mozilla's program
+func LoadRootsFromPEM(aPath string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(aPath)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("No certificates found in %s", aPath)
+	}
+	return roots, nil
+}
+
+// Checks that every issuer has a certificate which builds a path to one of
+// aRoots, through the other CCADB certificates, and marks those which don't
+// so they're never enrolled. This guards against CCADB entries which no
+// longer chain, e.g. stale ones. Returns how many issuers were marked.
+func (mi *MozIssuers) VerifyChains(aRoots *x509.CertPool) int {
+	mi.mutex.Lock()
+	defer mi.mutex.Unlock()
+
+	intermediates := x509.NewCertPool()
+	for _, data := range mi.issuerMap {
+		for _, ic := range data.certs {
+			if ic.cert != nil {
+				intermediates.AddCert(ic.cert)
+			}
+		}
+	}
+	opts := x509.VerifyOptions{
+		Roots:         aRoots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+
+	unchainedCount := 0
+	for id, data := range mi.issuerMap {
+		if data.noValidChain {
+			continue
+		}
+		err := verifyAnyCert(data.certs, opts)
+		if err == nil {
+			continue
+		}
+		glog.Warningf("Issuer %s (%s) doesn't chain to a trusted root, and won't be enrolled: %s", id,
+			data.certs[0].subjectDN, err)
+		data.noValidChain = true
+		data.enrolled = false
+		if !data.revokedInOneCRL {
+			data.reason = ReasonNoValidChain
+		}
+		mi.issuerMap[id] = data
+		unchainedCount++
+	}
+
+	glog.Infof("Verified the chains of %d issuers, %d of which don't chain to a trusted root",
+		len(mi.issuerMap), unchainedCount)
+	return unchainedCount
+}
+
+// Returns nil if any of aCerts verifies, otherwise the last error
+func verifyAnyCert(aCerts []issuerCert, aOpts x509.VerifyOptions) error {
+	err := fmt.Errorf("No certificate")
+	for _, ic := range aCerts {
+		if ic.cert == nil {
+			continue
+		}
+		if _, err = ic.cert.Verify(aOpts); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (mi *MozIssuers) HasValidChain(aIssuer storage.Issuer) bool {
+	mi.mutex.Lock()
+	defer mi.mutex.Unlock()
+
+	return !mi.issuerMap[aIssuer.ID()].noValidChain
+}
@@ -0,0 +1,130 @@
+package rootprogram
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	newx509 "github.com/google/certificate-transparency-go/x509"
+)
+
+type testCA struct {
+	template *x509.Certificate
+	key      *ecdsa.PrivateKey
+	cert     *newx509.Certificate
+	pem      string
+}
+
+// Makes a CA certificate signed by aParent, or self-signed if it's nil
+func makeTestCA(t *testing.T, aName string, aParent *testCA) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: aName},
+		NotBefore:             time.Now().AddDate(-1, 0, 0),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	parentTemplate, parentKey := template, key
+	if aParent != nil {
+		parentTemplate, parentKey = aParent.template, aParent.key
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, parentTemplate, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := newx509.ParseCertificate(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{
+		template: template,
+		key:      key,
+		cert:     cert,
+		pem:      string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes})),
+	}
+}
+
+func Test_VerifyChains(t *testing.T) {
+	root := makeTestCA(t, "Trusted Root", nil)
+	intermediate := makeTestCA(t, "Intermediate", root)
+	// Chains to the root only through another CCADB certificate
+	subIntermediate := makeTestCA(t, "Sub-Intermediate", intermediate)
+	untrusted := makeTestCA(t, "Untrusted Intermediate", makeTestCA(t, "Untrusted Root", nil))
+
+	rootsFile, err := ioutil.TempFile("", "Test_VerifyChains")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(rootsFile.Name())
+	if _, err = rootsFile.WriteString(root.pem); err != nil {
+		t.Fatal(err)
+	}
+	rootsFile.Close()
+
+	roots, err := LoadRootsFromPEM(rootsFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mi := NewMozillaIssuers()
+	chained := mi.InsertIssuerFromCertAndPem(intermediate.cert, intermediate.pem)
+	subChained := mi.InsertIssuerFromCertAndPem(subIntermediate.cert, subIntermediate.pem)
+	unchained := mi.InsertIssuerFromCertAndPem(untrusted.cert, untrusted.pem)
+
+	if count := mi.VerifyChains(roots); count != 1 {
+		t.Errorf("Expected one issuer without a valid chain, got %d", count)
+	}
+	if !mi.HasValidChain(chained) || !mi.HasValidChain(subChained) {
+		t.Error("Expected the intermediates issued under the root to chain")
+	}
+	if mi.HasValidChain(unchained) {
+		t.Error("Expected the intermediate under an untrusted root not to chain")
+	}
+
+	// Enrollment is refused, whatever its CRLs
+	mi.Enroll(chained)
+	mi.Enroll(unchained)
+	if !mi.IsIssuerEnrolled(chained) {
+		t.Error("Expected the chained issuer to enroll")
+	}
+	if mi.IsIssuerEnrolled(unchained) {
+		t.Error("Expected the unchained issuer not to enroll")
+	}
+	if reason, _ := mi.GetEnrollmentReason(unchained); reason != ReasonNoValidChain {
+		t.Errorf("Expected reason %s, got %s", ReasonNoValidChain, reason)
+	}
+}
+
+func Test_LoadRootsFromPEMInvalid(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "Test_LoadRootsFromPEMInvalid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	if _, err = tmpfile.WriteString("not a certificate"); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	if _, err = LoadRootsFromPEM(tmpfile.Name()); err == nil {
+		t.Error("Expected an error for a file without certificates")
+	}
+	if _, err = LoadRootsFromPEM(tmpfile.Name() + ".missing"); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
@@ -0,0 +1,99 @@
+package rootprogram
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+// Experimental: discovers the CRL Distribution Point URLs an issuer puts in
+// its certificates, for issuers CCADB lists no CRLs for. Reads up to
+// aSampleSize of the issuer's certificates that ct-fetch stored from CT,
+// those expiring last first, as the most recently issued, skipping dates
+// before aNotBefore. Returns the http and https URLs in the order first
+// found, without repeats, and an error only if no certificate could be read.
+func SampleCrlUrlsFromCT(ctx context.Context, aBackend storage.StorageBackend, aIssuer storage.Issuer,
+	aNotBefore time.Time, aSampleSize int) ([]string, error) {
+	dates, err := aBackend.ListExpirationDates(ctx, aNotBefore)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(storage.ExpDateList(dates)))
+
+	urls := []string{}
+	seen := make(map[string]bool)
+	sampled := 0
+	var lastErr error
+	for _, expDate := range dates {
+		if sampled >= aSampleSize {
+			break
+		}
+		serials, err := aBackend.ListSerialsForExpirationDateAndIssuer(ctx, expDate, aIssuer)
+		if err != nil {
+			return nil, err
+		}
+		for _, serial := range serials {
+			if sampled >= aSampleSize {
+				break
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			cert, err := loadStoredCertificate(ctx, aBackend, serial, expDate, aIssuer)
+			if err != nil {
+				glog.Warningf("[%s] Couldn't sample certificate %s expiring %s: %s", aIssuer.ID(),
+					serial.HexString(), expDate, err)
+				lastErr = err
+				continue
+			}
+			sampled++
+			for _, crlUrl := range cert.CRLDistributionPoints {
+				if seen[crlUrl] || !isFetchableUrl(crlUrl) {
+					continue
+				}
+				seen[crlUrl] = true
+				urls = append(urls, crlUrl)
+			}
+		}
+	}
+
+	if sampled == 0 && lastErr != nil {
+		return nil, fmt.Errorf("Couldn't read any certificate of %s: %s", aIssuer.ID(), lastErr)
+	}
+	glog.V(1).Infof("[%s] Found %d CRL URLs in %d certificates from CT", aIssuer.ID(), len(urls), sampled)
+	return urls, nil
+}
+
+func loadStoredCertificate(ctx context.Context, aBackend storage.StorageBackend, aSerial storage.Serial,
+	aExpDate storage.ExpDate, aIssuer storage.Issuer) (*x509.Certificate, error) {
+	data, err := aBackend.LoadCertificatePEM(ctx, aSerial, aExpDate, aIssuer)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("Not a valid PEM")
+	}
+	// As ct-fetch, which stored it, accept certificates with non-fatal errors
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if _, ok := err.(x509.NonFatalErrors); !ok && err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// The downloader only fetches over http and https, not, for example, ldap
+func isFetchableUrl(aUrl string) bool {
+	u, err := url.Parse(aUrl)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "http" || u.Scheme == "https"
+}
@@ -0,0 +1,116 @@
+package rootprogram
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mozilla/crlite/go/storage"
+)
+
+// Stores a leaf certificate issued by aCA with the given CRL URLs, as
+// ct-fetch would
+func storeSampleCert(t *testing.T, aBackend *storage.MockBackend, aCA *testCA, aIssuer storage.Issuer,
+	aExpDate string, aSerial int64, aCrlUrls ...string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(aSerial),
+		Subject:               pkix.Name{CommonName: "leaf"},
+		NotBefore:             time.Now().AddDate(-1, 0, 0),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		CRLDistributionPoints: aCrlUrls,
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, aCA.template, key.Public(), aCA.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expDate, err := storage.NewExpDate(aExpDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := storage.NewSerialFromBytes(big.NewInt(aSerial).Bytes())
+	if err = aBackend.AllocateExpDateAndIssuer(context.TODO(), expDate, aIssuer); err != nil {
+		t.Fatal(err)
+	}
+	err = aBackend.StoreCertificatePEM(context.TODO(), serial, expDate, aIssuer,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes}))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_SampleCrlUrlsFromCT(t *testing.T) {
+	ca := makeTestCA(t, "Issuing CA", nil)
+	issuer := storage.NewIssuer(ca.cert)
+	other := storage.NewIssuerFromString("otherIssuer")
+	notBefore := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	backend := storage.NewMockBackend()
+	storeSampleCert(t, backend, ca, issuer, "2030-03-01", 1, "http://crl.example.com/new.crl")
+	storeSampleCert(t, backend, ca, issuer, "2030-02-01", 2, "http://crl.example.com/new.crl",
+		"ldap://ldap.example.com/cn=CA?certificateRevocationList")
+	storeSampleCert(t, backend, ca, issuer, "2030-02-01", 3, "https://crl.example.com/other.crl")
+	storeSampleCert(t, backend, ca, issuer, "2030-02-01", 4)
+	// Expired, so not sampled
+	storeSampleCert(t, backend, ca, issuer, "2029-12-01", 5, "http://crl.example.com/expired.crl")
+	storeSampleCert(t, backend, ca, other, "2030-02-01", 6, "http://other.example.com/other.crl")
+
+	urls, err := SampleCrlUrlsFromCT(context.TODO(), backend, issuer, notBefore, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"http://crl.example.com/new.crl", "https://crl.example.com/other.crl"}
+	if !reflect.DeepEqual(expected, urls) {
+		t.Errorf("Expected %v, got %v", expected, urls)
+	}
+
+	// The last to expire are sampled first
+	urls, err = SampleCrlUrlsFromCT(context.TODO(), backend, issuer, notBefore, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected[:1], urls) {
+		t.Errorf("Expected %v sampling one certificate, got %v", expected[:1], urls)
+	}
+
+	urls, err = SampleCrlUrlsFromCT(context.TODO(), backend, storage.NewIssuerFromString("unknown"),
+		notBefore, 10)
+	if err != nil || len(urls) != 0 {
+		t.Errorf("Expected no URLs for an issuer without certificates, got %v, %v", urls, err)
+	}
+}
+
+func Test_SampleCrlUrlsFromCTUnreadable(t *testing.T) {
+	issuer := storage.NewIssuerFromString("issuer")
+	expDate, err := storage.NewExpDate("2030-02-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := storage.NewMockBackend()
+	if err = backend.AllocateExpDateAndIssuer(context.TODO(), expDate, issuer); err != nil {
+		t.Fatal(err)
+	}
+	err = backend.StoreCertificatePEM(context.TODO(), storage.NewSerialFromHex("01"), expDate, issuer,
+		[]byte("not PEM"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := SampleCrlUrlsFromCT(context.TODO(), backend, issuer, time.Time{}, 10); err == nil {
+		t.Error("Expected an error when no certificate could be read")
+	}
+}
@@ -1,74 +1,177 @@
 package rootprogram
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
+	"net/http"
 	"net/url"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/golang/glog"
 	"github.com/google/certificate-transparency-go/x509"
+	"github.com/jpillora/backoff"
 	"github.com/mozilla/crlite/go/downloader"
 	"github.com/mozilla/crlite/go/storage"
 	"github.com/vbauerster/mpb/v5"
 )
 
 const (
-	kMozCCADBReport = "https://ccadb-public.secure.force.com/mozilla/MozillaIntermediateCertsCSVReport"
+	kMozCCADBReport  = "https://ccadb-public.secure.force.com/mozilla/MozillaIntermediateCertsCSVReport"
+	kCCADBURLTimeout = 5 * time.Minute
+	// The wait before retrying a failed CCADB fetch doubles each time, up to
+	// this
+	kCCADBMaxRetryDelay = 2 * time.Minute
+
+	kCCADBValidToColumn          = "Valid To [GMT]"
+	kCCADBValidToLayout          = "2006 Jan 02"
+	kCCADBRevocationStatusColumn = "Revocation Status"
+	kCCADBNotRevoked             = "Not Revoked"
+	kCCADBCrlUrlsColumn          = "CRL URL(s)"
+	kCCADBNameColumn             = "Certificate Name"
+	kCCADBFingerprintColumn      = "SHA-256 Fingerprint"
 )
 
+// How many times the network loads retry fetching CCADB by default
+const DefaultLoadRetries = 3
+
+// Why parseCCADB left a row out
+type ccadbExclusion string
+
+const (
+	excludedRevoked ccadbExclusion = "revoked"
+	excludedExpired ccadbExclusion = "expired"
+)
+
+// A CCADB row left out because its certificate couldn't be decoded
+type InvalidCCADBRow struct {
+	Line        int    `json:"line"`
+	Name        string `json:"name,omitempty"`
+	Fingerprint string `json:"sha256Fingerprint,omitempty"`
+	Error       string `json:"error"`
+}
+
 type issuerCert struct {
 	cert      *x509.Certificate
 	subjectDN string
 	pemInfo   string
+	// From CCADB's CRL URL(s) column, if any
+	crlUrls []string
 }
 
+// EnrollmentReason records why an issuer did or did not get enrolled, so it
+// can be read straight out of the enrolled-issuers JSON.
+type EnrollmentReason string
+
+const (
+	ReasonUnprocessed             EnrollmentReason = "unprocessed"
+	ReasonEnrolled                EnrollmentReason = "enrolled"
+	ReasonNoCrls                  EnrollmentReason = "no-crls"
+	ReasonNoRevocations           EnrollmentReason = "no-revocations"
+	ReasonSomeCrlsFailed          EnrollmentReason = "some-crls-failed"
+	ReasonAllCrlsFailedValidation EnrollmentReason = "all-crls-failed-validation"
+	ReasonAllCrlsFailedDownload   EnrollmentReason = "all-crls-failed-download"
+	ReasonRevokedInOneCRL         EnrollmentReason = "revoked-in-onecrl"
+	ReasonStoreFailed             EnrollmentReason = "store-failed"
+	ReasonNoValidChain            EnrollmentReason = "no-valid-chain"
+	ReasonTooManyCrls             EnrollmentReason = "too-many-crls"
+	// Its CRLs couldn't be checked, as its certificate wasn't loaded
+	ReasonNoIssuerCertificate EnrollmentReason = "no-issuer-certificate"
+)
+
 type IssuerData struct {
 	certs    []issuerCert
 	enrolled bool
+	reason   EnrollmentReason
+	// Either overrides any later enrollment
+	revokedInOneCRL bool
+	noValidChain    bool
+	// CCADB's CRL URLs for the issuer that weren't processed
+	missingCrlUrls []string
+	// CCADB's CRL URLs for the issuer whose CRLs were fetched but left out,
+	// as they only cover attribute certificates
+	outOfScopeCrlUrls []string
 }
 
 type EnrolledIssuer struct {
-	PubKeyHash string `json:"pubKeyHash"`
-	Whitelist  bool   `json:"whitelist"`
-	SubjectDN  string `json:"subjectDN"`
-	Subject    string `json:"subject"`
-	Pem        string `json:"pem"`
-	Enrolled   bool   `json:"enrolled"`
+	PubKeyHash string           `json:"pubKeyHash"`
+	Whitelist  bool             `json:"whitelist"`
+	SubjectDN  string           `json:"subjectDN"`
+	Subject    string           `json:"subject"`
+	Pem        string           `json:"pem"`
+	Enrolled   bool             `json:"enrolled"`
+	Reason     EnrollmentReason `json:"reason"`
+	// Whether LoadOneCRL found any of the issuer's certificates revoked
+	RevokedInOneCRL bool `json:"revokedInOneCRL"`
+	// CRL URLs CCADB lists for the issuer which weren't fetched and
+	// validated, when aggregate-crls checks coverage
+	MissingCrlUrls []string `json:"missingCrlUrls,omitempty"`
+	// CRL URLs CCADB lists for the issuer which were fetched and validated
+	// but only cover attribute certificates, which CRLite doesn't
+	OutOfScopeCrlUrls []string `json:"outOfScopeCrlUrls,omitempty"`
+	// The Fingerprint of the issuers the list was saved from, the same for
+	// every entry, so the list can be traced to its CCADB input
+	CcadbFingerprint string `json:"ccadbFingerprint,omitempty"`
 }
 
 type MozIssuers struct {
 	issuerMap map[string]IssuerData
 	mutex     *sync.Mutex
+	// Issuer ID to *x509.Certificate, so the per-CRL lookups from every
+	// worker don't contend on mutex
+	certCache *sync.Map
 	DiskPath  string
 	ReportUrl string
-	modTime   time.Time
+	OneCRLUrl string
+	// Keep CCADB rows for certificates that are revoked or expired, which are
+	// otherwise left out when loading
+	IncludeInactive bool
+	// Leave out CCADB rows whose certificates can't be decoded, listing them
+	// in GetInvalidRows, rather than failing the whole load
+	SkipInvalidRows bool
+	invalidRows     []InvalidCCADBRow
+	// How many more times Load and LoadFromURL try fetching CCADB after a
+	// failure, first waiting LoadRetryDelay and then doubling it each time
+	LoadRetries    uint
+	LoadRetryDelay time.Duration
+	modTime        time.Time
 }
 
 func NewMozillaIssuers() *MozIssuers {
 	return &MozIssuers{
 		issuerMap: make(map[string]IssuerData, 0),
 		mutex:     &sync.Mutex{},
+		certCache: &sync.Map{},
 		DiskPath:  fmt.Sprintf("%s/mozilla_issuers.csv", os.TempDir()),
 		ReportUrl: kMozCCADBReport,
+		OneCRLUrl: kMozOneCRLRecords,
+
+		LoadRetries:    DefaultLoadRetries,
+		LoadRetryDelay: 5 * time.Second,
 	}
 }
 
 type verifier struct {
+	skipInvalidRows bool
 }
 
 func (v *verifier) IsValid(path string) error {
 	mi := NewMozillaIssuers()
+	mi.SkipInvalidRows = v.skipInvalidRows
 	return mi.LoadFromDisk(path)
 }
 
@@ -86,6 +189,10 @@ func (ta *loggingAuditor) FailedVerifyPath(issuer downloader.DownloadIdentifier,
 	err error) {
 	glog.Warningf("Failed verify of %s (local: %s): %s", crlUrl.String(), crlPath, err)
 }
+func (ta *loggingAuditor) DownloadFinished(issuer downloader.DownloadIdentifier, crlUrl *url.URL,
+	status downloader.DownloadStatus) {
+	glog.V(1).Infof("Download of %s finished with status %s", crlUrl.String(), status)
+}
 
 type identifier struct{}
 
@@ -106,15 +213,25 @@ func (mi *MozIssuers) Load() error {
 		return err
 	}
 
-	isAcceptable, err := downloader.DownloadAndVerifyFileSync(ctx, &verifier{}, &loggingAuditor{}, &identifier{},
-		display, *dataUrl, mi.DiskPath, 3)
+	// Usable data, even if only the previously-downloaded copy, needn't be
+	// retried
+	var isAcceptable bool
+	var dlErr error
+	err = mi.withRetries(ctx, dataUrl.String(), func() error {
+		isAcceptable, dlErr = downloader.DownloadAndVerifyFileSync(ctx, &verifier{skipInvalidRows: mi.SkipInvalidRows}, &loggingAuditor{},
+			&identifier{}, display, *dataUrl, mi.DiskPath, 0, downloader.NewDownloadOptions())
+		if isAcceptable {
+			return nil
+		}
+		return dlErr
+	})
 
 	if !isAcceptable {
 		return err
 	}
 
-	if err != nil {
-		glog.Warningf("Error encountered loading CCADB data, but able to proceed with previous data. Error: %s", err)
+	if dlErr != nil {
+		glog.Warningf("Error encountered loading CCADB data, but able to proceed with previous data. Error: %s", dlErr)
 	}
 
 	return mi.LoadFromDisk(mi.DiskPath)
@@ -135,6 +252,149 @@ func (mi *MozIssuers) LoadFromDisk(aPath string) error {
 	return mi.parseCCADB(fd)
 }
 
+// Loads several CCADB CSV files on top of anything already loaded. Sources
+// apply in order: when a later source has an issuer ID that an earlier one
+// (or the existing data) had, the later source's certificates replace the
+// earlier ones entirely. The dataset age becomes that of the oldest source.
+func (mi *MozIssuers) LoadFromDiskMerge(aPaths ...string) error {
+	for _, path := range aPaths {
+		source := NewMozillaIssuers()
+		source.IncludeInactive = mi.IncludeInactive
+		source.SkipInvalidRows = mi.SkipInvalidRows
+		if err := source.LoadFromDisk(path); err != nil {
+			return fmt.Errorf("Couldn't load CCADB source %s: %s", path, err)
+		}
+
+		mi.mergeFrom(source, path)
+		mi.invalidRows = append(mi.invalidRows, source.invalidRows...)
+
+		if mi.modTime.IsZero() || source.modTime.Before(mi.modTime) {
+			mi.modTime = source.modTime
+		}
+	}
+	return nil
+}
+
+func (mi *MozIssuers) mergeFrom(aSource *MozIssuers, aSourceName string) {
+	mi.mutex.Lock()
+	defer mi.mutex.Unlock()
+
+	for id, data := range aSource.issuerMap {
+		if previous, exists := mi.issuerMap[id]; exists {
+			glog.Infof("[%s] CCADB source %s overrides %d earlier certificate(s) for %s", id, aSourceName,
+				len(previous.certs), data.certs[0].subjectDN)
+		}
+		mi.issuerMap[id] = data
+		mi.certCache.Delete(id)
+	}
+}
+
+func isAcceptableCCADBContentType(aContentType string) bool {
+	if aContentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(aContentType)
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "text/csv", "text/plain", "application/csv", "application/octet-stream":
+		return true
+	}
+	return false
+}
+
+// Fetch and parse a CCADB CSV report directly from u, without caching it on disk.
+func (mi *MozIssuers) LoadFromURL(ctx context.Context, u string) error {
+	dataUrl, err := url.Parse(u)
+	if err != nil {
+		return fmt.Errorf("Couldn't parse CCADB URL of %s: %s", u, err)
+	}
+
+	// The whole report is fetched before parsing, so that a failure partway
+	// through can be retried without leaving half of it loaded
+	var data []byte
+	var modTime time.Time
+	err = mi.withRetries(ctx, dataUrl.String(), func() error {
+		var fetchErr error
+		data, modTime, fetchErr = fetchCCADB(ctx, dataUrl)
+		return fetchErr
+	})
+	if err != nil {
+		return err
+	}
+
+	mi.modTime = modTime
+	return mi.parseCCADB(bytes.NewReader(data))
+}
+
+// Returns the CCADB report at aUrl and when it was last modified
+func fetchCCADB(ctx context.Context, aUrl *url.URL) ([]byte, time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, kCCADBURLTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", aUrl.String(), nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	req.Header.Add("X-Automated-Tool", "https://github.com/mozilla/crlite")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("Non-OK status fetching CCADB from %s: %s", aUrl.String(), resp.Status)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if !isAcceptableCCADBContentType(contentType) {
+		return nil, time.Time{}, fmt.Errorf("Unexpected content type fetching CCADB from %s: %s", aUrl.String(),
+			contentType)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	modTime := time.Now()
+	if lastMod, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		modTime = lastMod
+	}
+	return data, modTime, nil
+}
+
+// Calls aFetch until it succeeds or LoadRetries more attempts have failed,
+// backing off between them. Gives up early if ctx is done.
+func (mi *MozIssuers) withRetries(ctx context.Context, aSource string, aFetch func() error) error {
+	b := &backoff.Backoff{
+		Jitter: true,
+		Min:    mi.LoadRetryDelay,
+		Max:    kCCADBMaxRetryDelay,
+	}
+
+	var attempt uint
+	for {
+		err := aFetch()
+		if err == nil || attempt >= mi.LoadRetries || ctx.Err() != nil {
+			return err
+		}
+		attempt++
+
+		d := b.Duration()
+		glog.Warningf("Failed to fetch CCADB from %s, retrying in %s (%d/%d): %s", aSource, d, attempt,
+			mi.LoadRetries, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(d):
+		}
+	}
+}
+
 func (mi *MozIssuers) DatasetAge() time.Duration {
 	if mi.modTime.IsZero() {
 		return 0
@@ -142,6 +402,33 @@ func (mi *MozIssuers) DatasetAge() time.Duration {
 	return time.Since(mi.modTime)
 }
 
+// Returns a hex SHA-256 digest identifying the issuers loaded, and their
+// certificates, whatever order CCADB listed them in
+func (mi *MozIssuers) Fingerprint() string {
+	mi.mutex.Lock()
+	defer mi.mutex.Unlock()
+	return mi.fingerprint()
+}
+
+func (mi *MozIssuers) fingerprint() string {
+	lines := make([]string, 0, len(mi.issuerMap))
+	for id, data := range mi.issuerMap {
+		for _, ic := range data.certs {
+			certHash := sha256.Sum256(ic.cert.Raw)
+			lines = append(lines, fmt.Sprintf("%s %s\n", id, hex.EncodeToString(certHash[:])))
+		}
+	}
+	sort.Strings(lines)
+
+	digest := sha256.New()
+	for _, line := range lines {
+		io.WriteString(digest, line) // hash.Hash never returns an error
+	}
+	return hex.EncodeToString(digest.Sum(nil))
+}
+
+// Returns the issuers ordered by ID, so output built from them is
+// reproducible
 func (mi *MozIssuers) GetIssuers() []storage.Issuer {
 	mi.mutex.Lock()
 	defer mi.mutex.Unlock()
@@ -154,14 +441,23 @@ func (mi *MozIssuers) GetIssuers() []storage.Issuer {
 		issuers[i] = storage.NewIssuer(cert)
 		i++
 	}
+	sort.Slice(issuers, func(a, b int) bool {
+		return issuers[a].ID() < issuers[b].ID()
+	})
 	return issuers
 }
 
 func (mi *MozIssuers) SaveIssuersList(filePath string) error {
+	return mi.SaveIssuersListWithMode(filePath, 0644)
+}
+
+// As SaveIssuersList, creating the file with aMode, before the umask
+func (mi *MozIssuers) SaveIssuersListWithMode(filePath string, aMode os.FileMode) error {
 	mi.mutex.Lock()
 	defer mi.mutex.Unlock()
 	enrolledCount := 0
 	certCount := 0
+	fingerprint := mi.fingerprint()
 
 	issuers := make([]EnrolledIssuer, 0, len(mi.issuerMap))
 	for _, val := range mi.issuerMap {
@@ -174,6 +470,12 @@ func (mi *MozIssuers) SaveIssuersList(filePath string) error {
 				Subject:    cert.subjectDN,
 				Pem:        cert.pemInfo,
 				Enrolled:   val.enrolled,
+				Reason:     val.reason,
+
+				RevokedInOneCRL:   val.revokedInOneCRL,
+				MissingCrlUrls:    val.missingCrlUrls,
+				OutOfScopeCrlUrls: val.outOfScopeCrlUrls,
+				CcadbFingerprint:  fingerprint,
 			})
 			certCount++
 			if val.enrolled {
@@ -183,20 +485,38 @@ func (mi *MozIssuers) SaveIssuersList(filePath string) error {
 	}
 
 	glog.Infof("Saving %d issuers and %d certs, of which %d are marked as enrolled", len(mi.issuerMap), certCount, enrolledCount)
-	fd, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+
+	// Write to a temporary file alongside the final path and rename it into
+	// place, so readers never observe a partially-written list.
+	tmpPath := fmt.Sprintf("%s.tmp", filePath)
+	defer func() {
+		removeErr := os.Remove(tmpPath)
+		if removeErr != nil && !os.IsNotExist(removeErr) {
+			glog.Warningf("Failed to remove tmp file %s: %s", tmpPath, removeErr)
+		}
+	}()
+
+	fd, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, aMode)
 	if err != nil {
-		glog.Errorf("Error opening enrolled issuer %s: %s", filePath, err)
+		glog.Errorf("Error opening enrolled issuer %s: %s", tmpPath, err)
 		return err
 	}
 
 	enc := json.NewEncoder(fd)
 
 	if err := enc.Encode(issuers); err != nil {
-		glog.Errorf("Error marshaling enrolled issuer %s: %s", filePath, err)
+		glog.Errorf("Error marshaling enrolled issuer %s: %s", tmpPath, err)
+		fd.Close() // ignore error
+		return err
 	}
 
 	if err = fd.Close(); err != nil {
-		glog.Errorf("Error storing enrolled issuer %s: %s", filePath, err)
+		glog.Errorf("Error storing enrolled issuer %s: %s", tmpPath, err)
+		return err
+	}
+
+	if err = os.Rename(tmpPath, filePath); err != nil {
+		glog.Errorf("Couldn't rename %s to %s: %s", tmpPath, filePath, err)
 	}
 
 	return err
@@ -220,8 +540,12 @@ func (mi *MozIssuers) LoadEnrolledIssuers(filePath string) error {
 			return err
 		}
 		issuer := mi.InsertIssuerFromCertAndPem(cert, ei.Pem)
-		if ei.Enrolled {
+		if ei.RevokedInOneCRL {
+			mi.markRevokedInOneCRL(issuer)
+		} else if ei.Enrolled {
 			mi.Enroll(issuer)
+		} else if ei.Reason != "" {
+			mi.MarkUnenrolled(issuer, ei.Reason)
 		}
 		// TODO: Support whitelisting, overall
 	}
@@ -229,31 +553,103 @@ func (mi *MozIssuers) LoadEnrolledIssuers(filePath string) error {
 	return nil
 }
 
+// Whether the issuer is kept from enrollment whatever its CRLs
+func (d IssuerData) excluded() bool {
+	return d.revokedInOneCRL || d.noValidChain
+}
+
 func (mi *MozIssuers) Enroll(aIssuer storage.Issuer) {
 	mi.mutex.Lock()
 	defer mi.mutex.Unlock()
 
-	if _, ok := mi.issuerMap[aIssuer.ID()]; ok {
-		data := mi.issuerMap[aIssuer.ID()]
+	if data, ok := mi.issuerMap[aIssuer.ID()]; ok && !data.excluded() {
 		data.enrolled = true
+		data.reason = ReasonEnrolled
+		mi.issuerMap[aIssuer.ID()] = data
+	}
+}
+
+func (mi *MozIssuers) MarkUnenrolled(aIssuer storage.Issuer, aReason EnrollmentReason) {
+	mi.mutex.Lock()
+	defer mi.mutex.Unlock()
+
+	if data, ok := mi.issuerMap[aIssuer.ID()]; ok && !data.excluded() {
+		data.enrolled = false
+		data.reason = aReason
+		mi.issuerMap[aIssuer.ID()] = data
+	}
+}
+
+// Records the CRL URLs CCADB lists for the issuer that weren't processed,
+// marking its coverage as partial. It doesn't change enrollment.
+func (mi *MozIssuers) SetMissingCrlUrls(aIssuer storage.Issuer, aUrls []string) {
+	mi.mutex.Lock()
+	defer mi.mutex.Unlock()
+
+	if data, ok := mi.issuerMap[aIssuer.ID()]; ok {
+		data.missingCrlUrls = aUrls
+		mi.issuerMap[aIssuer.ID()] = data
+	}
+}
+
+func (mi *MozIssuers) GetMissingCrlUrls(aIssuer storage.Issuer) []string {
+	mi.mutex.Lock()
+	defer mi.mutex.Unlock()
+
+	return mi.issuerMap[aIssuer.ID()].missingCrlUrls
+}
+
+// Records the CRL URLs CCADB lists for the issuer whose CRLs were left out
+// as out of CRLite's scope, so they aren't reported as missing. It doesn't
+// change enrollment.
+func (mi *MozIssuers) SetOutOfScopeCrlUrls(aIssuer storage.Issuer, aUrls []string) {
+	mi.mutex.Lock()
+	defer mi.mutex.Unlock()
+
+	if data, ok := mi.issuerMap[aIssuer.ID()]; ok {
+		data.outOfScopeCrlUrls = aUrls
 		mi.issuerMap[aIssuer.ID()] = data
 	}
 }
 
+func (mi *MozIssuers) GetOutOfScopeCrlUrls(aIssuer storage.Issuer) []string {
+	mi.mutex.Lock()
+	defer mi.mutex.Unlock()
+
+	return mi.issuerMap[aIssuer.ID()].outOfScopeCrlUrls
+}
+
+func (mi *MozIssuers) GetEnrollmentReason(aIssuer storage.Issuer) (EnrollmentReason, error) {
+	mi.mutex.Lock()
+	defer mi.mutex.Unlock()
+
+	entry, ok := mi.issuerMap[aIssuer.ID()]
+	if !ok {
+		return "", fmt.Errorf("Unknown issuer: %s", aIssuer.ID())
+	}
+	return entry.reason, nil
+}
+
 func (mi *MozIssuers) IsIssuerInProgram(aIssuer storage.Issuer) bool {
+	mi.mutex.Lock()
+	defer mi.mutex.Unlock()
+
 	_, ok := mi.issuerMap[aIssuer.ID()]
 	return ok
 }
 
 func (mi *MozIssuers) IsIssuerEnrolled(aIssuer storage.Issuer) bool {
-	if _, ok := mi.issuerMap[aIssuer.ID()]; ok {
-		data := mi.issuerMap[aIssuer.ID()]
-		return data.enrolled
-	}
-	return false
+	mi.mutex.Lock()
+	defer mi.mutex.Unlock()
+
+	return mi.issuerMap[aIssuer.ID()].enrolled
 }
 
 func (mi *MozIssuers) GetCertificateForIssuer(aIssuer storage.Issuer) (*x509.Certificate, error) {
+	if cert, ok := mi.certCache.Load(aIssuer.ID()); ok {
+		return cert.(*x509.Certificate), nil
+	}
+
 	mi.mutex.Lock()
 	defer mi.mutex.Unlock()
 
@@ -261,7 +657,48 @@ func (mi *MozIssuers) GetCertificateForIssuer(aIssuer storage.Issuer) (*x509.Cer
 	if !ok {
 		return nil, fmt.Errorf("Unknown issuer: %s", aIssuer.ID())
 	}
-	return entry.certs[0].cert, nil
+	cert := entry.certs[0].cert
+	if cert == nil {
+		return nil, fmt.Errorf("No certificate for issuer: %s", aIssuer.ID())
+	}
+	mi.certCache.Store(aIssuer.ID(), cert)
+	return cert, nil
+}
+
+// Returns every certificate in the program with the given DER subject, e.g.
+// to find the signer of an indirect CRL.
+func (mi *MozIssuers) GetCertificatesForSubject(aRawSubject []byte) []*x509.Certificate {
+	mi.mutex.Lock()
+	defer mi.mutex.Unlock()
+
+	var certs []*x509.Certificate
+	for _, entry := range mi.issuerMap {
+		for _, ic := range entry.certs {
+			if ic.cert != nil && bytes.Equal(ic.cert.RawSubject, aRawSubject) {
+				certs = append(certs, ic.cert)
+			}
+		}
+	}
+	return certs
+}
+
+// Returns the certificate whose Subject Key Identifier is aKeyId, as named by
+// a CRL's Authority Key Identifier. Unlike the subject, this tells apart a
+// CA's old and new keys when it rotates its key but keeps its name.
+func (mi *MozIssuers) GetCertificateForIssuerByAKI(aKeyId []byte) (*x509.Certificate, error) {
+	mi.mutex.Lock()
+	defer mi.mutex.Unlock()
+
+	if len(aKeyId) > 0 {
+		for _, entry := range mi.issuerMap {
+			for _, ic := range entry.certs {
+				if ic.cert != nil && bytes.Equal(ic.cert.SubjectKeyId, aKeyId) {
+					return ic.cert, nil
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("Unknown authority key identifier: %x", aKeyId)
 }
 
 func (mi *MozIssuers) GetSubjectForIssuer(aIssuer storage.Issuer) (string, error) {
@@ -299,17 +736,66 @@ func decodeCertificateFromRow(aColMap map[string]int, aRow []string, aLineNum in
 	return cert, nil
 }
 
+// Returns the CCADB rows left out under SkipInvalidRows, in the order read
+func (mi *MozIssuers) GetInvalidRows() []InvalidCCADBRow {
+	mi.mutex.Lock()
+	defer mi.mutex.Unlock()
+
+	rows := make([]InvalidCCADBRow, len(mi.invalidRows))
+	copy(rows, mi.invalidRows)
+	return rows
+}
+
+// Returns the CRL URLs CCADB lists across all of the issuer's certificates,
+// in the order first listed, without repeats
+func (mi *MozIssuers) GetCrlUrlsForIssuer(aIssuer storage.Issuer) ([]string, error) {
+	mi.mutex.Lock()
+	defer mi.mutex.Unlock()
+
+	entry, ok := mi.issuerMap[aIssuer.ID()]
+	if !ok {
+		return nil, fmt.Errorf("Unknown issuer: %s", aIssuer.ID())
+	}
+
+	urls := []string{}
+	seen := make(map[string]bool)
+	for _, ic := range entry.certs {
+		for _, crlUrl := range ic.crlUrls {
+			if !seen[crlUrl] {
+				seen[crlUrl] = true
+				urls = append(urls, crlUrl)
+			}
+		}
+	}
+	return urls, nil
+}
+
+// CCADB separates the URLs in its CRL URL(s) column with commas, semicolons,
+// or line breaks, depending on how they were entered
+func crlUrlsFromRow(aColMap map[string]int, aRow []string) []string {
+	index, ok := aColMap[kCCADBCrlUrlsColumn]
+	if !ok || index >= len(aRow) {
+		return nil
+	}
+	return strings.FieldsFunc(aRow[index], func(r rune) bool {
+		return r == ',' || r == ';' || unicode.IsSpace(r)
+	})
+}
+
 func (mi *MozIssuers) InsertIssuerFromCertAndPem(aCert *x509.Certificate, aPem string) storage.Issuer {
-	issuer := storage.NewIssuer(aCert)
-	ic := issuerCert{
+	return mi.insertIssuerCert(issuerCert{
 		cert:      aCert,
 		subjectDN: aCert.Subject.String(),
 		pemInfo:   aPem,
-	}
+	})
+}
+
+func (mi *MozIssuers) insertIssuerCert(ic issuerCert) storage.Issuer {
+	issuer := storage.NewIssuer(ic.cert)
 
 	v, exists := mi.issuerMap[issuer.ID()]
 	if exists {
-		glog.V(1).Infof("[%s] Duplicate issuer ID: %v with %v", issuer.ID(), v, aCert.Subject.String())
+		glog.V(1).Infof("[%s] Duplicate issuer ID: %v with %v", issuer.ID(), v, ic.subjectDN)
 		v.certs = append(v.certs, ic)
 		mi.issuerMap[issuer.ID()] = v
 		return issuer
@@ -318,6 +804,7 @@ func (mi *MozIssuers) InsertIssuerFromCertAndPem(aCert *x509.Certificate, aPem s
 	mi.issuerMap[issuer.ID()] = IssuerData{
 		certs:    []issuerCert{ic},
 		enrolled: false,
+		reason:   ReasonUnprocessed,
 	}
 	return issuer
 }
@@ -327,9 +814,11 @@ func (mi *MozIssuers) NewTestIssuerFromSubjectString(aSub string) storage.Issuer
 	ic := issuerCert{
 		subjectDN: aSub,
 	}
+	mi.certCache.Delete(issuer.ID())
 	mi.issuerMap[issuer.ID()] = IssuerData{
 		certs:    []issuerCert{ic},
 		enrolled: false,
+		reason:   ReasonUnprocessed,
 	}
 	return issuer
 }
@@ -349,18 +838,90 @@ func (mi *MozIssuers) parseCCADB(aStream io.Reader) error {
 		columnMap[attr] = index
 	}
 
+	now := time.Now()
+	excluded := make(map[ccadbExclusion]int)
+
 	lineNum := 1
 	for row, err := reader.Read(); err == nil; row, err = reader.Read() {
 		lineNum += 1
+		rowLineNum := lineNum
+		lineNum += strings.Count(strings.Join(row, ""), "\n")
 
-		cert, err := decodeCertificateFromRow(columnMap, row, lineNum)
+		if !mi.IncludeInactive {
+			if exclusion, ok := inactiveCCADBRow(columnMap, row, rowLineNum, now); ok {
+				excluded[exclusion]++
+				continue
+			}
+		}
+
+		cert, err := decodeCertificateFromRow(columnMap, row, rowLineNum)
 		if err != nil {
-			return err
+			if !mi.SkipInvalidRows {
+				return err
+			}
+			invalid := InvalidCCADBRow{
+				Line:        rowLineNum,
+				Name:        ccadbColumn(columnMap, row, kCCADBNameColumn),
+				Fingerprint: ccadbColumn(columnMap, row, kCCADBFingerprintColumn),
+				Error:       err.Error(),
+			}
+			glog.Warningf("Skipping CCADB row for %q (sha256 %s): %s", invalid.Name, invalid.Fingerprint, err)
+			mi.invalidRows = append(mi.invalidRows, invalid)
+			continue
 		}
 
-		_ = mi.InsertIssuerFromCertAndPem(cert, strings.Trim(row[columnMap["PEM"]], "'"))
-		lineNum += strings.Count(strings.Join(row, ""), "\n")
+		_ = mi.insertIssuerCert(issuerCert{
+			cert:      cert,
+			subjectDN: cert.Subject.String(),
+			pemInfo:   strings.Trim(row[columnMap["PEM"]], "'"),
+			crlUrls:   crlUrlsFromRow(columnMap, row),
+		})
+	}
+
+	for _, exclusion := range []ccadbExclusion{excludedRevoked, excludedExpired} {
+		if excluded[exclusion] > 0 {
+			glog.Infof("Excluded %d %s certificates from CCADB", excluded[exclusion], exclusion)
+		}
+	}
+	if len(mi.invalidRows) > 0 {
+		glog.Warningf("Skipped %d CCADB rows whose certificates couldn't be decoded", len(mi.invalidRows))
 	}
 
 	return nil
 }
+
+// Returns the row's value for aColumn, or "" if the CSV lacks it
+func ccadbColumn(aColMap map[string]int, aRow []string, aColumn string) string {
+	if index, ok := aColMap[aColumn]; ok && index < len(aRow) {
+		return strings.TrimSpace(aRow[index])
+	}
+	return ""
+}
+
+// Reports whether a CCADB row is for a certificate that's revoked, or that
+// expired before aNow. Rows without the status or validity columns, or with
+// values that can't be parsed, are kept.
+func inactiveCCADBRow(aColMap map[string]int, aRow []string, aLineNum int,
+	aNow time.Time) (ccadbExclusion, bool) {
+	if index, ok := aColMap[kCCADBRevocationStatusColumn]; ok && index < len(aRow) {
+		status := strings.TrimSpace(aRow[index])
+		if status != "" && status != kCCADBNotRevoked {
+			return excludedRevoked, true
+		}
+	}
+
+	if index, ok := aColMap[kCCADBValidToColumn]; ok && index < len(aRow) {
+		validTo, err := time.Parse(kCCADBValidToLayout, strings.TrimSpace(aRow[index]))
+		if err != nil {
+			glog.Warningf("Couldn't parse %s %q at line %d, keeping the certificate: %s",
+				kCCADBValidToColumn, aRow[index], aLineNum, err)
+			return "", false
+		}
+		// The date is inclusive, so the certificate is valid through its end
+		if aNow.After(validTo.AddDate(0, 0, 1)) {
+			return excludedExpired, true
+		}
+	}
+
+	return "", false
+}
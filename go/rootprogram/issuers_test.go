@@ -1,11 +1,14 @@
 package rootprogram
 
 import (
+	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/csv"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
@@ -13,7 +16,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -23,8 +30,10 @@ import (
 
 const (
 	// curl https://ccadb-public.secure.force.com/mozilla/PublicAllInterCertsIncTechConsWithPEMCSV | head -n 36 | pbcopy
+	// The "Valid To [GMT]" dates are moved into the future, so these rows
+	// aren't excluded as expired.
 	kFirstTwoLines = `"CA Owner","Parent Name","Certificate Name","Certificate Issuer Common Name","Certificate Issuer Organization","Certificate Issuer Organizational Unit","Certificate Subject Common Name","Certificate Subject Organization","Certificate Serial Number","SHA-1 Fingerprint","SHA-256 Fingerprint","Subject + SPKI SHA256","Technically Constrained","Valid From [GMT]","Valid To [GMT]","CRL URL(s)","Public Key Algorithm","Signature Hash Algorithm","Key Usage","Extended Key Usage","CP/CPS Same As Parent","Certificate Policy (CP)","Certification Practice Statement (CPS)","Audits Same As Parent","Standard Audit","BR Audit","Auditor","Standard Audit Statement Dt","Management Assertions By","Comments","PEM"
-"AC Camerfirma, S.A.","AC Camerfirma","RACER","AC Camerfirma","AC Camerfirma SA","","RACER","AC Camerfirma SA","01","F82701F8E04770F3448C19070F9B2158B16621A0","F1712177935DBA40BDBD99C5F753319CF6293549B7284741E43916AD3BFBDD75","80C14510C26519770718D4086A713C32DBC2209FF30B2AAA36523CC310424096","false","2003 Dec 04","2023 Dec 04","http://crl.camerfirma.com/racer.crl","RSA 2047 bits","SHA1WithRSA","Digital Signature, Certificate Sign, CRL Sign","(not present)","TRUE","","","TRUE","","","","","","","'-----BEGIN CERTIFICATE-----
+"AC Camerfirma, S.A.","AC Camerfirma","RACER","AC Camerfirma","AC Camerfirma SA","","RACER","AC Camerfirma SA","01","F82701F8E04770F3448C19070F9B2158B16621A0","F1712177935DBA40BDBD99C5F753319CF6293549B7284741E43916AD3BFBDD75","80C14510C26519770718D4086A713C32DBC2209FF30B2AAA36523CC310424096","false","2003 Dec 04","2099 Dec 04","http://crl.camerfirma.com/racer.crl","RSA 2047 bits","SHA1WithRSA","Digital Signature, Certificate Sign, CRL Sign","(not present)","TRUE","","","TRUE","","","","","","","'-----BEGIN CERTIFICATE-----
 MIIGDzCCBPegAwIBAgIBATANBgkqhkiG9w0BAQUFADCBxjELMAkGA1UEBhMCRVMx
 KzApBgkqhkiG9w0BCQEWHGFjX2NhbWVyZmlybWFAY2FtZXJmaXJtYS5jb20xEjAQ
 BgNVBAUTCUE4Mjc0MzI4NzFDMEEGA1UEBxM6TWFkcmlkIChzZWUgY3VycmVudCBh
@@ -64,10 +73,10 @@ cxhzf8XjZXECKL54a/4o9ISBcA==
 	kFirstTwoLinesSubject  = "SERIALNUMBER=A82743287,CN=RACER,O=AC Camerfirma SA,L=Madrid (see current address at www.camerfirma.com/address),C=ES"
 
 	kFirstTwoLinesNoPem = `"CA Owner","Parent Name","Certificate Name","Certificate Issuer Common Name","Certificate Issuer Organization","Certificate Issuer Organizational Unit","Certificate Subject Common Name","Certificate Subject Organization","Certificate Serial Number","SHA-1 Fingerprint","SHA-256 Fingerprint","Subject + SPKI SHA256","Technically Constrained","Valid From [GMT]","Valid To [GMT]","CRL URL(s)","Public Key Algorithm","Signature Hash Algorithm","Key Usage","Extended Key Usage","CP/CPS Same As Parent","Certificate Policy (CP)","Certification Practice Statement (CPS)","Audits Same As Parent","Standard Audit","BR Audit","Auditor","Standard Audit Statement Dt","Management Assertions By","Comments","PEM"
-"AC Camerfirma, S.A.","AC Camerfirma","RACER","AC Camerfirma","AC Camerfirma SA","","RACER","AC Camerfirma SA","01","F82701F8E04770F3448C19070F9B2158B16621A0","F1712177935DBA40BDBD99C5F753319CF6293549B7284741E43916AD3BFBDD75","80C14510C26519770718D4086A713C32DBC2209FF30B2AAA36523CC310424096","false","2003 Dec 04","2023 Dec 04","http://crl.camerfirma.com/racer.crl","RSA 2047 bits","SHA1WithRSA","Digital Signature, Certificate Sign, CRL Sign","(not present)","TRUE","","","TRUE","","","","","","",""`
+"AC Camerfirma, S.A.","AC Camerfirma","RACER","AC Camerfirma","AC Camerfirma SA","","RACER","AC Camerfirma SA","01","F82701F8E04770F3448C19070F9B2158B16621A0","F1712177935DBA40BDBD99C5F753319CF6293549B7284741E43916AD3BFBDD75","80C14510C26519770718D4086A713C32DBC2209FF30B2AAA36523CC310424096","false","2003 Dec 04","2099 Dec 04","http://crl.camerfirma.com/racer.crl","RSA 2047 bits","SHA1WithRSA","Digital Signature, Certificate Sign, CRL Sign","(not present)","TRUE","","","TRUE","","","","","","",""`
 
 	kEmptyAKI = `"CA Owner","Parent Name","Certificate Name","Certificate Issuer Common Name","Certificate Issuer Organization","Certificate Issuer Organizational Unit","Certificate Subject Common Name","Certificate Subject Organization","Certificate Serial Number","SHA-1 Fingerprint","SHA-256 Fingerprint","Subject + SPKI SHA256","Technically Constrained","Valid From [GMT]","Valid To [GMT]","CRL URL(s)","Public Key Algorithm","Signature Hash Algorithm","Key Usage","Extended Key Usage","CP/CPS Same As Parent","Certificate Policy (CP)","Certification Practice Statement (CPS)","Audits Same As Parent","Standard Audit","BR Audit","Auditor","Standard Audit Statement Dt","Management Assertions By","Comments","PEM"
-"Test Corporation","Test Corporation","test","Test Corporation","Test Corporation CA","","test","Test Corporation CA","71:8a:bd:2f:20:13:18:ea:a2:73:67:b0:3d:b5:3f:6b:24:3c:f6:f5","F82701F8E04770F3448C19070F9B2158B16621A0","F1712177935DBA40BDBD99C5F753319CF6293549B7284741E43916AD3BFBDD75","80C14510C26519770718D4086A713C32DBC2209FF30B2AAA36523CC310424096","false","2016 Nov 27","2019 Feb 05","http://crl.example.com/test.crl","RSA 2048 bits","SHA1WithRSA","Digital Signature, Certificate Sign, CRL Sign","(not present)","TRUE","","","TRUE","","","","","","","'-----BEGIN CERTIFICATE-----
+"Test Corporation","Test Corporation","test","Test Corporation","Test Corporation CA","","test","Test Corporation CA","71:8a:bd:2f:20:13:18:ea:a2:73:67:b0:3d:b5:3f:6b:24:3c:f6:f5","F82701F8E04770F3448C19070F9B2158B16621A0","F1712177935DBA40BDBD99C5F753319CF6293549B7284741E43916AD3BFBDD75","80C14510C26519770718D4086A713C32DBC2209FF30B2AAA36523CC310424096","false","2016 Nov 27","2099 Feb 05","http://crl.example.com/test.crl","RSA 2048 bits","SHA1WithRSA","Digital Signature, Certificate Sign, CRL Sign","(not present)","TRUE","","","TRUE","","","","","","","'-----BEGIN CERTIFICATE-----
 MIICyTCCAbGgAwIBAgIURxOdvmKY1LMeejuRTiuHeGBhZHwwDQYJKoZIhvcNAQEL
 BQAwDTELMAkGA1UEAwwCY2EwIhgPMjAxNjExMjcwMDAwMDBaGA8yMDE5MDIwNTAw
 MDAwMFowDTELMAkGA1UEAwwCY2EwggEiMA0GCSqGSIb3DQEBAQUAA4IBDwAwggEK
@@ -109,6 +118,17 @@ func makeCert(t *testing.T, issuerDN string, expDate string, serial storage.Seri
 		return nil, ""
 	}
 
+	return makeCertWithKey(t, privKey, issuerDN, expDate, serial)
+}
+
+// Certificates sharing a key share an issuer ID
+func makeCertWithKey(t *testing.T, privKey *ecdsa.PrivateKey, issuerDN string, expDate string,
+	serial storage.Serial) (*newx509.Certificate, string) {
+	return makeCertWithKeyAndKeyId(t, privKey, issuerDN, expDate, serial, nil)
+}
+
+func makeCertWithKeyAndKeyId(t *testing.T, privKey *ecdsa.PrivateKey, issuerDN string, expDate string,
+	serial storage.Serial, keyId []byte) (*newx509.Certificate, string) {
 	notAfter, err := time.Parse("2006-01-02", expDate)
 	if err != nil {
 		t.Fatalf("Programmer error on timestamp %s: %v", expDate, err)
@@ -120,9 +140,10 @@ func makeCert(t *testing.T, issuerDN string, expDate string, serial storage.Seri
 		Subject: pkix.Name{
 			CommonName: issuerDN,
 		},
-		NotBefore: notBefore,
-		NotAfter:  notAfter,
-		IsCA:      true,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		IsCA:         true,
+		SubjectKeyId: keyId,
 	}
 	certBytes, err := x509.CreateCertificate(rand.Reader, &template, &template,
 		privKey.Public(), privKey)
@@ -181,6 +202,33 @@ func Test_GetIssuers(t *testing.T) {
 	}
 }
 
+func Test_GetIssuersOrdered(t *testing.T) {
+	mi := NewMozillaIssuers()
+	for i := 0; i < 20; i++ {
+		cert, pem := makeCert(t, fmt.Sprintf("CN=Issuer %d", i), "2030-01-01", storage.NewSerialFromHex("01"))
+		mi.InsertIssuerFromCertAndPem(cert, pem)
+	}
+
+	issuers := mi.GetIssuers()
+	for i := 1; i < len(issuers); i++ {
+		if issuers[i-1].ID() >= issuers[i].ID() {
+			t.Fatalf("Expected issuers ordered by ID, got %s before %s", issuers[i-1].ID(), issuers[i].ID())
+		}
+	}
+
+	first, err := json.Marshal(mi.GetIssuers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := json.Marshal(mi.GetIssuers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Errorf("Expected identical encodings, got:\n%s\n%s", first, second)
+	}
+}
+
 func Test_GetIssuersEmptyAKI(t *testing.T) {
 	mi, err := loadSampleIssuers(kEmptyAKI)
 	if err != nil {
@@ -253,6 +301,96 @@ func Test_GetCertificateForIssuer(t *testing.T) {
 	if cert.Subject.String() != kFirstTwoLinesSubject {
 		t.Error("Unexpected certificate subject")
 	}
+
+	// In the program, but without a certificate
+	issuer := mi.NewTestIssuerFromSubjectString("No Certificate")
+	cert, err = mi.GetCertificateForIssuer(issuer)
+	if err == nil || err.Error() != "No certificate for issuer: No Certificate" {
+		t.Errorf("Expected an error for an issuer without a certificate, got %v", err)
+	}
+	if cert != nil {
+		t.Error("Cert should have been nil")
+	}
+}
+
+// kFirstTwoLines with a second row whose certificate is corrupt
+func ccadbWithMalformedPem(t *testing.T) string {
+	t.Helper()
+	records, err := csv.NewReader(strings.NewReader(kFirstTwoLines)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[name] = i
+	}
+
+	badRow := append([]string{}, records[1]...)
+	badRow[columns["Certificate Name"]] = "Broken RACER"
+	badRow[columns["SHA-256 Fingerprint"]] = "00112233"
+	badRow[columns["PEM"]] = "'-----BEGIN CERTIFICATE-----\nMIIGDzCCBPegAwIBAgIBATANBgkq\n-----END CERTIFICATE-----'"
+	records = append(records, badRow)
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if err = writer.WriteAll(records); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func Test_LoadMalformedPem(t *testing.T) {
+	content := ccadbWithMalformedPem(t)
+
+	// By default, the whole load fails up front
+	_, err := loadSampleIssuers(content)
+	if err == nil {
+		t.Fatal("Expected the malformed certificate to fail the load")
+	}
+
+	tmpfile, err := ioutil.TempFile("", "Test_LoadMalformedPem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	if err = ioutil.WriteFile(tmpfile.Name(), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mi := NewMozillaIssuers()
+	mi.SkipInvalidRows = true
+	if err = mi.LoadFromDisk(tmpfile.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	issuers := mi.GetIssuers()
+	if len(issuers) != 1 || issuers[0].ID() != kFirstTwoLinesIssuerID {
+		t.Errorf("Expected only the valid issuer, got %v", issuers)
+	}
+	if _, err = mi.GetCertificateForIssuer(issuers[0]); err != nil {
+		t.Error(err)
+	}
+
+	invalid := mi.GetInvalidRows()
+	if len(invalid) != 1 {
+		t.Fatalf("Expected one invalid row, got %+v", invalid)
+	}
+	if invalid[0].Name != "Broken RACER" || invalid[0].Fingerprint != "00112233" || invalid[0].Error == "" {
+		t.Errorf("Expected the broken row to be identified, got %+v", invalid[0])
+	}
+	if invalid[0].Line <= 2 {
+		t.Errorf("Expected the broken row's line to follow the valid row, got %d", invalid[0].Line)
+	}
+
+	// Overlays are reported alongside
+	merged := NewMozillaIssuers()
+	merged.SkipInvalidRows = true
+	if err = merged.LoadFromDiskMerge(tmpfile.Name(), tmpfile.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if len(merged.GetInvalidRows()) != 2 {
+		t.Errorf("Expected an invalid row from each source, got %+v", merged.GetInvalidRows())
+	}
 }
 
 func Test_GetSubjectForIssuer(t *testing.T) {
@@ -286,6 +424,42 @@ func Test_GetSubjectForIssuer(t *testing.T) {
 	}
 }
 
+func Test_GetCrlUrlsForIssuer(t *testing.T) {
+	// Separated every way CCADB does, with a repeat
+	multipleDPs := strings.Replace(kFirstTwoLines, `"http://crl.camerfirma.com/racer.crl"`,
+		"\"http://crl.camerfirma.com/racer.crl, http://crl1.camerfirma.com/racer.crl;"+
+			"http://crl.camerfirma.com/racer.crl\nhttp://crl2.camerfirma.com/racer.crl\"", 1)
+	mi, err := loadSampleIssuers(multipleDPs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	urls, err := mi.GetCrlUrlsForIssuer(storage.NewIssuerFromString(kFirstTwoLinesIssuerID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"http://crl.camerfirma.com/racer.crl",
+		"http://crl1.camerfirma.com/racer.crl",
+		"http://crl2.camerfirma.com/racer.crl",
+	}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Errorf("Expected %v, got %v", expected, urls)
+	}
+
+	if _, err = mi.GetCrlUrlsForIssuer(storage.NewIssuerFromString("abc")); err == nil {
+		t.Error("Expected an unknown issuer to be an error")
+	}
+
+	// Certificates not from CCADB have none
+	cert, certPem := makeCert(t, "CN=Not From CCADB", "2030-01-01", storage.NewSerialFromHex("01"))
+	issuer := mi.InsertIssuerFromCertAndPem(cert, certPem)
+	urls, err = mi.GetCrlUrlsForIssuer(issuer)
+	if err != nil || len(urls) != 0 {
+		t.Errorf("Expected no CRL URLs, got %v: %v", urls, err)
+	}
+}
+
 func Test_SaveIssuersList(t *testing.T) {
 	mi, err := loadSampleIssuers(kFirstTwoLines)
 	if err != nil {
@@ -319,6 +493,77 @@ func Test_SaveIssuersList(t *testing.T) {
 	}
 }
 
+func Test_SaveIssuersListAtomic(t *testing.T) {
+	mi, err := loadSampleIssuers(kFirstTwoLines)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpDir, err := ioutil.TempDir("", "Test_SaveIssuersListAtomic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	finalPath := filepath.Join(tmpDir, "enrolled.json")
+	previousContents := []byte("[\"previous\"]")
+	if err = ioutil.WriteFile(finalPath, previousContents, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Block the tmp file from being written, simulating an interrupted save
+	if err = os.Mkdir(finalPath+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(finalPath+".tmp", "blocker"), []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = mi.SaveIssuersList(finalPath); err == nil {
+		t.Error("Expected failure writing the tmp file")
+	}
+
+	data, err := ioutil.ReadFile(finalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(previousContents) {
+		t.Errorf("Previous file should be intact, got %s", data)
+	}
+
+	if err = os.RemoveAll(finalPath + ".tmp"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = mi.SaveIssuersList(finalPath); err != nil {
+		t.Fatal(err)
+	}
+
+	list := make([]EnrolledIssuer, 0)
+	data, err = ioutil.ReadFile(finalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(data, &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Errorf("Unexpected issuers list length: %+v", list)
+	}
+
+	fi, err := os.Stat(finalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0644 {
+		t.Errorf("Unexpected permissions: %v", fi.Mode().Perm())
+	}
+
+	if _, err = os.Stat(finalPath + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("tmp file should have been cleaned up: %v", err)
+	}
+}
+
 func Test_SaveLoadIssuersList(t *testing.T) {
 	enrolledCert, enrolledCertPem := makeCert(t, "CN=Enrolled Issuer", "2001-01-01",
 		storage.NewSerialFromHex("00"))
@@ -363,6 +608,95 @@ func Test_SaveLoadIssuersList(t *testing.T) {
 	}
 }
 
+func Test_SaveIssuersListReasons(t *testing.T) {
+	reasons := []EnrollmentReason{
+		ReasonUnprocessed,
+		ReasonEnrolled,
+		ReasonNoCrls,
+		ReasonNoRevocations,
+		ReasonSomeCrlsFailed,
+		ReasonAllCrlsFailedValidation,
+		ReasonAllCrlsFailedDownload,
+		ReasonRevokedInOneCRL,
+	}
+
+	mi := NewMozillaIssuers()
+	expected := make(map[string]EnrollmentReason)
+	for i, reason := range reasons {
+		cert, certPem := makeCert(t, fmt.Sprintf("CN=Issuer %d", i), "2001-01-01",
+			storage.NewSerialFromHex("00"))
+		issuer := mi.InsertIssuerFromCertAndPem(cert, certPem)
+
+		switch reason {
+		case ReasonUnprocessed:
+		case ReasonEnrolled:
+			mi.Enroll(issuer)
+		default:
+			mi.MarkUnenrolled(issuer, reason)
+		}
+		expected[certPem] = reason
+	}
+
+	tmpfile, err := ioutil.TempFile("", "Test_SaveIssuersListReasons")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	err = mi.SaveIssuersList(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bytes, err := ioutil.ReadFile(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, reason := range reasons {
+		if !strings.Contains(string(bytes), fmt.Sprintf("\"reason\":\"%s\"", reason)) {
+			t.Errorf("Expected reason %s in the JSON: %s", reason, bytes)
+		}
+	}
+
+	list := make([]EnrolledIssuer, 0)
+	err = json.Unmarshal(bytes, &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != len(reasons) {
+		t.Fatalf("Unexpected issuers list length: %+v", list)
+	}
+
+	for _, ei := range list {
+		if ei.Reason != expected[ei.Pem] {
+			t.Errorf("Expected reason %s, got %s for %s", expected[ei.Pem], ei.Reason, ei.Subject)
+		}
+		if ei.Enrolled != (ei.Reason == ReasonEnrolled) {
+			t.Errorf("Enrolled flag disagrees with reason %s", ei.Reason)
+		}
+	}
+
+	loadedIssuers := NewMozillaIssuers()
+	if err = loadedIssuers.LoadEnrolledIssuers(tmpfile.Name()); err != nil {
+		t.Fatal(err)
+	}
+	for _, ei := range list {
+		cert, err := decodeCertificateFromPem(ei.Pem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reason, err := loadedIssuers.GetEnrollmentReason(storage.NewIssuer(cert))
+		if err != nil {
+			t.Error(err)
+		}
+		if reason != ei.Reason {
+			t.Errorf("Expected loaded reason %s, got %s", ei.Reason, reason)
+		}
+	}
+}
+
 func Test_IsIssuerEnrolled(t *testing.T) {
 	cert, certPem := makeCert(t, "CN=Issuer", "2001-01-01",
 		storage.NewSerialFromHex("00"))
@@ -397,7 +731,7 @@ func Test_NewTestIssuerFromSubjectString(t *testing.T) {
 
 func Test_LoadFromURL(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintln(w, kFirstTwoLines)
+		fmt.Fprint(w, kFirstTwoLines)
 	}))
 	defer ts.Close()
 
@@ -432,7 +766,7 @@ func Test_LoadFromURL(t *testing.T) {
 
 func Test_LoadFromURLToDefaultLocation(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintln(w, kFirstTwoLines)
+		fmt.Fprint(w, kFirstTwoLines)
 	}))
 	defer ts.Close()
 
@@ -472,6 +806,7 @@ func Test_LoadFrom404URLNoLocal(t *testing.T) {
 	mi := NewMozillaIssuers()
 	mi.ReportUrl = ts.URL
 	mi.DiskPath = tmpfile.Name()
+	mi.LoadRetryDelay = time.Millisecond
 
 	err = mi.Load()
 	if err == nil {
@@ -585,6 +920,7 @@ func Test_LoadInvalidWithNoLocal(t *testing.T) {
 	mi := NewMozillaIssuers()
 	mi.ReportUrl = ts.URL
 	mi.DiskPath = tmpfile.Name()
+	mi.LoadRetryDelay = time.Millisecond
 
 	err = mi.Load()
 	if err == nil {
@@ -592,6 +928,144 @@ func Test_LoadInvalidWithNoLocal(t *testing.T) {
 	}
 }
 
+func Test_LoadFromURLDirect(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv; charset=UTF-8")
+		fmt.Fprint(w, kFirstTwoLines)
+	}))
+	defer ts.Close()
+
+	mi := NewMozillaIssuers()
+	err := mi.LoadFromURL(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subject, err := mi.GetSubjectForIssuer(storage.NewIssuerFromString(kFirstTwoLinesIssuerID))
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if subject != kFirstTwoLinesSubject {
+		t.Errorf("Unexpected certificate subject: %s", subject)
+	}
+}
+
+func Test_LoadFromURLDirectBadContentType(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer ts.Close()
+
+	mi := NewMozillaIssuers()
+	mi.LoadRetryDelay = time.Millisecond
+	err := mi.LoadFromURL(context.Background(), ts.URL)
+	if err == nil || !strings.Contains(err.Error(), "Unexpected content type") {
+		t.Errorf("Expected a content type error, got %v", err)
+	}
+}
+
+func Test_LoadFromURLDirect404(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	mi := NewMozillaIssuers()
+	mi.LoadRetryDelay = time.Millisecond
+	err := mi.LoadFromURL(context.Background(), ts.URL)
+	if err == nil || !strings.Contains(err.Error(), "Non-OK status") {
+		t.Errorf("Expected a status error, got %v", err)
+	}
+}
+
+// Fails the first aFailures requests with a 503, then serves kFirstTwoLines
+func flakyCCADBServer(aFailures int32, aCount *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(aCount, 1) <= aFailures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		fmt.Fprint(w, kFirstTwoLines)
+	}))
+}
+
+func Test_LoadFromURLRetries(t *testing.T) {
+	var count int32
+	ts := flakyCCADBServer(2, &count)
+	defer ts.Close()
+
+	mi := NewMozillaIssuers()
+	mi.LoadRetryDelay = time.Millisecond
+	if err := mi.LoadFromURL(context.Background(), ts.URL); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 requests, got %d", count)
+	}
+	if _, err := mi.GetSubjectForIssuer(storage.NewIssuerFromString(kFirstTwoLinesIssuerID)); err != nil {
+		t.Error(err)
+	}
+
+	count = 0
+	exhausted := NewMozillaIssuers()
+	exhausted.LoadRetries = 1
+	exhausted.LoadRetryDelay = time.Millisecond
+	err := exhausted.LoadFromURL(context.Background(), ts.URL)
+	if err == nil || !strings.Contains(err.Error(), "Non-OK status") {
+		t.Errorf("Expected a status error once the retries ran out, got %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 requests, got %d", count)
+	}
+	if len(exhausted.GetIssuers()) != 0 {
+		t.Error("Expected nothing to be loaded")
+	}
+}
+
+func Test_LoadRetries(t *testing.T) {
+	var count int32
+	ts := flakyCCADBServer(3, &count)
+	defer ts.Close()
+
+	tmpfile, err := ioutil.TempFile("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	mi := NewMozillaIssuers()
+	mi.ReportUrl = ts.URL
+	mi.DiskPath = tmpfile.Name()
+	mi.LoadRetryDelay = time.Millisecond
+	if err = mi.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 {
+		t.Errorf("Expected 4 requests, got %d", count)
+	}
+	if _, err = mi.GetSubjectForIssuer(storage.NewIssuerFromString(kFirstTwoLinesIssuerID)); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_LoadFromURLRetriesCancelled(t *testing.T) {
+	var count int32
+	ts := flakyCCADBServer(10, &count)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The default delay would take minutes
+	mi := NewMozillaIssuers()
+	if err := mi.LoadFromURL(ctx, ts.URL); err == nil {
+		t.Error("Expected an error")
+	}
+	if count > 1 {
+		t.Errorf("Expected no retries once cancelled, got %d requests", count)
+	}
+}
+
 func Test_DatasetAge(t *testing.T) {
 	mi, err := loadSampleIssuers(kEmptyAKI)
 	if err != nil {
@@ -606,3 +1080,397 @@ func Test_DatasetAge(t *testing.T) {
 		t.Errorf("expected less than one second of age, got %v", mi.DatasetAge())
 	}
 }
+
+func Test_GetCertificateForIssuerConcurrent(t *testing.T) {
+	mi := NewMozillaIssuers()
+	cert, _ := makeCert(t, "CN=Concurrent CA", "2050-01-01", storage.NewSerialFromHex("01"))
+	issuer := mi.InsertIssuerFromCertAndPem(cert, "")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				found, err := mi.GetCertificateForIssuer(issuer)
+				if err != nil || found != cert {
+					t.Errorf("Expected the inserted certificate, got %v, %v", found, err)
+					return
+				}
+				mi.Enroll(issuer)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Replacing the entry must not leave a stale certificate behind
+	mi.NewTestIssuerFromSubjectString(issuer.ID())
+	found, err := mi.GetCertificateForIssuer(storage.NewIssuerFromString(issuer.ID()))
+	if err == nil {
+		t.Error("Expected an error for a test issuer, which has no certificate")
+	}
+	if found != nil {
+		t.Errorf("Expected no certificate for a test issuer, got %v", found.Subject)
+	}
+}
+
+// Simulates the download and aggregate workers looking up the issuer once per
+// CRL shard, while other workers update enrollment.
+func Benchmark_GetCertificateForIssuer(b *testing.B) {
+	mi := NewMozillaIssuers()
+	issuers := make([]storage.Issuer, 0, 64)
+	for i := 0; i < 64; i++ {
+		cert, _ := makeCert(&testing.T{}, fmt.Sprintf("CN=Issuer %d", i), "2050-01-01",
+			storage.NewSerialFromHex(fmt.Sprintf("%02x", i+1)))
+		issuers = append(issuers, mi.InsertIssuerFromCertAndPem(cert, ""))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			issuer := issuers[i%len(issuers)]
+			if _, err := mi.GetCertificateForIssuer(issuer); err != nil {
+				b.Fatal(err)
+			}
+			if i%16 == 0 {
+				mi.Enroll(issuer)
+			}
+			i++
+		}
+	})
+}
+
+func writeCCADBFile(t *testing.T, aDir string, aName string, aPems ...string) string {
+	t.Helper()
+	var sb strings.Builder
+	sb.WriteString("\"Certificate Name\",\"PEM\"\n")
+	for i, p := range aPems {
+		sb.WriteString(fmt.Sprintf("\"cert %d\",\"'%s'\"\n", i, p))
+	}
+	path := filepath.Join(aDir, aName)
+	if err := ioutil.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_LoadFromDiskMerge(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_LoadFromDiskMerge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	sharedKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	baseShared, baseSharedPem := makeCertWithKey(t, sharedKey, "Base Shared", "2050-01-01", storage.NewSerialFromHex("01"))
+	_, baseOnlyPem := makeCert(t, "Base Only", "2050-01-01", storage.NewSerialFromHex("02"))
+	overlayShared, overlaySharedPem := makeCertWithKey(t, sharedKey, "Overlay Shared", "2050-01-01", storage.NewSerialFromHex("03"))
+	_, overlayOnlyPem := makeCert(t, "Overlay Only", "2050-01-01", storage.NewSerialFromHex("04"))
+
+	basePath := writeCCADBFile(t, tmpDir, "base.csv", baseSharedPem, baseOnlyPem)
+	overlayPath := writeCCADBFile(t, tmpDir, "overlay.csv", overlaySharedPem, overlayOnlyPem)
+
+	mi := NewMozillaIssuers()
+	if err := mi.LoadFromDiskMerge(basePath); err != nil {
+		t.Fatal(err)
+	}
+
+	sharedIssuer := storage.NewIssuer(baseShared)
+	cert, err := mi.GetCertificateForIssuer(sharedIssuer)
+	if err != nil || cert.Subject.CommonName != "Base Shared" {
+		t.Fatalf("Expected the base certificate before merging, got %v, %v", cert, err)
+	}
+
+	if err := mi.LoadFromDiskMerge(overlayPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mi.GetIssuers()) != 3 {
+		t.Errorf("Expected 3 issuers, got %d", len(mi.GetIssuers()))
+	}
+
+	cert, err = mi.GetCertificateForIssuer(sharedIssuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.Equal(overlayShared) {
+		t.Errorf("Expected the overlay to override the base, got %s", cert.Subject)
+	}
+
+	subject, err := mi.GetSubjectForIssuer(sharedIssuer)
+	if err != nil || subject != "CN=Overlay Shared" {
+		t.Errorf("Expected the overlay subject, got %s, %v", subject, err)
+	}
+
+	// The overridden certificate is gone, not kept alongside
+	if certs := mi.GetCertificatesForSubject(baseShared.RawSubject); len(certs) != 0 {
+		t.Errorf("Expected the base certificate to be replaced, got %d", len(certs))
+	}
+}
+
+func Test_LoadFromDiskMergeOrder(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_LoadFromDiskMergeOrder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	sharedKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, firstPem := makeCertWithKey(t, sharedKey, "First", "2050-01-01", storage.NewSerialFromHex("01"))
+	_, secondPem := makeCertWithKey(t, sharedKey, "Second", "2050-01-01", storage.NewSerialFromHex("02"))
+
+	firstPath := writeCCADBFile(t, tmpDir, "first.csv", firstPem)
+	secondPath := writeCCADBFile(t, tmpDir, "second.csv", secondPem)
+
+	mi := NewMozillaIssuers()
+	if err := mi.LoadFromDiskMerge(secondPath, firstPath); err != nil {
+		t.Fatal(err)
+	}
+
+	subject, err := mi.GetSubjectForIssuer(storage.NewIssuer(first))
+	if err != nil || subject != "CN=First" {
+		t.Errorf("Expected the last source to win, got %s, %v", subject, err)
+	}
+}
+
+func Test_LoadFromDiskMergeDuplicates(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_LoadFromDiskMergeDuplicates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cert, certPem := makeCert(t, "Duplicated", "2050-01-01", storage.NewSerialFromHex("01"))
+	path := writeCCADBFile(t, tmpDir, "dup.csv", certPem)
+
+	mi := NewMozillaIssuers()
+	if err := mi.LoadFromDiskMerge(path, path, path); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mi.GetIssuers()) != 1 {
+		t.Errorf("Expected one issuer, got %d", len(mi.GetIssuers()))
+	}
+	if certs := mi.GetCertificatesForSubject(cert.RawSubject); len(certs) != 1 {
+		t.Errorf("Expected one certificate, got %d", len(certs))
+	}
+}
+
+func Test_LoadFromDiskMergeMissing(t *testing.T) {
+	mi := NewMozillaIssuers()
+	err := mi.LoadFromDiskMerge("/nonexistent/ccadb.csv")
+	if err == nil || !strings.Contains(err.Error(), "/nonexistent/ccadb.csv") {
+		t.Errorf("Expected an error naming the missing source, got %v", err)
+	}
+}
+
+func Test_GetCertificateForIssuerByAKI(t *testing.T) {
+	mi := NewMozillaIssuers()
+
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same DN before and after a key rotation
+	oldCert, oldPem := makeCertWithKeyAndKeyId(t, oldKey, "Rotating CA", "2050-01-01",
+		storage.NewSerialFromHex("01"), []byte{0x01, 0x01})
+	newCert, newPem := makeCertWithKeyAndKeyId(t, newKey, "Rotating CA", "2050-01-01",
+		storage.NewSerialFromHex("02"), []byte{0x02, 0x02})
+	mi.InsertIssuerFromCertAndPem(oldCert, oldPem)
+	mi.InsertIssuerFromCertAndPem(newCert, newPem)
+
+	if certs := mi.GetCertificatesForSubject(oldCert.RawSubject); len(certs) != 2 {
+		t.Fatalf("Expected both certificates to share a subject, got %d", len(certs))
+	}
+
+	found, err := mi.GetCertificateForIssuerByAKI([]byte{0x01, 0x01})
+	if err != nil || !found.Equal(oldCert) {
+		t.Errorf("Expected the old certificate, got %v", err)
+	}
+	found, err = mi.GetCertificateForIssuerByAKI([]byte{0x02, 0x02})
+	if err != nil || !found.Equal(newCert) {
+		t.Errorf("Expected the new certificate, got %v", err)
+	}
+
+	if _, err = mi.GetCertificateForIssuerByAKI([]byte{0x03}); err == nil {
+		t.Error("Expected an error for an unknown key identifier")
+	}
+	if _, err = mi.GetCertificateForIssuerByAKI(nil); err == nil {
+		t.Error("Expected an error for an empty key identifier")
+	}
+}
+
+// Returns the path of a CCADB CSV, and its issuers by name, split by whether
+// they should be kept when inactive certificates are excluded
+func writeMixedStatusCCADB(t *testing.T) (string, map[string]storage.Issuer, map[string]storage.Issuer) {
+	t.Helper()
+	rows := []struct {
+		name     string
+		validTo  string
+		status   string
+		included bool
+	}{
+		{"Current", "2099 Jan 01", "Not Revoked", true},
+		{"No Status", "2099 Jan 01", "", true},
+		{"Unparseable Date", "sometime", "Not Revoked", true},
+		{"Revoked", "2099 Jan 01", "Revoked", false},
+		{"Parent Revoked", "2099 Jan 01", "Parent Cert Revoked", false},
+		{"Expired", "2001 Jan 01", "Not Revoked", false},
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write([]string{"Certificate Subject Common Name", "Valid To [GMT]", "Revocation Status", "PEM"}); err != nil {
+		t.Fatal(err)
+	}
+	kept := make(map[string]storage.Issuer)
+	excluded := make(map[string]storage.Issuer)
+	for i, row := range rows {
+		cert, certPem := makeCert(t, "CN="+row.name, "2030-01-01", storage.NewSerialFromHex(fmt.Sprintf("%02x", i+1)))
+		if err := w.Write([]string{row.name, row.validTo, row.status, "'" + certPem + "'"}); err != nil {
+			t.Fatal(err)
+		}
+		if row.included {
+			kept[row.name] = storage.NewIssuer(cert)
+		} else {
+			excluded[row.name] = storage.NewIssuer(cert)
+		}
+	}
+	w.Flush()
+
+	tmpfile, err := ioutil.TempFile("", "writeMixedStatusCCADB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmpfile.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return tmpfile.Name(), kept, excluded
+}
+
+func Test_LoadFromDiskExcludesInactive(t *testing.T) {
+	path, kept, excluded := writeMixedStatusCCADB(t)
+	defer os.Remove(path)
+
+	mi := NewMozillaIssuers()
+	if err := mi.LoadFromDisk(path); err != nil {
+		t.Fatal(err)
+	}
+	for name, issuer := range kept {
+		if !mi.IsIssuerInProgram(issuer) {
+			t.Errorf("%s: Expected to be kept", name)
+		}
+	}
+	for name, issuer := range excluded {
+		if mi.IsIssuerInProgram(issuer) {
+			t.Errorf("%s: Expected to be excluded", name)
+		}
+	}
+	if len(mi.GetIssuers()) != 3 {
+		t.Errorf("Expected 3 issuers, got %d", len(mi.GetIssuers()))
+	}
+
+	merged := NewMozillaIssuers()
+	if err := merged.LoadFromDiskMerge(path); err != nil {
+		t.Fatal(err)
+	}
+	if len(merged.GetIssuers()) != 3 {
+		t.Errorf("Expected merged sources to be filtered too, got %d issuers", len(merged.GetIssuers()))
+	}
+
+	inactive := NewMozillaIssuers()
+	inactive.IncludeInactive = true
+	if err := inactive.LoadFromDiskMerge(path); err != nil {
+		t.Fatal(err)
+	}
+	for name, issuer := range excluded {
+		if !inactive.IsIssuerInProgram(issuer) {
+			t.Errorf("%s: Expected to be included with IncludeInactive", name)
+		}
+	}
+}
+
+func Test_Fingerprint(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_Fingerprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Two certificates for one issuer, whose order in the issuer also
+	// follows the rows
+	sharedKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, sharedPem1 := makeCertWithKey(t, sharedKey, "Shared 1", "2050-01-01", storage.NewSerialFromHex("01"))
+	_, sharedPem2 := makeCertWithKey(t, sharedKey, "Shared 2", "2050-01-01", storage.NewSerialFromHex("02"))
+	_, otherPem := makeCert(t, "Other", "2050-01-01", storage.NewSerialFromHex("03"))
+	_, extraPem := makeCert(t, "Extra", "2050-01-01", storage.NewSerialFromHex("04"))
+
+	load := func(aName string, aPems ...string) *MozIssuers {
+		mi := NewMozillaIssuers()
+		if err := mi.LoadFromDisk(writeCCADBFile(t, tmpDir, aName, aPems...)); err != nil {
+			t.Fatal(err)
+		}
+		return mi
+	}
+
+	forward := load("forward.csv", sharedPem1, sharedPem2, otherPem)
+	reversed := load("reversed.csv", otherPem, sharedPem2, sharedPem1)
+	if forward.Fingerprint() != reversed.Fingerprint() {
+		t.Errorf("Expected the same fingerprint whatever the row order, got %s and %s",
+			forward.Fingerprint(), reversed.Fingerprint())
+	}
+	if len(forward.Fingerprint()) != 64 {
+		t.Errorf("Expected a hex SHA-256 digest, got %s", forward.Fingerprint())
+	}
+
+	if extra := load("extra.csv", sharedPem1, sharedPem2, otherPem, extraPem); extra.Fingerprint() == forward.Fingerprint() {
+		t.Error("Expected an added issuer to change the fingerprint")
+	}
+	if fewer := load("fewer.csv", sharedPem1, otherPem); fewer.Fingerprint() == forward.Fingerprint() {
+		t.Error("Expected a removed certificate to change the fingerprint")
+	}
+
+	// Enrollment isn't part of the input
+	forward.Enroll(forward.GetIssuers()[0])
+	if forward.Fingerprint() != reversed.Fingerprint() {
+		t.Error("Expected enrollment not to change the fingerprint")
+	}
+
+	enrolledPath := filepath.Join(tmpDir, "enrolled.json")
+	if err = forward.SaveIssuersList(enrolledPath); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(enrolledPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := []EnrolledIssuer{}
+	if err = json.Unmarshal(data, &list); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range list {
+		if entry.CcadbFingerprint != forward.Fingerprint() {
+			t.Errorf("Expected each entry to carry the fingerprint, got %q", entry.CcadbFingerprint)
+		}
+	}
+}
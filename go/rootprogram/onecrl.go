@@ -0,0 +1,171 @@
+package rootprogram
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+const kMozOneCRLRecords = "https://firefox.settings.services.mozilla.com/v1/buckets/security-state/collections/onecrl/records"
+
+// One OneCRL entry from Remote Settings. It names the revoked certificate
+// either by issuer and serial, or by subject and SHA-256 of its SPKI, with
+// each value base64-encoded DER.
+type oneCRLRecord struct {
+	IssuerName   string `json:"issuerName"`
+	SerialNumber string `json:"serialNumber"`
+	Subject      string `json:"subject"`
+	PubKeyHash   string `json:"pubKeyHash"`
+}
+
+type oneCRLRecords struct {
+	Data []oneCRLRecord `json:"data"`
+}
+
+type oneCRLEntry struct {
+	issuerName []byte
+	serial     *big.Int
+	subject    []byte
+	pubKeyHash []byte
+}
+
+func decodeOneCRLRecord(aRecord oneCRLRecord) (oneCRLEntry, error) {
+	var entry oneCRLEntry
+	decode := func(aField string, aValue string) []byte {
+		if aValue == "" {
+			return nil
+		}
+		value, err := base64.StdEncoding.DecodeString(aValue)
+		if err != nil {
+			glog.Warningf("Ignoring malformed OneCRL %s %q: %s", aField, aValue, err)
+			return nil
+		}
+		return value
+	}
+
+	entry.issuerName = decode("issuerName", aRecord.IssuerName)
+	if serial := decode("serialNumber", aRecord.SerialNumber); serial != nil {
+		entry.serial = new(big.Int).SetBytes(serial)
+	}
+	entry.subject = decode("subject", aRecord.Subject)
+	entry.pubKeyHash = decode("pubKeyHash", aRecord.PubKeyHash)
+
+	if (entry.issuerName == nil || entry.serial == nil) && (entry.subject == nil || entry.pubKeyHash == nil) {
+		return entry, fmt.Errorf("OneCRL record names neither an issuer and serial nor a subject and key")
+	}
+	return entry, nil
+}
+
+func (e oneCRLEntry) matches(aCert *x509.Certificate) bool {
+	if e.issuerName != nil && e.serial != nil && bytes.Equal(e.issuerName, aCert.RawIssuer) &&
+		e.serial.Cmp(aCert.SerialNumber) == 0 {
+		return true
+	}
+	if e.subject != nil && e.pubKeyHash != nil && bytes.Equal(e.subject, aCert.RawSubject) {
+		pubKeyHash := sha256.Sum256(aCert.RawSubjectPublicKeyInfo)
+		return bytes.Equal(e.pubKeyHash, pubKeyHash[:])
+	}
+	return false
+}
+
+// Fetches OneCRL and marks every issuer with a certificate in it as revoked,
+// so that it's never enrolled, whatever CCADB says.
+func (mi *MozIssuers) LoadOneCRL(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, kCCADBURLTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", mi.OneCRLUrl, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("X-Automated-Tool", "https://github.com/mozilla/crlite")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Non-OK status fetching OneCRL from %s: %s", mi.OneCRLUrl, resp.Status)
+	}
+
+	return mi.applyOneCRL(resp.Body)
+}
+
+func (mi *MozIssuers) applyOneCRL(aStream io.Reader) error {
+	var records oneCRLRecords
+	if err := json.NewDecoder(aStream).Decode(&records); err != nil {
+		return fmt.Errorf("Couldn't parse OneCRL: %s", err)
+	}
+
+	entries := make([]oneCRLEntry, 0, len(records.Data))
+	for _, record := range records.Data {
+		entry, err := decodeOneCRLRecord(record)
+		if err != nil {
+			glog.Warningf("Ignoring OneCRL record %+v: %s", record, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	mi.mutex.Lock()
+	defer mi.mutex.Unlock()
+
+	revokedCount := 0
+	for id, data := range mi.issuerMap {
+		if data.revokedInOneCRL || !anyCertInOneCRL(data.certs, entries) {
+			continue
+		}
+		glog.Infof("Issuer %s (%s) is revoked in OneCRL, and won't be enrolled", id, data.certs[0].subjectDN)
+		mi.issuerMap[id] = revokeInOneCRL(data)
+		revokedCount++
+	}
+
+	glog.Infof("Loaded %d OneCRL entries, revoking %d issuers", len(entries), revokedCount)
+	return nil
+}
+
+func revokeInOneCRL(aData IssuerData) IssuerData {
+	aData.revokedInOneCRL = true
+	aData.enrolled = false
+	aData.reason = ReasonRevokedInOneCRL
+	return aData
+}
+
+func (mi *MozIssuers) markRevokedInOneCRL(aIssuer storage.Issuer) {
+	mi.mutex.Lock()
+	defer mi.mutex.Unlock()
+
+	if data, ok := mi.issuerMap[aIssuer.ID()]; ok {
+		mi.issuerMap[aIssuer.ID()] = revokeInOneCRL(data)
+	}
+}
+
+func anyCertInOneCRL(aCerts []issuerCert, aEntries []oneCRLEntry) bool {
+	for _, cert := range aCerts {
+		for _, entry := range aEntries {
+			if entry.matches(cert.cert) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (mi *MozIssuers) IsIssuerRevokedInOneCRL(aIssuer storage.Issuer) bool {
+	mi.mutex.Lock()
+	defer mi.mutex.Unlock()
+
+	return mi.issuerMap[aIssuer.ID()].revokedInOneCRL
+}
@@ -0,0 +1,168 @@
+package rootprogram
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	newx509 "github.com/google/certificate-transparency-go/x509"
+	"github.com/mozilla/crlite/go/storage"
+)
+
+func oneCRLSubjectRecord(aCert *newx509.Certificate) oneCRLRecord {
+	pubKeyHash := sha256.Sum256(aCert.RawSubjectPublicKeyInfo)
+	return oneCRLRecord{
+		Subject:    base64.StdEncoding.EncodeToString(aCert.RawSubject),
+		PubKeyHash: base64.StdEncoding.EncodeToString(pubKeyHash[:]),
+	}
+}
+
+func oneCRLSerialRecord(aCert *newx509.Certificate) oneCRLRecord {
+	return oneCRLRecord{
+		IssuerName:   base64.StdEncoding.EncodeToString(aCert.RawIssuer),
+		SerialNumber: base64.StdEncoding.EncodeToString(aCert.SerialNumber.Bytes()),
+	}
+}
+
+func hostOneCRL(t *testing.T, aRecords ...oneCRLRecord) *httptest.Server {
+	t.Helper()
+	body, err := json.Marshal(oneCRLRecords{Data: aRecords})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}))
+}
+
+func Test_LoadOneCRL(t *testing.T) {
+	mi, err := loadSampleIssuers(kFirstTwoLines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	racer := storage.NewIssuerFromString(kFirstTwoLinesIssuerID)
+	racerCert, err := mi.GetCertificateForIssuer(racer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serialCert, serialPem := makeCert(t, "CN=Revoked By Serial", "2030-01-01", storage.NewSerialFromHex("1234"))
+	serialIssuer := mi.InsertIssuerFromCertAndPem(serialCert, serialPem)
+	keptCert, keptPem := makeCert(t, "CN=Not Revoked", "2030-01-01", storage.NewSerialFromHex("1234"))
+	keptIssuer := mi.InsertIssuerFromCertAndPem(keptCert, keptPem)
+
+	// Same name and serial as serialCert, but from a different issuer
+	otherIssuerRecord := oneCRLSerialRecord(keptCert)
+	otherIssuerRecord.IssuerName = base64.StdEncoding.EncodeToString([]byte("not a name"))
+
+	ts := hostOneCRL(t, oneCRLSubjectRecord(racerCert), oneCRLSerialRecord(serialCert), otherIssuerRecord,
+		oneCRLRecord{Subject: "!!!"})
+	defer ts.Close()
+
+	mi.OneCRLUrl = ts.URL
+	if err = mi.LoadOneCRL(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, issuer := range []storage.Issuer{racer, serialIssuer} {
+		if !mi.IsIssuerRevokedInOneCRL(issuer) {
+			t.Errorf("Expected %s to be revoked in OneCRL", issuer.ID())
+		}
+		mi.Enroll(issuer)
+		if mi.IsIssuerEnrolled(issuer) {
+			t.Errorf("Expected %s not to be enrollable", issuer.ID())
+		}
+		mi.MarkUnenrolled(issuer, ReasonNoRevocations)
+		if reason, _ := mi.GetEnrollmentReason(issuer); reason != ReasonRevokedInOneCRL {
+			t.Errorf("Expected reason %s, got %s", ReasonRevokedInOneCRL, reason)
+		}
+	}
+
+	if mi.IsIssuerRevokedInOneCRL(keptIssuer) {
+		t.Error("Expected the unlisted issuer not to be revoked")
+	}
+	mi.Enroll(keptIssuer)
+	if !mi.IsIssuerEnrolled(keptIssuer) {
+		t.Error("Expected the unlisted issuer to be enrollable")
+	}
+}
+
+func Test_LoadOneCRLErrors(t *testing.T) {
+	mi := NewMozillaIssuers()
+
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+	mi.OneCRLUrl = notFound.URL
+	if err := mi.LoadOneCRL(context.TODO()); err == nil || !strings.Contains(err.Error(), "Non-OK status") {
+		t.Errorf("Expected a status error, got %v", err)
+	}
+
+	malformed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html>")
+	}))
+	defer malformed.Close()
+	mi.OneCRLUrl = malformed.URL
+	if err := mi.LoadOneCRL(context.TODO()); err == nil || !strings.Contains(err.Error(), "Couldn't parse OneCRL") {
+		t.Errorf("Expected a parse error, got %v", err)
+	}
+}
+
+func Test_SaveLoadIssuersListOneCRL(t *testing.T) {
+	mi := NewMozillaIssuers()
+	revokedCert, revokedPem := makeCert(t, "CN=Revoked", "2030-01-01", storage.NewSerialFromHex("01"))
+	revoked := mi.InsertIssuerFromCertAndPem(revokedCert, revokedPem)
+	keptCert, keptPem := makeCert(t, "CN=Kept", "2030-01-01", storage.NewSerialFromHex("02"))
+	kept := mi.InsertIssuerFromCertAndPem(keptCert, keptPem)
+
+	ts := hostOneCRL(t, oneCRLSubjectRecord(revokedCert))
+	defer ts.Close()
+	mi.OneCRLUrl = ts.URL
+	if err := mi.LoadOneCRL(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+	mi.Enroll(kept)
+
+	tmpfile, err := ioutil.TempFile("", "Test_SaveLoadIssuersListOneCRL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if err = mi.SaveIssuersList(tmpfile.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := make([]EnrolledIssuer, 0)
+	if err = json.Unmarshal(data, &list); err != nil {
+		t.Fatal(err)
+	}
+	for _, ei := range list {
+		if ei.RevokedInOneCRL != (ei.Pem == revokedPem) {
+			t.Errorf("Unexpected OneCRL state %t for %s", ei.RevokedInOneCRL, ei.Subject)
+		}
+	}
+
+	loaded := NewMozillaIssuers()
+	if err = loaded.LoadEnrolledIssuers(tmpfile.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.IsIssuerRevokedInOneCRL(revoked) || loaded.IsIssuerEnrolled(revoked) {
+		t.Error("Expected the OneCRL revocation to be restored")
+	}
+	if loaded.IsIssuerRevokedInOneCRL(kept) || !loaded.IsIssuerEnrolled(kept) {
+		t.Error("Expected the kept issuer to stay enrolled")
+	}
+}
@@ -12,12 +12,14 @@ import (
 
 const kIssuers = "issuer"
 const kCrls = "crl"
+const kOcsps = "ocsp"
 
 type IssuerMetadata struct {
 	issuer         Issuer
 	cache          RemoteCache
 	mutex          *sync.RWMutex
 	knownCrlDPs    map[string]struct{}
+	knownOcsps     map[string]struct{}
 	knownIssuerDNs map[string]struct{}
 	knownExpDates  map[string]struct{}
 }
@@ -28,6 +30,7 @@ func NewIssuerMetadata(aIssuer Issuer, aCache RemoteCache) *IssuerMetadata {
 		cache:          aCache,
 		mutex:          &sync.RWMutex{},
 		knownCrlDPs:    make(map[string]struct{}),
+		knownOcsps:     make(map[string]struct{}),
 		knownIssuerDNs: make(map[string]struct{}),
 		knownExpDates:  make(map[string]struct{}),
 	}
@@ -41,6 +44,10 @@ func (im *IssuerMetadata) crlId() string {
 	return fmt.Sprintf("%s::%s", kCrls, im.id())
 }
 
+func (im *IssuerMetadata) ocspId() string {
+	return fmt.Sprintf("%s::%s", kOcsps, im.id())
+}
+
 func (im *IssuerMetadata) issuersId() string {
 	return fmt.Sprintf("%s::%s", kIssuers, im.id())
 }
@@ -52,6 +59,8 @@ func (im *IssuerMetadata) addCRL(aCRL string) error {
 		return nil
 	}
 
+	// These could only be fetched through a downloader.Fetcher, and none is
+	// registered for them yet
 	if url.Scheme == "ldap" || url.Scheme == "ldaps" {
 		return nil
 	} else if url.Scheme != "http" && url.Scheme != "https" {
@@ -72,6 +81,31 @@ func (im *IssuerMetadata) addCRL(aCRL string) error {
 	return nil
 }
 
+func (im *IssuerMetadata) addOCSP(aOCSP string) error {
+	url, err := url.Parse(strings.TrimSpace(aOCSP))
+	if err != nil {
+		glog.Warningf("Not a valid OCSP URL: %s %s", aOCSP, err)
+		return nil
+	}
+
+	if url.Scheme != "http" && url.Scheme != "https" {
+		glog.V(3).Infof("Ignoring unknown OCSP scheme: %v", url)
+		return nil
+	}
+
+	result, err := im.cache.SetInsert(im.ocspId(), url.String())
+	if err != nil {
+		return err
+	}
+
+	if result {
+		glog.V(3).Infof("[%s] OCSP unknown: %s", im.id(), url.String())
+	} else {
+		glog.V(3).Infof("[%s] OCSP already known: %s", im.id(), url.String())
+	}
+	return nil
+}
+
 func (im *IssuerMetadata) addIssuerDN(aIssuerDN string) error {
 	result, err := im.cache.SetInsert(im.issuersId(), aIssuerDN)
 	if err != nil {
@@ -125,6 +159,24 @@ func (im *IssuerMetadata) Accumulate(aCert *x509.Certificate) (bool, error) {
 			}
 		}
 	}
+
+	for _, ocsp := range aCert.OCSPServer {
+		_, ok := im.knownOcsps[ocsp]
+
+		if !ok {
+			im.mutex.RUnlock()
+			im.mutex.Lock()
+			im.knownOcsps[ocsp] = struct{}{}
+			im.mutex.Unlock()
+			im.mutex.RLock()
+
+			err := im.addOCSP(ocsp)
+			if err != nil {
+				im.mutex.RUnlock()
+				return seenExpDateBefore, fmt.Errorf("Could not accumulate OCSP %s: %v", im.id(), err)
+			}
+		}
+	}
 	im.mutex.RUnlock()
 
 	if !seenIssuerDn {
@@ -152,3 +204,11 @@ func (im *IssuerMetadata) CRLs() []string {
 	}
 	return strList
 }
+
+func (im *IssuerMetadata) OCSPs() []string {
+	strList, err := im.cache.SetList(im.ocspId())
+	if err != nil {
+		glog.Fatalf("Error obtaining list of OCSP responders: %v", err)
+	}
+	return strList
+}
@@ -59,6 +59,32 @@ func Test_DuplicateCRLs(t *testing.T) {
 	}
 }
 
+func Test_DuplicateOCSPs(t *testing.T) {
+	meta := NewIssuerMetadata(NewIssuerFromString("issuer"), NewMockRemoteCache())
+
+	if err := meta.addOCSP("ldap://ldap.ocsp"); err != nil {
+		t.Error(err)
+	}
+	if err := meta.addOCSP("http://ocsp.example.com"); err != nil {
+		t.Error(err)
+	}
+
+	if len(meta.OCSPs()) != 1 {
+		t.Error("Only one of these OCSP URLs was valid")
+	}
+
+	if err := meta.addOCSP(" http://ocsp.example.com "); err != nil {
+		t.Error(err)
+	}
+	if len(meta.OCSPs()) != 1 {
+		t.Error("Shouldn't dupe even with spaces")
+	}
+
+	if len(meta.CRLs()) != 0 {
+		t.Error("OCSP URLs shouldn't be listed as CRLs")
+	}
+}
+
 func makeCert(t *testing.T, issuerDN string, expDate string, serial Serial) *newx509.Certificate {
 	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
@@ -126,6 +152,10 @@ func Test_Accumulate(t *testing.T) {
 		t.Error("There should have been no CRL dps")
 	}
 
+	if len(meta.OCSPs()) != 0 {
+		t.Error("There should have been no OCSP responders")
+	}
+
 	if len(meta.Issuers()) != 1 {
 		t.Errorf("There should have been a single issuer DN: %+v", meta.Issuers())
 	}
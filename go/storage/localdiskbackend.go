@@ -1,9 +1,11 @@
 package storage
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -21,12 +23,26 @@ const (
 )
 
 type LocalDiskBackend struct {
-	perms    os.FileMode
-	rootPath string
+	perms        os.FileMode
+	rootPath     string
+	serialFormat SerialFormat
+	compress     bool
 }
 
 func NewLocalDiskBackend(perms os.FileMode, aPath string) StorageBackend {
-	return &LocalDiskBackend{perms, aPath}
+	return NewLocalDiskBackendWithSerialFormat(perms, aPath, SerialFormatDefault)
+}
+
+func NewLocalDiskBackendWithSerialFormat(perms os.FileMode, aPath string,
+	serialFormat SerialFormat) StorageBackend {
+	return NewLocalDiskBackendWithCompression(perms, aPath, serialFormat, false)
+}
+
+// With compress, known certificate lists are gzipped and stored as
+// <issuer>.gz. Loading reads either form, whichever the backend writes.
+func NewLocalDiskBackendWithCompression(perms os.FileMode, aPath string,
+	serialFormat SerialFormat, compress bool) StorageBackend {
+	return &LocalDiskBackend{perms, aPath, serialFormat, compress}
 }
 
 func isDirectory(aPath string) bool {
@@ -215,18 +231,54 @@ func (db *LocalDiskBackend) StoreKnownCertificateList(ctx context.Context, issue
 		return err
 	}
 
+	// Remove the list in the other form, so a run switching compression on
+	// or off doesn't leave a stale one to be loaded
+	stalePath := path + CompressedSuffix
+	if db.compress {
+		stalePath, path = path, stalePath
+	}
+	if err := os.Remove(stalePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
 	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, db.perms)
 	if err != nil {
 		return err
 	}
 
 	defer fd.Close()
+
+	if !db.compress {
+		return db.writeKnownCertificateList(ctx, fd, issuer, serials)
+	}
+	gz := gzip.NewWriter(fd)
+	if err := db.writeKnownCertificateList(ctx, gz, issuer, serials); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func (db *LocalDiskBackend) writeKnownCertificateList(ctx context.Context, w io.Writer, issuer Issuer,
+	serials []Serial) error {
+	switch db.serialFormat {
+	case SerialFormatBinary:
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return WriteSerialsBinary(w, serials)
+	case SerialFormatSPKIBundle:
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return WriteSerialsBundle(w, issuer.ID(), serials)
+	}
+
 	for _, s := range serials {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
-			_, err := fd.Write([]byte(s.HexString() + "\n"))
+			_, err := w.Write([]byte(s.HexString() + "\n"))
 			if err != nil {
 				return err
 			}
@@ -235,6 +287,38 @@ func (db *LocalDiskBackend) StoreKnownCertificateList(ctx context.Context, issue
 	return nil
 }
 
+// Returns an error satisfying os.IsNotExist if nothing was stored for the
+// issuer. Lists stored compressed are found and decompressed whether or not
+// this backend compresses.
+func (db *LocalDiskBackend) LoadKnownCertificateList(ctx context.Context, issuer Issuer) ([]Serial, error) {
+	path := filepath.Join(db.rootPath, issuer.ID())
+	fd, err := os.Open(path + CompressedSuffix)
+	if os.IsNotExist(err) {
+		fd, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	r, err := NewSerialsReader(fd)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read %s: %s", fd.Name(), err)
+	}
+
+	switch db.serialFormat {
+	case SerialFormatBinary:
+		return ReadSerialsBinary(r)
+	case SerialFormatSPKIBundle:
+		bundle, err := ReadSerialsBundle(r)
+		if err != nil {
+			return nil, err
+		}
+		return bundle[issuer.ID()], nil
+	}
+	return ReadSerialsText(r)
+}
+
 func (db *LocalDiskBackend) LoadCertificatePEM(_ context.Context, serial Serial, expDate ExpDate,
 	issuer Issuer) ([]byte, error) {
 	return nil, fmt.Errorf("Unimplemented")
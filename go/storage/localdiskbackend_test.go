@@ -7,6 +7,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"testing"
 )
 
@@ -83,3 +85,35 @@ func Test_KnownCertificateList(t *testing.T) {
 		t.Fatalf("Data should match exactly - expected=[%+v] loaded=[%+v]", expected, fileBytes)
 	}
 }
+
+func Test_LoadKnownCertificateList(t *testing.T) {
+	for _, format := range []SerialFormat{SerialFormatDefault, SerialFormatBinary} {
+		rootFolder, err := ioutil.TempDir("", t.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(rootFolder)
+
+		db := NewLocalDiskBackendWithSerialFormat(0644, rootFolder, format)
+		loader := db.(KnownCertificateListLoader)
+
+		issuer := NewIssuerFromString("issuerAKI")
+		if _, err = loader.LoadKnownCertificateList(context.TODO(), issuer); !os.IsNotExist(err) {
+			t.Errorf("%s: Expected a missing list to not exist, got %v", format, err)
+		}
+
+		serials := []Serial{NewSerialFromHex("01"), NewSerialFromHex("00FF")}
+		if err = db.StoreKnownCertificateList(context.TODO(), issuer, serials); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := loader.LoadKnownCertificateList(context.TODO(), issuer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Sort(SerialList(loaded))
+		sort.Sort(SerialList(serials))
+		if !reflect.DeepEqual(loaded, serials) {
+			t.Errorf("%s: Expected %v, got %v", format, serials, loaded)
+		}
+	}
+}
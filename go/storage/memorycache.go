@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A RemoteCache held in memory, for running without Redis, e.g. from a
+// MetadataSnapshot. Safe for concurrent use, but nothing is persisted.
+// Sets are kept sorted and lists in order, under the one key space, as Redis
+// does.
+type MemoryCache struct {
+	mutex       *sync.Mutex
+	data        map[string][]string
+	expirations map[string]time.Time
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{
+		mutex:       &sync.Mutex{},
+		data:        make(map[string][]string),
+		expirations: make(map[string]time.Time),
+	}
+}
+
+// Returns the key's entries, dropping the key first if it has expired. The
+// mutex must be held.
+func (mc *MemoryCache) get(key string) ([]string, bool) {
+	if expiry, ok := mc.expirations[key]; ok && !time.Now().Before(expiry) {
+		delete(mc.data, key)
+		delete(mc.expirations, key)
+	}
+	entries, ok := mc.data[key]
+	return entries, ok
+}
+
+// Stores the key's entries, dropping the key once it has none, as Redis
+// does. The mutex must be held.
+func (mc *MemoryCache) put(key string, entries []string) {
+	if len(entries) == 0 {
+		delete(mc.data, key)
+		delete(mc.expirations, key)
+		return
+	}
+	mc.data[key] = entries
+}
+
+// Makes key exist as a set, even without entries, which Redis can't hold.
+// Lets a snapshot record that a set existed without its contents.
+func (mc *MemoryCache) ensureSet(key string) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	if _, ok := mc.get(key); !ok {
+		mc.data[key] = []string{}
+	}
+}
+
+func (mc *MemoryCache) Exists(key string) (bool, error) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	_, ok := mc.get(key)
+	return ok, nil
+}
+
+func (mc *MemoryCache) SetInsert(key string, entry string) (bool, error) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	entries, _ := mc.get(key)
+	idx := sort.SearchStrings(entries, entry)
+	if idx < len(entries) && entries[idx] == entry {
+		return false, nil
+	}
+	entries = append(entries, "")
+	copy(entries[idx+1:], entries[idx:])
+	entries[idx] = entry
+	mc.data[key] = entries
+	return true, nil
+}
+
+func (mc *MemoryCache) SetRemove(key string, entry string) (bool, error) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	entries, _ := mc.get(key)
+	idx := sort.SearchStrings(entries, entry)
+	if idx == len(entries) || entries[idx] != entry {
+		return false, nil
+	}
+	mc.put(key, append(entries[:idx:idx], entries[idx+1:]...))
+	return true, nil
+}
+
+func (mc *MemoryCache) SetContains(key string, entry string) (bool, error) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	entries, _ := mc.get(key)
+	idx := sort.SearchStrings(entries, entry)
+	return idx < len(entries) && entries[idx] == entry, nil
+}
+
+func (mc *MemoryCache) SetList(key string) ([]string, error) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	entries, ok := mc.get(key)
+	if !ok {
+		return nil, nil
+	}
+	return append([]string{}, entries...), nil
+}
+
+func (mc *MemoryCache) SetToChan(key string, c chan<- string) error {
+	defer close(c)
+	entries, err := mc.SetList(key)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		c <- entry
+	}
+	return nil
+}
+
+func (mc *MemoryCache) SetCardinality(key string) (int, error) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	entries, _ := mc.get(key)
+	return len(entries), nil
+}
+
+func (mc *MemoryCache) ExpireAt(key string, aExpTime time.Time) error {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	if _, ok := mc.get(key); ok {
+		mc.expirations[key] = aExpTime
+	}
+	return nil
+}
+
+func (mc *MemoryCache) ExpireIn(key string, aDur time.Duration) error {
+	return mc.ExpireAt(key, time.Now().Add(aDur))
+}
+
+func (mc *MemoryCache) Queue(key string, identifier string) (int64, error) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	entries, _ := mc.get(key)
+	entries = append(entries, identifier)
+	mc.data[key] = entries
+	return int64(len(entries)), nil
+}
+
+func (mc *MemoryCache) Pop(key string) (string, error) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	entries, _ := mc.get(key)
+	if len(entries) == 0 {
+		return "", fmt.Errorf("Queue %s is empty", key)
+	}
+	mc.put(key, entries[1:])
+	return entries[0], nil
+}
+
+func (mc *MemoryCache) QueueLength(key string) (int64, error) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	entries, _ := mc.get(key)
+	return int64(len(entries)), nil
+}
+
+// Moves the last entry of key to the front of dest. Nothing else can queue
+// while the mutex is held, so this doesn't wait out timeout when key is
+// empty.
+func (mc *MemoryCache) BlockingPopCopy(key string, dest string, timeout time.Duration) (string, error) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	entries, _ := mc.get(key)
+	if len(entries) == 0 {
+		return "", fmt.Errorf("Queue %s is empty", key)
+	}
+	last := entries[len(entries)-1]
+	mc.put(key, entries[:len(entries)-1])
+	destEntries, _ := mc.get(dest)
+	mc.data[dest] = append([]string{last}, destEntries...)
+	return last, nil
+}
+
+func (mc *MemoryCache) ListRemove(key string, value string) error {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	entries, _ := mc.get(key)
+	for i, entry := range entries {
+		if entry == value {
+			mc.put(key, append(entries[:i:i], entries[i+1:]...))
+			break
+		}
+	}
+	return nil
+}
+
+func (mc *MemoryCache) TrySet(key string, v string, life time.Duration) (string, error) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	if entries, ok := mc.get(key); ok && len(entries) > 0 {
+		return entries[0], nil
+	}
+	mc.data[key] = []string{v}
+	mc.expirations[key] = time.Now().Add(life)
+	return v, nil
+}
+
+func (mc *MemoryCache) KeysToChan(pattern string, c chan<- string) error {
+	defer close(c)
+
+	mc.mutex.Lock()
+	var keys []string
+	for key := range mc.data {
+		if _, ok := mc.get(key); !ok {
+			continue
+		}
+		matched, err := filepath.Match(pattern, key)
+		if err != nil {
+			mc.mutex.Unlock()
+			return err
+		}
+		if matched {
+			keys = append(keys, key)
+		}
+	}
+	mc.mutex.Unlock()
+
+	for _, key := range keys {
+		c <- key
+	}
+	return nil
+}
+
+func (mc *MemoryCache) StoreLogState(aLogObj *CertificateLog) error {
+	encoded, err := json.Marshal(aLogObj)
+	if err != nil {
+		return err
+	}
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	mc.data[shortUrlToLogKey(aLogObj.ShortURL)] = []string{string(encoded)}
+	return nil
+}
+
+func (mc *MemoryCache) LoadLogState(aLogUrl string) (*CertificateLog, error) {
+	mc.mutex.Lock()
+	entries, ok := mc.get(shortUrlToLogKey(aLogUrl))
+	mc.mutex.Unlock()
+	if !ok || len(entries) != 1 {
+		return nil, fmt.Errorf("Log state not found")
+	}
+
+	var log CertificateLog
+	if err := json.Unmarshal([]byte(entries[0]), &log); err != nil {
+		return nil, err
+	}
+	return &log, nil
+}
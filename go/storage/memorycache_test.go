@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_MemoryCacheSets(t *testing.T) {
+	mc := NewMemoryCache()
+
+	for _, entry := range []string{"c", "a", "b", "a"} {
+		if _, err := mc.SetInsert("set", entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if list, _ := mc.SetList("set"); !reflect.DeepEqual(list, []string{"a", "b", "c"}) {
+		t.Errorf("Expected a sorted set without repeats, got %v", list)
+	}
+	if count, _ := mc.SetCardinality("set"); count != 3 {
+		t.Errorf("Expected 3 entries, got %d", count)
+	}
+	if contains, _ := mc.SetContains("set", "b"); !contains {
+		t.Error("Expected the set to contain b")
+	}
+
+	if removed, _ := mc.SetRemove("set", "b"); !removed {
+		t.Error("Expected b to be removed")
+	}
+	if removed, _ := mc.SetRemove("set", "b"); removed {
+		t.Error("Expected b to be gone")
+	}
+	if list, _ := mc.SetList("set"); !reflect.DeepEqual(list, []string{"a", "c"}) {
+		t.Errorf("Expected a and c left, got %v", list)
+	}
+
+	c := make(chan string)
+	go func() {
+		if err := mc.SetToChan("set", c); err != nil {
+			t.Error(err)
+		}
+	}()
+	var streamed []string
+	for entry := range c {
+		streamed = append(streamed, entry)
+	}
+	if !reflect.DeepEqual(streamed, []string{"a", "c"}) {
+		t.Errorf("Expected a and c streamed, got %v", streamed)
+	}
+
+	// As in Redis, a set without entries doesn't exist
+	_, _ = mc.SetRemove("set", "a")
+	_, _ = mc.SetRemove("set", "c")
+	if exists, _ := mc.Exists("set"); exists {
+		t.Error("Expected the emptied set not to exist")
+	}
+}
+
+func Test_MemoryCacheExpiry(t *testing.T) {
+	mc := NewMemoryCache()
+
+	_, _ = mc.SetInsert("expired", "a")
+	_, _ = mc.SetInsert("current", "a")
+	if err := mc.ExpireAt("expired", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := mc.ExpireIn("current", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := mc.Exists("expired"); exists {
+		t.Error("Expected the expired key to be gone")
+	}
+	if exists, _ := mc.Exists("current"); !exists {
+		t.Error("Expected the unexpired key to remain")
+	}
+
+	if v, _ := mc.TrySet("lock", "first", time.Hour); v != "first" {
+		t.Errorf("Expected the first value set, got %s", v)
+	}
+	if v, _ := mc.TrySet("lock", "second", time.Hour); v != "first" {
+		t.Errorf("Expected the first value kept, got %s", v)
+	}
+}
+
+func Test_MemoryCacheQueues(t *testing.T) {
+	mc := NewMemoryCache()
+
+	for _, entry := range []string{"a", "b", "c"} {
+		if _, err := mc.Queue("queue", entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if length, _ := mc.QueueLength("queue"); length != 3 {
+		t.Errorf("Expected 3 queued, got %d", length)
+	}
+	if v, err := mc.Pop("queue"); err != nil || v != "a" {
+		t.Errorf("Expected a popped first, got %s, %v", v, err)
+	}
+	if v, err := mc.BlockingPopCopy("queue", "dest", time.Second); err != nil || v != "c" {
+		t.Errorf("Expected c moved, got %s, %v", v, err)
+	}
+	if list, _ := mc.SetList("dest"); !reflect.DeepEqual(list, []string{"c"}) {
+		t.Errorf("Expected c in dest, got %v", list)
+	}
+	if err := mc.ListRemove("queue", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mc.Pop("queue"); err == nil {
+		t.Error("Expected an error popping an empty queue")
+	}
+}
+
+func Test_MemoryCacheKeysAndLogState(t *testing.T) {
+	mc := NewMemoryCache()
+	_, _ = mc.SetInsert("serials::a", "1")
+	_, _ = mc.SetInsert("serials::b", "1")
+	_, _ = mc.SetInsert("crls::a", "1")
+
+	c := make(chan string)
+	go func() {
+		if err := mc.KeysToChan("serials::*", c); err != nil {
+			t.Error(err)
+		}
+	}()
+	var keys []string
+	for key := range c {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"serials::a", "serials::b"}) {
+		t.Errorf("Expected the serials keys, got %v", keys)
+	}
+
+	log := &CertificateLog{ShortURL: "log.example.com/2030", MaxEntry: 42}
+	if err := mc.StoreLogState(log); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := mc.LoadLogState(log.ShortURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.MaxEntry != 42 {
+		t.Errorf("Expected MaxEntry 42, got %d", loaded.MaxEntry)
+	}
+	if _, err := mc.LoadLogState("unknown"); err == nil {
+		t.Error("Expected an error for an unknown log")
+	}
+}
+
+func Test_MemoryCacheConcurrent(t *testing.T) {
+	mc := NewMemoryCache()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(aWorker int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, _ = mc.SetInsert("set", string(rune('a'+aWorker)))
+				_, _ = mc.SetList("set")
+				_, _ = mc.Exists("set")
+			}
+		}(i)
+	}
+	wg.Wait()
+	if count, _ := mc.SetCardinality("set"); count != 8 {
+		t.Errorf("Expected 8 entries, got %d", count)
+	}
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// The issuer metadata aggregate-crls reads from the cache, saved to a file so
+// that it can be run offline, e.g. during development, without Redis. It
+// doesn't hold the serials of known certificates.
+type MetadataSnapshot struct {
+	Issuers map[string]IssuerSnapshot `json:"issuers"`
+}
+
+type IssuerSnapshot struct {
+	ExpDates  []string `json:"expDates"`
+	CRLs      []string `json:"crls,omitempty"`
+	OCSPs     []string `json:"ocsps,omitempty"`
+	IssuerDNs []string `json:"issuerDNs,omitempty"`
+}
+
+func NewMetadataSnapshot(aDB CertDatabase) (*MetadataSnapshot, error) {
+	issuerDates, err := aDB.GetIssuerAndDatesFromCache()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &MetadataSnapshot{Issuers: make(map[string]IssuerSnapshot, len(issuerDates))}
+	for _, issuerDate := range issuerDates {
+		expDates := make([]string, 0, len(issuerDate.ExpDates))
+		for _, expDate := range issuerDate.ExpDates {
+			expDates = append(expDates, expDate.ID())
+		}
+		sort.Strings(expDates)
+
+		meta := aDB.GetIssuerMetadata(issuerDate.Issuer)
+		snapshot.Issuers[issuerDate.Issuer.ID()] = IssuerSnapshot{
+			ExpDates:  expDates,
+			CRLs:      meta.CRLs(),
+			OCSPs:     meta.OCSPs(),
+			IssuerDNs: meta.Issuers(),
+		}
+	}
+	return snapshot, nil
+}
+
+func LoadMetadataSnapshot(aPath string) (*MetadataSnapshot, error) {
+	fd, err := os.Open(aPath)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var snapshot MetadataSnapshot
+	if err = json.NewDecoder(fd).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("Couldn't decode metadata snapshot %s: %s", aPath, err)
+	}
+	return &snapshot, nil
+}
+
+func (s *MetadataSnapshot) Save(aPath string) error {
+	fd, err := os.Create(aPath)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(fd)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(s); err != nil {
+		fd.Close() // ignore error
+		return err
+	}
+
+	return fd.Close()
+}
+
+// Returns an in-memory cache holding the snapshot under the keys the cache
+// it was taken from used. Each expiration date's set of serials is empty.
+func (s *MetadataSnapshot) Cache() (RemoteCache, error) {
+	cache := NewMemoryCache()
+	for issuerID, issuerSnapshot := range s.Issuers {
+		im := NewIssuerMetadata(NewIssuerFromString(issuerID), cache)
+		for _, expDateID := range issuerSnapshot.ExpDates {
+			expDate, err := NewExpDate(expDateID)
+			if err != nil {
+				return nil, fmt.Errorf("Issuer %s has an invalid expiration date: %s", issuerID, err)
+			}
+			kc := NewKnownCertificates(expDate, im.issuer, cache)
+			cache.ensureSet(kc.serialId())
+		}
+		for _, crl := range issuerSnapshot.CRLs {
+			if _, err := cache.SetInsert(im.crlId(), crl); err != nil {
+				return nil, err
+			}
+		}
+		for _, ocsp := range issuerSnapshot.OCSPs {
+			if _, err := cache.SetInsert(im.ocspId(), ocsp); err != nil {
+				return nil, err
+			}
+		}
+		for _, dn := range issuerSnapshot.IssuerDNs {
+			if _, err := cache.SetInsert(im.issuersId(), dn); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return cache, nil
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/certificate-transparency-go/x509/pkix"
+)
+
+func Test_MetadataSnapshotRoundTrip(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db, _ := NewFilesystemDatabase(NewMockBackend(), NewMockRemoteCache())
+	issuer := NewIssuerFromString("issuerAKI")
+	otherIssuer := NewIssuerFromString("otherAKI")
+	expiries := []time.Time{
+		time.Date(2030, time.January, 1, 5, 0, 0, 0, time.UTC),
+		time.Date(2030, time.March, 1, 7, 0, 0, 0, time.UTC),
+	}
+	for i, notAfter := range expiries {
+		cert := &x509.Certificate{
+			NotAfter:              notAfter,
+			Issuer:                pkix.Name{CommonName: "Snapshot CA"},
+			CRLDistributionPoints: []string{"http://crl.example/" + string(rune('a'+i)) + ".crl"},
+			OCSPServer:            []string{"http://ocsp.example"},
+		}
+		if _, err = db.GetIssuerMetadata(issuer).Accumulate(cert); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = db.GetKnownCertificates(NewExpDateFromTime(notAfter), issuer).WasUnknown(NewSerialFromHex("01")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = db.GetKnownCertificates(NewExpDateFromTime(expiries[0]), otherIssuer).WasUnknown(NewSerialFromHex("02")); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := NewMetadataSnapshot(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(tmpDir, "snapshot.json")
+	if err = snapshot.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadMetadataSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(snapshot, loaded) {
+		t.Errorf("Expected %+v, got %+v", snapshot, loaded)
+	}
+
+	cache, err := loaded.Cache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	offlineDB, _ := NewFilesystemDatabase(NewNoopBackend(), cache)
+
+	issuerDates := func(aDB CertDatabase) map[string][]string {
+		result := make(map[string][]string)
+		list, err := aDB.GetIssuerAndDatesFromCache()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, issuerDate := range list {
+			for _, expDate := range issuerDate.ExpDates {
+				result[issuerDate.Issuer.ID()] = append(result[issuerDate.Issuer.ID()], expDate.ID())
+			}
+			sort.Strings(result[issuerDate.Issuer.ID()])
+		}
+		return result
+	}
+	if expected, got := issuerDates(db), issuerDates(offlineDB); !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected issuers and dates %v, got %v", expected, got)
+	}
+
+	for _, i := range []Issuer{issuer, otherIssuer} {
+		expected := db.GetIssuerMetadata(i)
+		got := offlineDB.GetIssuerMetadata(i)
+		if !reflect.DeepEqual(expected.CRLs(), got.CRLs()) || !reflect.DeepEqual(expected.OCSPs(), got.OCSPs()) ||
+			!reflect.DeepEqual(expected.Issuers(), got.Issuers()) {
+			t.Errorf("[%s] Expected CRLs %v, OCSPs %v, DNs %v, got %v, %v, %v", i.ID(), expected.CRLs(),
+				expected.OCSPs(), expected.Issuers(), got.CRLs(), got.OCSPs(), got.Issuers())
+		}
+	}
+	if len(offlineDB.GetIssuerMetadata(issuer).CRLs()) != 2 {
+		t.Errorf("Expected 2 CRLs, got %v", offlineDB.GetIssuerMetadata(issuer).CRLs())
+	}
+}
+
+func Test_MetadataSnapshotInvalidDate(t *testing.T) {
+	snapshot := &MetadataSnapshot{Issuers: map[string]IssuerSnapshot{
+		"issuerAKI": {ExpDates: []string{"next tuesday"}},
+	}}
+	if _, err := snapshot.Cache(); err == nil {
+		t.Error("Expected an invalid expiration date to fail")
+	}
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"sort"
+)
+
+// The revoked serials of a set of issuers, as read back from what
+// StoreKnownCertificateList wrote, for answering revocation queries
+type RevokedSerials struct {
+	// Issuer ID to serials, sorted ascending
+	serials map[string][]Serial
+}
+
+// Loads each issuer's stored serials. Issuers with nothing stored are taken
+// to have no revocations.
+func LoadRevokedSerials(ctx context.Context, loader KnownCertificateListLoader,
+	issuers []Issuer) (*RevokedSerials, error) {
+	rs := &RevokedSerials{
+		serials: make(map[string][]Serial, len(issuers)),
+	}
+	for _, issuer := range issuers {
+		serials, err := loader.LoadKnownCertificateList(ctx, issuer)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sort.Sort(SerialList(serials))
+		rs.serials[issuer.ID()] = serials
+	}
+	return rs, nil
+}
+
+func (rs *RevokedSerials) IsRevoked(issuer Issuer, serial Serial) bool {
+	serials := rs.serials[issuer.ID()]
+	i := sort.Search(len(serials), func(i int) bool {
+		return serials[i].Cmp(serial) >= 0
+	})
+	return i < len(serials) && serials[i].Cmp(serial) == 0
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_RevokedSerials(t *testing.T) {
+	for _, format := range []SerialFormat{SerialFormatDefault, SerialFormatBinary} {
+		rootFolder, err := ioutil.TempDir("", t.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(rootFolder)
+
+		db := NewLocalDiskBackendWithSerialFormat(0644, rootFolder, format)
+		issuer := NewIssuerFromString("issuerAKI")
+		otherIssuer := NewIssuerFromString("otherAKI")
+		unstoredIssuer := NewIssuerFromString("unstoredAKI")
+
+		serials := []Serial{NewSerialFromHex("FF"), NewSerialFromHex("01"), NewSerialFromHex("00AB")}
+		if err = db.StoreKnownCertificateList(context.TODO(), issuer, serials); err != nil {
+			t.Fatal(err)
+		}
+		if err = db.StoreKnownCertificateList(context.TODO(), otherIssuer, []Serial{NewSerialFromHex("02")}); err != nil {
+			t.Fatal(err)
+		}
+
+		revoked, err := LoadRevokedSerials(context.TODO(), db.(KnownCertificateListLoader),
+			[]Issuer{issuer, otherIssuer, unstoredIssuer})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, s := range serials {
+			if !revoked.IsRevoked(issuer, s) {
+				t.Errorf("%s: Expected %s to be revoked", format, s.HexString())
+			}
+		}
+		if !revoked.IsRevoked(otherIssuer, NewSerialFromHex("02")) {
+			t.Errorf("%s: Expected 02 to be revoked for the other issuer", format)
+		}
+
+		for _, s := range []Serial{NewSerialFromHex("00"), NewSerialFromHex("02"), NewSerialFromHex("AB"),
+			NewSerialFromHex("0100")} {
+			if revoked.IsRevoked(issuer, s) {
+				t.Errorf("%s: Expected %s to not be revoked", format, s.HexString())
+			}
+		}
+		if revoked.IsRevoked(otherIssuer, NewSerialFromHex("01")) {
+			t.Errorf("%s: Expected serials to be per issuer", format)
+		}
+		if revoked.IsRevoked(unstoredIssuer, NewSerialFromHex("01")) {
+			t.Errorf("%s: Expected nothing revoked for an issuer with nothing stored", format)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/golang/glog"
+)
+
+// S3Backend writes known/revoked serial lists as objects under
+// s3://<bucket>/<prefix>/<issuer>, in the same format as LocalDiskBackend.
+// Only the storing of certificate lists is supported.
+type S3Backend struct {
+	client   s3iface.S3API
+	bucket   string
+	prefix   string
+	compress bool
+}
+
+func NewS3Backend(aClient s3iface.S3API, aBucket string, aPrefix string) StorageBackend {
+	return NewS3BackendWithCompression(aClient, aBucket, aPrefix, false)
+}
+
+// With compress, lists are gzipped and stored as <issuer>.gz, as
+// LocalDiskBackend does
+func NewS3BackendWithCompression(aClient s3iface.S3API, aBucket string, aPrefix string,
+	compress bool) StorageBackend {
+	return &S3Backend{
+		client:   aClient,
+		bucket:   aBucket,
+		prefix:   aPrefix,
+		compress: compress,
+	}
+}
+
+func (db *S3Backend) unimplemented() error {
+	return fmt.Errorf("Unimplemented for the S3Backend")
+}
+
+func (db *S3Backend) key(id string) string {
+	return path.Join(db.prefix, id)
+}
+
+func (db *S3Backend) MarkDirty(_ string) error {
+	return nil
+}
+
+func (db *S3Backend) StoreCertificatePEM(_ context.Context, _ Serial, _ ExpDate,
+	_ Issuer, _ []byte) error {
+	return db.unimplemented()
+}
+
+func (db *S3Backend) StoreLogState(_ context.Context, _ *CertificateLog) error {
+	return db.unimplemented()
+}
+
+func (db *S3Backend) StoreKnownCertificateList(ctx context.Context, issuer Issuer,
+	serials []Serial) error {
+	var buf bytes.Buffer
+	for _, s := range serials {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			buf.WriteString(s.HexString())
+			buf.WriteByte('\n')
+		}
+	}
+
+	key := db.key(issuer.ID())
+	contentType := "text/plain"
+	if db.compress {
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		if _, err := gz.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		buf = compressed
+		key += CompressedSuffix
+		contentType = "application/gzip"
+	}
+	_, err := db.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(db.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(buf.Bytes()),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return fmt.Errorf("Couldn't store s3://%s/%s: %v", db.bucket, key, err)
+	}
+
+	glog.V(1).Infof("[%s] Stored %d serials to s3://%s/%s", issuer.ID(), len(serials), db.bucket, key)
+	return nil
+}
+
+func (db *S3Backend) LoadCertificatePEM(_ context.Context, _ Serial, _ ExpDate,
+	_ Issuer) ([]byte, error) {
+	return nil, db.unimplemented()
+}
+
+func (db *S3Backend) LoadLogState(_ context.Context, _ string) (*CertificateLog, error) {
+	return nil, db.unimplemented()
+}
+
+func (db *S3Backend) AllocateExpDateAndIssuer(_ context.Context, _ ExpDate, _ Issuer) error {
+	return db.unimplemented()
+}
+
+func (db *S3Backend) ListExpirationDates(_ context.Context, _ time.Time) ([]ExpDate, error) {
+	return []ExpDate{}, db.unimplemented()
+}
+
+func (db *S3Backend) ListIssuersForExpirationDate(_ context.Context, _ ExpDate) ([]Issuer, error) {
+	return []Issuer{}, db.unimplemented()
+}
+
+func (db *S3Backend) ListSerialsForExpirationDateAndIssuer(_ context.Context, _ ExpDate,
+	_ Issuer) ([]Serial, error) {
+	return []Serial{}, db.unimplemented()
+}
+
+func (db *S3Backend) StreamSerialsForExpirationDateAndIssuer(_ context.Context, _ ExpDate,
+	_ Issuer, _ <-chan struct{}, _ chan<- UniqueCertIdentifier) error {
+	return db.unimplemented()
+}
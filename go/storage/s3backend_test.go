@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+type mockS3Client struct {
+	s3iface.S3API
+	objects map[string][]byte
+	fail    bool
+}
+
+func (m *mockS3Client) PutObjectWithContext(_ aws.Context, input *s3.PutObjectInput,
+	_ ...request.Option) (*s3.PutObjectOutput, error) {
+	if m.fail {
+		return nil, fmt.Errorf("mock failure")
+	}
+	data, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.objects[aws.StringValue(input.Bucket)+"/"+aws.StringValue(input.Key)] = data
+	return &s3.PutObjectOutput{}, nil
+}
+
+func Test_S3BackendStoreKnownCertificateList(t *testing.T) {
+	client := &mockS3Client{objects: make(map[string][]byte)}
+	backend := NewS3Backend(client, "bucket", "revoked/2020")
+
+	issuer := NewIssuerFromString("issuerAKI")
+	serials := []Serial{NewSerialFromHex("01"), NewSerialFromHex("ABCD")}
+
+	err := backend.StoreKnownCertificateList(context.TODO(), issuer, serials)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, ok := client.objects["bucket/revoked/2020/issuerAKI"]
+	if !ok {
+		t.Fatalf("Expected an object to be stored: %+v", client.objects)
+	}
+	if string(data) != "01\nabcd\n" {
+		t.Errorf("Unexpected object contents: %s", data)
+	}
+}
+
+func Test_S3BackendStoreFailure(t *testing.T) {
+	client := &mockS3Client{objects: make(map[string][]byte), fail: true}
+	backend := NewS3Backend(client, "bucket", "")
+
+	err := backend.StoreKnownCertificateList(context.TODO(), NewIssuerFromString("issuerAKI"),
+		[]Serial{NewSerialFromHex("01")})
+	if err == nil {
+		t.Error("Expected an error")
+	}
+}
+
+func Test_S3BackendStoreCompressed(t *testing.T) {
+	client := &mockS3Client{objects: make(map[string][]byte)}
+	backend := NewS3BackendWithCompression(client, "bucket", "revoked", true)
+
+	issuer := NewIssuerFromString("issuerAKI")
+	serials := []Serial{NewSerialFromHex("01"), NewSerialFromHex("ABCD")}
+	if err := backend.StoreKnownCertificateList(context.TODO(), issuer, serials); err != nil {
+		t.Fatal(err)
+	}
+
+	data, ok := client.objects["bucket/revoked/issuerAKI.gz"]
+	if !ok {
+		t.Fatalf("Expected a compressed object to be stored: %+v", client.objects)
+	}
+	r, err := NewSerialsReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ReadSerialsText(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(serials, loaded) {
+		t.Errorf("Expected %v, got %v", serials, loaded)
+	}
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+type SerialFormat string
+
+const (
+	// One hex-encoded serial per line
+	SerialFormatDefault SerialFormat = "default"
+	// Serials sorted ascending, each a uvarint length followed by the raw bytes
+	SerialFormatBinary SerialFormat = "binary"
+	// A PEM block per issuer, headed by its ID, holding the binary format
+	SerialFormatSPKIBundle SerialFormat = "spki-bundle"
+)
+
+const (
+	// With SerialFormatSPKIBundle, the file in the output folder that
+	// concatenates every enrolled issuer's block
+	SerialsBundleName = "revoked-serials.pem"
+	// Appended to the name of a serials file that was gzipped
+	CompressedSuffix = ".gz"
+
+	kBundleBlockType    = "CRLITE REVOKED SERIALS"
+	kBundleIssuerHeader = "Issuer"
+)
+
+func ParseSerialFormat(aName string) (SerialFormat, error) {
+	switch SerialFormat(aName) {
+	case SerialFormatDefault, SerialFormatBinary, SerialFormatSPKIBundle:
+		return SerialFormat(aName), nil
+	default:
+		return "", fmt.Errorf("Unknown serial format: %s", aName)
+	}
+}
+
+func WriteSerialsBinary(w io.Writer, serials []Serial) error {
+	sorted := make(SerialList, len(serials))
+	copy(sorted, serials)
+	sort.Sort(sorted)
+
+	writer := bufio.NewWriter(w)
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	for _, s := range sorted {
+		n := binary.PutUvarint(lenBuf, uint64(len(s.serial)))
+		if _, err := writer.Write(lenBuf[:n]); err != nil {
+			return err
+		}
+		if _, err := writer.Write(s.serial); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+func ReadSerialsBinary(r io.Reader) ([]Serial, error) {
+	reader := bufio.NewReader(r)
+	serials := make([]Serial, 0, 1024)
+	for {
+		length, err := binary.ReadUvarint(reader)
+		if err == io.EOF {
+			return serials, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't read serial length: %s", err)
+		}
+
+		serial := make([]byte, length)
+		if _, err = io.ReadFull(reader, serial); err != nil {
+			return nil, fmt.Errorf("Couldn't read serial of length %d: %s", length, err)
+		}
+		serials = append(serials, NewSerialFromBytes(serial))
+	}
+}
+
+// The start of a gzip stream: its magic number and the deflate method. No
+// serials format begins so, short of a binary one whose first serial is a
+// non-minimal 31 byte encoding.
+var kGzipHeader = []byte{0x1f, 0x8b, 0x08}
+
+// Returns a reader of the serials in r, in any format, decompressing them if
+// they were gzipped
+func NewSerialsReader(r io.Reader) (io.Reader, error) {
+	reader := bufio.NewReader(r)
+	header, err := reader.Peek(len(kGzipHeader))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !bytes.Equal(header, kGzipHeader) {
+		return reader, nil
+	}
+	return gzip.NewReader(reader)
+}
+
+// Reads the default format, one hex-encoded serial per line
+func ReadSerialsText(r io.Reader) ([]Serial, error) {
+	serials := make([]Serial, 0, 1024)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		serial, err := hex.DecodeString(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't decode serial %q: %s", scanner.Text(), err)
+		}
+		serials = append(serials, NewSerialFromBytes(serial))
+	}
+	return serials, scanner.Err()
+}
+
+// Writes the serials as one PEM block, whose Issuer header is aIssuerID: the
+// URL-safe base64 of the SHA-256 of the issuer's SPKI. Blocks for different
+// issuers can be concatenated into a bundle.
+func WriteSerialsBundle(w io.Writer, aIssuerID string, serials []Serial) error {
+	var encoded bytes.Buffer
+	if err := WriteSerialsBinary(&encoded, serials); err != nil {
+		return err
+	}
+	return pem.Encode(w, &pem.Block{
+		Type:    kBundleBlockType,
+		Headers: map[string]string{kBundleIssuerHeader: aIssuerID},
+		Bytes:   encoded.Bytes(),
+	})
+}
+
+// Reads a bundle of WriteSerialsBundle blocks, keyed by issuer ID
+func ReadSerialsBundle(r io.Reader) (map[string][]Serial, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := make(map[string][]Serial)
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			if len(bytes.TrimSpace(rest)) > 0 {
+				return nil, fmt.Errorf("Trailing data after %d issuers' serials", len(bundle))
+			}
+			return bundle, nil
+		}
+		if block.Type != kBundleBlockType {
+			return nil, fmt.Errorf("Unexpected PEM block of type %s", block.Type)
+		}
+		issuerID := block.Headers[kBundleIssuerHeader]
+		if issuerID == "" {
+			return nil, fmt.Errorf("PEM block without an %s header", kBundleIssuerHeader)
+		}
+
+		serials, err := ReadSerialsBinary(bytes.NewReader(block.Bytes))
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't read serials of %s: %s", issuerID, err)
+		}
+		if previous, ok := bundle[issuerID]; ok {
+			serials = append(previous, serials...)
+		}
+		bundle[issuerID] = serials
+		data = rest
+	}
+}
@@ -0,0 +1,305 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/certificate-transparency-go/x509"
+)
+
+func Test_SerialsBinaryRoundTrip(t *testing.T) {
+	serials := []Serial{
+		NewSerialFromHex("03"),
+		NewSerialFromHex("00FF"),
+		NewSerialFromHex("01"),
+		NewSerialFromHex("7F1122334455667788990011223344556677889900"),
+	}
+
+	var buf bytes.Buffer
+	if err := WriteSerialsBinary(&buf, serials); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := ReadSerialsBinary(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := make(SerialList, len(serials))
+	copy(expected, serials)
+	sort.Sort(expected)
+
+	if !reflect.DeepEqual([]Serial(expected), loaded) {
+		t.Errorf("Expected %v, got %v", expected, loaded)
+	}
+
+	if serials[0].HexString() != "03" {
+		t.Error("Writing should not reorder the caller's slice")
+	}
+}
+
+func Test_SerialsBinaryEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSerialsBinary(&buf, []Serial{}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected no output, got %d bytes", buf.Len())
+	}
+
+	loaded, err := ReadSerialsBinary(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 0 {
+		t.Errorf("Expected no serials, got %v", loaded)
+	}
+}
+
+func Test_SerialsBinaryTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSerialsBinary(&buf, []Serial{NewSerialFromHex("AABBCC")}); err != nil {
+		t.Fatal(err)
+	}
+
+	truncated := buf.Bytes()[:buf.Len()-1]
+	if _, err := ReadSerialsBinary(bytes.NewReader(truncated)); err == nil {
+		t.Error("Expected an error reading a truncated file")
+	}
+}
+
+func Test_ParseSerialFormat(t *testing.T) {
+	for _, name := range []string{"default", "binary", "spki-bundle"} {
+		if format, err := ParseSerialFormat(name); err != nil || string(format) != name {
+			t.Errorf("Expected %s to parse, got %s, %v", name, format, err)
+		}
+	}
+	if _, err := ParseSerialFormat("xml"); err == nil {
+		t.Error("Expected an unknown format to fail")
+	}
+}
+
+func Test_LocalDiskKnownCertificateListBinary(t *testing.T) {
+	rootFolder, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rootFolder)
+
+	db := NewLocalDiskBackendWithSerialFormat(0644, rootFolder, SerialFormatBinary)
+
+	issuer := NewIssuerFromString("issuerAKI")
+	serials := []Serial{NewSerialFromHex("02"), NewSerialFromHex("01")}
+	if err = db.StoreKnownCertificateList(context.TODO(), issuer, serials); err != nil {
+		t.Fatal(err)
+	}
+
+	fileBytes, err := ioutil.ReadFile(filepath.Join(rootFolder, issuer.ID()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{0x01, 0x01, 0x01, 0x02}
+	if !bytes.Equal(expected, fileBytes) {
+		t.Errorf("Expected %x, got %x", expected, fileBytes)
+	}
+}
+
+func Test_SerialsBundleRoundTrip(t *testing.T) {
+	first := NewIssuer(&x509.Certificate{RawSubjectPublicKeyInfo: []byte("first SPKI")})
+	second := NewIssuer(&x509.Certificate{RawSubjectPublicKeyInfo: []byte("second SPKI")})
+	expected := map[string][]Serial{
+		first.ID():  {NewSerialFromHex("00FF"), NewSerialFromHex("01")},
+		second.ID(): {NewSerialFromHex("7F1122334455667788990011223344556677889900")},
+	}
+
+	var buf bytes.Buffer
+	for _, issuer := range []Issuer{first, second} {
+		if err := WriteSerialsBundle(&buf, issuer.ID(), expected[issuer.ID()]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !strings.Contains(buf.String(), "Issuer: "+first.ID()+"\n") {
+		t.Errorf("Expected a header line with the issuer's SPKI hash, got %s", buf.String())
+	}
+
+	loaded, err := ReadSerialsBundle(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, loaded) {
+		t.Errorf("Expected %v, got %v", expected, loaded)
+	}
+}
+
+func Test_SerialsBundleMalformed(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSerialsBundle(&buf, "issuer", []Serial{NewSerialFromHex("01")}); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.String()
+
+	for _, bundle := range []string{
+		valid + "not PEM",
+		strings.Replace(valid, "Issuer: issuer\n", "", 1),
+		strings.Replace(valid, kBundleBlockType, "CERTIFICATE", -1),
+	} {
+		if _, err := ReadSerialsBundle(strings.NewReader(bundle)); err == nil {
+			t.Errorf("Expected an error reading %q", bundle)
+		}
+	}
+}
+
+func Test_LocalDiskKnownCertificateListBundle(t *testing.T) {
+	rootFolder, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rootFolder)
+
+	db := NewLocalDiskBackendWithSerialFormat(0644, rootFolder, SerialFormatSPKIBundle)
+
+	issuer := NewIssuerFromString("issuerAKI")
+	serials := []Serial{NewSerialFromHex("02"), NewSerialFromHex("01")}
+	if err = db.StoreKnownCertificateList(context.TODO(), issuer, serials); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := db.(KnownCertificateListLoader).LoadKnownCertificateList(context.TODO(), issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Serial{NewSerialFromHex("01"), NewSerialFromHex("02")}
+	if !reflect.DeepEqual(expected, loaded) {
+		t.Errorf("Expected %v, got %v", expected, loaded)
+	}
+}
+
+func Test_LocalDiskKnownCertificateListCompressed(t *testing.T) {
+	for _, format := range []SerialFormat{SerialFormatDefault, SerialFormatBinary, SerialFormatSPKIBundle} {
+		rootFolder, err := ioutil.TempDir("", t.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(rootFolder)
+
+		issuer := NewIssuerFromString("issuerAKI")
+		serials := []Serial{NewSerialFromHex("02"), NewSerialFromHex("00FF"), NewSerialFromHex("01")}
+
+		// A list left by an uncompressed run is replaced
+		plain := NewLocalDiskBackendWithSerialFormat(0644, rootFolder, format)
+		if err = plain.StoreKnownCertificateList(context.TODO(), issuer, serials[:1]); err != nil {
+			t.Fatal(err)
+		}
+
+		db := NewLocalDiskBackendWithCompression(0644, rootFolder, format, true)
+		if err = db.StoreKnownCertificateList(context.TODO(), issuer, serials); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(rootFolder, issuer.ID())); !os.IsNotExist(err) {
+			t.Errorf("%s: Expected the uncompressed list to be removed, got %v", format, err)
+		}
+		fileBytes, err := ioutil.ReadFile(filepath.Join(rootFolder, issuer.ID()+".gz"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(fileBytes, kGzipHeader) {
+			t.Errorf("%s: Expected a gzip file, got %x", format, fileBytes)
+		}
+
+		// Either backend reads it back
+		for _, loader := range []StorageBackend{db, plain} {
+			loaded, err := loader.(KnownCertificateListLoader).LoadKnownCertificateList(context.TODO(), issuer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Sort(SerialList(loaded))
+			expected := []Serial{NewSerialFromHex("00FF"), NewSerialFromHex("01"), NewSerialFromHex("02")}
+			sort.Sort(SerialList(expected))
+			if !reflect.DeepEqual(expected, loaded) {
+				t.Errorf("%s: Expected %v, got %v", format, expected, loaded)
+			}
+		}
+
+		// Switching back removes the compressed list
+		if err = plain.StoreKnownCertificateList(context.TODO(), issuer, serials); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(rootFolder, issuer.ID()+".gz")); !os.IsNotExist(err) {
+			t.Errorf("%s: Expected the compressed list to be removed, got %v", format, err)
+		}
+	}
+}
+
+func Test_NewSerialsReader(t *testing.T) {
+	for _, data := range []string{"", "0", "01\n02\n"} {
+		r, err := NewSerialsReader(strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, err := ioutil.ReadAll(r)
+		if err != nil || string(read) != data {
+			t.Errorf("Expected %q to be read unchanged, got %q, %v", data, read, err)
+		}
+	}
+
+	truncated := append([]byte{}, kGzipHeader...)
+	if _, err := NewSerialsReader(bytes.NewReader(truncated)); err == nil {
+		t.Error("Expected an error reading a truncated gzip header")
+	}
+}
+
+const kBenchmarkSerialCount = 5 * 1000 * 1000
+
+func makeBenchmarkSerials() []Serial {
+	serials := make([]Serial, kBenchmarkSerialCount)
+	for i := range serials {
+		b := make([]byte, 16)
+		binary.BigEndian.PutUint64(b, uint64(i)*0x9E3779B97F4A7C15)
+		binary.BigEndian.PutUint64(b[8:], uint64(i))
+		serials[i] = NewSerialFromBytes(b)
+	}
+	return serials
+}
+
+func Benchmark_LoadSerialsBinary(b *testing.B) {
+	var buf bytes.Buffer
+	if err := WriteSerialsBinary(&buf, makeBenchmarkSerials()); err != nil {
+		b.Fatal(err)
+	}
+	data := buf.Bytes()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		serials, err := ReadSerialsBinary(bytes.NewReader(data))
+		if err != nil || len(serials) != kBenchmarkSerialCount {
+			b.Fatalf("Loaded %d serials: %v", len(serials), err)
+		}
+	}
+}
+
+func Benchmark_LoadSerialsText(b *testing.B) {
+	var buf bytes.Buffer
+	for _, s := range makeBenchmarkSerials() {
+		buf.WriteString(s.HexString() + "\n")
+	}
+	data := buf.Bytes()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		serials, err := ReadSerialsText(bytes.NewReader(data))
+		if err != nil || len(serials) != kBenchmarkSerialCount {
+			b.Fatalf("Loaded %d serials: %v", len(serials), err)
+		}
+	}
+}
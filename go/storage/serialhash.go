@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"crypto/sha256"
+)
+
+// HashSerial stands in for a serial where raw serials mustn't be kept. It's
+// SHA-256 over the issuer's DER-encoded SubjectPublicKeyInfo, as in its
+// certificate, followed directly by the serial's bytes: the content octets of
+// its DER INTEGER, in the minimal form Serial holds. The 32-byte digest is
+// returned as a Serial, so it's stored, compared and hex-encoded like one.
+//
+// To check whether a certificate is in a hashed list, recompute
+// SHA-256(issuer.RawSubjectPublicKeyInfo || serial content octets) and look
+// for it. Including the issuer's key means the same serial hashes differently
+// under each issuer.
+func HashSerial(aIssuerSPKI []byte, aSerial Serial) Serial {
+	h := sha256.New()
+	h.Write(aIssuerSPKI)
+	h.Write(aSerial.serial)
+	return NewSerialFromBytes(h.Sum(nil))
+}
+
+// HashSerials applies HashSerial to each of the serials
+func HashSerials(aIssuerSPKI []byte, serials []Serial) []Serial {
+	hashed := make([]Serial, len(serials))
+	for i, s := range serials {
+		hashed[i] = HashSerial(aIssuerSPKI, s)
+	}
+	return hashed
+}
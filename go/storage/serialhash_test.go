@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func Test_HashSerial(t *testing.T) {
+	spki, _ := hex.DecodeString("300d06092a864886f70d0101010500")
+	serial := NewSerialFromHex("00ff10")
+
+	// Computed independently as
+	// sha256(bytes.fromhex("300d06092a864886f70d0101010500") + bytes.fromhex("00ff10"))
+	expected := "5c39fb67ee73e74adb1c81de2a3c071123495a2ef6162628353f4bbc823a50cb"
+
+	hashed := HashSerial(spki, serial)
+	if hashed.HexString() != expected {
+		t.Errorf("Expected %s, got %s", expected, hashed.HexString())
+	}
+	if again := HashSerial(spki, NewSerialFromHex("00ff10")); again.Cmp(hashed) != 0 {
+		t.Errorf("Hashing isn't deterministic: %s then %s", hashed, again)
+	}
+
+	otherSpki, _ := hex.DecodeString("300d06092a864886f70d0101010501")
+	if other := HashSerial(otherSpki, serial); other.Cmp(hashed) == 0 {
+		t.Errorf("Expected a different issuer to give a different hash")
+	}
+}
+
+func Test_HashSerials(t *testing.T) {
+	spki := []byte{0x30, 0x00}
+	serials := []Serial{NewSerialFromHex("01"), NewSerialFromHex("02")}
+
+	hashed := HashSerials(spki, serials)
+	if len(hashed) != len(serials) {
+		t.Fatalf("Expected %d hashes, got %d", len(serials), len(hashed))
+	}
+	for i, s := range serials {
+		if hashed[i].Cmp(HashSerial(spki, s)) != 0 {
+			t.Errorf("Hash %d was %s, expected %s", i, hashed[i], HashSerial(spki, s))
+		}
+	}
+	if serials[0].HexString() != "01" {
+		t.Errorf("The input serials shouldn't change, got %s", serials[0])
+	}
+}
@@ -67,6 +67,12 @@ type StorageBackend interface {
 		issuer Issuer, quitChan <-chan struct{}, stream chan<- UniqueCertIdentifier) error
 }
 
+// Implemented by backends that can read back what StoreKnownCertificateList
+// wrote
+type KnownCertificateListLoader interface {
+	LoadKnownCertificateList(ctx context.Context, issuer Issuer) ([]Serial, error)
+}
+
 type CertDatabase interface {
 	Cleanup() error
 	SaveLogState(aLogObj *CertificateLog) error
@@ -114,6 +120,8 @@ func NewIssuer(aCert *x509.Certificate) Issuer {
 	return obj
 }
 
+// NewIssuerFromString rebuilds an Issuer from the value of ID(), such that
+// NewIssuerFromString(i.ID()).ID() == i.ID(). The string is kept verbatim.
 func NewIssuerFromString(aStr string) Issuer {
 	obj := Issuer{
 		id: &aStr,
@@ -121,6 +129,8 @@ func NewIssuerFromString(aStr string) Issuer {
 	return obj
 }
 
+// ID is the URL-safe, padded base64 SHA-256 digest of the issuer's SPKI.
+// It's case-sensitive and used as-is for map keys and file names.
 func (o *Issuer) ID() string {
 	if o.id == nil {
 		encodedDigest := o.spki.Sha256DigestURLEncodedBase64()
@@ -158,10 +168,26 @@ func (o SPKI) Sha256DigestURLEncodedBase64() string {
 	return encodedDigest
 }
 
+// Serials read from certificates and CRLs hold the content octets of the DER
+// INTEGER in their minimal form: big-endian two's complement with no redundant
+// leading 0x00 or 0xFF bytes. That's what a conforming certificate or CRL
+// already carries, including the 0x00 that keeps a serial with a high-bit-set
+// first byte positive, so it matches the serial bytes clients look up.
+// Non-minimal encodings from nonconforming issuers are reduced to it, so every
+// source of serials compares alike. The other constructors take their bytes
+// verbatim, as they decode forms this package wrote.
 type Serial struct {
 	serial []byte
 }
 
+// Strips sign padding that the next byte makes redundant
+func canonicalSerialBytes(b []byte) []byte {
+	for len(b) > 1 && ((b[0] == 0x00 && b[1]&0x80 == 0) || (b[0] == 0xFF && b[1]&0x80 != 0)) {
+		b = b[1:]
+	}
+	return b
+}
+
 type tbsCertWithRawSerial struct {
 	Raw          asn1.RawContent
 	Version      asn1.RawValue `asn1:"optional,explicit,default:0,tag:0"`
@@ -174,7 +200,14 @@ func NewSerial(aCert *x509.Certificate) Serial {
 	if err != nil {
 		panic(err)
 	}
-	return NewSerialFromBytes(tbsCert.SerialNumber.Bytes)
+	return NewSerialFromDERBytes(tbsCert.SerialNumber.Bytes)
+}
+
+// Takes the content octets of a DER INTEGER, e.g. a CRL entry's serial
+func NewSerialFromDERBytes(b []byte) Serial {
+	return Serial{
+		serial: canonicalSerialBytes(b),
+	}
 }
 
 func NewSerialFromBytes(b []byte) Serial {
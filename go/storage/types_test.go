@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
 	"math"
@@ -56,6 +57,53 @@ func TestIssuerLazyInit(t *testing.T) {
 	}
 }
 
+func TestIssuerIDRoundTrip(t *testing.T) {
+	b, _ := pem.Decode([]byte(kLeadingZeroes))
+	cert, err := x509.ParseCertificate(b.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		issuer Issuer
+		id     string
+	}{
+		{NewIssuer(cert), "VCIlmPM9NkgFQtrs4Oa5TeFcDu6MWRTKSNdePEhOgD8="},
+		{Issuer{spki: SPKI{[]byte{0xFF}}}, "qBAK5qoZQNC2Y7sxzUZhQuu9vVGHExuS2TgYmHgy64k="},
+		// URL-safe alphabet, so '-' and '_' rather than '+' and '/'
+		{Issuer{spki: SPKI{[]byte{}}}, "47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU="},
+		{Issuer{spki: SPKI{[]byte{0x04, 0x00}}}, "wLqKM6xn9Eq_9ZhN-7b1bEa4gKwrhuHyPn-pxALFOuc="},
+	}
+
+	for _, tc := range testcases {
+		if tc.issuer.ID() != tc.id {
+			t.Errorf("Expected ID %s, got %s", tc.id, tc.issuer.ID())
+		}
+
+		fromString := NewIssuerFromString(tc.issuer.ID())
+		if fromString.ID() != tc.id {
+			t.Errorf("NewIssuerFromString(%s).ID() = %s", tc.id, fromString.ID())
+		}
+
+		data, err := json.Marshal(&tc.issuer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fromJSON Issuer
+		if err = json.Unmarshal(data, &fromJSON); err != nil {
+			t.Fatal(err)
+		}
+		if fromJSON.ID() != tc.id {
+			t.Errorf("JSON round trip of %s gave %s", tc.id, fromJSON.ID())
+		}
+
+		idMap := map[string]bool{tc.issuer.ID(): true}
+		if !idMap[fromJSON.ID()] || !idMap[fromString.ID()] {
+			t.Errorf("Round-tripped issuers should find %s as a map key", tc.id)
+		}
+	}
+}
+
 func TestSerial(t *testing.T) {
 	x := NewSerialFromHex("DEADBEEF")
 	y := Serial{
@@ -118,6 +166,48 @@ func TestSerialJson(t *testing.T) {
 	}
 }
 
+func TestSerialFromDERBytes(t *testing.T) {
+	testcases := []struct {
+		in       string
+		expected string
+	}{
+		{"01", "01"},
+		{"0001", "01"},
+		{"00000001", "01"},
+		{"001100", "1100"},
+		// A high-bit-set first byte needs its sign byte to stay positive
+		{"0080", "0080"},
+		{"00FF01", "00ff01"},
+		{"000080", "0080"},
+		{"80", "80"},
+		{"FF80", "80"},
+		{"FFFF7F", "ff7f"},
+		{"00", "00"},
+		{"0000", "00"},
+		{"FF", "ff"},
+		{"", ""},
+	}
+
+	for _, tc := range testcases {
+		raw, _ := hex.DecodeString(tc.in)
+		if got := NewSerialFromDERBytes(raw).HexString(); got != tc.expected {
+			t.Errorf("NewSerialFromDERBytes(%s) = %s, expected %s", tc.in, got, tc.expected)
+		}
+	}
+
+	if NewSerialFromDERBytes([]byte{0x00, 0x01}).Cmp(NewSerialFromHex("01")) != 0 {
+		t.Error("Padded and minimal encodings should compare equal")
+	}
+}
+
+func TestNewSerialHighBit(t *testing.T) {
+	// Go's x509 encodes the 0x00 sign byte for this serial
+	cert := makeCert(t, "CN=High Bit", "2050-01-01", NewSerialFromHex("00FF01"))
+	if serial := NewSerial(cert); serial.HexString() != "00ff01" {
+		t.Errorf("Expected the sign byte to be kept, got %s", serial.HexString())
+	}
+}
+
 func TestSerialBigInt(t *testing.T) {
 	bint := big.NewInt(0xCAFEDEAD)
 	serial := NewSerialFromBytes(bint.Bytes())
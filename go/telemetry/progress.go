@@ -0,0 +1,125 @@
+package telemetry
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Progress holds live counters for a run, and serves them over HTTP in the
+// Prometheus text exposition format, so headless runs can be monitored
+// without the progress bars. All methods are safe to call on a nil *Progress,
+// which discards the updates.
+type Progress struct {
+	// Accessed atomically; kept first for 64-bit alignment
+	issuersTotal      int64
+	issuersProcessed  int64
+	crlsDownloaded    int64
+	crlsFailed        int64
+	serialsAggregated int64
+
+	namespace string
+	mutex     sync.Mutex
+	phase     string
+}
+
+func NewProgress(namespace string) *Progress {
+	return &Progress{
+		namespace: namespace,
+		phase:     "starting",
+	}
+}
+
+func (p *Progress) SetPhase(aPhase string) {
+	if p == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.phase = aPhase
+}
+
+func (p *Progress) Phase() string {
+	if p == nil {
+		return ""
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.phase
+}
+
+func (p *Progress) SetIssuersTotal(n int64) {
+	if p != nil {
+		atomic.StoreInt64(&p.issuersTotal, n)
+	}
+}
+
+func (p *Progress) IssuerProcessed() {
+	if p != nil {
+		atomic.AddInt64(&p.issuersProcessed, 1)
+	}
+}
+
+func (p *Progress) IssuersTotal() int64 {
+	if p == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&p.issuersTotal)
+}
+
+func (p *Progress) IssuersProcessed() int64 {
+	if p == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&p.issuersProcessed)
+}
+
+func (p *Progress) CrlDownloaded() {
+	if p != nil {
+		atomic.AddInt64(&p.crlsDownloaded, 1)
+	}
+}
+
+func (p *Progress) CrlFailed() {
+	if p != nil {
+		atomic.AddInt64(&p.crlsFailed, 1)
+	}
+}
+
+func (p *Progress) SerialsAggregated(n int64) {
+	if p != nil {
+		atomic.AddInt64(&p.serialsAggregated, n)
+	}
+}
+
+func (p *Progress) writeMetric(buf *bytes.Buffer, name string, kind string, help string, value int64) {
+	fullName := fmt.Sprintf("%s_%s", p.namespace, name)
+	fmt.Fprintf(buf, "# HELP %s %s\n", fullName, help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", fullName, kind)
+	fmt.Fprintf(buf, "%s %d\n", fullName, value)
+}
+
+func (p *Progress) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	buf := bytes.NewBuffer(nil)
+
+	p.writeMetric(buf, "issuers_total", "gauge", "Issuers with CRLs to process.",
+		atomic.LoadInt64(&p.issuersTotal))
+	p.writeMetric(buf, "issuers_processed", "counter", "Issuers whose CRLs have been aggregated.",
+		atomic.LoadInt64(&p.issuersProcessed))
+	p.writeMetric(buf, "crls_downloaded", "counter", "CRLs downloaded or found current on disk.",
+		atomic.LoadInt64(&p.crlsDownloaded))
+	p.writeMetric(buf, "crls_failed", "counter", "CRLs that could not be obtained.",
+		atomic.LoadInt64(&p.crlsFailed))
+	p.writeMetric(buf, "serials_aggregated", "counter", "Revoked serials saved across all issuers.",
+		atomic.LoadInt64(&p.serialsAggregated))
+
+	phaseName := fmt.Sprintf("%s_phase", p.namespace)
+	fmt.Fprintf(buf, "# HELP %s The phase the run is currently in.\n", phaseName)
+	fmt.Fprintf(buf, "# TYPE %s gauge\n", phaseName)
+	fmt.Fprintf(buf, "%s{phase=%q} 1\n", phaseName, p.Phase())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write(buf.Bytes())
+}
@@ -0,0 +1,64 @@
+package telemetry
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_ProgressServeHTTP(t *testing.T) {
+	p := NewProgress("test")
+	p.SetIssuersTotal(10)
+	p.IssuerProcessed()
+	p.IssuerProcessed()
+	p.CrlDownloaded()
+	p.CrlFailed()
+	p.SerialsAggregated(42)
+	p.SetPhase("aggregate")
+
+	if p.IssuersTotal() != 10 || p.IssuersProcessed() != 2 {
+		t.Errorf("Unexpected issuer counts: %d of %d", p.IssuersProcessed(), p.IssuersTotal())
+	}
+
+	ts := httptest.NewServer(p)
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		"# TYPE test_issuers_total gauge",
+		"test_issuers_total 10",
+		"test_issuers_processed 2",
+		"test_crls_downloaded 1",
+		"test_crls_failed 1",
+		"test_serials_aggregated 42",
+		"test_phase{phase=\"aggregate\"} 1",
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("Expected line %q in:\n%s", line, body)
+		}
+	}
+}
+
+func Test_ProgressNil(t *testing.T) {
+	var p *Progress
+	p.SetIssuersTotal(1)
+	p.IssuerProcessed()
+	p.CrlDownloaded()
+	p.CrlFailed()
+	p.SerialsAggregated(1)
+	p.SetPhase("done")
+	if p.Phase() != "" || p.IssuersTotal() != 0 || p.IssuersProcessed() != 0 {
+		t.Error("A nil Progress should have no phase or counts")
+	}
+}
@@ -2,7 +2,12 @@ package types
 
 import (
 	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+	"math/big"
 	"net/url"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/mozilla/crlite/go/storage"
@@ -23,6 +28,178 @@ func (self IssuerCrlMap) Merge(other IssuerCrlMap) {
 	}
 }
 
+// Serialized as issuer -> sorted list of URLs, so the output is stable
+// across runs.
+func (self IssuerCrlMap) MarshalJSON() ([]byte, error) {
+	out := make(map[string][]string, len(self))
+	for issuer, crls := range self {
+		list := make([]string, 0, len(crls))
+		for crl := range crls {
+			list = append(list, crl)
+		}
+		sort.Strings(list)
+		out[issuer] = list
+	}
+	return json.Marshal(out)
+}
+
+func (self *IssuerCrlMap) UnmarshalJSON(data []byte) error {
+	var in map[string][]string
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+
+	result := make(IssuerCrlMap, len(in))
+	for issuer, list := range in {
+		crls := make(map[string]bool, len(list))
+		for _, crl := range list {
+			crls[crl] = true
+		}
+		result[issuer] = crls
+	}
+	*self = result
+	return nil
+}
+
+// ConcurrentIssuerCrlMap is an IssuerCrlMap that many workers can add to at
+// once, so their results needn't be buffered and merged afterward.
+type ConcurrentIssuerCrlMap struct {
+	mutex *sync.Mutex
+	crls  IssuerCrlMap
+}
+
+func NewConcurrentIssuerCrlMap() *ConcurrentIssuerCrlMap {
+	return &ConcurrentIssuerCrlMap{
+		mutex: &sync.Mutex{},
+		crls:  make(IssuerCrlMap),
+	}
+}
+
+// Records the issuer even if crlUrls is empty, as IssuerCrlMap.Merge would
+func (c *ConcurrentIssuerCrlMap) Add(issuer string, crlUrls []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	crls, pres := c.crls[issuer]
+	if !pres {
+		crls = make(map[string]bool, len(crlUrls))
+		c.crls[issuer] = crls
+	}
+	for _, crl := range crlUrls {
+		crls[crl] = true
+	}
+}
+
+func (c *ConcurrentIssuerCrlMap) Merge(other IssuerCrlMap) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.crls.Merge(other)
+}
+
+// Returns the merged map. It's shared, so the workers must be done adding.
+func (c *ConcurrentIssuerCrlMap) Map() IssuerCrlMap {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.crls
+}
+
+type IssuerOcspMap map[string]map[string]bool
+
+func (self IssuerOcspMap) Merge(other IssuerOcspMap) {
+	for issuer, ocsps := range other {
+		selfOcsps, pres := self[issuer]
+		if !pres {
+			selfOcsps = make(map[string]bool)
+		}
+		for ocsp, _ := range ocsps {
+			selfOcsps[ocsp] = true
+		}
+		self[issuer] = selfOcsps
+	}
+}
+
+type CrlManifestEntry struct {
+	Url        string    `json:"url"`
+	Path       string    `json:"path"`
+	SHA256     string    `json:"sha256"`
+	Size       int64     `json:"size"`
+	ThisUpdate time.Time `json:"thisUpdate"`
+	NextUpdate time.Time `json:"nextUpdate"`
+	// nil if the CRL has no CRL Number extension
+	CrlNumber *big.Int `json:"crlNumber,omitempty"`
+	// Set when the download failed and the previously-cached file was used
+	FromCache  bool `json:"fromCache"`
+	Aggregated bool `json:"aggregated"`
+}
+
+// CrlManifest records, per issuer, exactly which CRL files went into a run.
+// It's safe for concurrent use by the fetch and aggregate workers.
+type CrlManifest struct {
+	mutex   *sync.Mutex
+	entries map[string]map[string]*CrlManifestEntry
+}
+
+func NewCrlManifest() *CrlManifest {
+	return &CrlManifest{
+		mutex:   &sync.Mutex{},
+		entries: make(map[string]map[string]*CrlManifestEntry),
+	}
+}
+
+func (m *CrlManifest) Add(issuer storage.Issuer, entry CrlManifestEntry) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	issuerEntries, pres := m.entries[issuer.ID()]
+	if !pres {
+		issuerEntries = make(map[string]*CrlManifestEntry)
+		m.entries[issuer.ID()] = issuerEntries
+	}
+	issuerEntries[entry.Url] = &entry
+}
+
+func (m *CrlManifest) MarkAggregated(issuer storage.Issuer, crlUrl string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if entry, pres := m.entries[issuer.ID()][crlUrl]; pres {
+		entry.Aggregated = true
+	}
+}
+
+func (m *CrlManifest) Get(issuer storage.Issuer, crlUrl string) (CrlManifestEntry, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entry, pres := m.entries[issuer.ID()][crlUrl]
+	if !pres {
+		return CrlManifestEntry{}, false
+	}
+	return *entry, true
+}
+
+func (m *CrlManifest) MarshalJSON() ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// encoding/json sorts map keys, so the issuers come out ordered, and
+	// ordering each issuer's entries by URL keeps the output diffable.
+	out := make(map[string][]CrlManifestEntry, len(m.entries))
+	for issuerID, issuerEntries := range m.entries {
+		list := make([]CrlManifestEntry, 0, len(issuerEntries))
+		for _, entry := range issuerEntries {
+			list = append(list, *entry)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].Url < list[j].Url
+		})
+		out[issuerID] = list
+	}
+	return json.Marshal(out)
+}
+
 type IssuerRevocations struct {
 	Issuer         storage.Issuer
 	RevokedSerials []storage.Serial
@@ -62,6 +239,13 @@ type RevokedCertificateWithRawSerial struct {
 	Raw            asn1.RawContent
 	SerialNumber   asn1.RawValue
 	RevocationTime time.Time
+	Extensions     []RawExtension `asn1:"optional"`
+}
+
+type RawExtension struct {
+	Id       asn1.ObjectIdentifier
+	Critical bool `asn1:"optional"`
+	Value    []byte
 }
 
 func DecodeRawTBSCertList(data []byte) (*TBSCertificateListWithRawSerials, error) {
@@ -70,6 +254,17 @@ func DecodeRawTBSCertList(data []byte) (*TBSCertificateListWithRawSerials, error
 	return &tbsCertList, err
 }
 
+// Decodes one entry of a TBSCertList's revokedCertificates, for reading a
+// CRL's entries one at a time
+func DecodeRawRevokedCertificate(data []byte) (*RevokedCertificateWithRawSerial, error) {
+	var revoked RevokedCertificateWithRawSerial
+	rest, err := asn1.Unmarshal(data, &revoked)
+	if err == nil && len(rest) > 0 {
+		err = fmt.Errorf("Trailing data after revoked certificate")
+	}
+	return &revoked, err
+}
+
 type SerialSet struct {
 	setData map[string]struct{}
 }
@@ -80,16 +275,23 @@ func NewSerialSet() *SerialSet {
 	}
 }
 
+// Keyed by the raw serial bytes, rather than the encoded ID, to keep
+// memory down for issuers with millions of revocations.
 func (s *SerialSet) Add(serial storage.Serial) bool {
-	_, alreadyExisted := s.setData[serial.ID()]
-	s.setData[serial.ID()] = struct{}{}
+	key := serial.BinaryString()
+	_, alreadyExisted := s.setData[key]
+	s.setData[key] = struct{}{}
 	return !alreadyExisted
 }
 
+func (s SerialSet) Len() int {
+	return len(s.setData)
+}
+
 func (s SerialSet) List() []storage.Serial {
 	serialList := make([]storage.Serial, 0, len(s.setData))
-	for idString := range s.setData {
-		serial, _ := storage.NewSerialFromIDString(idString)
+	for binString := range s.setData {
+		serial, _ := storage.NewSerialFromBinaryString(binString)
 		serialList = append(serialList, serial)
 	}
 	return serialList
@@ -2,7 +2,10 @@ package types
 
 import (
 	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/mozilla/crlite/go/storage"
@@ -69,6 +72,10 @@ func Test_SerialSet(t *testing.T) {
 		t.Error("Should have been new")
 	}
 
+	if set.Len() != len(testSerials) {
+		t.Errorf("Expected %d entries, got %d", len(testSerials), set.Len())
+	}
+
 	actualSerials := set.List()
 
 	if len(actualSerials) != len(testSerials) {
@@ -88,3 +95,163 @@ func Test_SerialSet(t *testing.T) {
 		}
 	}
 }
+
+func Test_CrlManifestMarshal(t *testing.T) {
+	manifest := NewCrlManifest()
+	issuer := storage.NewIssuerFromString("issuer")
+
+	manifest.Add(issuer, CrlManifestEntry{Url: "http://b.example/crl", Size: 2})
+	manifest.Add(issuer, CrlManifestEntry{Url: "http://a.example/crl", Size: 1})
+	manifest.MarkAggregated(issuer, "http://b.example/crl")
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded map[string][]CrlManifestEntry
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := decoded["issuer"]
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %+v", entries)
+	}
+	if entries[0].Url != "http://a.example/crl" || entries[1].Url != "http://b.example/crl" {
+		t.Errorf("Expected entries sorted by URL, got %+v", entries)
+	}
+	if entries[0].Aggregated || !entries[1].Aggregated {
+		t.Errorf("Expected only the second entry to be aggregated, got %+v", entries)
+	}
+}
+
+func Test_IssuerCrlMapJSON(t *testing.T) {
+	crls := IssuerCrlMap{
+		"issuerB": {"http://b.example/2.crl": true, "http://b.example/1.crl": true},
+		"issuerA": {"http://a.example/1.crl": true},
+		"issuerC": {},
+	}
+
+	data, err := json.Marshal(crls)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"issuerA":["http://a.example/1.crl"],"issuerB":["http://b.example/1.crl","http://b.example/2.crl"],"issuerC":[]}`
+	if string(data) != expected {
+		t.Errorf("Expected %s, got %s", expected, data)
+	}
+
+	var decoded IssuerCrlMap
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(crls, decoded) {
+		t.Errorf("Round trip mismatch: expected %+v, got %+v", crls, decoded)
+	}
+}
+
+func Test_ConcurrentIssuerCrlMap(t *testing.T) {
+	concurrent := NewConcurrentIssuerCrlMap()
+	expected := make(IssuerCrlMap)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				issuer := fmt.Sprintf("issuer%d", i)
+				concurrent.Add(issuer, []string{fmt.Sprintf("http://%d.example/crl", worker)})
+				if i%10 == 0 {
+					concurrent.Merge(IssuerCrlMap{issuer: {"http://shared.example/crl": true}})
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for i := 0; i < 100; i++ {
+		issuer := fmt.Sprintf("issuer%d", i)
+		expected[issuer] = make(map[string]bool)
+		for w := 0; w < 8; w++ {
+			expected[issuer][fmt.Sprintf("http://%d.example/crl", w)] = true
+		}
+		if i%10 == 0 {
+			expected[issuer]["http://shared.example/crl"] = true
+		}
+	}
+	if !reflect.DeepEqual(concurrent.Map(), expected) {
+		t.Errorf("Merged maps differ, got %d issuers", len(concurrent.Map()))
+	}
+
+	concurrent.Add("noCrls", nil)
+	if crls, pres := concurrent.Map()["noCrls"]; !pres || len(crls) != 0 {
+		t.Errorf("Expected an issuer without CRLs to be recorded, got %v", crls)
+	}
+}
+
+const (
+	benchIssuers = 20000
+	benchWorkers = 8
+)
+
+func benchCrls(issuer int) []string {
+	return []string{
+		fmt.Sprintf("http://crl%d.example/a.crl", issuer),
+		fmt.Sprintf("http://crl%d.example/b.crl", issuer),
+	}
+}
+
+// Each worker builds its own IssuerCrlMap, buffered until all are merged.
+// Compare B/op with Benchmark_ConcurrentIssuerCrlMap.
+func Benchmark_IssuerCrlMapBufferedMerge(b *testing.B) {
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		resultChan := make(chan IssuerCrlMap, benchWorkers)
+		var wg sync.WaitGroup
+		for w := 0; w < benchWorkers; w++ {
+			wg.Add(1)
+			go func(worker int) {
+				defer wg.Done()
+				partial := make(IssuerCrlMap)
+				for i := worker; i < benchIssuers; i += benchWorkers {
+					crls := make(map[string]bool)
+					for _, crl := range benchCrls(i) {
+						crls[crl] = true
+					}
+					partial[fmt.Sprintf("issuer%d", i)] = crls
+				}
+				resultChan <- partial
+			}(w)
+		}
+		wg.Wait()
+		close(resultChan)
+
+		merged := make(IssuerCrlMap)
+		for partial := range resultChan {
+			merged.Merge(partial)
+		}
+	}
+}
+
+// Each worker adds into a shared ConcurrentIssuerCrlMap as it goes
+func Benchmark_ConcurrentIssuerCrlMap(b *testing.B) {
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		merged := NewConcurrentIssuerCrlMap()
+		var wg sync.WaitGroup
+		for w := 0; w < benchWorkers; w++ {
+			wg.Add(1)
+			go func(worker int) {
+				defer wg.Done()
+				for i := worker; i < benchIssuers; i += benchWorkers {
+					merged.Add(fmt.Sprintf("issuer%d", i), benchCrls(i))
+				}
+			}(w)
+		}
+		wg.Wait()
+		_ = merged.Map()
+	}
+}